@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var errNoCover = errors.New("no cover image found")
+
+var coverImages = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
+
+// formatOf falls back on the extension for requests sent before the format was passed along
+func formatOf(format, name string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".epub":
+		return "epub"
+	case ".mobi", ".prc":
+		return "mobi"
+	case ".azw", ".azw3":
+		return "azw3"
+	case ".djvu", ".djv":
+		return "djvu"
+	case ".cbz":
+		return "cbz"
+	}
+	return "pdf"
+}
+
+// extractCover writes the cover image embedded in an ebook or comic to out
+func extractCover(format, name, out string) error {
+	var image []byte
+	var err error
+	switch format {
+	case "epub":
+		image, err = epubCover(name)
+	case "cbz":
+		image, err = comicCover(name)
+	case "mobi", "azw3":
+		image, err = mobiCover(name)
+	default:
+		return errors.New("format has no embedded cover")
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, image, 0600)
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Meta []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"metadata>meta"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+// epubCover finds the cover through the cover-image property of EPUB 3 or the cover meta of EPUB 2
+func epubCover(name string) ([]byte, error) {
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var container epubContainer
+	if err := decodeXML(&archive.Reader, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errNoCover
+	}
+	root := container.Rootfiles[0].FullPath
+	var pkg epubPackage
+	if err := decodeXML(&archive.Reader, root, &pkg); err != nil {
+		return nil, err
+	}
+
+	coverID := ""
+	for _, meta := range pkg.Meta {
+		if meta.Name == "cover" {
+			coverID = meta.Content
+		}
+	}
+	for _, item := range pkg.Manifest {
+		if strings.Contains(item.Properties, "cover-image") || (item.ID == coverID && strings.HasPrefix(item.MediaType, "image/")) {
+			return readEntry(&archive.Reader, path.Join(path.Dir(root), item.Href))
+		}
+	}
+	return nil, errNoCover
+}
+
+// comicCover takes the first page, pages are ordered by their names
+func comicCover(name string) ([]byte, error) {
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	pages := []string{}
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, "__MACOSX/") && coverImages[strings.ToLower(path.Ext(f.Name))] {
+			pages = append(pages, f.Name)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, errNoCover
+	}
+	sort.Strings(pages)
+	return readEntry(&archive.Reader, pages[0])
+}
+
+func decodeXML(archive *zip.Reader, name string, v interface{}) error {
+	for _, f := range archive.File {
+		if f.Name != path.Clean(name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		decoder := xml.NewDecoder(rc)
+		decoder.Strict = false
+		decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+		return decoder.Decode(v)
+	}
+	return errors.New("missing " + name)
+}
+
+func readEntry(archive *zip.Reader, name string) ([]byte, error) {
+	for _, f := range archive.File {
+		if f.Name != path.Clean(name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, errNoCover
+}
+
+// mobiCover reads the record the EXTH cover offset points at, counted from the first image record of the MOBI header.
+// See https://wiki.mobileread.com/wiki/MOBI
+func mobiCover(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 78 || string(data[60:68]) != "BOOKMOBI" {
+		return nil, errors.New("not a mobi file")
+	}
+	count := int(binary.BigEndian.Uint16(data[76:]))
+	if len(data) < 78+count*8 {
+		return nil, errors.New("not a mobi file")
+	}
+	record := func(i int) []byte {
+		if i < 0 || i >= count {
+			return nil
+		}
+		start := int(binary.BigEndian.Uint32(data[78+i*8:]))
+		end := len(data)
+		if i+1 < count {
+			end = int(binary.BigEndian.Uint32(data[78+(i+1)*8:]))
+		}
+		if start > end || end > len(data) {
+			return nil
+		}
+		return data[start:end]
+	}
+
+	rec := record(0)
+	if len(rec) < 132 || string(rec[16:20]) != "MOBI" || binary.BigEndian.Uint32(rec[128:])&0x40 == 0 {
+		return nil, errNoCover
+	}
+	firstImage := binary.BigEndian.Uint32(rec[108:])
+	headerEnd := 16 + int(binary.BigEndian.Uint32(rec[20:]))
+	if firstImage == 0xFFFFFFFF || headerEnd+12 > len(rec) || string(rec[headerEnd:headerEnd+4]) != "EXTH" {
+		return nil, errNoCover
+	}
+	exth := rec[headerEnd:]
+
+	offsets := map[uint32]uint32{}
+	entries := binary.BigEndian.Uint32(exth[8:])
+	exth = exth[12:]
+	for i := uint32(0); i < entries && len(exth) >= 8; i++ {
+		kind, length := binary.BigEndian.Uint32(exth), int(binary.BigEndian.Uint32(exth[4:]))
+		if length < 8 || length > len(exth) {
+			break
+		}
+		// 201 is the cover, 202 the thumbnail
+		if (kind == 201 || kind == 202) && length == 12 {
+			offsets[kind] = binary.BigEndian.Uint32(exth[8:])
+		}
+		exth = exth[length:]
+	}
+	for _, kind := range []uint32{201, 202} {
+		if offset, ok := offsets[kind]; ok {
+			if image := record(int(firstImage + offset)); len(image) > 0 {
+				return image, nil
+			}
+		}
+	}
+	return nil, errNoCover
+}
+
+// removeAll cleans up the intermediate files of a cover, missing files are fine
+func removeAll(names ...string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
@@ -3,9 +3,9 @@ module cover-gen
 go 1.13
 
 require (
-	github.com/gorilla/handlers v1.4.2 // indirect
-	github.com/gorilla/mux v1.7.3 // indirect
-	github.com/sirupsen/logrus v1.4.2 // indirect
-	go.uber.org/fx v1.10.0 // indirect
-	gocloud.dev v0.18.0 // indirect
+	github.com/gorilla/handlers v1.4.2
+	github.com/gorilla/mux v1.7.3
+	github.com/sirupsen/logrus v1.4.2
+	go.uber.org/fx v1.10.0
+	gocloud.dev v0.18.0
 )
@@ -34,7 +34,7 @@ func (h *thumbnailHandler) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.CreateCover(e.ID, e.Path); err != nil {
+	if err := h.service.CreateCover(e.ID, e.Path, e.Format); err != nil {
 		logrus.WithError(err).Error("unable to create thumbnail")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -57,7 +57,7 @@ func (h *thumbnailHandler) BulkPost(w http.ResponseWriter, r *http.Request) {
 
 	results := make(map[string]string)
 	for _, e := range entities{
-		if err := h.service.CreateCover(e.ID, e.Path); err != nil {
+		if err := h.service.CreateCover(e.ID, e.Path, e.Format); err != nil {
 			logrus.WithError(err).Error("unable to create bulk thumbnail")
 			results[e.ID] = "failed"
 			continue
@@ -73,4 +73,5 @@ func (h *thumbnailHandler) BulkPost(w http.ResponseWriter, r *http.Request) {
 type request struct {
 	ID string `json:"id"`
 	Path string `json:"path"`
+	Format string `json:"format"`
 }
@@ -22,7 +22,7 @@ func NewService(bucket *BookBucket, siteBucket *SiteBucket) *Service {
 	}
 }
 
-func (s *Service) CreateCover(id string, path string) error {
+func (s *Service) CreateCover(id string, path string, format string) error {
 	//Download
 	r, err := s.bookBucket.GetBook(path)
 	if err != nil {
@@ -37,20 +37,16 @@ func (s *Service) CreateCover(id string, path string) error {
 	}
 	r.Close()
 
-	logrus.WithField("id", id).Info("extracting cover")
-	// Generate image
-	//gs -sDEVICE=jpeg -dPDFFitPage=true -dDEVICEWIDTHPOINTS=250 -dDEVICEHEIGHTPOINTS=250 -sOutputFile=outputfile.jpeg inputfile.pdf
-	args := []string{"-sDEVICE=jpeg","-dPDFFitPage=true","-dDEVICEWIDTHPOINTS=350","-dDEVICEHEIGHTPOINTS=350",fmt.Sprintf("-sOutputFile=/tmp/%s.jpg", id),file.Name()}
-
-	cmd := exec.Command("gs", args...)
-	if err := cmd.Run(); err != nil {
+	format = formatOf(format, path)
+	logrus.WithFields(logrus.Fields{"id": id, "format": format}).Info("extracting cover")
+	if err := render(id, file.Name(), format); err != nil {
 		logrus.WithError(err).Error("unable to create thumbnail")
 		return errors.New("unable to create thumbnail")
 	}
 
 	logrus.WithField("id", id).Info("optimizing jpg")
 
-	cmd = exec.Command("jpegoptim", fmt.Sprintf("/tmp/%s.jpg", id))
+	cmd := exec.Command("jpegoptim", fmt.Sprintf("/tmp/%s.jpg", id))
 	if err := cmd.Run(); err != nil {
 		logrus.WithError(err).Error("unable to optimize jpg")
 		return errors.New("unable to optimize jpg")
@@ -86,3 +82,36 @@ func (s *Service) CreateCover(id string, path string) error {
 
 	return nil
 }
+
+// render writes the cover to /tmp/{id}.jpg. Pdfs are drawn with ghostscript and djvus with ddjvu, ebooks and comics
+// carry their cover as an image that only needs resizing.
+func render(id string, name string, format string) error {
+	out := fmt.Sprintf("/tmp/%s.jpg", id)
+	switch format {
+	case "djvu":
+		//ddjvu -format=tiff -page=1 inputfile.djvu page.tiff
+		page := fmt.Sprintf("/tmp/%s.tiff", id)
+		defer removeAll(page)
+		if err := exec.Command("ddjvu", "-format=tiff", "-page=1", name, page).Run(); err != nil {
+			return err
+		}
+		return thumbnail(page, out)
+	case "epub", "cbz", "mobi", "azw3":
+		image := fmt.Sprintf("/tmp/%s.img", id)
+		defer removeAll(image)
+		if err := extractCover(format, name, image); err != nil {
+			return err
+		}
+		return thumbnail(image, out)
+	default:
+		//gs -sDEVICE=jpeg -dPDFFitPage=true -dDEVICEWIDTHPOINTS=250 -dDEVICEHEIGHTPOINTS=250 -sOutputFile=outputfile.jpeg inputfile.pdf
+		args := []string{"-sDEVICE=jpeg","-dPDFFitPage=true","-dDEVICEWIDTHPOINTS=350","-dDEVICEHEIGHTPOINTS=350",fmt.Sprintf("-sOutputFile=%s", out),name}
+		return exec.Command("gs", args...).Run()
+	}
+}
+
+// thumbnail scales an image of any kind imagemagick reads into a jpg, [0] takes the first frame of a gif or tiff
+func thumbnail(in string, out string) error {
+	//convert image[0] -thumbnail 350x350 outputfile.jpg
+	return exec.Command("convert", in+"[0]", "-thumbnail", "350x350", out).Run()
+}
@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Holmes89/alexandria/mind/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -32,12 +33,16 @@ import (
 var addBookCmd = &cobra.Command{
 	Use:   "book",
 	Short: "Upload book to the service",
-	Long:  `Add book to library from local file system providing a path and a name.`,
+	Long: `Add book to library from local file system providing a path and a name. The file is sent in chunks and an
+			interrupted upload resumes when the command is run again.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := app.UploadBook(uploadPath, name); err != nil {
 			if debug {
 				fmt.Fprintln(out, err.Error())
 			}
+			if errors.Is(err, internal.ErrUploadInterrupted) {
+				return err
+			}
 			return errors.New("unable to upload file")
 		}
 		fmt.Fprintln(out, "file successfully uploaded")
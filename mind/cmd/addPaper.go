@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Holmes89/alexandria/mind/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -32,12 +33,16 @@ import (
 var addPaperCmd = &cobra.Command{
 	Use:   "paper",
 	Short: "Upload paper to the service",
-	Long:  `Add paper to library from local file system providing a path and a name.`,
+	Long: `Add paper to library from local file system providing a path and optionally a name. Without a name the title
+			found in the pdf is used.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := app.UploadPapers(uploadPath, name); err != nil {
 			if debug {
 				fmt.Fprintln(out, err.Error())
 			}
+			if errors.Is(err, internal.ErrUploadInterrupted) {
+				return err
+			}
 			return errors.New("unable to upload file")
 		}
 		fmt.Fprintln(out, "file successfully uploaded")
@@ -51,5 +56,4 @@ func init() {
 	addPaperCmd.Flags().StringVarP(&uploadPath, "path", "p", "", "filepath to upload")
 	addPaperCmd.Flags().StringVarP(&name, "name", "n", "", "display name of the file")
 	addPaperCmd.MarkFlagRequired("path")
-	addPaperCmd.MarkFlagRequired("name")
 }
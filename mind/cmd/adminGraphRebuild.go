@@ -0,0 +1,68 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var wipe bool
+
+// adminGraphRebuildCmd represents the admin graph-rebuild command
+var adminGraphRebuildCmd = &cobra.Command{
+	Use:   "graph-rebuild",
+	Short: "Rebuild the graph from the library",
+	Long: `Write every book, paper, link, tag and tag edge from the library into the graph. Nodes and edges that no
+longer exist in the library are removed, use --wipe to clear the whole graph first. Safe to run repeatedly.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := app.RebuildGraph(wipe)
+		if err != nil {
+			if debug {
+				fmt.Fprintln(out, err.Error())
+			}
+			return errors.New("unable to rebuild graph")
+		}
+		before := result.Before
+		fmt.Fprintf(out, "drift before rebuild: %d missing nodes, %d extra nodes, %d missing edges, %d extra edges\n",
+			len(before.MissingNodes), len(before.ExtraNodes), len(before.MissingEdges), len(before.ExtraEdges))
+
+		tw := getTabWriter()
+		fmt.Fprintf(tw, "\n %s\t%s\t", "TYPE", "COUNT")
+		for _, label := range []string{"Book", "Paper", "Link", "Tag"} {
+			fmt.Fprintf(tw, "\n %s\t%d\t", label, result.Nodes[label])
+		}
+		fmt.Fprintf(tw, "\n %s\t%d\t", "HAS_TAG", result.Edges)
+		fmt.Fprintf(tw, "\n\n")
+		tw.Flush()
+		fmt.Fprintf(out, "removed %d nodes and %d edges\n", result.RemovedNodes, result.RemovedEdges)
+		return nil
+	},
+}
+
+func init() {
+	adminCmd.AddCommand(adminGraphRebuildCmd)
+
+	adminGraphRebuildCmd.Flags().BoolVar(&wipe, "wipe", false, "delete the whole graph before rebuilding")
+}
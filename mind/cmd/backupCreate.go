@@ -0,0 +1,62 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	incremental   bool
+	includeFiles  bool
+	includeCovers bool
+)
+
+// backupCreateCmd represents the backup create command
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a backup",
+	Long: `Create a backup of the library. An incremental backup only stores what changed since the previous backup,
+a full backup is taken instead when there is none.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := app.CreateBackup(incremental, includeFiles, includeCovers)
+		if err != nil {
+			if debug {
+				fmt.Fprintln(out, err.Error())
+			}
+			return errors.New("unable to create backup")
+		}
+		fmt.Fprintf(out, "created %s backup %s\n", result.Kind, result.ID)
+		return nil
+	},
+}
+
+func init() {
+	backupCmd.AddCommand(backupCreateCmd)
+
+	backupCreateCmd.Flags().BoolVar(&incremental, "incremental", false, "only back up changes since the previous backup")
+	backupCreateCmd.Flags().BoolVar(&includeFiles, "files", false, "include document files")
+	backupCreateCmd.Flags().BoolVar(&includeCovers, "covers", false, "include cover images")
+}
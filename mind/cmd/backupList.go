@@ -0,0 +1,72 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// backupListCmd represents the backup list command
+var backupListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List available backups",
+	Aliases: []string{"ls"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		results, err := app.FindBackups()
+		if err != nil {
+			if debug {
+				fmt.Fprintln(out, err.Error())
+			}
+			return errors.New("unable to fetch backups")
+		}
+		tw := getTabWriter()
+		fmt.Fprintf(tw, "\n %s\t%s\t%s\t%s\t%s\t", "ID", "KIND", "CREATED", "SIZE", "DOCUMENTS")
+		for _, r := range results {
+			docs := "-"
+			if count, ok := r.Counts["documents"]; ok {
+				docs = fmt.Sprintf("%d", count)
+			}
+			fmt.Fprintf(tw, "\n %s\t%s\t%s\t%s\t%s\t", r.ID, r.Kind, r.Created.Local().Format("2006-01-02 15:04"), formatSize(r.Size), docs)
+		}
+		fmt.Fprintf(tw, "\n\n")
+		tw.Flush()
+		return nil
+	},
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+func init() {
+	backupCmd.AddCommand(backupListCmd)
+}
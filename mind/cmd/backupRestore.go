@@ -0,0 +1,86 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Holmes89/alexandria/mind/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreType string
+	restoreMode string
+)
+
+// backupRestoreCmd represents the backup restore command
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a backup",
+	Long: `Restore a backup by ID, use "mind backup list" to find it. The merge mode keeps whichever copy of a record
+was updated last, replace clears everything first and dry-run reports what a merge would change.`,
+	Args:       cobra.ExactArgs(1),
+	ArgAliases: []string{"id"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := app.RestoreBackup(args[0], restoreType, restoreMode)
+		if err != nil {
+			if debug {
+				fmt.Fprintln(out, err.Error())
+			}
+			return errors.New("unable to restore backup")
+		}
+		tw := getTabWriter()
+		fmt.Fprintf(tw, "\n %s\t%s\t%s\t%s\t%s\t", "TABLE", "INSERTED", "UPDATED", "UNCHANGED", "DELETED")
+		for _, counts := range []map[string]*internal.RestoreCounts{result.Postgres, result.Graph} {
+			for _, name := range sortedKeys(counts) {
+				c := counts[name]
+				fmt.Fprintf(tw, "\n %s\t%d\t%d\t%d\t%d\t", name, c.Inserted, c.Updated, c.Unchanged, c.Deleted)
+			}
+		}
+		fmt.Fprintf(tw, "\n\n")
+		tw.Flush()
+		if result.Mode == "dry-run" {
+			fmt.Fprintf(out, "dry run, nothing was restored\n")
+		}
+		return nil
+	},
+}
+
+func init() {
+	backupCmd.AddCommand(backupRestoreCmd)
+
+	backupRestoreCmd.Flags().StringVar(&restoreType, "type", "", "restore only postgres or graph")
+	backupRestoreCmd.Flags().StringVar(&restoreMode, "mode", "merge", "merge, replace or dry-run")
+}
+
+func sortedKeys(counts map[string]*internal.RestoreCounts) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
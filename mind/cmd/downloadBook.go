@@ -28,15 +28,18 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var downloadFormat string
+
 // downloadBookCmd represents the downloadBook command
 var downloadBookCmd = &cobra.Command{
-	Use:        "book",
-	Short:      "Download book from server",
-	Long:       `Given an ID save the file to the file system.`,
+	Use:   "book",
+	Short: "Download book from server",
+	Long: `Given an ID save the file to the file system. With --format the server converts the book first, to deliver
+			it to an e-reader that can't open the uploaded format.`,
 	Args:       cobra.ExactArgs(1),
 	ArgAliases: []string{"id"},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := app.DownloadDocument(args[0]); err != nil {
+		if err := app.DownloadDocument(args[0], downloadFormat); err != nil {
 			if debug {
 				fmt.Fprintln(out, err)
 			}
@@ -49,4 +52,6 @@ var downloadBookCmd = &cobra.Command{
 
 func init() {
 	downloadCmd.AddCommand(downloadBookCmd)
+
+	downloadBookCmd.Flags().StringVarP(&downloadFormat, "format", "f", "", "format to convert to, epub, pdf or txt")
 }
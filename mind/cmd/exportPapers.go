@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat string
+	exportTag    string
+	exportOutput string
+)
+
+// exportPapersCmd represents the exportPapers command
+var exportPapersCmd = &cobra.Command{
+	Use:   "papers",
+	Short: "Export papers as citations",
+	Long: `Export the papers in the library as bibtex, ris or csl-json, optionally only those with a tag. The citations
+			are written to stdout unless an output file is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var w io.Writer = out
+		if exportOutput != "" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := app.ExportPapers(w, exportFormat, exportTag); err != nil {
+			if debug {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+			return errors.New("unable to export papers")
+		}
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(exportPapersCmd)
+
+	exportPapersCmd.Flags().StringVarP(&exportFormat, "format", "f", "bibtex", "citation format, bibtex, ris or csl-json")
+	exportPapersCmd.Flags().StringVarP(&exportTag, "tag", "t", "", "only export papers with this tag")
+	exportPapersCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write the citations to")
+}
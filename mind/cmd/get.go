@@ -22,9 +22,15 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"github.com/Holmes89/alexandria/mind/internal"
 	"github.com/spf13/cobra"
 )
 
+var (
+	listLimit int
+	listAll   bool
+)
+
 // getCmd represents the get command
 var getCmd = &cobra.Command{
 	Use:   "get",
@@ -33,4 +39,10 @@ var getCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(getCmd)
+	getCmd.PersistentFlags().IntVar(&listLimit, "limit", 100, "number of results to list per page")
+	getCmd.PersistentFlags().BoolVar(&listAll, "all", false, "list every result instead of the first page")
+}
+
+func listPage() internal.Page {
+	return internal.Page{Limit: listLimit, All: listAll}
 }
@@ -42,7 +42,7 @@ var getBookCmd = &cobra.Command{
 	Aliases:    []string{"books"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			results, err := app.FindBooks()
+			results, err := app.FindBooks(listPage())
 			if err != nil {
 				if debug {
 					errString := fmt.Errorf("error: %w", err)
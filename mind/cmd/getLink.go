@@ -32,7 +32,7 @@ var getLinkCmd = &cobra.Command{
 	Aliases:    []string{"links"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			results, err := app.FindLinks()
+			results, err := app.FindLinks(listPage())
 			if err != nil {
 				if debug {
 					errString := fmt.Errorf("error: %w", err)
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -25,7 +27,7 @@ var getPaperCmd = &cobra.Command{
 	Aliases:    []string{"papers"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			results, err := app.FindPapers()
+			results, err := app.FindPapers(listPage())
 			if err != nil {
 				if debug {
 					errString := fmt.Errorf("error: %w", err)
@@ -34,9 +36,13 @@ var getPaperCmd = &cobra.Command{
 				return errors.New("unable to fetch papers")
 			}
 			tw := getTabWriter()
-			fmt.Fprintf(tw, "\n %s\t%s\t", "ID", "NAME")
+			fmt.Fprintf(tw, "\n %s\t%s\t%s\t%s\t", "ID", "NAME", "AUTHORS", "YEAR")
 			for _, r := range results {
-				fmt.Fprintf(tw, "\n %s\t%s\t", r.ID, r.DisplayName)
+				year := ""
+				if r.Published != nil {
+					year = strconv.Itoa(r.Published.Year())
+				}
+				fmt.Fprintf(tw, "\n %s\t%s\t%s\t%s\t", r.ID, r.DisplayName, strings.Join(r.Authors, ", "), year)
 			}
 			fmt.Fprintf(tw, "\n\n")
 			tw.Flush()
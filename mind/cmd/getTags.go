@@ -26,7 +26,7 @@ var getTagsCmd = &cobra.Command{
 	Use:   "tags",
 	Short: "List tags in system",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		results, err := app.FindTags()
+		results, err := app.FindTags(listPage())
 		if err != nil {
 			if debug {
 				errString := fmt.Errorf("error: %w", err)
@@ -0,0 +1,23 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Logout of service",
+	Long:  `Revoke the current login and remove the stored tokens`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return app.Logout()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+}
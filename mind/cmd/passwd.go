@@ -0,0 +1,37 @@
+/*
+Copyright © 2020 Joel Holmes <holmes89@gmail.com>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	currentPassword string
+	newPassword     string
+)
+
+// passwdCmd represents the passwd command
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change password",
+	Long:  `Change the password of the logged in user, every login has to sign in again afterwards`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := app.ChangePassword(currentPassword, newPassword); err != nil {
+			return err
+		}
+		fmt.Println("password changed, login again to continue")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+
+	passwdCmd.Flags().StringVarP(&currentPassword, "current", "c", "", "current password")
+	passwdCmd.Flags().StringVarP(&newPassword, "new", "n", "", "new password")
+	passwdCmd.MarkFlagRequired("new")
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type GraphNode struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type GraphEdge struct {
+	ResourceID string `json:"resource_id"`
+	TagID      string `json:"tag_id"`
+}
+
+type GraphDrift struct {
+	MissingNodes []GraphNode `json:"missing_nodes"`
+	ExtraNodes   []GraphNode `json:"extra_nodes"`
+	MissingEdges []GraphEdge `json:"missing_edges"`
+	ExtraEdges   []GraphEdge `json:"extra_edges"`
+	InSync       bool        `json:"in_sync"`
+}
+
+type GraphRebuild struct {
+	Before       GraphDrift     `json:"before"`
+	Wiped        bool           `json:"wiped"`
+	Nodes        map[string]int `json:"nodes"`
+	Edges        int            `json:"edges"`
+	RemovedNodes int            `json:"removed_nodes"`
+	RemovedEdges int            `json:"removed_edges"`
+}
+
+const baseAdminPath = "/admin"
+
+func (app *App) RebuildGraph(wipe bool) (*GraphRebuild, error) {
+	endpoint := fmt.Sprintf("%s%s/graph/rebuild", app.Endpoint, baseAdminPath)
+	client := app.client()
+	resp, err := client.R().SetQueryParam("wipe", fmt.Sprintf("%t", wipe)).Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	entity := &GraphRebuild{}
+	if err := json.Unmarshal(resp.Body(), entity); err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/spf13/viper"
+	"net/http"
+	"os"
 	"time"
 )
 
 type App struct {
-	Endpoint string
-	Token string
-	Config   *viper.Viper
+	Endpoint     string
+	Token        string
+	RefreshToken string `mapstructure:"refresh_token"`
+	TokenExpires int64  `mapstructure:"token_expires"`
+	Config       *viper.Viper
 }
 
 func (app *App) SetConfigurationValue(key, value string) error {
@@ -44,12 +48,101 @@ func (app *App) GetAuthToken(username, password string) error {
 		return err
 	}
 
-	app.Config.Set("token", t.AccessToken)
-	return app.Config.WriteConfig()
+	return app.saveToken(t)
+}
+
+// Logout revokes the current tokens on the server and removes them from the config
+func (app *App) Logout() error {
+	endpoint := fmt.Sprintf("%s/%s", app.Endpoint, "auth/logout")
+	resp, err := resty.New().SetAuthToken(app.Token).R().
+		SetBody(map[string]string{"refresh_token": app.RefreshToken}).
+		Post(endpoint)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() && resp.StatusCode() != http.StatusUnauthorized {
+		return fmt.Errorf("unable to logout: %s", resp.Status())
+	}
+	return app.saveToken(token{})
+}
+
+// ChangePassword sets a new password for the logged in user. The server ends every login when the password changes
+// so the stored tokens are removed as well.
+func (app *App) ChangePassword(current, updated string) error {
+	endpoint := fmt.Sprintf("%s/%s", app.Endpoint, "auth/password")
+	resp, err := app.client().R().
+		SetBody(map[string]string{"current_password": current, "new_password": updated}).
+		Put(endpoint)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		fmt.Printf("error: %s\n", string(resp.Body()))
+		return errors.New("unable to change password")
+	}
+	return app.saveToken(token{})
+}
+
+// client is used for every authenticated request. The access token is refreshed before it expires and once more if
+// the server rejects it, so a login lasts as long as the refresh token.
+func (app *App) client() *resty.Client {
+	if app.RefreshToken != "" && time.Now().Add(time.Minute).After(time.Unix(app.TokenExpires, 0)) {
+		app.refresh()
+	}
+	client := resty.New().SetAuthToken(app.Token)
+	client.SetRetryCount(1).AddRetryCondition(func(r *resty.Response, err error) bool {
+		if err != nil || r.StatusCode() != http.StatusUnauthorized || app.refresh() != nil {
+			return false
+		}
+		client.SetAuthToken(app.Token)
+		return true
+	})
+	return client
+}
+
+func (app *App) refresh() error {
+	if app.RefreshToken == "" {
+		return errors.New("not logged in")
+	}
+	endpoint := fmt.Sprintf("%s/%s", app.Endpoint, "auth/refresh")
+	resp, err := resty.New().R().
+		SetBody(map[string]string{"refresh_token": app.RefreshToken}).
+		Post(endpoint)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return errors.New("session expired, login again")
+	}
+
+	var t token
+	if err := json.Unmarshal(resp.Body(), &t); err != nil {
+		return err
+	}
+	return app.saveToken(t)
+}
+
+// saveToken keeps the tokens in the config file, which is only readable by the current user
+func (app *App) saveToken(t token) error {
+	app.Token = t.AccessToken
+	app.RefreshToken = t.RefreshToken
+	app.TokenExpires = 0
+	if !t.Expires.IsZero() {
+		app.TokenExpires = t.Expires.Unix()
+	}
+
+	app.Config.Set("token", app.Token)
+	app.Config.Set("refresh_token", app.RefreshToken)
+	app.Config.Set("token_expires", app.TokenExpires)
+	if err := app.Config.WriteConfig(); err != nil {
+		return err
+	}
+	return os.Chmod(app.Config.ConfigFileUsed(), 0600)
 }
 
 type token struct {
-	Type        string    `json:"type"`
-	AccessToken string    `json:"token"`
-	Expires     time.Time `json:"expires"`
+	Type         string    `json:"type"`
+	AccessToken  string    `json:"token"`
+	Expires      time.Time `json:"expires"`
+	RefreshToken string    `json:"refresh_token"`
 }
\ No newline at end of file
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Backup struct {
+	ID      string         `json:"id"`
+	Kind    string         `json:"kind"`
+	Parent  string         `json:"parent,omitempty"`
+	Created time.Time      `json:"created"`
+	Size    int64          `json:"size"`
+	Counts  map[string]int `json:"counts,omitempty"`
+}
+
+type BackupManifest struct {
+	ID      string         `json:"id"`
+	Kind    string         `json:"kind"`
+	Parent  string         `json:"parent,omitempty"`
+	Created time.Time      `json:"created"`
+	Counts  map[string]int `json:"counts"`
+}
+
+type RestoreCounts struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+type BackupRestore struct {
+	ID       string                    `json:"id"`
+	Target   string                    `json:"target"`
+	Mode     string                    `json:"mode"`
+	Postgres map[string]*RestoreCounts `json:"postgres"`
+	Graph    map[string]*RestoreCounts `json:"graph"`
+}
+
+type BackupPrune struct {
+	Kept    []string `json:"kept"`
+	Deleted []string `json:"deleted"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+func (app *App) FindBackups() ([]Backup, error) {
+	endpoint := fmt.Sprintf("%s/backups/", app.Endpoint)
+	client := app.client()
+	resp, err := client.R().Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	var entities []Backup
+	if err := json.Unmarshal(resp.Body(), &entities); err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
+
+func (app *App) CreateBackup(incremental, files, covers bool) (*BackupManifest, error) {
+	endpoint := fmt.Sprintf("%s/backup/", app.Endpoint)
+	client := app.client()
+	resp, err := client.R().SetQueryParams(map[string]string{
+		"incremental": fmt.Sprintf("%t", incremental),
+		"files":       fmt.Sprintf("%t", files),
+		"covers":      fmt.Sprintf("%t", covers),
+	}).Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	entity := &BackupManifest{}
+	if err := json.Unmarshal(resp.Body(), entity); err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+func (app *App) RestoreBackup(id, restoreType, mode string) (*BackupRestore, error) {
+	endpoint := fmt.Sprintf("%s/restore/%s", app.Endpoint, id)
+	client := app.client()
+	req := client.R()
+	if restoreType != "" {
+		req.SetQueryParam("type", restoreType)
+	}
+	if mode != "" {
+		req.SetQueryParam("mode", mode)
+	}
+	resp, err := req.Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	entity := &BackupRestore{}
+	if err := json.Unmarshal(resp.Body(), entity); err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+func (app *App) PruneBackups(dryRun bool) (*BackupPrune, error) {
+	endpoint := fmt.Sprintf("%s/backups/prune", app.Endpoint)
+	client := app.client()
+	resp, err := client.R().SetQueryParam("dry_run", fmt.Sprintf("%t", dryRun)).Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	entity := &BackupPrune{}
+	if err := json.Unmarshal(resp.Body(), entity); err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
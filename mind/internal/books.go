@@ -3,16 +3,13 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-resty/resty/v2"
-	"net/url"
-	"path"
 )
 
 const baseBooksPath = "/books"
 
 func (app *App) FindBookByID(id string) (*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseDocumentsPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	results, err := client.R().Get(endpoint)
 	if err != nil {
 		return nil, err
@@ -26,16 +23,19 @@ func (app *App) FindBookByID(id string) (*Document, error) {
 	return entity, nil
 }
 
-func (app *App) FindBooks() ([]*Document, error) {
+func (app *App) FindBooks(page Page) ([]*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseBooksPath)
-	client := resty.New().SetAuthToken(app.Token)
-	results, err := client.R().Get(endpoint)
-	if err != nil {
-		return nil, err
-	}
 
-	var entities []*Document
-	if err := json.Unmarshal(results.Body(), &entities); err != nil {
+	entities := []*Document{}
+	err := app.fetchPages(endpoint, page, func(body []byte) error {
+		var results []*Document
+		if err := json.Unmarshal(body, &results); err != nil {
+			return err
+		}
+		entities = append(entities, results...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -43,41 +43,17 @@ func (app *App) FindBooks() ([]*Document, error) {
 }
 
 func (app *App) DownloadBook(id string) error {
-	entity, err := app.FindBookByID(id)
-	if err != nil {
-		return err
-	}
-	p := entity.Path
-	u, err := url.Parse(p)
-	if err != nil {
-		return err
-	}
-	fname := path.Base(u.Path)
-
-	client := resty.New().SetAuthToken(app.Token)
-	if _, err = client.R().SetOutput(fname).Get(p); err != nil {
-		return err
-	}
-
-	return nil
+	return app.DownloadDocument(id, "")
 }
-func (app *App) UploadBook(path, name string) error {
-	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseBooksPath)
-	client := resty.New().SetAuthToken(app.Token)
-	_, err := client.R().SetFile("file", path).
-		SetFormData(map[string]string{
-			"name": name,
-		}).Post(endpoint)
-	if err != nil {
-		return err
-	}
 
-	return nil
+// UploadBook sends the book in chunks, an upload that was interrupted resumes when the same file is uploaded again
+func (app *App) UploadBook(path, name string) error {
+	return app.uploadDocument(path, name, "book")
 }
 
 func (app *App) TagBook(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, baseBooksPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Post(endpoint)
 	if err != nil {
 		return err
@@ -88,7 +64,7 @@ func (app *App) TagBook(id, tag string) error {
 
 func (app *App) UntagBook(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, baseBooksPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Delete(endpoint)
 	if err != nil {
 		return err
@@ -3,9 +3,8 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-resty/resty/v2"
-	"net/url"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -19,13 +18,22 @@ type Document struct {
 	Tags        []string   `json:"tag_ids" yaml:"tags"`
 	Created     time.Time  `json:"created" yaml:"created"`
 	Updated     *time.Time `json:"updated" yaml:"updated"`
+	Title       string     `json:"title" yaml:"title,omitempty"`
+	Authors     []string   `json:"authors" yaml:"authors,omitempty"`
+	Publisher   string     `json:"publisher" yaml:"publisher,omitempty"`
+	Language    string     `json:"language" yaml:"language,omitempty"`
+	ISBN        string     `json:"isbn" yaml:"isbn,omitempty"`
+	Subjects    []string   `json:"subjects" yaml:"subjects,omitempty"`
+	Published   *time.Time `json:"published" yaml:"published,omitempty"`
+	DOI         string     `json:"doi" yaml:"doi,omitempty"`
+	ArXivID     string     `json:"arxiv_id" yaml:"arxiv_id,omitempty"`
 }
 
 const baseDocumentsPath = "/documents"
 
 func (app *App) FindDocumentByID(id string) (*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseDocumentsPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	results, err := client.R().Get(endpoint)
 	if err != nil {
 		return nil, err
@@ -39,37 +47,45 @@ func (app *App) FindDocumentByID(id string) (*Document, error) {
 	return entity, nil
 }
 
-func (app *App) FindDocuments() ([]*Document, error) {
+func (app *App) FindDocuments(page Page) ([]*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseDocumentsPath)
-	client := resty.New().SetAuthToken(app.Token)
-	results, err := client.R().Get(endpoint)
-	if err != nil {
-		return nil, err
-	}
 
-	var entities []*Document
-	if err := json.Unmarshal(results.Body(), &entities); err != nil {
+	entities := []*Document{}
+	err := app.fetchPages(endpoint, page, func(body []byte) error {
+		var results []*Document
+		if err := json.Unmarshal(body, &results); err != nil {
+			return err
+		}
+		entities = append(entities, results...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return entities, nil
 }
 
-func (app *App) DownloadDocument(id string) error {
+// DownloadDocument saves the document to the working directory, the server converts it first when a format is given
+func (app *App) DownloadDocument(id, format string) error {
 	entity, err := app.FindDocumentByID(id)
 	if err != nil {
 		return err
 	}
-	p := entity.Path
-	u, err := url.Parse(p)
+	fname := path.Base(entity.Name)
+
+	endpoint := fmt.Sprintf("%s/%s/%s/content", app.Endpoint, baseDocumentsPath, id)
+	req := app.client().R().SetQueryParam("download", "true")
+	if format != "" {
+		fname = strings.TrimSuffix(fname, path.Ext(fname)) + "." + format
+		req.SetQueryParam("format", format)
+	}
+	resp, err := req.SetOutput(fname).Get(endpoint)
 	if err != nil {
 		return err
 	}
-	fname := path.Base(u.Path)
-
-	client := resty.New().SetAuthToken(app.Token)
-	if _, err = client.R().SetOutput(fname).Get(p); err != nil {
-		return err
+	if resp.IsError() {
+		return fmt.Errorf("unable to download document: %s", resp.Status())
 	}
 
 	return nil
@@ -85,7 +101,7 @@ func (app *App) UpdateDocument(id, displayName, description, docType string) err
 		Type:        docType,
 	}
 
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(doc).Patch(endpoint)
 	if err != nil {
 		return err
@@ -97,7 +113,7 @@ func (app *App) UpdateDocument(id, displayName, description, docType string) err
 func (app *App) DeleteDocument(id string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseDocumentsPath, id)
 
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().Delete(endpoint)
 	if err != nil {
 		return err
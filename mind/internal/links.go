@@ -3,7 +3,6 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-resty/resty/v2"
 	"time"
 )
 
@@ -18,16 +17,19 @@ type Link struct {
 
 const baseLinkPath = "/links"
 
-func (app *App) FindLinks() ([]Link, error) {
+func (app *App) FindLinks(page Page) ([]Link, error) {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseLinkPath)
-	client := resty.New().SetAuthToken(app.Token)
-	results, err := client.R().Get(endpoint)
-	if err != nil {
-		return nil, err
-	}
 
-	var entities []Link
-	if err := json.Unmarshal(results.Body(), &entities); err != nil {
+	entities := []Link{}
+	err := app.fetchPages(endpoint, page, func(body []byte) error {
+		var results []Link
+		if err := json.Unmarshal(body, &results); err != nil {
+			return err
+		}
+		entities = append(entities, results...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -35,7 +37,7 @@ func (app *App) FindLinks() ([]Link, error) {
 }
 func (app *App) FindLinkByID(id string) (entity Link, err error) {
 	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseLinkPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	results, err := client.R().Get(endpoint)
 	if err != nil {
 		return entity, err
@@ -50,7 +52,7 @@ func (app *App) FindLinkByID(id string) (entity Link, err error) {
 
 func (app *App) CreateLink(url string) error {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseLinkPath)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(Link{Link: url}).Post(endpoint)
 	if err != nil {
 		return err
@@ -61,7 +63,7 @@ func (app *App) CreateLink(url string) error {
 
 func (app *App) TagLink(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, baseLinkPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Post(endpoint)
 	if err != nil {
 		return err
@@ -72,7 +74,7 @@ func (app *App) TagLink(id, tag string) error {
 
 func (app *App) UntagLink(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, baseLinkPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Delete(endpoint)
 	if err != nil {
 		return err
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Page controls how much of a list is fetched, a zero limit leaves it to the server and All follows the
+// next cursor until every page has been read
+type Page struct {
+	Limit int
+	All   bool
+}
+
+func (app *App) fetchPages(endpoint string, page Page, collect func(body []byte) error) error {
+	client := app.client()
+	cursor := ""
+	for {
+		req := client.R()
+		if page.Limit > 0 {
+			req.SetQueryParam("limit", strconv.Itoa(page.Limit))
+		}
+		if cursor != "" {
+			req.SetQueryParam("cursor", cursor)
+		}
+		results, err := req.Get(endpoint)
+		if err != nil {
+			return err
+		}
+		if results.IsError() {
+			return fmt.Errorf("unexpected response: %s", results.Status())
+		}
+		if err := collect(results.Body()); err != nil {
+			return err
+		}
+
+		cursor = results.Header().Get("X-Next-Cursor")
+		if !page.All || cursor == "" {
+			return nil
+		}
+	}
+}
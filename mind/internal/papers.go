@@ -3,16 +3,14 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-resty/resty/v2"
-	"net/url"
-	"path"
+	"io"
 )
 
 const basePapersPath = "/papers"
 
 func (app *App) FindPaperByID(id string) (*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, basePapersPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	results, err := client.R().Get(endpoint)
 	if err != nil {
 		return nil, err
@@ -26,16 +24,19 @@ func (app *App) FindPaperByID(id string) (*Document, error) {
 	return entity, nil
 }
 
-func (app *App) FindPapers() ([]*Document, error) {
+func (app *App) FindPapers(page Page) ([]*Document, error) {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, basePapersPath)
-	client := resty.New().SetAuthToken(app.Token)
-	results, err := client.R().Get(endpoint)
-	if err != nil {
-		return nil, err
-	}
 
-	var entities []*Document
-	if err := json.Unmarshal(results.Body(), &entities); err != nil {
+	entities := []*Document{}
+	err := app.fetchPages(endpoint, page, func(body []byte) error {
+		var results []*Document
+		if err := json.Unmarshal(body, &results); err != nil {
+			return err
+		}
+		entities = append(entities, results...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -43,42 +44,36 @@ func (app *App) FindPapers() ([]*Document, error) {
 }
 
 func (app *App) DownloadPaper(id string) error {
-	entity, err := app.FindPaperByID(id)
-	if err != nil {
-		return err
-	}
-	p := entity.Path
-	u, err := url.Parse(p)
-	if err != nil {
-		return err
-	}
-	fname := path.Base(u.Path)
-
-	client := resty.New().SetAuthToken(app.Token)
-	if _, err = client.R().SetOutput(fname).Get(p); err != nil {
-		return err
-	}
-
-	return nil
+	return app.DownloadDocument(id, "")
 }
 
 func (app *App) UploadPapers(path, name string) error {
-	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, basePapersPath)
-	client := resty.New().SetAuthToken(app.Token)
-	_, err := client.R().SetFile("file", path).
-		SetFormData(map[string]string{
-			"name": name,
-		}).Post(endpoint)
+	return app.uploadDocument(path, name, "paper")
+}
+
+// ExportPapers writes the papers as citations in the given format, only those with the tag when one is set
+func (app *App) ExportPapers(w io.Writer, format, tag string) error {
+	endpoint := fmt.Sprintf("%s/%s/export", app.Endpoint, basePapersPath)
+	client := app.client()
+	req := client.R().SetQueryParam("format", format)
+	if tag != "" {
+		req.SetQueryParam("tag", tag)
+	}
+	resp, err := req.Get(endpoint)
 	if err != nil {
 		return err
 	}
+	if resp.IsError() {
+		return fmt.Errorf("unable to export papers: %s", resp.Status())
+	}
 
-	return nil
+	_, err = w.Write(resp.Body())
+	return err
 }
 
 func (app *App) TagPaper(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, basePapersPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Post(endpoint)
 	if err != nil {
 		return err
@@ -89,7 +84,7 @@ func (app *App) TagPaper(id, tag string) error {
 
 func (app *App) UntagPaper(id, tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/%s/tags/", app.Endpoint, basePapersPath, id)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(tagRequest{Tag: tag}).Delete(endpoint)
 	if err != nil {
 		return err
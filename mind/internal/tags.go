@@ -3,7 +3,6 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-resty/resty/v2"
 )
 
 type Tag struct {
@@ -14,16 +13,19 @@ type Tag struct {
 
 const baseTagPath = "/tags"
 
-func (app *App) FindTags() ([]Tag, error) {
+func (app *App) FindTags(page Page) ([]Tag, error) {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseTagPath)
-	client := resty.New().SetAuthToken(app.Token)
-	results, err := client.R().Get(endpoint)
-	if err != nil {
-		return nil, err
-	}
 
-	var entities []Tag
-	if err := json.Unmarshal(results.Body(), &entities); err != nil {
+	entities := []Tag{}
+	err := app.fetchPages(endpoint, page, func(body []byte) error {
+		var results []Tag
+		if err := json.Unmarshal(body, &results); err != nil {
+			return err
+		}
+		entities = append(entities, results...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -31,7 +33,7 @@ func (app *App) FindTags() ([]Tag, error) {
 }
 
 func (app *App) TagMap() (map[string]string, error) {
-	tags, err := app.FindTags()
+	tags, err := app.FindTags(Page{All: true})
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +48,7 @@ func (app *App) TagMap() (map[string]string, error) {
 
 func (app *App) CreateTag(tag string) error {
 	endpoint := fmt.Sprintf("%s/%s/", app.Endpoint, baseTagPath)
-	client := resty.New().SetAuthToken(app.Token)
+	client := app.client()
 	_, err := client.R().SetBody(Tag{DisplayName: tag}).Post(endpoint)
 	if err != nil {
 		return err
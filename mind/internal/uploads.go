@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const baseUploadsPath = "/uploads"
+
+const (
+	chunkSize    = 8 << 20
+	chunkRetries = 3
+)
+
+type upload struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	Offset      int64  `json:"offset"`
+	SHA256      string `json:"sha256"`
+}
+
+// ErrUploadInterrupted is returned when chunks keep failing, running the same upload again picks up where it stopped
+var ErrUploadInterrupted = errors.New("upload interrupted, run the command again to resume")
+
+// uploadDocument sends the file in chunks. The upload is remembered in the config by the checksum of the file until
+// the document is created, so uploading the same file again resumes from the bytes the server already has.
+func (app *App) uploadDocument(path, name, docType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	u, err := app.resumeUpload(checksum, info.Size())
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		u, err = app.createUpload(upload{
+			Name:        filepath.Base(path),
+			DisplayName: name,
+			Type:        docType,
+			Size:        info.Size(),
+			SHA256:      checksum,
+		})
+		if err != nil {
+			return err
+		}
+		if err := app.rememberUpload(checksum, u.ID); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, chunkSize)
+	for failures := 0; u.Offset < u.Size; {
+		n, err := f.ReadAt(buf, u.Offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		offset, err := app.writeChunk(u.ID, u.Offset, buf[:n])
+		if err != nil {
+			if failures++; failures >= chunkRetries {
+				return fmt.Errorf("%w: %v", ErrUploadInterrupted, err)
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+			continue
+		}
+		u.Offset, failures = offset, 0
+	}
+
+	return app.completeUpload(u.ID, checksum)
+}
+
+// resumeUpload finds the upload started for the same file, it is nil when there is none left on the server
+func (app *App) resumeUpload(checksum string, size int64) (*upload, error) {
+	id := app.Config.GetStringMapString("uploads")[checksum]
+	if id == "" {
+		return nil, nil
+	}
+	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseUploadsPath, id)
+	resp, err := app.client().R().Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, app.forgetUpload(checksum)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("unable to resume upload: %s", resp.Status())
+	}
+
+	u := &upload{}
+	if err := json.Unmarshal(resp.Body(), u); err != nil {
+		return nil, err
+	}
+	if u.Size != size {
+		return nil, app.forgetUpload(checksum)
+	}
+	return u, nil
+}
+
+func (app *App) createUpload(req upload) (*upload, error) {
+	endpoint := fmt.Sprintf("%s/%s", app.Endpoint, baseUploadsPath)
+	resp, err := app.client().R().SetBody(req).Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("unable to start upload: %s", resp.Status())
+	}
+
+	u := &upload{}
+	if err := json.Unmarshal(resp.Body(), u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// writeChunk returns the offset to continue from, on a conflict it is the one the server expects
+func (app *App) writeChunk(id string, offset int64, chunk []byte) (int64, error) {
+	endpoint := fmt.Sprintf("%s/%s/%s", app.Endpoint, baseUploadsPath, id)
+	resp, err := app.client().R().
+		SetHeader("Content-Type", "application/offset+octet-stream").
+		SetHeader("Upload-Offset", strconv.FormatInt(offset, 10)).
+		SetBody(chunk).
+		Patch(endpoint)
+	if err != nil {
+		return offset, err
+	}
+	if resp.IsError() && resp.StatusCode() != http.StatusConflict {
+		return offset, fmt.Errorf("unable to upload chunk: %s", resp.Status())
+	}
+	next, err := strconv.ParseInt(resp.Header().Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return offset, fmt.Errorf("unable to upload chunk: %s", resp.Status())
+	}
+	return next, nil
+}
+
+func (app *App) completeUpload(id, checksum string) error {
+	endpoint := fmt.Sprintf("%s/%s/%s/complete", app.Endpoint, baseUploadsPath, id)
+	resp, err := app.client().R().SetBody(map[string]string{"sha256": checksum}).Post(endpoint)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusCreated:
+		return app.forgetUpload(checksum)
+	case http.StatusConflict:
+		// either the file is already in the library or the server is missing bytes, the latter resumes next time
+		duplicate := struct {
+			ID string `json:"id"`
+		}{}
+		if json.Unmarshal(resp.Body(), &duplicate) == nil && duplicate.ID != "" {
+			app.forgetUpload(checksum)
+			return fmt.Errorf("file already uploaded as document %s", duplicate.ID)
+		}
+	case http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType, http.StatusNotFound:
+		// the server dropped the upload so the next attempt starts over
+		app.forgetUpload(checksum)
+	}
+	return fmt.Errorf("unable to complete upload: %s", resp.Status())
+}
+
+// the uploads in progress are kept in the config as a map of file checksum to upload id
+func (app *App) rememberUpload(checksum, id string) error {
+	uploads := app.Config.GetStringMapString("uploads")
+	uploads[checksum] = id
+	app.Config.Set("uploads", uploads)
+	return app.Config.WriteConfig()
+}
+
+func (app *App) forgetUpload(checksum string) error {
+	uploads := app.Config.GetStringMapString("uploads")
+	delete(uploads, checksum)
+	app.Config.Set("uploads", uploads)
+	return app.Config.WriteConfig()
+}
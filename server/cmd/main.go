@@ -6,11 +6,17 @@ import (
 	"alexandria/internal/common"
 	"alexandria/internal/database"
 	"alexandria/internal/documents"
+	"alexandria/internal/enrichment"
+	"alexandria/internal/files"
+	"alexandria/internal/graph"
 	"alexandria/internal/journal"
 	"alexandria/internal/links"
 	"alexandria/internal/network"
 	"alexandria/internal/papers"
+	"alexandria/internal/search"
+	"alexandria/internal/sharing"
 	"alexandria/internal/tags"
+	"alexandria/internal/uploads"
 	"alexandria/internal/user"
 	"context"
 	"fmt"
@@ -22,6 +28,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -37,12 +44,16 @@ func NewApp() *fx.App {
 		fx.Provide(
 			config.LoadPostgresDatabaseConfig,
 			config.LoadNeo4jConfig,
+			config.LoadOIDCConfig,
+			config.LoadEnrichmentConfig,
 			database.NewPostgresDatabase,
 			database.NewNeo4jDatabase,
 			config.LoadBucketConfig,
-			common.NewGCPBucketStorage,
+			common.NewURLSigner,
+			common.NewBucketStorage,
 			common.NewBucketDocumentStorage,
 			common.NewBackupStorage,
+			common.NewCoverStorage,
 			documents.NewDocumentService,
 			books.NewBookService,
 			papers.NewPaperService,
@@ -50,18 +61,34 @@ func NewApp() *fx.App {
 			backup.NewService,
 			backup.NewSystemAggregator,
 			network.NewService,
+			search.NewService,
+			search.NewIndexer,
+			sharing.NewService,
+			graph.NewService,
+			enrichment.NewService,
+			uploads.NewService,
 			database.NewDocumentRepository,
 			database.NewUserPostgresRepository,
+			database.NewTokenRepository,
+			database.NewKeyRepository,
+			database.NewAuditRepository,
 			database.NewJournalRepository,
 			database.NewLinksRepository,
 			database.NewTagsRepository,
 			database.NewBackupRepository,
+			database.NewSearchRepository,
+			database.NewGraphRepository,
+			database.NewSharesRepository,
+			database.NewUploadsRepository,
 			user.NewUserService,
+			user.NewOIDCProvider,
 			NewMux,
 		),
 		fx.Invoke(documents.MakeDocumentHandler,
 			books.MakeBookHandler,
 			user.MakeLoginHandler,
+			user.MakeUserHandler,
+			user.MakeOIDCHandler,
 			papers.MakePaperHandler,
 			journal.MakeJournalHandler,
 			links.MakeLinksHandler,
@@ -69,22 +96,30 @@ func NewApp() *fx.App {
 			backup.MakeBackupHandler,
 			backup.NewBackupRunner,
 			network.MakeNetworkHandler,
+			search.MakeSearchHandler,
+			files.MakeFileHandler,
+			graph.MakeGraphHandler,
+			sharing.MakeShareHandler,
+			enrichment.MakeEnrichmentHandler,
+			uploads.MakeUploadHandler,
+			database.NewGraphRelay,
 		),
 		fx.Logger(NewLogger()),
 	)
 }
-func NewMux(lc fx.Lifecycle) *mux.Router {
+func NewMux(lc fx.Lifecycle, shares sharing.Service, users user.Service) *mux.Router {
 	logrus.Info("creating mux")
 
 	router := mux.NewRouter()
 
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "X-Share-Token", "Upload-Offset"})
 	originsOk := handlers.AllowedOrigins([]string{"*"})
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "OPTIONS", "DELETE"})
-	cors := handlers.CORS(originsOk, headersOk, methodsOk)
+	exposedOk := handlers.ExposedHeaders([]string{"Link", "X-Next-Cursor", "Location", "Upload-Offset", "Upload-Length"})
+	cors := handlers.CORS(originsOk, headersOk, methodsOk, exposedOk)
 
-	router.Use(cors, authenticate)
-	handler := (cors)((authenticate)(router))
+	router.Use(cors, authorize(shares))
+	handler := (cors)((authenticate(users))(router))
 
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -118,46 +153,187 @@ func EndpointLogging(h http.Handler) http.Handler {
 	})
 }
 
-func authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func authenticate(users user.Service) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		// Exclude auth
-		if strings.Contains(r.URL.Path, "auth") && r.Method == "GET" {
-			next.ServeHTTP(w, r) // call original
-			return
-		}
+			// Exclude auth and signed file urls, which are verified by the file handler
+			if isPublicRoute(r) {
+				next.ServeHTTP(w, r) // call original
+				return
+			}
 
-		// sample token string taken from the New example
-		tokenString := r.Header.Get("Authorization")
-		tokenString = strings.Replace(tokenString, "Bearer ", "", -1)
-		if tokenString == "" {
-			http.Error(w, "Authorization Header Required", http.StatusUnauthorized)
-			return
-		}
-		// Parse takes the token string and a function for looking up the key. The latter is especially
-		// useful if you use multiple keys for your application.  The standard is to use 'kid' in the
-		// head of the token to identify which key to use, but the parsed token (head and claims) is provided
-		// to the callback, providing flexibility.
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			// Public links are checked against their share by the authorizer
+			if r.Header.Get("Authorization") == "" && (shareToken(r) != "" || strings.HasPrefix(r.URL.Path, "/shares/public/")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// API keys carry their own scope which limits the methods they can use
+			if apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "ApiKey "); apiKey != r.Header.Get("Authorization") {
+				identity, err := users.AuthenticateKey(r.Context(), apiKey)
+				if err == user.ErrInvalidKey {
+					http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Server Error", http.StatusInternalServerError)
+					return
+				}
+				if !identity.Permits(r.Method) {
+					http.Error(w, "API key scope does not allow "+r.Method, http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(common.WithIdentity(r.Context(), identity)))
+				return
+			}
+
+			// sample token string taken from the New example
+			tokenString := r.Header.Get("Authorization")
+			tokenString = strings.Replace(tokenString, "Bearer ", "", -1)
+			if tokenString == "" {
+				http.Error(w, "Authorization Header Required", http.StatusUnauthorized)
+				return
 			}
+			// Parse takes the token string and a function for looking up the key. The latter is especially
+			// useful if you use multiple keys for your application.  The standard is to use 'kid' in the
+			// head of the token to identify which key to use, but the parsed token (head and claims) is provided
+			// to the callback, providing flexibility.
+			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				// Don't forget to validate the alg is what you expect:
+				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				}
 
-			// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-			return []byte(os.Getenv("JWT_SECRET")), nil
+				// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
+				return []byte(os.Getenv("JWT_SECRET")), nil
+			})
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+				// repositories limit every query to the library of the token subject
+				sub, _ := claims["sub"].(string)
+				role, _ := claims["role"].(string)
+				jti, _ := claims["jti"].(string)
+				exp, _ := claims["exp"].(float64)
+				if sub == "" || jti == "" {
+					http.Error(w, "Invalid Token", http.StatusUnauthorized)
+					return
+				}
+				// logged out tokens stay valid until they expire unless they are on the revocation list
+				revoked, err := users.IsRevoked(r.Context(), jti)
+				if err != nil {
+					http.Error(w, "Server Error", http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					http.Error(w, "Token Revoked", http.StatusUnauthorized)
+					return
+				}
+				ctx := common.WithIdentity(r.Context(), common.Identity{
+					ID:      sub,
+					Role:    role,
+					TokenID: jti,
+					Expires: time.Unix(int64(exp), 0),
+				})
+				next.ServeHTTP(w, r.WithContext(ctx)) // call original
+			} else {
+				http.Error(w, "Invalid Token", http.StatusUnauthorized)
+				return
+			}
 		})
+	}
+}
 
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
+// sharedPrefixes are the prefixes whose {id} is a document, link or tag that can be shared
+var sharedPrefixes = []string{"/documents/", "/books/", "/papers/", "/links/", "/tags/"}
 
-		if _, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			next.ServeHTTP(w, r) // call original
-		} else {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-	})
+// sharedRoutes are the only routes a share reaches and the role each needs, the rest of a shared resource's routes,
+// such as deleting or merging it, are left to its owner
+var sharedRoutes = map[string]sharing.Role{
+	"GET /documents/{id}":          sharing.RoleViewer,
+	"GET /documents/{id}/content":  sharing.RoleViewer,
+	"HEAD /documents/{id}/content": sharing.RoleViewer,
+	"PATCH /documents/{id}":        sharing.RoleEditor,
+	"POST /documents/{id}/enrich":  sharing.RoleEditor,
+	"GET /books/{id}":              sharing.RoleViewer,
+	"POST /books/{id}/tags/":       sharing.RoleEditor,
+	"DELETE /books/{id}/tags/":     sharing.RoleEditor,
+	"GET /papers/{id}":             sharing.RoleViewer,
+	"POST /papers/{id}/tags/":      sharing.RoleEditor,
+	"DELETE /papers/{id}/tags/":    sharing.RoleEditor,
+	"GET /links/{id}":              sharing.RoleViewer,
+	"POST /links/{id}/tags/":       sharing.RoleEditor,
+	"DELETE /links/{id}/tags/":     sharing.RoleEditor,
+	"GET /tags/{id}/resources/":    sharing.RoleViewer,
+}
+
+// authorize runs once the route is matched and checks the caller's role on the resource in the path. Owners pass
+// straight through, callers with a share keep their identity and get a grant for that one resource.
+func authorize(shares sharing.Service) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			_, authenticated := common.IdentityFromContext(ctx)
+			token := shareToken(r)
+
+			template, _ := mux.CurrentRoute(r).GetPathTemplate()
+			id, scoped := mux.Vars(r)["id"], false
+			for _, prefix := range sharedPrefixes {
+				scoped = scoped || (id != "" && strings.HasPrefix(template, prefix))
+			}
+
+			if !scoped {
+				if !authenticated && !strings.HasPrefix(template, "/shares/public/") && !isPublicRoute(r) {
+					http.Error(w, "Authorization Header Required", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			access, err := shares.Authorize(ctx, id, token)
+			if err == sharing.ErrNotFound {
+				common.MakeError(w, http.StatusNotFound, "auth", "Not Found", "authorize")
+				return
+			}
+			if err != nil {
+				common.MakeError(w, http.StatusInternalServerError, "auth", "Server Error", "authorize")
+				return
+			}
+			if access.Role == sharing.RoleOwner {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			required, shared := sharedRoutes[r.Method+" "+template]
+			if !shared || !access.Allows(required) {
+				common.MakeError(w, http.StatusForbidden, "auth", "Forbidden", "authorize")
+				return
+			}
+			ctx = common.WithGrant(ctx, common.Grant{Resource: id, Owner: access.Owner, Role: access.Role, Token: token})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// isPublicRoute is true for requests that don't need a token
+func isPublicRoute(r *http.Request) bool {
+	return (r.URL.Path == "/auth/" && r.Method == "GET") ||
+		(strings.HasPrefix(r.URL.Path, "/auth/oidc/") && r.Method == "GET") ||
+		(r.URL.Path == "/auth/register" && r.Method == "POST") ||
+		(r.URL.Path == "/auth/refresh" && r.Method == "POST") ||
+		(strings.HasPrefix(r.URL.Path, "/files/") && r.URL.Query().Get("signature") != "")
+}
+
+// shareToken is the public link token, sent as a header or as the share query parameter for links opened in a browser
+func shareToken(r *http.Request) string {
+	if token := r.Header.Get("X-Share-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("share")
 }
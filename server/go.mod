@@ -20,9 +20,11 @@ require (
 	github.com/h2non/filetype v1.0.12
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
 	github.com/iancoleman/strcase v0.0.0-20191112232945-16388991a334
+	github.com/klauspost/compress v1.11.13
 	github.com/lib/pq v1.3.0
 	github.com/neo4j/neo4j-go-driver v1.7.4
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v1.0.0 // indirect
 	go.uber.org/fx v1.10.0
@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// archiveWriter writes a zstd compressed tarball recording the size and checksum of every entry for the manifest
+type archiveWriter struct {
+	tw    *tar.Writer
+	zw    *zstd.Encoder
+	files []FileEntry
+}
+
+func newArchiveWriter(w io.Writer) (*archiveWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveWriter{
+		tw:    tar.NewWriter(zw),
+		zw:    zw,
+		files: []FileEntry{},
+	}, nil
+}
+
+func (a *archiveWriter) writeJSON(name string, v interface{}) error {
+	marshalled, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return a.writeFile(name, int64(len(marshalled)), time.Now(), bytes.NewReader(marshalled))
+}
+
+func (a *archiveWriter) writeFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(a.tw, io.TeeReader(r, hash))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: wrote %d bytes, expected %d", name, n, size)
+	}
+
+	a.files = append(a.files, FileEntry{
+		Name:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// close appends the manifest and flushes the archive
+func (a *archiveWriter) close(m *Manifest) error {
+	m.Files = a.files
+	marshalled, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    manifestEntry,
+		Mode:    0644,
+		Size:    int64(len(marshalled)),
+		ModTime: m.Created,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(marshalled); err != nil {
+		return err
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.zw.Close()
+}
+
+// readArchive hands every entry of the archive to fn, verifying size and checksum against the manifest. Entries the
+// manifest doesn't know about are rejected. An entry is only verified once fn has read it, so fn mustn't write it
+// anywhere that outlives a failed read.
+func readArchive(r io.Reader, m Manifest, fn func(name string, r io.Reader) error) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	seen := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == manifestEntry {
+			continue
+		}
+
+		expected, ok := m.checksum(hdr.Name)
+		if !ok {
+			return fmt.Errorf("%s is not in the manifest", hdr.Name)
+		}
+
+		hash := sha256.New()
+		counter := &countingReader{r: io.TeeReader(tr, hash)}
+		if err := fn(hdr.Name, counter); err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+			return err
+		}
+
+		if counter.n != expected.Size || hex.EncodeToString(hash.Sum(nil)) != expected.SHA256 {
+			return fmt.Errorf("%s does not match its checksum", hdr.Name)
+		}
+		seen++
+	}
+
+	if seen != len(m.Files) {
+		return fmt.Errorf("archive has %d entries, manifest lists %d", seen, len(m.Files))
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// stagedFiles keeps the document files and covers of the archives being restored on local disk, so nothing is written
+// to the buckets before every archive of the restore has been verified
+type stagedFiles struct {
+	dir   string
+	names []string
+	paths map[string]string
+}
+
+func newStagedFiles() (*stagedFiles, error) {
+	dir, err := ioutil.TempDir("", "alexandria-restore-")
+	if err != nil {
+		return nil, err
+	}
+	return &stagedFiles{dir: dir, names: []string{}, paths: map[string]string{}}, nil
+}
+
+// add stages an entry, a later archive of the chain replaces the copy staged from an earlier one
+func (s *stagedFiles) add(name string, r io.Reader) error {
+	f, err := ioutil.TempFile(s.dir, "entry-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if previous, ok := s.paths[name]; ok {
+		os.Remove(previous)
+	} else {
+		s.names = append(s.names, name)
+	}
+	s.paths[name] = f.Name()
+	return nil
+}
+
+// each hands the staged entries to fn in the order they were first staged
+func (s *stagedFiles) each(fn func(name string, r io.Reader) error) error {
+	for _, name := range s.names {
+		f, err := os.Open(s.paths[name])
+		if err != nil {
+			return err
+		}
+		err = fn(name, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *stagedFiles) close() {
+	if err := os.RemoveAll(s.dir); err != nil {
+		logrus.WithError(err).WithField("dir", s.dir).Warn("unable to remove staged restore files")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 type backupHandler struct {
@@ -17,20 +19,83 @@ func MakeBackupHandler(r *mux.Router, service Service) http.Handler {
 		service: service,
 	}
 
-	r.HandleFunc("/backup/", h.Backup).Methods("POST")
-	r.HandleFunc("/restore/{id}", h.Restore).Methods("POST")
+	// backups cover every library so only admins can take or restore them
+	r.Handle("/backup/", common.RequireAdmin(http.HandlerFunc(h.Backup))).Methods("POST")
+	r.Handle("/backups/", common.RequireAdmin(http.HandlerFunc(h.List))).Methods("GET")
+	r.Handle("/backups/prune", common.RequireAdmin(http.HandlerFunc(h.Prune))).Methods("POST")
+	r.Handle("/backups/runs", common.RequireAdmin(http.HandlerFunc(h.Runs))).Methods("GET")
+	r.Handle("/restore/{id}", common.RequireAdmin(http.HandlerFunc(h.Restore))).Methods("POST")
 
 	return r
 }
 
 func (h *backupHandler) Backup(w http.ResponseWriter, r *http.Request) {
 
-	if err := h.service.Backup(); err != nil {
+	opts, err := parseOptions(r.URL.Query())
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "backup", "invalid backup options", "backup")
+		return
+	}
+
+	opts.Trigger = TriggerManual
+	manifest, err := h.service.Backup(opts)
+	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "backup", "unable to backup", "backup")
 		return
 	}
 
-	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+	common.EncodeResponse(r.Context(), w, manifest)
+}
+
+func (h *backupHandler) List(w http.ResponseWriter, r *http.Request) {
+
+	backups, err := h.service.List()
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "backup", "unable to list backups", "list")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, backups)
+}
+
+func (h *backupHandler) Prune(w http.ResponseWriter, r *http.Request) {
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			common.MakeError(w, http.StatusBadRequest, "backup", "invalid dry_run", "prune")
+			return
+		}
+	}
+
+	result, err := h.service.Prune(dryRun)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "backup", "unable to prune backups", "prune")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, result)
+}
+
+func (h *backupHandler) Runs(w http.ResponseWriter, r *http.Request) {
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			common.MakeError(w, http.StatusBadRequest, "backup", "invalid limit", "runs")
+			return
+		}
+	}
+
+	runs, err := h.service.Runs(limit)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "backup", "unable to list runs", "runs")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, runs)
 }
 
 func (h *backupHandler) Restore(w http.ResponseWriter, r *http.Request) {
@@ -51,12 +116,37 @@ func (h *backupHandler) Restore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logrus.WithFields(logrus.Fields{"id": id, "type": restoreType}).Info("attempting to restore database")
+	mode := ParseRestoreMode(v.Get("mode"))
 
-	if err := h.service.Restore(id, restoreType); err != nil {
+	if mode == ModeUnknown {
+		common.MakeError(w, http.StatusBadRequest, "backup", "unsupported restore mode", "restore")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"id": id, "type": restoreType, "mode": mode}).Info("attempting to restore database")
+
+	report, err := h.service.Restore(id, restoreType, mode)
+	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "backup", "unable to restore", "restore")
 		return
 	}
 
-	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+	common.EncodeResponse(r.Context(), w, report)
+}
+
+func parseOptions(v url.Values) (opts Options, err error) {
+	flags := map[string]*bool{
+		"incremental": &opts.Incremental,
+		"files":       &opts.IncludeFiles,
+		"covers":      &opts.IncludeCovers,
+	}
+	for name, flag := range flags {
+		if v.Get(name) == "" {
+			continue
+		}
+		if *flag, err = strconv.ParseBool(v.Get(name)); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
 }
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is bumped whenever the layout of the archive or its data files changes
+const SchemaVersion = 1
+
+const (
+	KindFull        = "full"
+	KindIncremental = "incremental"
+	KindLegacy      = "legacy"
+)
+
+const (
+	manifestEntry = "manifest.json"
+	indexEntry    = "data/ids.json"
+	docsEntry     = "data/documents.json"
+	journalEntry  = "data/journal_entries.json"
+	linksEntry    = "data/links.json"
+	tagsEntry     = "data/tags.json"
+	filesDir      = "files/"
+	coversDir     = "covers/"
+)
+
+// Manifest describes a backup archive. It is stored as the last entry of the archive and as a separate object next
+// to it so the chain of incremental backups can be walked without downloading every archive.
+type Manifest struct {
+	SchemaVersion  int            `json:"schema_version"`
+	ID             string         `json:"id"`
+	Kind           string         `json:"kind"`
+	Parent         string         `json:"parent,omitempty"`
+	Depth          int            `json:"depth"`
+	Since          *time.Time     `json:"since,omitempty"`
+	Created        time.Time      `json:"created"`
+	Counts         map[string]int `json:"counts"`
+	Files          []FileEntry    `json:"files"`
+	IncludesFiles  bool           `json:"includes_files"`
+	IncludesCovers bool           `json:"includes_covers"`
+}
+
+type FileEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Index lists every id present when a backup was taken, restoring an incremental chain drops anything not in the
+// index of the last backup so deletions are carried across.
+type Index struct {
+	Docs    []string `json:"documents"`
+	Journal []string `json:"journal_entries"`
+	Links   []string `json:"links"`
+	Tags    []string `json:"tags"`
+}
+
+type Options struct {
+	Incremental   bool   `json:"incremental"`
+	IncludeFiles  bool   `json:"include_files"`
+	IncludeCovers bool   `json:"include_covers"`
+	Trigger       string `json:"trigger,omitempty"`
+}
+
+func (m Manifest) checksum(name string) (FileEntry, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return FileEntry{}, false
+}
+
+func archiveName(id string) string {
+	return fmt.Sprintf("%s-%s%s", fileNameBase, id, archiveSuffix)
+}
+
+func manifestName(id string) string {
+	return fmt.Sprintf("%s-%s%s", fileNameBase, id, manifestSuffix)
+}
+
+func legacyName(id string) string {
+	return fmt.Sprintf("%s-%s%s", fileNameBase, id, legacySuffix)
+}
+
+const (
+	manifestSuffix = ".manifest.json"
+	archiveSuffix  = ".tar.zst"
+	legacySuffix   = ".json"
+)
+
+// parseBackupKey splits an object key into the backup id and the kind of file it is
+func parseBackupKey(key string) (id int64, suffix string, ok bool) {
+	prefix := fileNameBase + "-"
+	if !strings.HasPrefix(key, prefix) {
+		return 0, "", false
+	}
+	for _, suffix = range []string{manifestSuffix, archiveSuffix, legacySuffix} {
+		if strings.HasSuffix(key, suffix) {
+			break
+		}
+		suffix = ""
+	}
+	if suffix == "" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix), 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, suffix, true
+}
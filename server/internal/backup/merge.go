@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"alexandria/internal/documents"
+	"alexandria/internal/journal"
+	"alexandria/internal/links"
+	"alexandria/internal/tags"
+)
+
+// merge folds the rows of a later backup into b, rows with the same id are replaced by the newer copy
+func (b *Backup) merge(o Backup) {
+	docs := make(map[string]int, len(b.Docs))
+	for i, d := range b.Docs {
+		docs[d.ID] = i
+	}
+	for _, d := range o.Docs {
+		if i, ok := docs[d.ID]; ok {
+			b.Docs[i] = d
+			continue
+		}
+		docs[d.ID] = len(b.Docs)
+		b.Docs = append(b.Docs, d)
+	}
+
+	entries := make(map[string]int, len(b.Journal))
+	for i, e := range b.Journal {
+		entries[e.ID] = i
+	}
+	for _, e := range o.Journal {
+		if i, ok := entries[e.ID]; ok {
+			b.Journal[i] = e
+			continue
+		}
+		entries[e.ID] = len(b.Journal)
+		b.Journal = append(b.Journal, e)
+	}
+
+	l := make(map[string]int, len(b.Links))
+	for i, e := range b.Links {
+		l[e.ID] = i
+	}
+	for _, e := range o.Links {
+		if i, ok := l[e.ID]; ok {
+			b.Links[i] = e
+			continue
+		}
+		l[e.ID] = len(b.Links)
+		b.Links = append(b.Links, e)
+	}
+
+	t := make(map[string]int, len(b.Tags))
+	for i, e := range b.Tags {
+		t[e.ID] = i
+	}
+	for _, e := range o.Tags {
+		if i, ok := t[e.ID]; ok {
+			b.Tags[i] = e
+			continue
+		}
+		t[e.ID] = len(b.Tags)
+		b.Tags = append(b.Tags, e)
+	}
+}
+
+// prune drops every row that was deleted before the index was taken
+func (b *Backup) prune(idx Index) {
+	docs := []*documents.Document{}
+	keep := set(idx.Docs)
+	for _, d := range b.Docs {
+		if keep[d.ID] {
+			docs = append(docs, d)
+		}
+	}
+	b.Docs = docs
+
+	entries := []journal.Entry{}
+	keep = set(idx.Journal)
+	for _, e := range b.Journal {
+		if keep[e.ID] {
+			entries = append(entries, e)
+		}
+	}
+	b.Journal = entries
+
+	l := []links.Link{}
+	keep = set(idx.Links)
+	for _, e := range b.Links {
+		if keep[e.ID] {
+			l = append(l, e)
+		}
+	}
+	b.Links = l
+
+	t := []tags.Tag{}
+	keep = set(idx.Tags)
+	for _, e := range b.Tags {
+		if keep[e.ID] {
+			t = append(t, e)
+		}
+	}
+	b.Tags = t
+}
+
+func (b Backup) counts() map[string]int {
+	return map[string]int{
+		"documents":       len(b.Docs),
+		"journal_entries": len(b.Journal),
+		"links":           len(b.Links),
+		"tags":            len(b.Tags),
+	}
+}
+
+func set(ids []string) map[string]bool {
+	s := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		s[id] = true
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+package backup
+
+type Restore string
+
+const (
+	RestoreAll      Restore = "all"
+	RestoreUnknown  Restore = "unknown"
+	RestorePostgres Restore = "postgres"
+	RestoreGraph    Restore = "graph"
+)
+
+func ParseRestore(req string) Restore {
+	switch req {
+	case "", "all":
+		return RestoreAll
+	case "postgres":
+		return RestorePostgres
+	case "graph":
+		return RestoreGraph
+	default:
+		return RestoreUnknown
+	}
+}
+
+// IncludesDocuments is true for the targets that restore the document rows, and so the files that go with them
+func (r Restore) IncludesDocuments() bool {
+	return r == RestoreAll || r == RestorePostgres
+}
+
+// RestoreMode decides what happens to rows that already exist. Replace clears the target first, merge upserts by id
+// keeping whichever copy was updated last and dry-run reports what a merge would change without writing anything.
+type RestoreMode string
+
+const (
+	ModeMerge   RestoreMode = "merge"
+	ModeReplace RestoreMode = "replace"
+	ModeDryRun  RestoreMode = "dry-run"
+	ModeUnknown RestoreMode = "unknown"
+)
+
+func ParseRestoreMode(req string) RestoreMode {
+	switch req {
+	case "", "merge":
+		return ModeMerge
+	case "replace":
+		return ModeReplace
+	case "dry-run":
+		return ModeDryRun
+	default:
+		return ModeUnknown
+	}
+}
+
+type RestoreCounts struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+type RestoreReport struct {
+	ID       string                    `json:"id"`
+	Target   Restore                   `json:"target"`
+	Mode     RestoreMode               `json:"mode"`
+	Postgres map[string]*RestoreCounts `json:"postgres,omitempty"`
+	Graph    map[string]*RestoreCounts `json:"graph,omitempty"`
+}
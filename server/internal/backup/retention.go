@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Summary describes a backup available in the bucket
+type Summary struct {
+	ID      string         `json:"id"`
+	Kind    string         `json:"kind"`
+	Parent  string         `json:"parent,omitempty"`
+	Created time.Time      `json:"created"`
+	Size    int64          `json:"size"`
+	Counts  map[string]int `json:"counts,omitempty"`
+}
+
+// Retention is the number of hourly, daily and weekly backups to keep, the newest backup of each period is kept.
+// A policy of all zeros keeps everything.
+type Retention struct {
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+	Weekly int `json:"weekly"`
+}
+
+type PruneResult struct {
+	Kept    []string `json:"kept"`
+	Deleted []string `json:"deleted"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+func LoadRetention() Retention {
+	keep := func(name, fallback string) int {
+		n, err := strconv.Atoi(common.GetEnv(name, fallback))
+		if err != nil || n < 0 {
+			logrus.WithField("name", name).Warn("invalid retention setting, using default")
+			n, _ = strconv.Atoi(fallback)
+		}
+		return n
+	}
+	return Retention{
+		Hourly: keep("BACKUP_KEEP_HOURLY", "24"),
+		Daily:  keep("BACKUP_KEEP_DAILY", "7"),
+		Weekly: keep("BACKUP_KEEP_WEEKLY", "4"),
+	}
+}
+
+func (p Retention) enabled() bool {
+	return p.Hourly > 0 || p.Daily > 0 || p.Weekly > 0
+}
+
+// keep selects the backups to retain from a list sorted newest first. Every ancestor of a retained incremental
+// backup is retained as well, otherwise it could no longer be restored.
+func (p Retention) keep(backups []Summary) map[string]bool {
+	kept := map[string]bool{}
+	periods := []struct {
+		n      int
+		period func(time.Time) string
+	}{
+		{p.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+	}
+	for _, r := range periods {
+		seen := map[string]bool{}
+		for _, b := range backups {
+			if len(seen) >= r.n {
+				break
+			}
+			key := r.period(b.Created.UTC())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			kept[b.ID] = true
+		}
+	}
+
+	parents := map[string]string{}
+	for _, b := range backups {
+		parents[b.ID] = b.Parent
+	}
+	for id := range kept {
+		for parent := parents[id]; parent != "" && !kept[parent]; parent = parents[parent] {
+			kept[parent] = true
+		}
+	}
+	return kept
+}
+
+// List returns every backup in the bucket, newest first
+func (r *service) List() ([]Summary, error) {
+	ctx := context.Background()
+	objects, err := r.storage.ListObjects(ctx, fileNameBase+"-")
+	if err != nil {
+		logrus.WithError(err).Error("unable to list backups")
+		return nil, errors.New("unable to list backups")
+	}
+
+	manifests := map[int64]bool{}
+	sizes := map[int64]int64{}
+	summaries := []Summary{}
+	for _, obj := range objects {
+		id, suffix, ok := parseBackupKey(obj.Key)
+		if !ok {
+			continue
+		}
+		switch suffix {
+		case manifestSuffix:
+			manifests[id] = true
+		case archiveSuffix:
+			sizes[id] = obj.Size
+		case legacySuffix:
+			summaries = append(summaries, Summary{
+				ID:      strconv.FormatInt(id, 10),
+				Kind:    KindLegacy,
+				Created: time.Unix(id, 0),
+				Size:    obj.Size,
+			})
+		}
+	}
+
+	for id := range manifests {
+		m, err := r.loadManifest(ctx, strconv.FormatInt(id, 10))
+		if err != nil {
+			logrus.WithError(err).WithField("id", id).Warn("unable to load manifest")
+			continue
+		}
+		summaries = append(summaries, Summary{
+			ID:      m.ID,
+			Kind:    m.Kind,
+			Parent:  m.Parent,
+			Created: m.Created,
+			Size:    sizes[id],
+			Counts:  m.Counts,
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Created.After(summaries[j].Created) })
+	return summaries, nil
+}
+
+// Prune deletes the backups that fall outside of the retention policy
+func (r *service) Prune(dryRun bool) (PruneResult, error) {
+	result := PruneResult{Kept: []string{}, Deleted: []string{}, DryRun: dryRun}
+	if !r.retention.enabled() {
+		logrus.Debug("no retention policy, skipping prune")
+		return result, nil
+	}
+
+	backups, err := r.List()
+	if err != nil {
+		return result, err
+	}
+
+	kept := r.retention.keep(backups)
+	for _, b := range backups {
+		if kept[b.ID] {
+			result.Kept = append(result.Kept, b.ID)
+			continue
+		}
+		result.Deleted = append(result.Deleted, b.ID)
+		if dryRun {
+			continue
+		}
+
+		// the manifest goes first so a failure never leaves a listed backup without its archive
+		names := []string{manifestName(b.ID), archiveName(b.ID)}
+		if b.Kind == KindLegacy {
+			names = []string{legacyName(b.ID)}
+		}
+		for _, name := range names {
+			if err := r.storage.Delete(context.Background(), name); err != nil {
+				logrus.WithError(err).WithField("name", name).Error("unable to delete backup")
+				return result, errors.New("unable to delete backup")
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"kept": len(result.Kept), "deleted": len(result.Deleted), "dry_run": dryRun}).Info("backups pruned")
+	return result, nil
+}
@@ -10,28 +10,68 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/fx"
+	"gocloud.dev/gcerrors"
 	"golang.org/x/sync/errgroup"
+	"io"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type service struct {
 	backupRepo Repository
 	storage    common.BackupStorage
+	docs       common.DocumentStorage
+	covers     *common.CoverStorage
+	retention  Retention
+	alerter    *alerter
 }
 
 type runner struct {
-	service Service
-	ticker  *time.Ticker
+	service  Service
+	options  Options
+	schedule cron.Schedule
+	jitter   time.Duration
+	done     chan struct{}
 }
 
 var fileNameBase = common.GetEnv("BACKUP_FILE", "backups/backup")
 
+// maxChain is the number of incremental backups taken before the next backup is a full one again
+var maxChain, _ = strconv.Atoi(common.GetEnv("BACKUP_MAX_CHAIN", "24"))
+
+// NewBackupRunner takes backups on the cron schedule in BACKUP_SCHEDULE, each run is delayed by a random amount up to
+// BACKUP_JITTER so several instances sharing a bucket don't all fire at once.
 func NewBackupRunner(lc fx.Lifecycle, s Service) {
+	spec := common.GetEnv("BACKUP_SCHEDULE", "@hourly")
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		logrus.WithError(err).WithField("schedule", spec).Error("invalid backup schedule, falling back to hourly")
+		schedule, _ = cron.ParseStandard("@hourly")
+	}
+	jitter, err := time.ParseDuration(common.GetEnv("BACKUP_JITTER", "1m"))
+	if err != nil {
+		logrus.WithError(err).Error("invalid backup jitter, disabling jitter")
+		jitter = 0
+	}
+
 	r := &runner{
 		service: s,
+		options: Options{
+			Incremental:   envBool("BACKUP_INCREMENTAL", true),
+			IncludeFiles:  envBool("BACKUP_INCLUDE_FILES", false),
+			IncludeCovers: envBool("BACKUP_INCLUDE_COVERS", false),
+			Trigger:       TriggerSchedule,
+		},
+		schedule: schedule,
+		jitter:   jitter,
+		done:     make(chan struct{}),
 	}
 
 	lc.Append(fx.Hook{
@@ -49,48 +89,38 @@ func NewBackupRunner(lc fx.Lifecycle, s Service) {
 }
 
 type SystemAggregator interface {
-	AggregateAllData() (Backup, error)
+	AggregateAllData(ctx context.Context) (Backup, error)
 }
 
 type Service interface {
-	Backup() error
-	Restore(id string, restoreType Restore) error
+	Backup(opts Options) (Manifest, error)
+	Restore(id string, restoreType Restore, mode RestoreMode) (RestoreReport, error)
+	List() ([]Summary, error)
+	Prune(dryRun bool) (PruneResult, error)
+	Runs(limit int) ([]Run, error)
 }
 
 type Repository interface {
-	FindAllTags() ([]tags.Tag, error)
-	FindAll(ctx context.Context, filter map[string]interface{}) ([]*documents.Document, error)
-	FindAllLinks() ([]links.Link, error)
-	FindAllEntries() ([]journal.Entry, error)
-	Restore(b Backup) error
+	FindAllTags(ctx context.Context) ([]tags.Tag, error)
+	FindAll(ctx context.Context, filter documents.Filter) ([]*documents.Document, error)
+	FindAllLinks(ctx context.Context) ([]links.Link, error)
+	FindAllEntries(ctx context.Context) ([]journal.Entry, error)
+	FindChangedSince(ctx context.Context, since time.Time) (Backup, error)
+	FindAllIDs(ctx context.Context) (Index, error)
+	Restore(b Backup, restoreType Restore, mode RestoreMode) (RestoreReport, error)
+	StartRun(ctx context.Context, run Run) (int64, error)
+	FinishRun(ctx context.Context, run Run) error
+	FindRuns(ctx context.Context, limit int) ([]Run, error)
 }
 
-type Restore string
-
-const (
-	RestoreAll      Restore = "all"
-	RestoreUnknown  Restore = "unknown"
-	RestorePostgres Restore = "postgres"
-	RestoreGraph    Restore = "graph"
-)
-
-func ParseRestore(req string) Restore {
-	switch req {
-	case "":
-		return RestoreAll
-	case "postgres":
-		return RestorePostgres
-	case "graph":
-		return RestoreGraph
-	default:
-		return RestoreUnknown
-	}
-}
-
-func NewService(db Repository, storage common.BackupStorage) Service {
+func NewService(db Repository, storage common.BackupStorage, docs common.DocumentStorage, covers *common.CoverStorage) Service {
 	return &service{
 		backupRepo: db,
 		storage:    storage,
+		docs:       docs,
+		covers:     covers,
+		retention:  LoadRetention(),
+		alerter:    newAlerter(),
 	}
 }
 
@@ -107,61 +137,227 @@ type Backup struct {
 	Tags    []tags.Tag            `json:"tags"`
 }
 
-func (r *service) Restore(id string, restoreType Restore) error {
+func (r *service) Restore(id string, restoreType Restore, mode RestoreMode) (RestoreReport, error) {
+	ctx := common.WithSystem(context.Background())
+	report := RestoreReport{ID: id, Target: restoreType, Mode: mode}
+
+	b, err := r.load(ctx, id, mode != ModeDryRun && restoreType.IncludesDocuments())
+	if err != nil {
+		return report, err
+	}
+
+	result, err := r.backupRepo.Restore(b, restoreType, mode)
+	if err != nil {
+		logrus.WithError(err).Error("unable to populate database")
+		return report, errors.New("unable to populate database")
+	}
+	report.Postgres = result.Postgres
+	report.Graph = result.Graph
+
+	logrus.WithFields(logrus.Fields{"id": id, "type": restoreType, "mode": mode}).Info("backup restored")
+	return report, nil
+}
+
+// load reads a backup and every backup it is based on into a single set of rows. Document files and covers in the
+// archives are written back to their buckets when restoreFiles is set, once every archive of the chain has been
+// verified against its manifest.
+func (r *service) load(ctx context.Context, id string, restoreFiles bool) (Backup, error) {
+	chain, err := r.manifestChain(ctx, id)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return r.loadLegacy(ctx, id)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to load manifests")
+		return Backup{}, errors.New("unable to load manifests")
+	}
+
+	var staged *stagedFiles
+	if restoreFiles {
+		if staged, err = newStagedFiles(); err != nil {
+			logrus.WithError(err).Error("unable to stage backup files")
+			return Backup{}, errors.New("unable to stage backup files")
+		}
+		defer staged.close()
+	}
+
+	b := Backup{}
+	var idx Index
+	for _, m := range chain {
+		part, partIdx, err := r.readBackup(ctx, m, staged)
+		if err != nil {
+			logrus.WithError(err).WithField("id", m.ID).Error("unable to read backup")
+			return b, errors.New("unable to read backup")
+		}
+		b.merge(part)
+		idx = partIdx
+	}
+	b.prune(idx)
+
+	if staged != nil {
+		if err := r.restoreFiles(ctx, staged); err != nil {
+			logrus.WithError(err).Error("unable to restore files")
+			return b, errors.New("unable to restore files")
+		}
+	}
+	return b, nil
+}
+
+// restoreFiles writes the verified document files and covers back to their buckets
+func (r *service) restoreFiles(ctx context.Context, staged *stagedFiles) error {
+	return staged.each(func(name string, entry io.Reader) error {
+		switch {
+		case strings.HasPrefix(name, filesDir):
+			_, err := r.docs.Save(ctx, strings.TrimPrefix(name, filesDir), entry)
+			return err
+		case strings.HasPrefix(name, coversDir):
+			if !r.covers.Enabled() {
+				logrus.WithField("name", name).Debug("skipping cover, no site bucket configured")
+				return nil
+			}
+			return r.covers.Save(ctx, strings.TrimSuffix(path.Base(name), path.Ext(name)), entry)
+		}
+		return nil
+	})
+}
 
-	fileName := fmt.Sprintf("%s-%s.json", fileNameBase, id)
-	f, err := r.storage.Reader(context.Background(), fileName)
+// loadLegacy reads backups taken before the archive format, which are a single json document
+func (r *service) loadLegacy(ctx context.Context, id string) (Backup, error) {
+	b := Backup{}
+	f, err := r.storage.Reader(ctx, legacyName(id))
 	if err != nil {
 		logrus.WithError(err).Error("unable to fetch file")
-		return errors.New("unable to download file")
+		return b, errors.New("unable to download file")
 	}
 	defer f.Close()
 
 	buf := new(bytes.Buffer)
 	if _, err := buf.ReadFrom(f); err != nil {
 		logrus.WithError(err).Error("unable to read file")
-		return errors.New("unable to read file")
+		return b, errors.New("unable to read file")
 	}
 
-	b := Backup{}
 	if err := json.Unmarshal(buf.Bytes(), &b); err != nil {
 		logrus.WithError(err).Error("unable to unmarshal file")
-		return errors.New("unable to unmarshal file")
+		return b, errors.New("unable to unmarshal file")
 	}
+	return b, nil
+}
 
-	if err := r.backupRepo.Restore(b); err != nil {
-		logrus.WithError(err).Error("unable to populate database")
-		return errors.New("unable to populate database")
+// readBackup verifies an archive against its manifest and returns its rows, the document files and covers it carries
+// are staged when staged isn't nil
+func (r *service) readBackup(ctx context.Context, m Manifest, staged *stagedFiles) (Backup, Index, error) {
+	b := Backup{}
+	idx := Index{}
+
+	f, err := r.storage.Reader(ctx, archiveName(m.ID))
+	if err != nil {
+		return b, idx, err
 	}
+	defer f.Close()
 
-	logrus.WithField("id", id).Info("backup restored")
-	return nil
+	err = readArchive(f, m, func(name string, entry io.Reader) error {
+		switch {
+		case name == docsEntry:
+			return json.NewDecoder(entry).Decode(&b.Docs)
+		case name == journalEntry:
+			return json.NewDecoder(entry).Decode(&b.Journal)
+		case name == linksEntry:
+			return json.NewDecoder(entry).Decode(&b.Links)
+		case name == tagsEntry:
+			return json.NewDecoder(entry).Decode(&b.Tags)
+		case name == indexEntry:
+			return json.NewDecoder(entry).Decode(&idx)
+		case staged == nil:
+			return nil
+		case strings.HasPrefix(name, filesDir), strings.HasPrefix(name, coversDir):
+			return staged.add(name, entry)
+		}
+		return nil
+	})
+	return b, idx, err
 }
 
-func (r *service) AggregateAllData() (Backup, error) {
-	egroup, ctx := errgroup.WithContext(context.Background())
+func (r *service) loadManifest(ctx context.Context, id string) (Manifest, error) {
+	m := Manifest{}
+	f, err := r.storage.Reader(ctx, manifestName(id))
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return m, err
+	}
+	if m.SchemaVersion > SchemaVersion {
+		return m, errors.New("unsupported backup schema version " + strconv.Itoa(m.SchemaVersion))
+	}
+	return m, nil
+}
+
+// manifestChain returns the manifests needed to restore a backup, starting with the full backup it is based on
+func (r *service) manifestChain(ctx context.Context, id string) ([]Manifest, error) {
+	chain := []Manifest{}
+	for id != "" {
+		m, err := r.loadManifest(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]Manifest{m}, chain...)
+		id = m.Parent
+	}
+	return chain, nil
+}
+
+// latestManifest finds the manifest of the most recent backup, nil if there is none
+func (r *service) latestManifest(ctx context.Context) (*Manifest, error) {
+	objects, err := r.storage.ListObjects(ctx, fileNameBase+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, obj := range objects {
+		if id, suffix, ok := parseBackupKey(obj.Key); ok && suffix == manifestSuffix {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+
+	m, err := r.loadManifest(ctx, strconv.FormatInt(ids[0], 10))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// AggregateAllData collects the library of the user in the context, or every library for system work
+func (r *service) AggregateAllData(ctx context.Context) (Backup, error) {
+	egroup, ctx := errgroup.WithContext(ctx)
 
 	b := &Backup{}
 	egroup.Go(func() error {
-		docs, err := r.backupRepo.FindAll(ctx, nil)
+		docs, err := r.backupRepo.FindAll(ctx, documents.Filter{})
 		b.Docs = docs
 		return err
 	})
 
 	egroup.Go(func() error {
-		entries, err := r.backupRepo.FindAllEntries()
+		entries, err := r.backupRepo.FindAllEntries(ctx)
 		b.Journal = entries
 		return err
 	})
 
 	egroup.Go(func() error {
-		l, err := r.backupRepo.FindAllLinks()
+		l, err := r.backupRepo.FindAllLinks(ctx)
 		b.Links = l
 		return err
 	})
 
 	egroup.Go(func() error {
-		l, err := r.backupRepo.FindAllTags()
+		l, err := r.backupRepo.FindAllTags(ctx)
 		b.Tags = l
 		return err
 	})
@@ -174,45 +370,220 @@ func (r *service) AggregateAllData() (Backup, error) {
 	return *b, nil
 }
 
-func (r *service) Backup() error {
-	b, err := r.AggregateAllData()
+// Backup takes a backup and records the attempt, failures are sent to the alert webhook
+func (r *service) Backup(opts Options) (Manifest, error) {
+	ctx := common.WithSystem(context.Background())
+	if opts.Trigger == "" {
+		opts.Trigger = TriggerManual
+	}
+
+	run := Run{Trigger: opts.Trigger, Started: time.Now(), Outcome: OutcomeRunning}
+	id, err := r.backupRepo.StartRun(ctx, run)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to record backup run")
+	}
+	run.ID = id
+
+	m, size, err := r.backup(ctx, opts)
+	finished := time.Now()
+	run.Finished = &finished
+	run.BackupID = m.ID
+	run.Kind = m.Kind
+	run.Size = size
+	run.Outcome = OutcomeSuccess
+	if err != nil {
+		run.Outcome = OutcomeFailed
+		run.Error = err.Error()
+	}
+
+	if run.ID != 0 {
+		if err := r.backupRepo.FinishRun(ctx, run); err != nil {
+			logrus.WithError(err).Warn("unable to record backup run")
+		}
+	}
+	if err != nil {
+		r.alerter.alert("backup failed", run)
+	}
+	return m, err
+}
+
+func (r *service) Runs(limit int) ([]Run, error) {
+	runs, err := r.backupRepo.FindRuns(context.Background(), limit)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find backup runs")
+		return nil, errors.New("unable to find backup runs")
+	}
+	return runs, nil
+}
+
+func (r *service) backup(ctx context.Context, opts Options) (Manifest, int64, error) {
+	now := time.Now()
+
+	m := Manifest{
+		SchemaVersion:  SchemaVersion,
+		ID:             strconv.FormatInt(now.Unix(), 10),
+		Kind:           KindFull,
+		Created:        now,
+		IncludesFiles:  opts.IncludeFiles,
+		IncludesCovers: opts.IncludeCovers && r.covers.Enabled(),
+	}
+	if opts.IncludeCovers && !m.IncludesCovers {
+		logrus.Warn("no site bucket configured, covers will not be included in backup")
+	}
+
+	var b Backup
+	var err error
+	if opts.Incremental {
+		prev, err := r.latestManifest(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("unable to find previous backup")
+			return m, 0, errors.New("unable to find previous backup")
+		}
+		if prev != nil && prev.Depth < maxChain {
+			m.Kind = KindIncremental
+			m.Parent = prev.ID
+			m.Depth = prev.Depth + 1
+			m.Since = &prev.Created
+		}
+	}
+
+	if m.Kind == KindIncremental {
+		b, err = r.backupRepo.FindChangedSince(ctx, *m.Since)
+	} else {
+		b, err = r.AggregateAllData(ctx)
+	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to aggregate data")
-		return errors.New("unable to aggregate data")
+		return m, 0, errors.New("unable to aggregate data")
 	}
-	marshalled, err := json.Marshal(b)
+
+	idx, err := r.backupRepo.FindAllIDs(ctx)
 	if err != nil {
-		logrus.WithError(err).Error("unable to marshall Backup")
-		return errors.New("unable to marshall Backup")
+		logrus.WithError(err).Error("unable to aggregate data")
+		return m, 0, errors.New("unable to aggregate data")
 	}
+	m.Counts = b.counts()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(r.writeBackup(ctx, pw, &m, b, idx))
+	}()
 
-	fileName := fmt.Sprintf("%s-%d.json", fileNameBase, time.Now().Unix())
-	location, err := r.storage.Save(context.Background(), fileName, bytes.NewReader(marshalled))
+	archive := &countingReader{r: pr}
+	location, err := r.storage.Save(ctx, archiveName(m.ID), archive)
 	if err != nil {
+		pr.CloseWithError(err)
 		logrus.WithError(err).Error("unable to send to bucket")
-		return errors.New("unable to persist file")
+		return m, 0, errors.New("unable to persist file")
 	}
 
-	logrus.WithField("location", location).Info("Backup successful")
-	return nil
+	marshalled, err := json.Marshal(m)
+	if err != nil {
+		logrus.WithError(err).Error("unable to marshall manifest")
+		return m, 0, errors.New("unable to marshall manifest")
+	}
+	if _, err := r.storage.Save(ctx, manifestName(m.ID), bytes.NewReader(marshalled)); err != nil {
+		logrus.WithError(err).Error("unable to send to bucket")
+		return m, 0, errors.New("unable to persist file")
+	}
+
+	logrus.WithFields(logrus.Fields{"location": location, "kind": m.Kind, "size": archive.n}).Info("Backup successful")
+	return m, archive.n, nil
 }
 
-func (r *runner) start() {
-	r.ticker = time.NewTicker(1 * time.Hour)
-	go func() {
-		for {
-			select {
-			case <-r.ticker.C:
-				go func() {
-					if err := r.service.Backup(); err != nil {
-						logrus.Fatal("unable to Backup")
-					}
-				}()
+func (r *service) writeBackup(ctx context.Context, w io.Writer, m *Manifest, b Backup, idx Index) error {
+	a, err := newArchiveWriter(w)
+	if err != nil {
+		return err
+	}
+
+	entries := []struct {
+		name string
+		v    interface{}
+	}{
+		{docsEntry, b.Docs},
+		{journalEntry, b.Journal},
+		{linksEntry, b.Links},
+		{tagsEntry, b.Tags},
+		{indexEntry, idx},
+	}
+	for _, e := range entries {
+		if err := a.writeJSON(e.name, e.v); err != nil {
+			return err
+		}
+	}
+
+	for _, doc := range b.Docs {
+		if m.IncludesFiles && doc.Path != "" {
+			if err := r.writeContent(a, filesDir+doc.Path, func() (*common.Content, error) {
+				return r.docs.Stream(ctx, doc.Path)
+			}); err != nil {
+				return err
 			}
 		}
-	}()
+		if m.IncludesCovers {
+			if err := r.writeContent(a, coversDir+doc.ID+".jpg", func() (*common.Content, error) {
+				return r.covers.Stream(ctx, doc.ID)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return a.close(m)
+}
+
+// writeContent copies a stored file into the archive, files that no longer exist are skipped
+func (r *service) writeContent(a *archiveWriter, name string, open func() (*common.Content, error)) error {
+	c, err := open()
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		logrus.WithField("name", name).Warn("file missing, skipping")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return a.writeFile(name, c.Size, c.ModTime, c)
+}
+
+func envBool(name string, fallback bool) bool {
+	v, err := strconv.ParseBool(common.GetEnv(name, strconv.FormatBool(fallback)))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func (r *runner) start() {
+	for {
+		next := r.schedule.Next(time.Now())
+		if r.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(r.jitter))))
+		}
+		logrus.WithField("next", next).Debug("scheduled backup")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-r.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			r.run()
+		}
+	}
+}
+
+// run takes a scheduled backup and prunes old ones, failures are recorded and alerted by the service
+func (r *runner) run() {
+	if _, err := r.service.Backup(r.options); err != nil {
+		return
+	}
+	if _, err := r.service.Prune(false); err != nil {
+		logrus.WithError(err).Error("unable to prune backups")
+	}
 }
 
 func (r *runner) stop() {
-	r.ticker.Stop()
+	close(r.done)
 }
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"alexandria/internal/common"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
+
+const (
+	OutcomeRunning = "running"
+	OutcomeSuccess = "success"
+	OutcomeFailed  = "failed"
+)
+
+// Run records a single attempt at taking a backup
+type Run struct {
+	ID       int64      `json:"id"`
+	Trigger  string     `json:"trigger"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	BackupID string     `json:"backup_id,omitempty"`
+	Kind     string     `json:"kind,omitempty"`
+	Size     int64      `json:"size"`
+	Outcome  string     `json:"outcome"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// alerter posts failed runs to BACKUP_ALERT_WEBHOOK, the payload carries a text field so it can be pointed straight
+// at a Slack or Mattermost incoming webhook
+type alerter struct {
+	url    string
+	client *http.Client
+}
+
+func newAlerter() *alerter {
+	return &alerter{
+		url:    common.GetEnv("BACKUP_ALERT_WEBHOOK", ""),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *alerter) alert(message string, run Run) {
+	logrus.WithFields(logrus.Fields{"run": run.ID, "error": run.Error}).Error(message)
+	if a == nil || a.url == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text": fmt.Sprintf("alexandria: %s: %s", message, run.Error),
+		"run":  run,
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to marshal alert")
+		return
+	}
+
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Error("unable to send alert")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logrus.WithField("status", resp.StatusCode).Error("alert webhook rejected alert")
+	}
+}
@@ -3,7 +3,9 @@ package books
 import (
 	"alexandria/internal/common"
 	"alexandria/internal/documents"
+	"alexandria/internal/tags"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
@@ -44,10 +46,10 @@ func (h *bookHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	displayName, ok := r.MultipartForm.Value["name"]
-	if !ok {
-		common.MakeError(w, http.StatusBadRequest, "book", "Name missing from form", "create")
-		return
+	// without a name the title read from the file is used, or else the file name
+	displayName := r.MultipartForm.Value["name"]
+	if len(displayName) == 0 {
+		displayName = []string{""}
 	}
 
 	book := &documents.Document{
@@ -57,6 +59,11 @@ func (h *bookHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.Add(ctx, file, book); err != nil {
+		duplicate := &documents.DuplicateError{}
+		if errors.As(err, &duplicate) {
+			documents.WriteDuplicate(w, r, duplicate)
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "book", err.Error(), "add")
 		return
 	}
@@ -68,13 +75,26 @@ func (h *bookHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *bookHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entity, err := h.service.FindAll(ctx)
+	page, err := common.ParsePageRequest(r, documents.SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "book", err.Error(), "findall")
+		return
+	}
+
+	filter, err := documents.ParseFilter(r)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "book", err.Error(), "findall")
+		return
+	}
+
+	entity, next, err := h.service.FindAll(ctx, filter, page)
 
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "book", "Server Error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(r.Context(), w, entity)
 }
 
@@ -91,6 +111,10 @@ func (h *bookHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 
 	entity, err := h.service.FindByID(ctx, id)
 
+	if err == documents.ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "findbyid")
+		return
+	}
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "findbyid")
 		return
@@ -115,7 +139,11 @@ func (h *bookHandler) AddTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.AddTag(id, req.Tag); err != nil {
+	if err := h.service.AddTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "addTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "addTag")
 		return
 	}
@@ -145,7 +173,11 @@ func (h *bookHandler) RemoveTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.RemoveTag(id, req.Tag); err != nil {
+	if err := h.service.RemoveTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "removeTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "removeTag")
 		return
 	}
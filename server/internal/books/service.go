@@ -1,6 +1,7 @@
 package books
 
 import (
+	"alexandria/internal/common"
 	"alexandria/internal/documents"
 	"alexandria/internal/tags"
 	"context"
@@ -10,11 +11,11 @@ import (
 )
 
 type BookService interface {
-	FindAll(ctx context.Context) ([]*documents.Document, error)
+	FindAll(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error)
 	FindByID(ctx context.Context, id string) (*documents.Document, error)
 	Add(ctx context.Context, file multipart.File, book *documents.Document) error
-	AddTag(id string, tag string) error
-	RemoveTag(id string, tag string) error
+	AddTag(ctx context.Context, id string, tag string) error
+	RemoveTag(ctx context.Context, id string, tag string) error
 }
 
 type service struct {
@@ -29,18 +30,21 @@ func NewBookService(docService documents.DocumentService, tagsRepo tags.Reposito
 	}
 }
 
-func (s *service) FindAll(ctx context.Context) ([]*documents.Document, error) {
-	m := map[string]interface{}{"type": "book"}
-	entities, err := s.docService.FindAll(ctx, m)
+func (s *service) FindAll(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error) {
+	filter.Type = "book"
+	entities, next, err := s.docService.FindPage(ctx, filter, page)
 	if err != nil {
 		logrus.WithError(err).Error("unable to fetch books from repository")
-		return nil, errors.Wrap(err, "unable to fetch from repository")
+		return nil, "", errors.Wrap(err, "unable to fetch from repository")
 	}
-	return entities, nil
+	return entities, next, nil
 }
 
 func (s *service) FindByID(ctx context.Context, id string) (*documents.Document, error) {
 	entity, err := s.docService.FindByID(ctx, id)
+	if err == documents.ErrNotFound {
+		return nil, err
+	}
 	if err != nil {
 		logrus.WithError(err).WithField("id", id).Error("unable to fetch book from repository")
 		return nil, errors.Wrap(err, "unable to fetch from repository")
@@ -59,10 +63,10 @@ func (s *service) Add(ctx context.Context, file multipart.File, book *documents.
 	return nil
 }
 
-func (s *service) AddTag(id string, tag string) error {
-	return s.tagsRepo.AddResourceTag(id, tags.BookResource, tag)
+func (s *service) AddTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.AddResourceTag(ctx, id, tags.BookResource, tag)
 }
 
-func (s *service) RemoveTag(id string, tag string) error {
-	return s.tagsRepo.RemoveResourceTag(id, tag)
+func (s *service) RemoveTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.RemoveResourceTag(ctx, id, tag)
 }
@@ -0,0 +1,465 @@
+package citations
+
+import (
+	"alexandria/internal/documents"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	bibtexEscaper = strings.NewReplacer(`\`, `\textbackslash{}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+		"{", `\{`, "}", `\}`)
+	accentCommand = regexp.MustCompile(`\\([` + "`" + `'^"~=.cvuHk])\s*(?:\{\s*(\\?[A-Za-z])\s*\}|(\\?[A-Za-z]))`)
+	letterCommand = regexp.MustCompile(`\\(ss|aa|AA|ae|AE|oe|OE|o|O|l|L|i)(\{\}|\b)\s?`)
+	otherCommand  = regexp.MustCompile(`\\[A-Za-z]+\*?\s*`)
+	monthNames    = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	accented      = map[string]string{}
+	letters       = map[string]string{
+		"ss": "ß", "aa": "å", "AA": "Å", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ", "o": "ø", "O": "Ø", "l": "ł", "L": "Ł", "i": "ı",
+	}
+)
+
+func init() {
+	for command, pairs := range map[string]string{
+		`"`: "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+		`'`: "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ",
+		"`": "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+		"^": "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+		"~": "aãnñoõAÃNÑOÕ",
+		"=": "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+		".": "zżeėZŻ",
+		"c": "cçsşCÇSŞ",
+		"v": "cčsšzžrřeěnňCČSŠZŽRŘEĚNŇ",
+		"u": "aăgğAĂGĞ",
+		"H": "oőuűOŐUŰ",
+		"k": "aąeęAĄEĘ",
+	} {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			accented[command+string(runes[i])] = string(runes[i+1])
+		}
+	}
+	// a dotless i is what usually carries the accent
+	for _, command := range []string{`"`, `'`, "`", "^"} {
+		accented[command+`\i`] = accented[command+"i"]
+	}
+}
+
+type bibtexField struct {
+	name, value string
+}
+
+func encodeBibTeX(w io.Writer, docs []*documents.Document, keys []string) error {
+	bw := bufio.NewWriter(w)
+	for i, doc := range docs {
+		typ := entryType(doc)
+		fmt.Fprintf(bw, "@%s{%s,\n", typ, keys[i])
+		for _, f := range bibtexFields(doc, typ) {
+			fmt.Fprintf(bw, "  %s = {%s},\n", f.name, f.value)
+		}
+		bw.WriteString("}\n\n")
+	}
+	return bw.Flush()
+}
+
+func bibtexFields(doc *documents.Document, typ string) []bibtexField {
+	var fields []bibtexField
+	add := func(name, value string, escape bool) {
+		if value = strings.TrimSpace(value); value == "" {
+			return
+		}
+		if escape {
+			value = bibtexEscaper.Replace(value)
+		} else {
+			value = strings.NewReplacer("{", "", "}", "").Replace(value)
+		}
+		fields = append(fields, bibtexField{name, value})
+	}
+
+	authors := make([]string, len(doc.Authors))
+	for i, author := range doc.Authors {
+		authors[i] = bibtexEscaper.Replace(author)
+		if strings.Contains(author, " and ") {
+			authors[i] = "{" + authors[i] + "}"
+		}
+	}
+	if len(authors) > 0 {
+		fields = append(fields, bibtexField{"author", strings.Join(authors, " and ")})
+	}
+	add("title", doc.Title, true)
+	switch typ {
+	case "inproceedings", "incollection":
+		add("booktitle", doc.Journal, true)
+	default:
+		add("journal", doc.Journal, true)
+	}
+	if doc.Published != nil {
+		add("year", strconv.Itoa(doc.Published.Year()), false)
+	}
+	add("volume", doc.Volume, true)
+	add("number", doc.Issue, true)
+	add("pages", strings.Replace(doc.Pages, "-", "--", 1), false)
+	add("publisher", doc.Publisher, true)
+	add("isbn", doc.ISBN, false)
+	add("doi", doc.DOI, false)
+	add("url", doc.URL, false)
+	if doc.ArXivID != "" {
+		add("eprint", doc.ArXivID, false)
+		add("archiveprefix", "arXiv", false)
+	}
+	add("keywords", strings.Join(doc.Subjects, ", "), true)
+	add("language", doc.Language, true)
+	add("abstract", doc.Description, true)
+	return fields
+}
+
+// bibtexParser reads entries, @string macros and # concatenation are supported while @comment and @preamble
+// are skipped
+type bibtexParser struct {
+	src    string
+	pos    int
+	macros map[string]string
+}
+
+func decodeBibTeX(r io.Reader) ([]*documents.Document, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &bibtexParser{src: string(b), macros: map[string]string{}}
+	for i, name := range monthNames {
+		p.macros[name] = strconv.Itoa(i + 1)
+	}
+
+	docs := []*documents.Document{}
+	for {
+		at := strings.IndexByte(p.src[p.pos:], '@')
+		if at < 0 {
+			return docs, nil
+		}
+		p.pos += at + 1
+		typ := strings.ToLower(p.identifier())
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '{' && p.src[p.pos] != '(') {
+			continue
+		}
+		closer := byte('}')
+		if p.src[p.pos] == '(' {
+			closer = ')'
+		}
+		p.pos++
+
+		switch typ {
+		case "comment", "preamble":
+			if err := p.skipEntry(closer); err != nil {
+				return nil, err
+			}
+		case "string":
+			fields, err := p.fields(closer)
+			if err != nil {
+				return nil, err
+			}
+			for name, value := range fields {
+				p.macros[name] = value
+			}
+		default:
+			key := strings.TrimSpace(p.until(','))
+			p.pos++
+			fields, err := p.fields(closer)
+			if err != nil {
+				return nil, fmt.Errorf("entry %s: %s", key, err)
+			}
+			docs = append(docs, bibtexDocument(typ, key, fields))
+		}
+	}
+}
+
+func (p *bibtexParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("_-:.+/", c) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *bibtexParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *bibtexParser) until(c byte) string {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != c {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *bibtexParser) skipEntry(closer byte) error {
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closer && depth == 0:
+			p.pos++
+			return nil
+		}
+	}
+	return fmt.Errorf("unterminated entry")
+}
+
+// fields reads name = value pairs up to the end of the entry, names are lower cased
+func (p *bibtexParser) fields(closer byte) (map[string]string, error) {
+	fields := map[string]string{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unterminated entry")
+		}
+		if p.src[p.pos] == closer {
+			p.pos++
+			return fields, nil
+		}
+		if p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		name := strings.ToLower(p.identifier())
+		p.skipSpace()
+		if name == "" || p.pos >= len(p.src) || p.src[p.pos] != '=' {
+			return nil, fmt.Errorf("expected field at offset %d", p.pos)
+		}
+		p.pos++
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+}
+
+// value reads braced, quoted, numeric and macro parts joined with #
+func (p *bibtexParser) value() (string, error) {
+	var b strings.Builder
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return "", fmt.Errorf("unterminated value")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			part, err := p.delimited('}')
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(part)
+		case c == '"':
+			part, err := p.delimited('"')
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(part)
+		default:
+			name := p.identifier()
+			if name == "" {
+				return "", fmt.Errorf("expected value at offset %d", p.pos)
+			}
+			if macro, ok := p.macros[strings.ToLower(name)]; ok {
+				name = macro
+			}
+			b.WriteString(name)
+		}
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '#' {
+			p.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+}
+
+// delimited reads up to the closing delimiter outside of nested braces, the content keeps its inner braces
+func (p *bibtexParser) delimited(end byte) (string, error) {
+	p.pos++
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '\\':
+			p.pos++
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == end && depth == 0:
+			value := p.src[start:p.pos]
+			p.pos++
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated value")
+}
+
+func bibtexDocument(typ, key string, f map[string]string) *documents.Document {
+	doc := &documents.Document{}
+	doc.CitationKey = key
+	doc.EntryType = typ
+	doc.Title = unlatex(f["title"])
+	doc.Journal = unlatex(firstValue(f["journal"], f["journaltitle"], f["booktitle"]))
+	doc.Volume = unlatex(f["volume"])
+	doc.Issue = unlatex(firstValue(f["number"], f["issue"]))
+	doc.Pages = strings.Replace(unlatex(f["pages"]), "--", "-", 1)
+	doc.Publisher = unlatex(firstValue(f["publisher"], f["institution"], f["school"], f["organization"]))
+	doc.Language = unlatex(f["language"])
+	doc.ISBN = documents.NormalizeISBN(f["isbn"])
+	doc.DOI = documents.NormalizeDOI(f["doi"])
+	doc.URL = strings.TrimSpace(f["url"])
+	doc.Description = unlatex(f["abstract"])
+
+	for _, name := range splitOutside(f["author"], " and ") {
+		if name = unlatex(name); name != "" && name != "others" {
+			doc.Authors = append(doc.Authors, displayName(name))
+		}
+	}
+	doc.Subjects = splitList(unlatex(f["keywords"]))
+
+	year, month := 0, 0
+	if d := strings.TrimSpace(f["date"]); d != "" {
+		parts := strings.Split(d, "-")
+		year, _ = strconv.Atoi(parts[0])
+		if len(parts) > 1 {
+			month, _ = strconv.Atoi(parts[1])
+		}
+	}
+	if year == 0 {
+		year, _ = strconv.Atoi(strings.TrimSpace(unlatex(f["year"])))
+		month = parseMonth(f["month"])
+	}
+	doc.Published = date(year, month)
+
+	if prefix := strings.ToLower(firstValue(f["archiveprefix"], f["eprinttype"])); prefix == "arxiv" {
+		doc.ArXivID = strings.TrimSpace(f["eprint"])
+	}
+	if doc.ArXivID == "" {
+		doc.ArXivID = documents.FindArXivID(doc.Journal + " " + doc.URL)
+	}
+	return doc
+}
+
+// unlatex turns the LaTeX of a field into plain text
+func unlatex(value string) string {
+	value = accentCommand.ReplaceAllStringFunc(value, func(match string) string {
+		m := accentCommand.FindStringSubmatch(match)
+		letter := m[2] + m[3]
+		if replaced, ok := accented[m[1]+letter]; ok {
+			return replaced
+		}
+		return strings.TrimPrefix(letter, `\`)
+	})
+	value = letterCommand.ReplaceAllStringFunc(value, func(match string) string {
+		return letters[letterCommand.FindStringSubmatch(match)[1]]
+	})
+	value = otherCommand.ReplaceAllString(value, "")
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '{' || r == '}':
+		case r == '~':
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// splitOutside splits on sep where it isn't inside braces
+func splitOutside(value, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(value[i:], sep) {
+				parts = append(parts, value[start:i])
+				start = i + len(sep)
+				i += len(sep) - 1
+			}
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// displayName turns "Family, Given" into "Given Family", which is how names read from files are stored
+func displayName(name string) string {
+	parts := strings.Split(name, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	switch len(parts) {
+	case 2:
+		return strings.TrimSpace(parts[1] + " " + parts[0])
+	case 3:
+		// Family, Jr, Given
+		return strings.TrimSpace(parts[2] + " " + parts[0] + " " + parts[1])
+	default:
+		return name
+	}
+}
+
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func parseMonth(value string) int {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if m, err := strconv.Atoi(value); err == nil {
+		return m
+	}
+	for i, name := range monthNames {
+		if strings.HasPrefix(value, name) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func firstValue(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
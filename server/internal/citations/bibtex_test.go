@@ -0,0 +1,140 @@
+package citations
+
+import (
+	"alexandria/internal/documents"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeBibTeX(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		title   string
+		authors []string
+		journal string
+		year    int
+	}{
+		{
+			name:    "string macros",
+			input:   `@string{jgr = "Journal of Geophysical Research"} @article{k, title = {Rain}, journal = jgr, year = 2001}`,
+			title:   "Rain",
+			journal: "Journal of Geophysical Research",
+			year:    2001,
+		},
+		{
+			name: "concatenation",
+			input: `@string{proc = "Proceedings of "}
+				@inproceedings{k, title = "Parsing" # " " # {Things}, booktitle = proc # "Everything", year = 1999}`,
+			title:   "Parsing Things",
+			journal: "Proceedings of Everything",
+			year:    1999,
+		},
+		{
+			name:  "nested braces",
+			input: `@book{k, title = {The {GNU} {C{++}} Compiler}}`,
+			title: "The GNU C++ Compiler",
+		},
+		{
+			name:    "accents",
+			input:   `@article{k, title = {Caf\'e na\"ive}, author = {G{\"o}del, Kurt and Erd\H{o}s, Paul and {\'E}mile Borel}}`,
+			title:   "Café naïve",
+			authors: []string{"Kurt Gödel", "Paul Erdős", "Émile Borel"},
+		},
+		{
+			name:    "and inside braces",
+			input:   `@book{k, title = {Catalog}, author = {{Barnes and Noble} and Smith, John}}`,
+			title:   "Catalog",
+			authors: []string{"Barnes and Noble", "John Smith"},
+		},
+		{
+			name:  "parentheses and comments",
+			input: `@comment{skipped {entirely}} @misc(k, title = "Quoted {"}Title")`,
+			title: `Quoted "Title`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, err := decodeBibTeX(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("expected 1 document, got %d", len(docs))
+			}
+			doc := docs[0]
+			if doc.Title != test.title || doc.Journal != test.journal {
+				t.Errorf("unexpected title %q or journal %q", doc.Title, doc.Journal)
+			}
+			if !reflect.DeepEqual(doc.Authors, test.authors) {
+				t.Errorf("unexpected authors %q", doc.Authors)
+			}
+			year := 0
+			if doc.Published != nil {
+				year = doc.Published.Year()
+			}
+			if year != test.year {
+				t.Errorf("expected year %d, got %d", test.year, year)
+			}
+		})
+	}
+}
+
+func TestDecodeBibTeXUnterminated(t *testing.T) {
+	for name, input := range map[string]string{
+		"value":   `@article{k, title = {Open`,
+		"quoted":  `@article{k, title = "Open`,
+		"entry":   `@article{k, title = {Closed}`,
+		"comment": `@comment{never {closed}`,
+	} {
+		if _, err := decodeBibTeX(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: unterminated entry was accepted", name)
+		}
+	}
+}
+
+func TestBibTeXRoundTrip(t *testing.T) {
+	published := time.Date(1976, time.January, 1, 0, 0, 0, 0, time.UTC)
+	doc := &documents.Document{Metadata: documents.Metadata{
+		Title:     "Proofs & {Refutations}: 100% $certain_",
+		Authors:   []string{"Barnes and Noble", "Kurt Gödel"},
+		Journal:   "Journal of Logic",
+		Volume:    "12",
+		Issue:     "3",
+		Pages:     "1-10",
+		Publisher: "Cambridge University Press",
+		DOI:       "10.1000/xyz123",
+		URL:       "https://example.com/proofs",
+		Subjects:  []string{"logic", "philosophy"},
+		EntryType: "article",
+		Published: &published,
+	}}
+
+	var b bytes.Buffer
+	if err := Encode(&b, FormatBibTeX, []*documents.Document{doc}); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := Decode(&b, FormatBibTeX)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, b.String())
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 document, got %d", len(docs))
+	}
+
+	got, want := docs[0].Metadata, doc.Metadata
+	if got.CitationKey == "" {
+		t.Error("no citation key was written")
+	}
+	got.CitationKey = ""
+	if got.Published == nil || got.Published.Year() != want.Published.Year() {
+		t.Errorf("unexpected published date %v", got.Published)
+	}
+	got.Published, want.Published = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the document\n got %+v\nwant %+v", got, want)
+	}
+}
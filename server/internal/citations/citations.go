@@ -0,0 +1,310 @@
+package citations
+
+import (
+	"alexandria/internal/documents"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	FormatBibTeX  = "bibtex"
+	FormatRIS     = "ris"
+	FormatCSLJSON = "csl-json"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("format must be bibtex, ris or csl-json")
+	ErrInvalidFile       = errors.New("unable to parse citations")
+	keyWord              = regexp.MustCompile(`[A-Za-z0-9]+`)
+)
+
+// Encode writes the documents as a bibliography, citation keys are generated for documents that don't have one
+func Encode(w io.Writer, format string, docs []*documents.Document) error {
+	keys := citationKeys(docs)
+	switch format {
+	case FormatBibTeX:
+		return encodeBibTeX(w, docs, keys)
+	case FormatRIS:
+		return encodeRIS(w, docs, keys)
+	case FormatCSLJSON:
+		return encodeCSL(w, docs, keys)
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+// Decode reads a bibliography into documents that only carry metadata
+func Decode(r io.Reader, format string) ([]*documents.Document, error) {
+	var (
+		docs []*documents.Document
+		err  error
+	)
+	switch format {
+	case FormatBibTeX:
+		docs, err = decodeBibTeX(r)
+	case FormatRIS:
+		docs, err = decodeRIS(r)
+	case FormatCSLJSON:
+		docs, err = decodeCSL(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	for _, doc := range docs {
+		doc.Type = "paper"
+		doc.DisplayName = doc.Title
+		fitColumns(doc)
+		if doc.Authors == nil {
+			doc.Authors = []string{}
+		}
+		if doc.Subjects == nil {
+			doc.Subjects = []string{}
+		}
+	}
+	return docs, nil
+}
+
+// fitColumns cuts the fields to the size of the columns they are stored in, so one long value in a bibliography
+// doesn't fail the import. An arXiv id that is too long is searched for an id instead.
+func fitColumns(doc *documents.Document) {
+	if utf8.RuneCountInString(doc.ArXivID) > 32 {
+		doc.ArXivID = documents.FindArXivID(doc.ArXivID)
+	}
+	for _, f := range []struct {
+		value *string
+		max   int
+	}{
+		{&doc.DisplayName, 255},
+		{&doc.Description, 1024},
+		{&doc.Language, 35},
+		{&doc.CitationKey, 255},
+		{&doc.EntryType, 32},
+		{&doc.Volume, 32},
+		{&doc.Issue, 32},
+		{&doc.Pages, 32},
+	} {
+		*f.value = truncate(*f.value, f.max)
+	}
+}
+
+func truncate(value string, max int) string {
+	if utf8.RuneCountInString(value) <= max {
+		return value
+	}
+	return strings.TrimSpace(string([]rune(value)[:max]))
+}
+
+// Supported reports whether a format can be encoded and decoded
+func Supported(format string) bool {
+	return format == FormatBibTeX || format == FormatRIS || format == FormatCSLJSON
+}
+
+// FormatFromName picks the format by file extension
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".bib", ".bibtex":
+		return FormatBibTeX
+	case ".ris":
+		return FormatRIS
+	case ".json":
+		return FormatCSLJSON
+	default:
+		return ""
+	}
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatBibTeX:
+		return "application/x-bibtex"
+	case FormatRIS:
+		return "application/x-research-info-systems"
+	default:
+		return "application/vnd.citationstyles.csl+json"
+	}
+}
+
+func Extension(format string) string {
+	switch format {
+	case FormatBibTeX:
+		return ".bib"
+	case FormatRIS:
+		return ".ris"
+	default:
+		return ".json"
+	}
+}
+
+// entryTypes maps the BibTeX types to RIS and CSL, the last row is used for anything else
+var entryTypes = []struct {
+	bibtex, ris, csl string
+}{
+	{"article", "JOUR", "article-journal"},
+	{"inproceedings", "CONF", "paper-conference"},
+	{"book", "BOOK", "book"},
+	{"incollection", "CHAP", "chapter"},
+	{"phdthesis", "THES", "thesis"},
+	{"techreport", "RPRT", "report"},
+	{"misc", "GEN", "article"},
+}
+
+// typeAliases are the other names tools use for the same kind of work
+var typeAliases = map[string]string{
+	"conference": "inproceedings", "inbook": "incollection", "mastersthesis": "phdthesis", "thesis": "phdthesis",
+	"report": "techreport", "online": "misc", "CPAPER": "inproceedings", "EJOUR": "article", "ELEC": "misc",
+	"article-newspaper": "article", "article-magazine": "article", "manuscript": "misc", "webpage": "misc",
+}
+
+func fromType(value string, field func(i int) string) string {
+	if alias, ok := typeAliases[value]; ok {
+		return alias
+	}
+	for i, t := range entryTypes {
+		if field(i) == value {
+			return t.bibtex
+		}
+	}
+	return "misc"
+}
+
+func toType(bibtex string, field func(i int) string) string {
+	if alias, ok := typeAliases[bibtex]; ok {
+		bibtex = alias
+	}
+	for i, t := range entryTypes {
+		if t.bibtex == bibtex {
+			return field(i)
+		}
+	}
+	return field(len(entryTypes) - 1)
+}
+
+func risName(i int) string { return entryTypes[i].ris }
+func cslName(i int) string { return entryTypes[i].csl }
+
+// entryType is the BibTeX type of a document, it is kept from an import and otherwise guessed
+func entryType(doc *documents.Document) string {
+	switch {
+	case doc.EntryType != "":
+		return doc.EntryType
+	case doc.Type == "book":
+		return "book"
+	case doc.Journal != "":
+		return "article"
+	default:
+		return "misc"
+	}
+}
+
+// citationKeys uses the stored key or the first author's family name, the year and the first word of the title
+func citationKeys(docs []*documents.Document) []string {
+	keys := make([]string, len(docs))
+	used := map[string]bool{}
+	for i, doc := range docs {
+		key := doc.CitationKey
+		if key == "" {
+			key = generateKey(doc)
+		}
+		base := key
+		for suffix := 'a'; used[key] && suffix <= 'z'; suffix++ {
+			key = base + string(suffix)
+		}
+		used[key] = true
+		keys[i] = key
+	}
+	return keys
+}
+
+func generateKey(doc *documents.Document) string {
+	key := ""
+	if len(doc.Authors) > 0 {
+		_, family := splitName(doc.Authors[0])
+		key = strings.Join(keyWord.FindAllString(asciiFold(family), -1), "")
+	}
+	if doc.Published != nil {
+		key += fmt.Sprintf("%d", doc.Published.Year())
+	}
+	for _, word := range keyWord.FindAllString(asciiFold(doc.Title), -1) {
+		if len(word) > 3 {
+			key += strings.ToLower(word)
+			break
+		}
+	}
+	if key == "" {
+		key = "doc" + strings.ReplaceAll(doc.ID, "-", "")
+		if len(key) > 11 {
+			key = key[:11]
+		}
+	}
+	return strings.ToLower(key[:1]) + key[1:]
+}
+
+// splitName separates "Family, Given" or "Given von Family" into its parts
+func splitName(name string) (given, family string) {
+	name = strings.TrimSpace(name)
+	if i := strings.Index(name, ","); i >= 0 {
+		return strings.TrimSpace(name[i+1:]), strings.TrimSpace(name[:i])
+	}
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return "", name
+	}
+	start := len(words) - 1
+	// particles like van or de belong to the family name
+	for start > 1 && isLower(words[start-1]) {
+		start--
+	}
+	return strings.Join(words[:start], " "), strings.Join(words[start:], " ")
+}
+
+func isLower(word string) bool {
+	for _, r := range word {
+		return unicode.IsLower(r)
+	}
+	return false
+}
+
+// asciiFold drops accents so keys only use letters every tool accepts
+func asciiFold(s string) string {
+	return strings.Map(func(r rune) rune {
+		if folded, ok := foldedLetters[r]; ok {
+			return folded
+		}
+		return r
+	}, s)
+}
+
+var foldedLetters = map[rune]rune{}
+
+func init() {
+	for plain, accented := range map[rune]string{
+		'a': "àáâãäåā", 'c': "çćč", 'e': "èéêëēě", 'i': "ìíîïī", 'n': "ñń", 'o': "òóôõöøō", 'r': "ř", 's': "śš",
+		'u': "ùúûüū", 'y': "ýÿ", 'z': "źž", 'A': "ÀÁÂÃÄÅ", 'C': "ÇĆČ", 'E': "ÈÉÊË", 'I': "ÌÍÎÏ", 'N': "Ñ",
+		'O': "ÒÓÔÕÖØ", 'S': "ŚŠ", 'U': "ÙÚÛÜ", 'Z': "ŹŽ",
+	} {
+		for _, r := range accented {
+			foldedLetters[r] = plain
+		}
+	}
+}
+
+// date is the start of the year, or month when known, a citation was published
+func date(year, month int) *time.Time {
+	if year <= 0 {
+		return nil
+	}
+	if month < 1 || month > 12 {
+		month = 1
+	}
+	t := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	return &t
+}
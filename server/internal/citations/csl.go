@@ -0,0 +1,143 @@
+package citations
+
+import (
+	"alexandria/internal/documents"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cslItem is the part of a CSL-JSON item the library keeps
+type cslItem struct {
+	ID             flexString  `json:"id"`
+	Type           string      `json:"type"`
+	Title          string      `json:"title,omitempty"`
+	Author         []cslAuthor `json:"author,omitempty"`
+	ContainerTitle string      `json:"container-title,omitempty"`
+	Issued         *cslDate    `json:"issued,omitempty"`
+	Volume         flexString  `json:"volume,omitempty"`
+	Issue          flexString  `json:"issue,omitempty"`
+	Page           flexString  `json:"page,omitempty"`
+	Publisher      string      `json:"publisher,omitempty"`
+	ISBN           string      `json:"ISBN,omitempty"`
+	DOI            string      `json:"DOI,omitempty"`
+	URL            string      `json:"URL,omitempty"`
+	Number         flexString  `json:"number,omitempty"`
+	Keyword        string      `json:"keyword,omitempty"`
+	Language       string      `json:"language,omitempty"`
+	Abstract       string      `json:"abstract,omitempty"`
+}
+
+type cslAuthor struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]flexString `json:"date-parts,omitempty"`
+	Raw       string         `json:"raw,omitempty"`
+}
+
+// flexString accepts numbers and strings, tools disagree on which to use for ids, volumes and dates
+type flexString string
+
+func (s *flexString) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = flexString(str)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*s = flexString(n.String())
+	return nil
+}
+
+func encodeCSL(w io.Writer, docs []*documents.Document, keys []string) error {
+	items := make([]cslItem, len(docs))
+	for i, doc := range docs {
+		item := cslItem{
+			ID:             flexString(keys[i]),
+			Type:           toType(entryType(doc), cslName),
+			Title:          doc.Title,
+			ContainerTitle: doc.Journal,
+			Volume:         flexString(doc.Volume),
+			Issue:          flexString(doc.Issue),
+			Page:           flexString(doc.Pages),
+			Publisher:      doc.Publisher,
+			ISBN:           doc.ISBN,
+			DOI:            doc.DOI,
+			URL:            doc.URL,
+			Keyword:        strings.Join(doc.Subjects, ", "),
+			Language:       doc.Language,
+			Abstract:       doc.Description,
+		}
+		for _, author := range doc.Authors {
+			given, family := splitName(author)
+			item.Author = append(item.Author, cslAuthor{Family: family, Given: given})
+		}
+		if doc.Published != nil {
+			item.Issued = &cslDate{DateParts: [][]flexString{{flexString(strconv.Itoa(doc.Published.Year()))}}}
+		}
+		if doc.ArXivID != "" {
+			item.Number = flexString("arXiv:" + doc.ArXivID)
+		}
+		items[i] = item
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(items)
+}
+
+func decodeCSL(r io.Reader) ([]*documents.Document, error) {
+	var items []cslItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+
+	docs := make([]*documents.Document, len(items))
+	for i, item := range items {
+		doc := &documents.Document{}
+		doc.CitationKey = string(item.ID)
+		doc.EntryType = fromType(item.Type, cslName)
+		doc.Title = item.Title
+		doc.Journal = item.ContainerTitle
+		doc.Volume = string(item.Volume)
+		doc.Issue = string(item.Issue)
+		doc.Pages = string(item.Page)
+		doc.Publisher = item.Publisher
+		doc.ISBN = documents.NormalizeISBN(item.ISBN)
+		doc.DOI = documents.NormalizeDOI(item.DOI)
+		doc.URL = item.URL
+		doc.Language = item.Language
+		doc.Description = item.Abstract
+		doc.Subjects = splitList(item.Keyword)
+		for _, author := range item.Author {
+			name := strings.TrimSpace(author.Given + " " + author.Family)
+			if author.Literal != "" {
+				name = author.Literal
+			}
+			if name != "" {
+				doc.Authors = append(doc.Authors, name)
+			}
+		}
+		if item.Issued != nil && len(item.Issued.DateParts) > 0 {
+			parts := item.Issued.DateParts[0]
+			year, month := 0, 0
+			if len(parts) > 0 {
+				year, _ = strconv.Atoi(string(parts[0]))
+			}
+			if len(parts) > 1 {
+				month, _ = strconv.Atoi(string(parts[1]))
+			}
+			doc.Published = date(year, month)
+		}
+		doc.ArXivID = documents.FindArXivID(string(item.Number) + " " + item.URL)
+		docs[i] = doc
+	}
+	return docs, nil
+}
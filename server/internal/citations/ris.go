@@ -0,0 +1,159 @@
+package citations
+
+import (
+	"alexandria/internal/documents"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var risLine = regexp.MustCompile(`^([A-Z][A-Z0-9])\s{1,2}-\s?(.*)$`)
+
+func encodeRIS(w io.Writer, docs []*documents.Document, keys []string) error {
+	bw := bufio.NewWriter(w)
+	for i, doc := range docs {
+		line := func(tag, value string) {
+			if value = strings.TrimSpace(value); value != "" {
+				fmt.Fprintf(bw, "%s  - %s\r\n", tag, strings.Join(strings.Fields(value), " "))
+			}
+		}
+		line("TY", toType(entryType(doc), risName))
+		line("ID", keys[i])
+		for _, author := range doc.Authors {
+			given, family := splitName(author)
+			if given != "" {
+				family += ", " + given
+			}
+			line("AU", family)
+		}
+		line("TI", doc.Title)
+		line("T2", doc.Journal)
+		if doc.Published != nil {
+			line("PY", strconv.Itoa(doc.Published.Year()))
+		}
+		line("VL", doc.Volume)
+		line("IS", doc.Issue)
+		pages := strings.SplitN(doc.Pages, "-", 2)
+		line("SP", pages[0])
+		if len(pages) == 2 {
+			line("EP", pages[1])
+		}
+		line("PB", doc.Publisher)
+		line("SN", doc.ISBN)
+		line("DO", doc.DOI)
+		line("UR", doc.URL)
+		if doc.ArXivID != "" {
+			line("M1", "arXiv:"+doc.ArXivID)
+		}
+		for _, keyword := range doc.Subjects {
+			line("KW", keyword)
+		}
+		line("LA", doc.Language)
+		line("AB", doc.Description)
+		bw.WriteString("ER  - \r\n\r\n")
+	}
+	return bw.Flush()
+}
+
+// decodeRIS reads records from TY to ER, lines that don't start with a tag continue the previous field
+func decodeRIS(r io.Reader) ([]*documents.Document, error) {
+	docs := []*documents.Document{}
+	var (
+		fields map[string][]string
+		last   string
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(strings.TrimPrefix(scanner.Text(), "\ufeff"), "\r ")
+		m := risLine.FindStringSubmatch(text)
+		switch {
+		case m == nil:
+			if fields != nil && last != "" && text != "" {
+				values := fields[last]
+				values[len(values)-1] += " " + strings.TrimSpace(text)
+			}
+		case m[1] == "TY":
+			fields = map[string][]string{"TY": {m[2]}}
+			last = "TY"
+		case m[1] == "ER":
+			if fields != nil {
+				docs = append(docs, risDocument(fields))
+			}
+			fields = nil
+		case fields != nil:
+			fields[m[1]] = append(fields[m[1]], strings.TrimSpace(m[2]))
+			last = m[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if fields != nil {
+		return nil, fmt.Errorf("record without ER")
+	}
+	return docs, nil
+}
+
+func risDocument(f map[string][]string) *documents.Document {
+	get := func(tags ...string) string {
+		for _, tag := range tags {
+			if values := f[tag]; len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	doc := &documents.Document{}
+	doc.EntryType = fromType(get("TY"), risName)
+	doc.CitationKey = get("ID")
+	doc.Title = get("TI", "T1", "CT")
+	doc.Journal = get("T2", "JF", "JO", "JA", "BT")
+	doc.Volume = get("VL")
+	doc.Issue = get("IS")
+	doc.Pages = get("SP")
+	if end := get("EP"); end != "" && doc.Pages != "" {
+		doc.Pages += "-" + end
+	}
+	doc.Publisher = get("PB")
+	doc.Language = get("LA")
+	doc.DOI = documents.NormalizeDOI(get("DO"))
+	doc.URL = get("UR", "L2")
+	doc.Description = get("AB", "N2")
+	for _, sn := range f["SN"] {
+		if isbn := documents.NormalizeISBN(sn); isbn != "" {
+			doc.ISBN = isbn
+			break
+		}
+	}
+	for _, tag := range []string{"AU", "A1", "A2"} {
+		for _, author := range f[tag] {
+			if author = strings.TrimSpace(author); author != "" {
+				doc.Authors = append(doc.Authors, displayName(author))
+			}
+		}
+		if len(doc.Authors) > 0 {
+			break
+		}
+	}
+	doc.Subjects = []string{}
+	for _, keyword := range f["KW"] {
+		doc.Subjects = append(doc.Subjects, splitList(keyword)...)
+	}
+
+	// PY is YYYY/MM/DD/other, all but the year are optional
+	parts := strings.Split(get("PY", "Y1", "DA"), "/")
+	year, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	month := 0
+	if len(parts) > 1 {
+		month, _ = strconv.Atoi(parts[1])
+	}
+	doc.Published = date(year, month)
+
+	doc.ArXivID = documents.FindArXivID(strings.Join([]string{get("M1"), get("N1"), doc.Journal, doc.URL}, " "))
+	return doc
+}
@@ -3,6 +3,8 @@ package common
 import (
 	"github.com/sirupsen/logrus"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -52,6 +54,8 @@ type BucketConfig struct {
 	ConnectionString string
 	AccessID         string
 	AccessKey        string
+	PublicURL        string
+	SigningKey       string
 }
 
 func (c *Config) LoadBucketConfig() BucketConfig {
@@ -62,6 +66,8 @@ func (c *Config) LoadBucketConfig() BucketConfig {
 		ConnectionString: host,
 		AccessID:         accessID,
 		AccessKey:        key,
+		PublicURL:        GetEnv("PUBLIC_URL", "http://localhost:8080"),
+		SigningKey:       os.Getenv("BUCKET_SIGNING_KEY"),
 	}
 }
 
@@ -72,3 +78,58 @@ func GetEnv(env, fallback string) string {
 	}
 	return e
 }
+
+// OIDCConfig points login at an OpenID Connect provider, it is disabled while the issuer is empty
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	AutoProvision bool
+	PostLoginURL  string
+	StateKey      string
+}
+
+func (c *Config) LoadOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Issuer:        strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   GetEnv("OIDC_REDIRECT_URL", GetEnv("PUBLIC_URL", "http://localhost:8080")+"/auth/oidc/callback"),
+		Scopes:        strings.Fields(GetEnv("OIDC_SCOPES", "openid profile email")),
+		UsernameClaim: GetEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		AutoProvision: os.Getenv("OIDC_AUTO_PROVISION") == "true",
+		PostLoginURL:  os.Getenv("OIDC_POST_LOGIN_URL"),
+		StateKey:      os.Getenv("JWT_SECRET"),
+	}
+}
+
+// EnrichmentConfig points metadata lookups at Open Library and Crossref, the urls can be swapped for a mirror or a
+// local stand-in. Delay spaces out the lookups of a bulk job so the public APIs aren't hammered.
+type EnrichmentConfig struct {
+	OpenLibraryURL string
+	CoversURL      string
+	CrossrefURL    string
+	Mailto         string
+	Timeout        time.Duration
+	Delay          time.Duration
+}
+
+func (c *Config) LoadEnrichmentConfig() EnrichmentConfig {
+	config := EnrichmentConfig{
+		OpenLibraryURL: strings.TrimSuffix(GetEnv("OPENLIBRARY_URL", "https://openlibrary.org"), "/"),
+		CoversURL:      strings.TrimSuffix(GetEnv("OPENLIBRARY_COVERS_URL", "https://covers.openlibrary.org"), "/"),
+		CrossrefURL:    strings.TrimSuffix(GetEnv("CROSSREF_URL", "https://api.crossref.org"), "/"),
+		Mailto:         os.Getenv("CROSSREF_MAILTO"),
+		Timeout:        10 * time.Second,
+		Delay:          time.Second,
+	}
+	if delay, err := time.ParseDuration(GetEnv("ENRICH_DELAY", "1s")); err != nil {
+		logrus.WithError(err).Error("invalid enrichment delay, using 1s")
+	} else {
+		config.Delay = delay
+	}
+	return config
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gocloud.dev/blob"
+	"io"
+	"time"
+)
+
+// Content is a seekable view of a stored file that lazily opens range readers against the bucket so only the
+// requested bytes are downloaded.
+type Content struct {
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+	Size        int64
+
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	offset int64
+	reader *blob.Reader
+}
+
+func (s *BucketStorage) Stream(ctx context.Context, path string) (*Content, error) {
+	attrs, err := s.Bucket.Attributes(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := fmt.Sprintf(`"%x-%x"`, attrs.ModTime.UnixNano(), attrs.Size)
+	if len(attrs.MD5) > 0 {
+		etag = fmt.Sprintf(`"%s"`, hex.EncodeToString(attrs.MD5))
+	}
+
+	return &Content{
+		ContentType: attrs.ContentType,
+		ETag:        etag,
+		ModTime:     attrs.ModTime,
+		Size:        attrs.Size,
+		ctx:         ctx,
+		bucket:      s.Bucket,
+		key:         path,
+	}, nil
+}
+
+func (c *Content) Read(p []byte) (int, error) {
+	if c.offset >= c.Size {
+		return 0, io.EOF
+	}
+	if c.reader == nil {
+		r, err := c.bucket.NewRangeReader(c.ctx, c.key, c.offset, -1, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.reader = r
+	}
+	n, err := c.reader.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+func (c *Content) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = c.offset + offset
+	case io.SeekEnd:
+		next = c.Size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+	if next != c.offset && c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+	c.offset = next
+	return next, nil
+}
+
+func (c *Content) Close() error {
+	if c.reader == nil {
+		return nil
+	}
+	err := c.reader.Close()
+	c.reader = nil
+	return err
+}
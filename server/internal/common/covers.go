@@ -0,0 +1,51 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+)
+
+var ErrNoCoverStorage = errors.New("no site bucket configured")
+
+// CoverStorage gives access to the cover images cover-gen publishes to the site bucket.
+type CoverStorage struct {
+	storage *BucketStorage
+}
+
+// NewCoverStorage connects to the bucket at SITE_BUCKET_HOST, covers are unavailable when it isn't set.
+func NewCoverStorage() *CoverStorage {
+	host := GetEnv("SITE_BUCKET_HOST", "")
+	if host == "" {
+		logrus.Info("no site bucket configured, covers will not be available")
+		return &CoverStorage{}
+	}
+	return &CoverStorage{
+		storage: NewBucketStorage(BucketConfig{ConnectionString: host}, nil),
+	}
+}
+
+func CoverPath(id string) string {
+	return fmt.Sprintf("assets/covers/%s.jpg", id)
+}
+
+func (s *CoverStorage) Enabled() bool {
+	return s != nil && s.storage != nil
+}
+
+func (s *CoverStorage) Stream(ctx context.Context, id string) (*Content, error) {
+	if !s.Enabled() {
+		return nil, ErrNoCoverStorage
+	}
+	return s.storage.Stream(ctx, CoverPath(id))
+}
+
+func (s *CoverStorage) Save(ctx context.Context, id string, reader io.Reader) error {
+	if !s.Enabled() {
+		return ErrNoCoverStorage
+	}
+	_, err := s.storage.Save(ctx, CoverPath(id), reader)
+	return err
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// API keys are limited to a scope, logins through a token have no scope and can do anything their role allows
+const (
+	ScopeRead   = "read"
+	ScopeUpload = "upload"
+	ScopeAdmin  = "admin"
+)
+
+var ErrNoIdentity = errors.New("no user in context")
+
+type contextKey string
+
+const (
+	identityKey contextKey = "identity"
+	systemKey   contextKey = "system"
+	grantKey    contextKey = "grant"
+)
+
+// Identity is the account a request is made for, taken from the token subject or API key. TokenID and Expires
+// describe the access token so it can be revoked.
+type Identity struct {
+	ID      string
+	Role    string
+	Scope   string
+	TokenID string
+	Expires time.Time
+}
+
+func (i Identity) IsAdmin() bool {
+	return i.Role == RoleAdmin && (i.Scope == "" || i.Scope == ScopeAdmin)
+}
+
+// Permits checks the request method against the scope, read keys can only fetch and upload keys can't delete
+func (i Identity) Permits(method string) bool {
+	switch i.Scope {
+	case "", ScopeAdmin:
+		return true
+	case ScopeUpload:
+		return method != http.MethodDelete
+	case ScopeRead:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	default:
+		return false
+	}
+}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey).(Identity)
+	return identity, ok && identity.ID != ""
+}
+
+// Grant is the share a request on a resource in another library is made through. The caller keeps their identity,
+// the grant only opens that one resource of the owner's library. Token is the public link token it came with.
+type Grant struct {
+	Resource string
+	Owner    string
+	Role     string
+	Token    string
+}
+
+func WithGrant(ctx context.Context, grant Grant) context.Context {
+	return context.WithValue(ctx, grantKey, grant)
+}
+
+func GrantFromContext(ctx context.Context) (Grant, bool) {
+	grant, ok := ctx.Value(grantKey).(Grant)
+	return grant, ok && grant.Resource != ""
+}
+
+// WithSystem marks work that isn't done for a user, such as backups and graph rebuilds, so repositories read and
+// write across every library. Without it queries are limited to the identity in the context.
+func WithSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey, true)
+}
+
+func IsSystem(ctx context.Context) bool {
+	system, _ := ctx.Value(systemKey).(bool)
+	return system
+}
+
+// RequireAdmin rejects requests that weren't made by an admin
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok || !identity.IsAdmin() {
+			MakeError(w, http.StatusForbidden, "auth", "Forbidden", "admin")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
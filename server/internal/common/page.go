@@ -0,0 +1,123 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// MaxPageSize caps the limit a client can ask for
+const MaxPageSize = 1000
+
+var ErrInvalidPage = errors.New("invalid paging parameters")
+
+// PageRequest holds the limit, cursor and ordering requested on a list endpoint. A limit of zero returns everything.
+type PageRequest struct {
+	Limit int
+	Sort  string
+	Order string
+	After *Cursor
+}
+
+// Cursor marks the last row of a page by its sort value and id, it is bound to the sort it was created with
+type Cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// SortOptions lists the sort keys an endpoint accepts, the first one is the default
+type SortOptions struct {
+	Keys  []string
+	Order string
+}
+
+// ParsePageRequest reads limit, cursor, sort and order from the query string
+func ParsePageRequest(r *http.Request, options SortOptions) (PageRequest, error) {
+	v := r.URL.Query()
+	page := PageRequest{
+		Sort:  options.Keys[0],
+		Order: options.Order,
+	}
+
+	if limit := v.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, ErrInvalidPage
+		}
+		if n > MaxPageSize {
+			n = MaxPageSize
+		}
+		page.Limit = n
+	}
+
+	if sort := v.Get("sort"); sort != "" {
+		if !contains(options.Keys, sort) {
+			return page, ErrInvalidPage
+		}
+		page.Sort = sort
+	}
+
+	if order := strings.ToLower(v.Get("order")); order != "" {
+		if order != OrderAsc && order != OrderDesc {
+			return page, ErrInvalidPage
+		}
+		page.Order = order
+	}
+
+	if cursor := v.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil || c.Sort != page.Sort || c.Order != page.Order {
+			return page, ErrInvalidPage
+		}
+		page.After = &c
+	}
+	return page, nil
+}
+
+// NextCursor creates the cursor pointing after the given row
+func (p PageRequest) NextCursor(value, id string) string {
+	b, _ := json.Marshal(Cursor{Sort: p.Sort, Order: p.Order, Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (c Cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// SetNextPage points the client at the next page with a Link header and X-Next-Cursor, nothing is set on the last page
+func SetNextPage(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	u := *r.URL
+	q := u.Query()
+	q.Set("cursor", next)
+	u.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	w.Header().Set("X-Next-Cursor", next)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpiredSignature = errors.New("signature expired")
+)
+
+// URLSigner emulates signed urls for buckets that are unable to create them (file and mem) by pointing at the
+// /files/ route of this server.
+type URLSigner struct {
+	baseURL string
+	key     []byte
+}
+
+func NewURLSigner(config BucketConfig) *URLSigner {
+	return &URLSigner{
+		baseURL: strings.TrimSuffix(config.PublicURL, "/"),
+		key:     []byte(config.SigningKey),
+	}
+}
+
+func (s *URLSigner) Sign(path string, expiry time.Duration) string {
+	return s.sign(path, expiry, url.Values{})
+}
+
+// SignShared signs a url handed out through a share. The resource, the user and the share token are part of the
+// signature so the /files/ route can check the share still stands before it serves the file.
+func (s *URLSigner) SignShared(path string, expiry time.Duration, grant Grant, user string) string {
+	q := url.Values{}
+	q.Set("resource", grant.Resource)
+	if user != "" {
+		q.Set("user", user)
+	}
+	if grant.Token != "" {
+		q.Set("share", grant.Token)
+	}
+	return s.sign(path, expiry, q)
+}
+
+func (s *URLSigner) sign(path string, expiry time.Duration, q url.Values) string {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	q.Set("expires", expires)
+	q.Set("signature", s.mac(path, expires, q))
+	return fmt.Sprintf("%s/files/%s?%s", s.baseURL, (&url.URL{Path: path}).EscapedPath(), q.Encode())
+}
+
+func (s *URLSigner) Verify(path string, q url.Values) error {
+	expires := q.Get("expires")
+	if !hmac.Equal([]byte(q.Get("signature")), []byte(s.mac(path, expires, q))) {
+		return ErrInvalidSignature
+	}
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > t {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func (s *URLSigner) mac(path, expires string, q url.Values) string {
+	m := hmac.New(sha256.New, s.key)
+	for _, part := range []string{path, expires, q.Get("resource"), q.Get("user"), q.Get("share")} {
+		m.Write([]byte(part))
+		m.Write([]byte{0})
+	}
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -6,11 +6,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
 	"gocloud.dev/blob/gcsblob"
 	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/memblob"
+	_ "gocloud.dev/blob/s3blob"
 	"gocloud.dev/gcp"
 	"io"
 	"net/url"
+	"os"
 	"time"
 )
 
@@ -27,32 +31,89 @@ type DocumentGet interface {
 	List(ctx context.Context) <-chan string
 }
 
+type DocumentReader interface {
+	Reader(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+type DocumentStreamer interface {
+	Stream(ctx context.Context, path string) (*Content, error)
+}
+
 type BackupReader interface {
 	Reader(ctx context.Context, path string) (io.ReadCloser, error)
 }
 
+type BackupList interface {
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+type BackupDelete interface {
+	Delete(ctx context.Context, path string) error
+}
+
+type DocumentDelete interface {
+	Delete(ctx context.Context, path string) error
+}
+
 type DocumentStorage interface {
 	DocumentSave
 	DocumentGet
+	DocumentReader
+	DocumentStreamer
+	DocumentDelete
 }
 
 type BackupStorage interface {
 	BackupSave
 	BackupReader
+	BackupList
+	BackupDelete
+}
+
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
 }
 
 type BucketStorage struct {
 	Bucket *blob.Bucket
+	signer *URLSigner // set when the bucket is unable to sign urls itself
 }
 
-func NewBucketStorage(config BucketConfig) *BucketStorage {
+// NewBucketStorage opens the bucket based on the scheme of the connection string (file://, mem://, s3:// or gs://).
+// Buckets that can't create signed urls have them served through the /files/ route instead.
+func NewBucketStorage(config BucketConfig, signer *URLSigner) *BucketStorage {
+	u, err := url.Parse(config.ConnectionString)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to parse bucket host")
+	}
+
+	switch u.Scheme {
+	case "gs":
+		return NewGCPBucketStorage(config)
+	case "file":
+		if err := os.MkdirAll(u.Path, 0755); err != nil {
+			logrus.WithError(err).Fatal("unable to create bucket directory")
+		}
+	}
+
 	bucket, err := blob.OpenBucket(context.Background(), config.ConnectionString)
 	if err != nil {
 		logrus.WithError(err).Fatal("unable to connect to bucket")
 	}
-	return &BucketStorage{
+	storage := &BucketStorage{
 		Bucket: bucket,
 	}
+	if u.Scheme != "s3" {
+		// the signing key has to be its own, anyone holding it can read every library in the bucket
+		if config.SigningKey == "" || config.SigningKey == os.Getenv("JWT_SECRET") {
+			logrus.WithField("scheme", u.Scheme).Fatal("BUCKET_SIGNING_KEY must be set to a key of its own for this bucket")
+		}
+		storage.signer = signer
+	}
+	logrus.WithField("scheme", u.Scheme).Info("connected to bucket")
+	return storage
 }
 
 // TODO redo this to pass in variables
@@ -135,6 +196,18 @@ func (s *BucketStorage) Get(ctx context.Context, path string) (string, error) {
 		Expiry: 15 * time.Hour,
 		Method: "GET",
 	}
+	grant, shared := GrantFromContext(ctx)
+	if s.signer != nil && shared {
+		identity, _ := IdentityFromContext(ctx)
+		return s.signer.SignShared(path, opts.Expiry, grant, identity.ID), nil
+	}
+	if s.signer != nil {
+		return s.signer.Sign(path, opts.Expiry), nil
+	}
+	if shared {
+		// the bucket's own urls can't be revoked with the share, so they only live long enough to be opened
+		opts.Expiry = 15 * time.Minute
+	}
 	return s.Bucket.SignedURL(ctx, path, opts)
 }
 
@@ -170,3 +243,26 @@ func (s *BucketStorage) List(ctx context.Context) <-chan string {
 	}()
 	return out
 }
+
+func (s *BucketStorage) Delete(ctx context.Context, path string) error {
+	return s.Bucket.Delete(ctx, path)
+}
+
+func (s *BucketStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	iter := s.Bucket.List(&blob.ListOptions{Prefix: prefix})
+	objects := []ObjectInfo{}
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			logrus.WithError(err).Error("unable to fetch object")
+			return nil, errors.Wrap(err, "unable to list objects")
+		}
+		if obj.IsDir {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime})
+	}
+}
@@ -2,13 +2,18 @@ package database
 
 import (
 	"alexandria/internal/backup"
+	"alexandria/internal/common"
 	"alexandria/internal/documents"
+	"alexandria/internal/graph"
 	"alexandria/internal/journal"
 	"alexandria/internal/links"
 	"alexandria/internal/tags"
 	"context"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"time"
 )
 
 func NewBackupRepository(psql *PostgresDatabase, neo *Neo4jDatabase) backup.Repository {
@@ -23,32 +28,282 @@ type backupRepo struct {
 	neo      *Neo4jDatabase
 }
 
-func (r *backupRepo) FindAllTags() ([]tags.Tag, error) {
-	return r.postgres.FindAllTags()
+func (r *backupRepo) FindAllTags(ctx context.Context) ([]tags.Tag, error) {
+	return r.postgres.FindAllTags(ctx)
 }
-func (r *backupRepo) FindAll(ctx context.Context, filter map[string]interface{}) ([]*documents.Document, error) {
+func (r *backupRepo) FindAll(ctx context.Context, filter documents.Filter) ([]*documents.Document, error) {
 	return r.postgres.FindAll(ctx, filter)
 }
-func (r *backupRepo) FindAllLinks() ([]links.Link, error) {
-	return r.postgres.FindAllLinks()
+func (r *backupRepo) FindAllLinks(ctx context.Context) ([]links.Link, error) {
+	return r.postgres.FindAllLinks(ctx)
 }
-func (r *backupRepo) FindAllEntries() ([]journal.Entry, error) {
-	return r.postgres.FindAllEntries()
+func (r *backupRepo) FindAllEntries(ctx context.Context) ([]journal.Entry, error) {
+	return r.postgres.FindAllEntries(ctx)
 }
-func (r *backupRepo) Restore(b backup.Backup) error {
-	eg, _ := errgroup.WithContext(context.Background())
+func (r *backupRepo) FindChangedSince(ctx context.Context, since time.Time) (backup.Backup, error) {
+	return r.postgres.FindChangedSince(ctx, since)
+}
+func (r *backupRepo) FindAllIDs(ctx context.Context) (backup.Index, error) {
+	return r.postgres.FindAllIDs(ctx)
+}
+func (r *backupRepo) StartRun(ctx context.Context, run backup.Run) (int64, error) {
+	return r.postgres.StartRun(ctx, run)
+}
+func (r *backupRepo) FinishRun(ctx context.Context, run backup.Run) error {
+	return r.postgres.FinishRun(ctx, run)
+}
+func (r *backupRepo) FindRuns(ctx context.Context, limit int) ([]backup.Run, error) {
+	return r.postgres.FindRuns(ctx, limit)
+}
+func (r *backupRepo) Restore(b backup.Backup, target backup.Restore, mode backup.RestoreMode) (report backup.RestoreReport, err error) {
+	if target.IncludesDocuments() {
+		if report.Postgres, err = r.postgres.Restore(b, mode); err != nil {
+			logrus.WithError(err).Error("unable to restore postgres")
+			return report, err
+		}
+	}
 
-	eg.Go(func() error {
-		return r.postgres.Restore(b)
-	})
+	switch target {
+	case backup.RestoreGraph:
+		report.Graph, err = r.restoreGraph(b, mode == backup.ModeReplace, mode == backup.ModeDryRun)
+	case backup.RestoreAll:
+		// after a merge postgres holds more than the backup so the graph is brought in line with postgres instead
+		source := b
+		if mode != backup.ModeDryRun {
+			if source, err = r.currentBackup(); err != nil {
+				return report, err
+			}
+		}
+		report.Graph, err = r.restoreGraph(source, mode != backup.ModeDryRun, mode == backup.ModeDryRun)
+	case backup.RestorePostgres:
+		logrus.Warn("graph was not restored, rebuild it if it has drifted")
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to restore graph")
+		return report, err
+	}
+	return report, nil
+}
+
+func (r *backupRepo) currentBackup() (b backup.Backup, err error) {
+	ctx := common.WithSystem(context.Background())
+	if b.Docs, err = r.postgres.FindAll(ctx, documents.Filter{}); err != nil {
+		return b, err
+	}
+	if b.Links, err = r.postgres.FindAllLinks(ctx); err != nil {
+		return b, err
+	}
+	if b.Tags, err = r.postgres.FindAllTags(ctx); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// restoreGraph merges the nodes and edges of a backup into the graph, with replace anything not in the backup is
+// removed first
+func (r *backupRepo) restoreGraph(b backup.Backup, replace bool, dryRun bool) (map[string]*backup.RestoreCounts, error) {
+	report := map[string]*backup.RestoreCounts{"nodes": {}, "edges": {}}
+
+	currentNodes, err := r.neo.FindNodes()
+	if err != nil {
+		return nil, err
+	}
+	currentEdges, err := r.neo.FindEdges()
+	if err != nil {
+		return nil, err
+	}
+	nodes, edges := backupGraph(b)
+
+	existing := map[string]bool{}
+	for _, n := range currentNodes {
+		existing[n.ID] = true
+	}
+	wanted := map[string]bool{}
+	for _, n := range nodes {
+		wanted[n.ID] = true
+		count(report["nodes"], !existing[n.ID], existing[n.ID])
+	}
 
-	eg.Go(func() error {
-		return r.neo.Restore(b)
+	existingEdges := map[graph.Edge]bool{}
+	for _, e := range currentEdges {
+		existingEdges[graph.Edge{ResourceID: e.ResourceID, TagID: e.TagID}] = true
+	}
+	wantedEdges := map[graph.Edge]bool{}
+	for _, e := range edges {
+		key := graph.Edge{ResourceID: e.ResourceID, TagID: e.TagID}
+		wantedEdges[key] = true
+		count(report["edges"], !existingEdges[key], false)
+	}
+
+	if replace {
+		for _, n := range currentNodes {
+			if wanted[n.ID] {
+				continue
+			}
+			report["nodes"].Deleted++
+			if !dryRun {
+				if err := r.neo.DeleteNode(n.ID, n.Label); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, e := range currentEdges {
+			if wantedEdges[e] || !wanted[e.ResourceID] {
+				continue
+			}
+			report["edges"].Deleted++
+			if !dryRun {
+				if err := r.neo.RemoveResourceTag(e.ResourceID, e.TagID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if err := r.neo.Restore(b); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func backupGraph(b backup.Backup) (nodes []graph.Node, edges []graph.Edge) {
+	for _, t := range b.Tags {
+		nodes = append(nodes, graph.Node{ID: t.ID, Label: "Tag"})
+	}
+	for _, d := range b.Docs {
+		nodes = append(nodes, graph.Node{ID: d.ID, Label: getNodeType(d.Type)})
+		for _, t := range d.Tags {
+			edges = append(edges, graph.Edge{ResourceID: d.ID, TagID: t, ResourceType: d.Type})
+		}
+	}
+	for _, l := range b.Links {
+		nodes = append(nodes, graph.Node{ID: l.ID, Label: "Link"})
+		for _, t := range l.Tags {
+			edges = append(edges, graph.Edge{ResourceID: l.ID, TagID: t, ResourceType: tags.LinksResource})
+		}
+	}
+	return nodes, edges
+}
+
+// FindChangedSince returns every row created or updated after since
+func (r *PostgresDatabase) FindChangedSince(ctx context.Context, since time.Time) (backup.Backup, error) {
+	eg, _ := errgroup.WithContext(ctx)
+
+	b := backup.Backup{}
+	eg.Go(func() (err error) {
+		b.Docs, _, err = r.findDocuments(ctx, sq.Gt{"COALESCE(documents.updated, documents.created)": since}, defaultDocumentPage)
+		return err
+	})
+	eg.Go(func() (err error) {
+		b.Journal, _, err = r.findEntries(ctx, sq.Gt{"created": since}, defaultEntryPage)
+		return err
+	})
+	eg.Go(func() (err error) {
+		b.Links, _, err = r.findLinks(ctx, sq.Gt{"links.updated": since}, defaultLinkPage)
+		return err
+	})
+	eg.Go(func() (err error) {
+		b.Tags, _, err = r.findTags(ctx, sq.Gt{"updated": since}, defaultTagPage)
+		return err
 	})
 
 	if err := eg.Wait(); err != nil {
-		logrus.WithError(err).Error("unable to restore")
-		return err
+		logrus.WithError(err).Error("unable to find changed rows")
+		return b, errors.New("unable to find changed rows")
+	}
+	return b, nil
+}
+
+func (r *PostgresDatabase) FindAllIDs(ctx context.Context) (idx backup.Index, err error) {
+	if idx.Docs, err = r.findIDs("documents"); err != nil {
+		return idx, err
+	}
+	if idx.Journal, err = r.findIDs("journal_entry"); err != nil {
+		return idx, err
+	}
+	if idx.Links, err = r.findIDs("links"); err != nil {
+		return idx, err
+	}
+	if idx.Tags, err = r.findIDs("tags"); err != nil {
+		return idx, err
+	}
+	return idx, nil
+}
+
+func (r *PostgresDatabase) findIDs(table string) ([]string, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select("id").From(table).RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).WithField("table", table).Error("unable to find ids")
+		return nil, errors.New("unable to find ids")
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			logrus.WithError(err).Warn("unable to scan id")
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (r *PostgresDatabase) StartRun(ctx context.Context, run backup.Run) (id int64, err error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if err := ps.Insert("backup_runs").
+		Columns("trigger", "started", "outcome").
+		Values(run.Trigger, run.Started, run.Outcome).
+		Suffix("RETURNING id").
+		RunWith(r.conn).QueryRowContext(ctx).Scan(&id); err != nil {
+		logrus.WithError(err).Error("unable to insert backup run")
+		return 0, errors.New("unable to insert backup run")
+	}
+	return id, nil
+}
+
+func (r *PostgresDatabase) FinishRun(ctx context.Context, run backup.Run) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("backup_runs").SetMap(map[string]interface{}{
+		"finished":  run.Finished,
+		"backup_id": run.BackupID,
+		"kind":      run.Kind,
+		"size":      run.Size,
+		"outcome":   run.Outcome,
+		"error":     run.Error,
+	}).Where(sq.Eq{"id": run.ID}).RunWith(r.conn).ExecContext(ctx); err != nil {
+		logrus.WithError(err).Error("unable to update backup run")
+		return errors.New("unable to update backup run")
 	}
 	return nil
 }
+
+func (r *PostgresDatabase) FindRuns(ctx context.Context, limit int) ([]backup.Run, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select("id", "trigger", "started", "finished", "COALESCE(backup_id, '')", "COALESCE(kind, '')", "size", "outcome", "COALESCE(error, '')").
+		From("backup_runs").
+		OrderBy("started DESC").
+		Limit(uint64(limit)).
+		RunWith(r.conn).QueryContext(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find backup runs")
+		return nil, errors.New("unable to find backup runs")
+	}
+	defer rows.Close()
+
+	runs := []backup.Run{}
+	for rows.Next() {
+		var run backup.Run
+		if err := rows.Scan(&run.ID, &run.Trigger, &run.Started, &run.Finished, &run.BackupID, &run.Kind, &run.Size, &run.Outcome, &run.Error); err != nil {
+			logrus.WithError(err).Warn("unable to scan backup run")
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
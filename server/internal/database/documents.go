@@ -1,8 +1,14 @@
 package database
 
 import (
+	"alexandria/internal/common"
 	"alexandria/internal/documents"
+	"alexandria/internal/tags"
 	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
 )
 
 type documentsRepo struct {
@@ -17,29 +23,142 @@ func NewDocumentRepository(psql *PostgresDatabase, neo *Neo4jDatabase) documents
 	}
 }
 
-func (r *documentsRepo) FindAll(ctx context.Context, filter map[string]interface{}) ([]*documents.Document, error) {
+func (r *documentsRepo) FindAll(ctx context.Context, filter documents.Filter) ([]*documents.Document, error) {
 	return r.postgres.FindAll(ctx, filter)
 }
 
+func (r *documentsRepo) FindPage(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error) {
+	return r.postgres.FindPage(ctx, filter, page)
+}
+
 func (r *documentsRepo) FindByID(ctx context.Context, id string) (*documents.Document, error) {
 	return r.postgres.FindByID(ctx, id)
 }
 
+func (r *documentsRepo) FindByHash(ctx context.Context, hash string) (*documents.Document, error) {
+	return r.postgres.FindByHash(ctx, hash)
+}
+
+func (r *documentsRepo) SetHash(ctx context.Context, id, hash string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("documents").Set("sha256", hash).
+		Where(sq.Eq{"id": id}).
+		Where(ownerPred(ctx, "owner_id")).RunWith(r.postgres.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to set doc hash")
+		return errors.New("unable to set doc hash")
+	}
+	return nil
+}
+
 func (r *documentsRepo) Insert(ctx context.Context, document *documents.Document) error {
-	if err := r.postgres.Insert(ctx, document); err != nil {
+	return r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.postgres.insertDocument(ctx, tx, document); err != nil {
+			return err
+		}
+		return enqueue(tx, eventDocumentUpserted, document)
+	})
+}
+
+func (r *documentsRepo) Delete(ctx context.Context, id string) error {
+	doc, err := r.postgres.FindByID(ctx, id)
+	if err != nil {
 		return err
 	}
-	return r.neo.Insert(ctx, document)
+	label := ""
+	if doc.Type != "" {
+		label = getNodeType(doc.Type)
+	}
+	return r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.postgres.deleteDocument(ctx, tx, id); err != nil {
+			return err
+		}
+		return enqueue(tx, eventNodeDeleted, nodeDeletedEvent{ID: id, Label: label})
+	})
 }
 
-func (r *documentsRepo) Delete(ctx context.Context, id string) error {
-	return r.postgres.Delete(ctx, id)
+func (r *documentsRepo) UpdateDocument(ctx context.Context, document documents.Document) (result documents.Document, err error) {
+	err = r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		result, err = r.postgres.updateDocument(ctx, tx, document)
+		if err != nil {
+			return err
+		}
+		return enqueue(tx, eventDocumentUpserted, result)
+	})
+	return result, err
 }
 
-func (r *documentsRepo) UpdateDocument(ctx context.Context, document documents.Document) (documents.Document, error) {
-	return r.postgres.UpdateDocument(ctx, document)
+func (r *documentsRepo) Merge(ctx context.Context, document documents.Document, duplicates []*documents.Document) (result documents.Document, err error) {
+	current, err := r.postgres.FindByID(ctx, document.ID)
+	if err != nil {
+		return result, err
+	}
+	tagged := map[string]bool{}
+	for _, id := range current.Tags {
+		tagged[id] = true
+	}
+	resourceType := tags.BookResource
+	if document.Type == "paper" {
+		resourceType = tags.PaperResource
+	}
+
+	err = r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		result, err = r.postgres.updateDocument(ctx, tx, document)
+		if err != nil {
+			return err
+		}
+		if document.Path != current.Path {
+			if err := r.postgres.moveDocumentFile(tx, document); err != nil {
+				return err
+			}
+		}
+		for _, id := range document.Tags {
+			if tagged[id] {
+				continue
+			}
+			t, err := r.postgres.getTagByID(tx, id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT INTO tagged_resources(id, resource_id, resource_type) VALUES ($1, $2, $3)", id, document.ID, resourceType); err != nil {
+				logrus.WithError(err).Error("unable to add tag")
+				return errors.New("unable to add tag")
+			}
+			if err := enqueue(tx, eventResourceTagged, resourceTagEvent{ResourceID: document.ID, ResourceType: resourceType, Tag: t}); err != nil {
+				return err
+			}
+		}
+		for _, duplicate := range duplicates {
+			if err := r.postgres.deleteDocument(ctx, tx, duplicate.ID); err != nil {
+				return err
+			}
+			if err := enqueue(tx, eventNodeDeleted, nodeDeletedEvent{ID: duplicate.ID, Label: getNodeType(duplicate.Type)}); err != nil {
+				return err
+			}
+		}
+		return enqueue(tx, eventDocumentUpserted, result)
+	})
+	return result, err
 }
 
 func (r *documentsRepo) UpsertStream(ctx context.Context, input <-chan *documents.Document) error {
-	return r.postgres.UpsertStream(ctx, input)
+	ctx = common.WithSystem(ctx)
+	count := 0
+	for doc := range input {
+		if exists, _ := r.postgres.existsByPath(ctx, doc.Path); exists {
+			continue
+		}
+		if exists, err := r.postgres.userExists(ctx, doc.Owner); err != nil {
+			return err
+		} else if !exists {
+			logrus.WithField("path", doc.Path).Warn("skipping document of unknown user")
+			continue
+		}
+		if err := r.Insert(ctx, doc); err != nil {
+			logrus.WithError(err).Info("unable to upsert document")
+			return errors.New("unable to upsert document")
+		}
+		count++
+	}
+	logrus.WithField("count", count).Info("documents added")
+	return nil
 }
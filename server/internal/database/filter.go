@@ -0,0 +1,58 @@
+package database
+
+import (
+	"alexandria/internal/documents"
+	sq "github.com/Masterminds/squirrel"
+	"strings"
+)
+
+const taggedWithSQL = `EXISTS (SELECT 1 FROM tagged_resources tr JOIN tags t ON t.id = tr.id
+	WHERE tr.resource_id = documents.id AND t.owner_id = documents.owner_id AND t.display_name = ?)`
+
+// documentFilter turns a parsed filter into a where clause, only values are passed through as arguments
+func documentFilter(f documents.Filter) sq.Sqlizer {
+	pred := sq.And{}
+	if f.Type != "" {
+		pred = append(pred, sq.Eq{"documents.type": f.Type})
+	}
+	if f.Format != "" {
+		pred = append(pred, sq.Eq{"documents.format": f.Format})
+	}
+	for _, tag := range f.Tags {
+		pred = append(pred, sq.Expr(taggedWithSQL, tag))
+	}
+	for _, tag := range f.ExcludeTags {
+		pred = append(pred, sq.Expr("NOT "+taggedWithSQL, tag))
+	}
+	if f.CreatedAfter != nil {
+		pred = append(pred, sq.GtOrEq{"documents.created": *f.CreatedAfter})
+	}
+	if f.CreatedBefore != nil {
+		pred = append(pred, sq.Lt{"documents.created": *f.CreatedBefore})
+	}
+	if f.UpdatedAfter != nil {
+		pred = append(pred, sq.GtOrEq{"COALESCE(documents.updated, documents.created)": *f.UpdatedAfter})
+	}
+	if f.UpdatedBefore != nil {
+		pred = append(pred, sq.Lt{"COALESCE(documents.updated, documents.created)": *f.UpdatedBefore})
+	}
+	if f.NamePrefix != "" {
+		pred = append(pred, sq.Expr("documents.display_name ILIKE ?", escapeLike(f.NamePrefix)+"%"))
+	}
+	if f.NameContains != "" {
+		pred = append(pred, sq.Expr("documents.display_name ILIKE ?", "%"+escapeLike(f.NameContains)+"%"))
+	}
+	if f.MissingDescription {
+		pred = append(pred, sq.Expr("COALESCE(documents.description, '') = ''"))
+	}
+	if len(pred) == 0 {
+		return nil
+	}
+	return pred
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"alexandria/internal/graph"
+	"alexandria/internal/tags"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+type graphRepo struct {
+	postgres *PostgresDatabase
+	neo      *Neo4jDatabase
+}
+
+func NewGraphRepository(psql *PostgresDatabase, neo *Neo4jDatabase) graph.Repository {
+	return &graphRepo{
+		postgres: psql,
+		neo:      neo,
+	}
+}
+
+func (r *graphRepo) OutboxStatus(ctx context.Context) (status graph.OutboxStatus, err error) {
+	var oldest *time.Time
+	var lastError sql.NullString
+	if err := r.postgres.conn.QueryRowContext(ctx, `SELECT
+			COUNT(*) FILTER (WHERE attempts < $1),
+			COUNT(*) FILTER (WHERE attempts >= $1),
+			MIN(created) FILTER (WHERE attempts < $1),
+			(SELECT last_error FROM graph_outbox WHERE processed IS NULL AND last_error IS NOT NULL ORDER BY id DESC LIMIT 1)
+		FROM graph_outbox WHERE processed IS NULL`, outboxMaxAttempts).
+		Scan(&status.Pending, &status.Failed, &oldest, &lastError); err != nil {
+		logrus.WithError(err).Error("unable to fetch outbox status")
+		return status, errors.New("unable to fetch outbox status")
+	}
+	status.OldestPending = oldest
+	status.LastError = lastError.String
+	return status, nil
+}
+
+func (r *graphRepo) SourceGraph(ctx context.Context) (nodes []graph.Node, edges []graph.Edge, err error) {
+	rows, err := r.postgres.conn.QueryContext(ctx, `SELECT id, CASE WHEN type = 'paper' THEN 'Paper' ELSE 'Book' END, owner_id::text FROM documents
+		UNION ALL SELECT id, 'Link', owner_id::text FROM links
+		UNION ALL SELECT id, 'Tag', owner_id::text FROM tags`)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch source nodes")
+		return nil, nil, errors.New("unable to fetch source nodes")
+	}
+	for rows.Next() {
+		var n graph.Node
+		if err := rows.Scan(&n.ID, &n.Label, &n.Owner); err != nil {
+			logrus.WithError(err).Warn("unable to scan node")
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+
+	rows, err = r.postgres.conn.QueryContext(ctx, "SELECT DISTINCT resource_id, id, resource_type FROM tagged_resources")
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch source edges")
+		return nil, nil, errors.New("unable to fetch source edges")
+	}
+	for rows.Next() {
+		var e graph.Edge
+		if err := rows.Scan(&e.ResourceID, &e.TagID, &e.ResourceType); err != nil {
+			logrus.WithError(err).Warn("unable to scan edge")
+			continue
+		}
+		edges = append(edges, e)
+	}
+	rows.Close()
+	return nodes, edges, nil
+}
+
+func (r *graphRepo) CurrentGraph(_ context.Context) ([]graph.Node, []graph.Edge, error) {
+	nodes, err := r.neo.FindNodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	edges, err := r.neo.FindEdges()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, edges, nil
+}
+
+// EnqueueRepair writes removals before additions so a relabeled or recreated node ends up with its edges
+func (r *graphRepo) EnqueueRepair(ctx context.Context, drift graph.DriftReport) (queued int, err error) {
+	ctx = common.WithSystem(ctx)
+	err = r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		for _, n := range drift.ExtraNodes {
+			if err := enqueue(tx, eventNodeDeleted, nodeDeletedEvent{ID: n.ID, Label: n.Label}); err != nil {
+				return err
+			}
+			queued++
+		}
+		for _, e := range drift.ExtraEdges {
+			if err := enqueue(tx, eventResourceUntagged, resourceTagEvent{ResourceID: e.ResourceID, Tag: tags.Tag{ID: e.TagID}}); err != nil {
+				return err
+			}
+			queued++
+		}
+		for _, n := range drift.MissingNodes {
+			eventType, payload, err := r.nodePayload(ctx, tx, n)
+			if err != nil {
+				return err
+			}
+			if err := enqueue(tx, eventType, payload); err != nil {
+				return err
+			}
+			queued++
+		}
+		for _, e := range drift.MissingEdges {
+			t, err := r.postgres.getTagByID(tx, e.TagID)
+			if err != nil {
+				return err
+			}
+			if err := enqueue(tx, eventResourceTagged, resourceTagEvent{ResourceID: e.ResourceID, ResourceType: e.ResourceType, Tag: t}); err != nil {
+				return err
+			}
+			queued++
+		}
+		return nil
+	})
+	return queued, err
+}
+
+func (r *graphRepo) nodePayload(ctx context.Context, tx *sql.Tx, n graph.Node) (string, interface{}, error) {
+	switch n.Label {
+	case "Book", "Paper":
+		doc, err := r.postgres.FindByID(ctx, n.ID)
+		return eventDocumentUpserted, doc, err
+	case "Link":
+		l, err := r.postgres.FindLinkByID(ctx, n.ID)
+		return eventLinkUpserted, l, err
+	case "Tag":
+		t, err := r.postgres.getTagByID(tx, n.ID)
+		return eventTagUpserted, t, err
+	default:
+		return "", nil, errors.New("unknown node label")
+	}
+}
+
+func (r *graphRepo) RetryFailed(ctx context.Context) (int, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	res, err := ps.Update("graph_outbox").
+		Set("attempts", 0).
+		Set("next_attempt", sq.Expr("now()")).
+		Where(sq.Eq{"processed": nil}).
+		Where(sq.GtOrEq{"attempts": outboxMaxAttempts}).
+		RunWith(r.postgres.conn).ExecContext(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to reset failed events")
+		return 0, errors.New("unable to reset failed events")
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (r *graphRepo) Rebuild(ctx context.Context, drift graph.DriftReport, wipe bool) (result graph.RebuildResult, err error) {
+	ctx = common.WithSystem(ctx)
+	result.Nodes = map[string]int{"Book": 0, "Paper": 0, "Link": 0, "Tag": 0}
+
+	if wipe {
+		result.RemovedNodes, err = r.neo.DeleteAll()
+		if err != nil {
+			return result, err
+		}
+	} else {
+		for _, n := range drift.ExtraNodes {
+			if err := r.neo.DeleteNode(n.ID, n.Label); err != nil {
+				return result, err
+			}
+			result.RemovedNodes++
+		}
+		for _, e := range drift.ExtraEdges {
+			if err := r.neo.RemoveResourceTag(e.ResourceID, e.TagID); err != nil {
+				return result, err
+			}
+			result.RemovedEdges++
+		}
+	}
+
+	allTags, err := r.postgres.FindAllTags(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, t := range allTags {
+		if _, err := r.neo.CreateTag(t); err != nil {
+			return result, err
+		}
+		result.Nodes["Tag"]++
+	}
+
+	docs, err := r.postgres.FindAll(ctx, documents.Filter{})
+	if err != nil {
+		return result, err
+	}
+	for _, doc := range docs {
+		if err := r.neo.Insert(ctx, doc); err != nil {
+			return result, err
+		}
+		result.Nodes[getNodeType(doc.Type)]++
+	}
+
+	allLinks, err := r.postgres.FindAllLinks(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, l := range allLinks {
+		if _, err := r.neo.CreateLink(l); err != nil {
+			return result, err
+		}
+		result.Nodes["Link"]++
+	}
+
+	_, edges, err := r.SourceGraph(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, e := range edges {
+		if err := r.neo.addResourceTagByID(e.ResourceID, e.ResourceType, e.TagID); err != nil {
+			return result, err
+		}
+		result.Edges++
+	}
+	return result, nil
+}
+
+func (r *Neo4jDatabase) FindNodes() ([]graph.Node, error) {
+	nodes := []graph.Node{}
+	sess, err := r.conn.Session(neo4j.AccessModeRead)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create session")
+		return nodes, errors.New("unable to create session")
+	}
+	defer sess.Close()
+
+	result, err := sess.Run("MATCH (n) WHERE n:Book OR n:Paper OR n:Link OR n:Tag RETURN n.id, labels(n), n.owner", nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch nodes")
+		return nodes, errors.New("unable to fetch nodes")
+	}
+	for result.Next() {
+		id, _ := result.Record().GetByIndex(0).(string)
+		labels, _ := result.Record().GetByIndex(1).([]interface{})
+		owner, _ := result.Record().GetByIndex(2).(string)
+		n := graph.Node{ID: id, Owner: owner}
+		for _, l := range labels {
+			if label, ok := l.(string); ok {
+				n.Label = label
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	if err := result.Err(); err != nil {
+		logrus.WithError(err).Error("unable to fetch nodes")
+		return nodes, errors.New("unable to fetch nodes")
+	}
+	return nodes, nil
+}
+
+func (r *Neo4jDatabase) FindEdges() ([]graph.Edge, error) {
+	edges := []graph.Edge{}
+	sess, err := r.conn.Session(neo4j.AccessModeRead)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create session")
+		return edges, errors.New("unable to create session")
+	}
+	defer sess.Close()
+
+	result, err := sess.Run("MATCH (a)-[:HAS_TAG]->(b:Tag) RETURN DISTINCT a.id, b.id", nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch edges")
+		return edges, errors.New("unable to fetch edges")
+	}
+	for result.Next() {
+		resourceID, _ := result.Record().GetByIndex(0).(string)
+		tagID, _ := result.Record().GetByIndex(1).(string)
+		edges = append(edges, graph.Edge{ResourceID: resourceID, TagID: tagID})
+	}
+	if err := result.Err(); err != nil {
+		logrus.WithError(err).Error("unable to fetch edges")
+		return edges, errors.New("unable to fetch edges")
+	}
+	return edges, nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"alexandria/internal/user"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var apiKeyColumns = []string{"id", "user_id", "name", "prefix", "scope", "created", "last_used"}
+
+func NewKeyRepository(database *PostgresDatabase) user.KeyRepository {
+	return database
+}
+
+func (r *PostgresDatabase) CreateAPIKey(ctx context.Context, key user.APIKey, hash string) error {
+	res, err := r.conn.ExecContext(ctx, `INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scope, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (user_id, name) DO NOTHING`,
+		key.ID, key.UserID, key.Name, key.Prefix, hash, key.Scope, key.Created)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create api key")
+		return errors.New("unable to create api key")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return user.ErrKeyExists
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FindAPIKeys(ctx context.Context, userID string) ([]user.APIKey, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("created").
+		RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find api keys")
+		return nil, errors.New("unable to find api keys")
+	}
+	defer rows.Close()
+
+	keys := []user.APIKey{}
+	for rows.Next() {
+		var key user.APIKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scope, &key.Created, &key.LastUsed); err != nil {
+			logrus.WithError(err).Warn("unable to scan api key")
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *PostgresDatabase) FindAPIKeyByHash(ctx context.Context, hash string) (*user.APIKey, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	var key user.APIKey
+	if err := ps.Select(apiKeyColumns...).
+		From("api_keys").
+		Where(sq.Eq{"key_hash": hash}).
+		RunWith(r.conn).QueryRow().
+		Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scope, &key.Created, &key.LastUsed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		logrus.WithError(err).Error("unable to find api key")
+		return nil, errors.New("unable to find api key")
+	}
+	return &key, nil
+}
+
+func (r *PostgresDatabase) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return user.ErrKeyNotFound
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	res, err := ps.Delete("api_keys").Where(sq.Eq{"id": id, "user_id": userID}).RunWith(r.conn).Exec()
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete api key")
+		return errors.New("unable to delete api key")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return user.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) TouchAPIKey(ctx context.Context, id string, used time.Time) error {
+	if _, err := r.conn.ExecContext(ctx, `UPDATE api_keys SET last_used = $1
+		WHERE id = $2 AND (last_used IS NULL OR last_used < $3)`, used, id, used.Add(-time.Minute)); err != nil {
+		logrus.WithError(err).Error("unable to update api key")
+		return errors.New("unable to update api key")
+	}
+	return nil
+}
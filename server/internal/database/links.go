@@ -1,6 +1,11 @@
 package database
 
-import "alexandria/internal/links"
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/links"
+	"context"
+	"database/sql"
+)
 
 type linksRepo struct {
 	postgres *PostgresDatabase
@@ -14,18 +19,25 @@ func NewLinksRepository(psql *PostgresDatabase, neo *Neo4jDatabase) links.Reposi
 	}
 }
 
-func (r *linksRepo) FindAllLinks() ([]links.Link, error) {
-	return r.postgres.FindAllLinks()
+func (r *linksRepo) FindAllLinks(ctx context.Context) ([]links.Link, error) {
+	return r.postgres.FindAllLinks(ctx)
 }
 
-func (r *linksRepo) FindLinkByID(id string) (links.Link, error) {
-	return r.postgres.FindLinkByID(id)
+func (r *linksRepo) FindLinksPage(ctx context.Context, page common.PageRequest) ([]links.Link, string, error) {
+	return r.postgres.FindLinksPage(ctx, page)
 }
 
-func (r *linksRepo) CreateLink(l links.Link) (links.Link, error) {
-	nl, err := r.postgres.CreateLink(l)
-	if err != nil {
-		return l, err
-	}
-	return r.neo.CreateLink(nl)
+func (r *linksRepo) FindLinkByID(ctx context.Context, id string) (links.Link, error) {
+	return r.postgres.FindLinkByID(ctx, id)
+}
+
+func (r *linksRepo) CreateLink(ctx context.Context, l links.Link) (nl links.Link, err error) {
+	err = r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		nl, err = r.postgres.createLink(ctx, tx, l)
+		if err != nil {
+			return err
+		}
+		return enqueue(tx, eventLinkUpserted, nl)
+	})
+	return nl, err
 }
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"alexandria/internal/user"
+	"context"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// countedFailure leaves out the attempts refused by a lockout, otherwise retrying while locked out would keep extending it
+const countedFailure = "NOT success AND COALESCE(reason, '') NOT IN ('" + user.ReasonLockedOut + "', '" + user.ReasonRateLimited + "')"
+
+// failures for a username only count after its last successful login
+const failedLoginsQuery = `SELECT
+	(SELECT count(*) FROM login_attempts WHERE username = $1 AND ` + countedFailure + ` AND created > $3
+		AND created > COALESCE((SELECT max(created) FROM login_attempts WHERE username = $1 AND success), $3)),
+	(SELECT count(*) FROM login_attempts WHERE ip = $2 AND ` + countedFailure + ` AND created > $3),
+	COALESCE((SELECT max(created) FROM login_attempts WHERE (username = $1 OR ip = $2) AND ` + countedFailure + `
+		AND created > $3), $3)`
+
+func NewAuditRepository(database *PostgresDatabase) user.AuditRepository {
+	return database
+}
+
+func (r *PostgresDatabase) RecordLoginAttempt(ctx context.Context, attempt user.LoginAttempt) error {
+	var userID interface{}
+	if attempt.UserID != "" {
+		userID = attempt.UserID
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("login_attempts").
+		Columns("username", "user_id", "ip", "success", "reason", "created").
+		Values(attempt.Username, userID, attempt.IP, attempt.Success, attempt.Reason, attempt.Created).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to record login attempt")
+		return errors.New("unable to record login attempt")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FailedLogins(ctx context.Context, username, ip string, since time.Time) (byUser int, byIP int, last time.Time, err error) {
+	if err := r.conn.QueryRowContext(ctx, failedLoginsQuery, username, ip, since).Scan(&byUser, &byIP, &last); err != nil {
+		logrus.WithError(err).Error("unable to count failed logins")
+		return 0, 0, last, errors.New("unable to count failed logins")
+	}
+	return byUser, byIP, last, nil
+}
+
+func (r *PostgresDatabase) FindLoginAttempts(ctx context.Context, username string, limit int) ([]user.LoginAttempt, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	q := ps.Select("id", "username", "COALESCE(user_id::varchar, '')", "ip", "success", "COALESCE(reason, '')", "created").
+		From("login_attempts").
+		OrderBy("created DESC").
+		Limit(uint64(limit))
+	if username != "" {
+		q = q.Where(sq.Eq{"username": username})
+	}
+	rows, err := q.RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find login attempts")
+		return nil, errors.New("unable to find login attempts")
+	}
+	defer rows.Close()
+
+	attempts := []user.LoginAttempt{}
+	for rows.Next() {
+		var a user.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Username, &a.UserID, &a.IP, &a.Success, &a.Reason, &a.Created); err != nil {
+			logrus.WithError(err).Warn("unable to scan login attempt")
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, nil
+}
@@ -113,18 +113,12 @@ func (r *Neo4jDatabase) Restore(b backup.Backup) error {
 }
 
 func (r *Neo4jDatabase) CreateLink(entity links.Link) (links.Link, error) {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return entity, errors.New("unable to create session")
-	}
-	defer sess.Close()
-
-	if _, err := sess.Run("CREATE (n:Link { id: $id, display_name: $display_name, link: $link, icon_path: $icon_path })", map[string]interface{}{
+	if err := r.run("MERGE (n:Link { id: $id }) SET n.display_name = $display_name, n.link = $link, n.icon_path = $icon_path, n.owner = $owner", map[string]interface{}{
 		"id":           entity.ID,
 		"display_name": entity.DisplayName,
 		"link":         entity.Link,
 		"icon_path":    entity.IconPath,
+		"owner":        entity.Owner,
 	}); err != nil {
 		logrus.WithError(err).Error("unable to create link nodes")
 		return entity, errors.New("unable to create link node")
@@ -133,70 +127,39 @@ func (r *Neo4jDatabase) CreateLink(entity links.Link) (links.Link, error) {
 	return entity, nil
 }
 
-func (r *Neo4jDatabase) Insert(ctx context.Context, entity *documents.Document) error {
+// Insert merges the document node by id, if the type of the document has changed the existing node is relabeled so
+// its edges are kept.
+func (r *Neo4jDatabase) Insert(_ context.Context, entity *documents.Document) error {
+	label, other := "Book", "Paper"
 	if entity.Type == "paper" {
-		return r.insertPaper(ctx, entity)
-	} else {
-		return r.insertBook(ctx, entity)
-	}
-
-}
-
-func (r *Neo4jDatabase) insertBook(_ context.Context, entity *documents.Document) error {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return errors.New("unable to create session")
-	}
-	defer sess.Close()
-	if _, err := sess.Run("CREATE (n:Book { id: $id, display_name: $display_name, path: $path, name: $name, description: $description })", map[string]interface{}{
-		"id":           entity.ID,
-		"display_name": entity.DisplayName,
-		"path":         entity.Path,
-		"name":         entity.Name,
-		"description":  entity.Description,
-	}); err != nil {
-		logrus.WithError(err).Error("unable to create book nodes")
-		return errors.New("unable to create book node")
-	}
-	return nil
-}
-
-func (r *Neo4jDatabase) insertPaper(_ context.Context, entity *documents.Document) error {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return errors.New("unable to create session")
+		label, other = "Paper", "Book"
 	}
 
-	defer sess.Close()
-	if _, err := sess.Run("CREATE (n:Paper { id: $id, display_name: $display_name, path: $path, name: $name, description: $description}) ", map[string]interface{}{
+	cypher := fmt.Sprintf(`OPTIONAL MATCH (o:%[2]s { id: $id })
+FOREACH (x IN CASE WHEN o IS NULL THEN [] ELSE [o] END | REMOVE x:%[2]s SET x:%[1]s)
+WITH 1 AS ignored
+MERGE (n:%[1]s { id: $id })
+SET n.display_name = $display_name, n.path = $path, n.name = $name, n.description = $description, n.owner = $owner`, label, other)
+	if err := r.run(cypher, map[string]interface{}{
 		"id":           entity.ID,
 		"display_name": entity.DisplayName,
 		"path":         entity.Path,
 		"name":         entity.Name,
 		"description":  entity.Description,
+		"owner":        entity.Owner,
 	}); err != nil {
-		logrus.WithError(err).Error("unable to create paper nodes")
-		return errors.New("unable to create paper node")
+		logrus.WithError(err).WithField("type", entity.Type).Error("unable to create document nodes")
+		return errors.New("unable to create document node")
 	}
-
 	return nil
 }
 
 func (r *Neo4jDatabase) CreateTag(entity tags.Tag) (tags.Tag, error) {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return entity, errors.New("unable to create session")
-	}
-	defer sess.Close()
-
-	// Create tag nodes
-	if _, err := sess.Run("MERGE (n:Tag { id: $id, display_name: $display_name, color: $color })", map[string]interface{}{
+	if err := r.run("MERGE (n:Tag { id: $id }) SET n.display_name = $display_name, n.color = $color, n.owner = $owner", map[string]interface{}{
 		"id":           entity.ID,
 		"display_name": entity.DisplayName,
 		"color":        entity.TagColor,
+		"owner":        entity.Owner,
 	}); err != nil {
 		logrus.WithError(err).Error("unable to create tag nodes")
 		return entity, errors.New("unable to create tag node")
@@ -206,16 +169,9 @@ func (r *Neo4jDatabase) CreateTag(entity tags.Tag) (tags.Tag, error) {
 }
 
 func (r *Neo4jDatabase) AddResourceTag(resourceID string, resourceType tags.ResourceType, tagName string) error {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return errors.New("unable to create session")
-	}
-	defer sess.Close()
-
 	nodeType := getNodeType(resourceType)
-	cypher := fmt.Sprintf("MATCH (a:%s),(b:Tag) WHERE a.id = $resourceID AND b.display_name = $tagName CREATE (a)-[r:HAS_TAG]->(b)", nodeType)
-	if _, err := sess.Run(cypher, map[string]interface{}{
+	cypher := fmt.Sprintf("MATCH (a:%s),(b:Tag) WHERE a.id = $resourceID AND b.display_name = $tagName AND b.owner = a.owner MERGE (a)-[r:HAS_TAG]->(b)", nodeType)
+	if err := r.run(cypher, map[string]interface{}{
 		"resourceID": resourceID,
 		"tagName":    tagName,
 	}); err != nil {
@@ -227,16 +183,9 @@ func (r *Neo4jDatabase) AddResourceTag(resourceID string, resourceType tags.Reso
 }
 
 func (r *Neo4jDatabase) addResourceTagByID(resourceID string, resourceType tags.ResourceType, tagID string) error {
-	sess, err := r.conn.Session(neo4j.AccessModeWrite)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create session")
-		return errors.New("unable to create session")
-	}
-	defer sess.Close()
-
 	nodeType := getNodeType(resourceType)
-	cypher := fmt.Sprintf("MATCH (a:%s),(b:Tag) WHERE a.id = $resourceID AND b.id = $tagID CREATE (a)-[r:HAS_TAG]->(b)", nodeType)
-	if _, err := sess.Run(cypher, map[string]interface{}{
+	cypher := fmt.Sprintf("MATCH (a:%s),(b:Tag) WHERE a.id = $resourceID AND b.id = $tagID MERGE (a)-[r:HAS_TAG]->(b)", nodeType)
+	if err := r.run(cypher, map[string]interface{}{
 		"resourceID": resourceID,
 		"tagID":      tagID,
 	}); err != nil {
@@ -247,27 +196,77 @@ func (r *Neo4jDatabase) addResourceTagByID(resourceID string, resourceType tags.
 	return nil
 }
 
-func (r *Neo4jDatabase) RemoveResourceTag(resourceID string, tagName string) error {
+func (r *Neo4jDatabase) RemoveResourceTag(resourceID string, tagID string) error {
+	if err := r.run("MATCH (a)-[r:HAS_TAG]->(b:Tag) WHERE a.id = $resourceID AND b.id = $tagID DELETE r", map[string]interface{}{
+		"resourceID": resourceID,
+		"tagID":      tagID,
+	}); err != nil {
+		logrus.WithError(err).Error("unable to delete tag edge")
+		return errors.New("unable to delete tag edge")
+	}
+
+	return nil
+}
+
+// DeleteNode removes the node and all of its edges, label is optional but narrows the lookup
+func (r *Neo4jDatabase) DeleteNode(id string, label string) error {
+	cypher := "MATCH (n { id: $id }) DETACH DELETE n"
+	if label != "" {
+		cypher = fmt.Sprintf("MATCH (n:%s { id: $id }) DETACH DELETE n", label)
+	}
+	if err := r.run(cypher, map[string]interface{}{"id": id}); err != nil {
+		logrus.WithError(err).Error("unable to delete node")
+		return errors.New("unable to delete node")
+	}
+	return nil
+}
+
+// DeleteAll removes every library node and edge from the graph
+func (r *Neo4jDatabase) DeleteAll() (int, error) {
 	sess, err := r.conn.Session(neo4j.AccessModeWrite)
 	if err != nil {
 		logrus.WithError(err).Error("unable to create session")
-		return errors.New("unable to create session")
+		return 0, errors.New("unable to create session")
 	}
 	defer sess.Close()
 
-	if _, err := sess.Run("MATCH (a)-[r:HAS_TAG]->(b:Tag) WHERE a.id = $resourceID AND b.id = $tagID DELETE r", map[string]interface{}{
-		"resourceID": resourceID,
-		"tagName":    tagName,
-	}); err != nil {
-		logrus.WithError(err).Error("unable to delete tag edge")
-		return errors.New("unable to delete tag edge")
+	result, err := sess.Run("MATCH (n) WHERE n:Book OR n:Paper OR n:Link OR n:Tag DETACH DELETE n", nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete nodes")
+		return 0, errors.New("unable to delete nodes")
+	}
+	summary, err := result.Consume()
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete nodes")
+		return 0, errors.New("unable to delete nodes")
 	}
+	return summary.Counters().NodesDeleted(), nil
+}
 
-	return nil
+// run executes a write statement and consumes the result so errors are not lost
+func (r *Neo4jDatabase) run(cypher string, params map[string]interface{}) error {
+	sess, err := r.conn.Session(neo4j.AccessModeWrite)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create session")
+		return errors.New("unable to create session")
+	}
+	defer sess.Close()
+
+	result, err := sess.Run(cypher, params)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume()
+	return err
 }
 
-func (r *Neo4jDatabase) GetTaggedResources(id string) ([]tags.TaggedResource, error) {
+// GetTaggedResources lists the resources with a tag, both have to be in the user's library
+func (r *Neo4jDatabase) GetTaggedResources(ctx context.Context, id string) ([]tags.TaggedResource, error) {
 	tr := []tags.TaggedResource{}
+	owner, err := libraryOwner(ctx, id)
+	if err != nil {
+		return tr, err
+	}
 	sess, err := r.conn.Session(neo4j.AccessModeWrite)
 	if err != nil {
 		logrus.WithError(err).Error("unable to create session")
@@ -275,8 +274,9 @@ func (r *Neo4jDatabase) GetTaggedResources(id string) ([]tags.TaggedResource, er
 	}
 	defer sess.Close()
 
-	result, err := sess.Run("MATCH (a)-[r:HAS_TAG]->(b:Tag) WHERE b.id = $tagID RETURN a", map[string]interface{}{
+	result, err := sess.Run("MATCH (a)-[r:HAS_TAG]->(b:Tag) WHERE b.id = $tagID AND b.owner = $owner AND a.owner = $owner RETURN a", map[string]interface{}{
 		"tagID": id,
+		"owner": owner,
 	})
 	if err != nil {
 		logrus.WithError(err).Error("unable to delete tag edge")
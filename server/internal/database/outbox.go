@@ -0,0 +1,308 @@
+package database
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"alexandria/internal/links"
+	"alexandria/internal/tags"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+	"net/http"
+	"time"
+)
+
+// Graph writes are recorded in the outbox within the same transaction as the postgres change and applied to neo4j
+// by the relay. Every event is idempotent so it is safe to apply more than once.
+const (
+	eventDocumentUpserted = "document_upserted"
+	eventLinkUpserted     = "link_upserted"
+	eventTagUpserted      = "tag_upserted"
+	eventResourceTagged   = "resource_tagged"
+	eventResourceUntagged = "resource_untagged"
+	eventNodeDeleted      = "node_deleted"
+)
+
+const (
+	outboxBatchSize   = 100
+	outboxMaxAttempts = 10
+	outboxMaxBackoff  = 5 * time.Minute
+)
+
+// relayLockID is the advisory lock held by the instance relaying events
+const relayLockID = 4711
+
+var relayInterval = common.GetEnv("GRAPH_RELAY_INTERVAL", "5s")
+
+// relayAlertURL is told when an event runs out of attempts, the payload has a text field like the backup alerts
+var relayAlertURL = common.GetEnv("GRAPH_ALERT_WEBHOOK", "")
+
+type resourceTagEvent struct {
+	ResourceID   string            `json:"resource_id"`
+	ResourceType tags.ResourceType `json:"resource_type"`
+	Tag          tags.Tag          `json:"tag"`
+}
+
+type nodeDeletedEvent struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type outboxEvent struct {
+	ID       int64
+	Type     string
+	Payload  []byte
+	Attempts int
+	Due      bool
+}
+
+func enqueue(run sqlRunner, eventType string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		logrus.WithError(err).Error("unable to marshal outbox event")
+		return errors.New("unable to marshal outbox event")
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("graph_outbox").
+		Columns("event_type", "payload").
+		Values(eventType, string(b)).
+		RunWith(run).Exec(); err != nil {
+		logrus.WithError(err).WithField("type", eventType).Error("unable to write outbox event")
+		return errors.New("unable to write outbox event")
+	}
+	return nil
+}
+
+// inTx runs fn within a transaction, rolling back if it fails
+func (r *PostgresDatabase) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create transaction")
+		return errors.New("unable to create transaction")
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		logrus.WithError(err).Error("unable to commit transaction")
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+type relay struct {
+	postgres *PostgresDatabase
+	neo      *Neo4jDatabase
+	ticker   *time.Ticker
+	done     chan struct{}
+}
+
+// NewGraphRelay starts the background process that applies outbox events to neo4j
+func NewGraphRelay(lc fx.Lifecycle, psql *PostgresDatabase, neo *Neo4jDatabase) {
+	interval, err := time.ParseDuration(relayInterval)
+	if err != nil {
+		logrus.WithError(err).Warn("invalid relay interval using default")
+		interval = 5 * time.Second
+	}
+	r := &relay{
+		postgres: psql,
+		neo:      neo,
+		done:     make(chan struct{}),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			logrus.Info("starting graph relay")
+			r.ticker = time.NewTicker(interval)
+			go r.start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logrus.Info("stopping graph relay")
+			r.ticker.Stop()
+			close(r.done)
+			return nil
+		},
+	})
+}
+
+func (r *relay) start() {
+	for {
+		select {
+		case <-r.ticker.C:
+			for {
+				n, err := r.processBatch(context.Background())
+				if err != nil {
+					logrus.WithError(err).Error("unable to relay graph events")
+					break
+				}
+				if n < outboxBatchSize {
+					break
+				}
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// processBatch applies pending events in order. When an event fails processing stops so later events for the same
+// resource are not applied out of order; the event is retried with a backoff until it runs out of attempts. An event
+// out of attempts is dead-lettered, the relay stays stopped at it until it is retried through the graph repair.
+// Only one instance relays at a time, it holds an advisory lock on its own connection while events are applied so
+// no transaction stays open across the calls to neo4j. Times are taken from the database so they compare against
+// next_attempt whatever the zone of the server.
+func (r *relay) processBatch(ctx context.Context) (processed int, err error) {
+	conn, err := r.postgres.conn.Conn(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get connection")
+		return 0, errors.New("unable to get connection")
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", relayLockID).Scan(&locked); err != nil {
+		logrus.WithError(err).Error("unable to lock outbox")
+		return 0, errors.New("unable to lock outbox")
+	}
+	if !locked {
+		// another instance is relaying
+		return 0, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", relayLockID); err != nil {
+			logrus.WithError(err).Error("unable to unlock outbox")
+		}
+	}()
+
+	rows, err := conn.QueryContext(ctx, `SELECT id, event_type, payload, attempts, next_attempt <= now() FROM graph_outbox
+		WHERE processed IS NULL ORDER BY id LIMIT $1`, outboxBatchSize)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch outbox events")
+		return 0, errors.New("unable to fetch outbox events")
+	}
+	var events []outboxEvent
+	for rows.Next() {
+		var e outboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload, &e.Attempts, &e.Due); err != nil {
+			rows.Close()
+			logrus.WithError(err).Error("unable to scan outbox event")
+			return 0, errors.New("unable to scan outbox event")
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+
+	for _, e := range events {
+		if e.Attempts >= outboxMaxAttempts || !e.Due {
+			return processed, nil
+		}
+		if applyErr := r.apply(e); applyErr != nil {
+			logrus.WithError(applyErr).WithFields(logrus.Fields{"id": e.ID, "type": e.Type}).Warn("unable to apply graph event")
+			backoff := time.Duration(1<<uint(e.Attempts)) * time.Second
+			if backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+			if _, err := conn.ExecContext(ctx, `UPDATE graph_outbox SET attempts = attempts + 1, last_error = $1,
+				next_attempt = now() + $2 * interval '1 second' WHERE id = $3`,
+				applyErr.Error(), int(backoff.Seconds()), e.ID); err != nil {
+				logrus.WithError(err).Error("unable to update outbox event")
+				return processed, errors.New("unable to update outbox event")
+			}
+			if e.Attempts+1 >= outboxMaxAttempts {
+				alertDeadEvent(e, applyErr)
+			}
+			return processed, nil
+		}
+		if _, err := conn.ExecContext(ctx, "UPDATE graph_outbox SET processed = now() WHERE id = $1", e.ID); err != nil {
+			logrus.WithError(err).Error("unable to update outbox event")
+			return processed, errors.New("unable to update outbox event")
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+func alertDeadEvent(e outboxEvent, applyErr error) {
+	message := fmt.Sprintf("graph relay stopped at event %d (%s) after %d attempts, retry it from the graph repair", e.ID,
+		e.Type, outboxMaxAttempts)
+	logrus.WithError(applyErr).WithFields(logrus.Fields{"id": e.ID, "type": e.Type}).Error(message)
+	if relayAlertURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text":     fmt.Sprintf("alexandria: %s: %s", message, applyErr),
+		"event_id": e.ID,
+		"type":     e.Type,
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to marshal alert")
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(relayAlertURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Error("unable to send alert")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logrus.WithField("status", resp.StatusCode).Error("alert webhook rejected alert")
+	}
+}
+
+func (r *relay) apply(e outboxEvent) error {
+	switch e.Type {
+	case eventDocumentUpserted:
+		doc := &documents.Document{}
+		if err := json.Unmarshal(e.Payload, doc); err != nil {
+			return err
+		}
+		return r.neo.Insert(context.Background(), doc)
+	case eventLinkUpserted:
+		l := links.Link{}
+		if err := json.Unmarshal(e.Payload, &l); err != nil {
+			return err
+		}
+		_, err := r.neo.CreateLink(l)
+		return err
+	case eventTagUpserted:
+		t := tags.Tag{}
+		if err := json.Unmarshal(e.Payload, &t); err != nil {
+			return err
+		}
+		_, err := r.neo.CreateTag(t)
+		return err
+	case eventResourceTagged:
+		rt := resourceTagEvent{}
+		if err := json.Unmarshal(e.Payload, &rt); err != nil {
+			return err
+		}
+		if _, err := r.neo.CreateTag(rt.Tag); err != nil {
+			return err
+		}
+		return r.neo.addResourceTagByID(rt.ResourceID, rt.ResourceType, rt.Tag.ID)
+	case eventResourceUntagged:
+		rt := resourceTagEvent{}
+		if err := json.Unmarshal(e.Payload, &rt); err != nil {
+			return err
+		}
+		return r.neo.RemoveResourceTag(rt.ResourceID, rt.Tag.ID)
+	case eventNodeDeleted:
+		n := nodeDeletedEvent{}
+		if err := json.Unmarshal(e.Payload, &n); err != nil {
+			return err
+		}
+		return r.neo.DeleteNode(n.ID, n.Label)
+	default:
+		return fmt.Errorf("unknown event type %s", e.Type)
+	}
+}
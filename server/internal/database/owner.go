@@ -0,0 +1,91 @@
+package database
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+var errNoOwner = errors.New("unable to find owner")
+
+// restoredOwnerExpr keeps the owner of a restored row when the account exists and hands it to the first admin
+// otherwise, legacy backups have no owners at all
+func restoredOwnerExpr(placeholder string) string {
+	return fmt.Sprintf(`COALESCE((SELECT id FROM users WHERE id = NULLIF(%[1]s, '')::uuid),
+	(SELECT id FROM users WHERE role = 'admin' ORDER BY created, username LIMIT 1))`, placeholder)
+}
+
+// ownerPred limits a query to the library of the user in the context, plus the one resource a share grants. System
+// work sees every library and a context with neither sees nothing.
+func ownerPred(ctx context.Context, column string) sq.Sqlizer {
+	if common.IsSystem(ctx) {
+		return nil
+	}
+	identity, ok := common.IdentityFromContext(ctx)
+	grant, granted := common.GrantFromContext(ctx)
+	switch {
+	case granted && ok:
+		return sq.Or{sq.Eq{column: identity.ID}, grantPred(column, grant)}
+	case granted:
+		return grantPred(column, grant)
+	case ok:
+		return sq.Eq{column: identity.ID}
+	}
+	logrus.WithField("column", column).Warn("query made without a user")
+	return sq.Expr("false")
+}
+
+// grantPred matches the shared row, the id column sits next to the owner column of the same table
+func grantPred(column string, grant common.Grant) sq.Sqlizer {
+	return sq.Eq{column: grant.Owner, strings.TrimSuffix(column, "owner_id") + "id": grant.Resource}
+}
+
+// ownerID picks the account a new row belongs to. Rows written through a share, such as the tags of a shared
+// document, go in the owner's library and system writes keep the owner already set on the entity.
+func ownerID(ctx context.Context, current string) (string, error) {
+	if common.IsSystem(ctx) && current != "" {
+		return current, nil
+	}
+	if grant, ok := common.GrantFromContext(ctx); ok && !common.IsSystem(ctx) {
+		return grant.Owner, nil
+	}
+	if identity, ok := common.IdentityFromContext(ctx); ok && !common.IsSystem(ctx) {
+		return identity.ID, nil
+	}
+	logrus.Error("write made without a user")
+	return "", errNoOwner
+}
+
+// libraryOwner is the library a resource is looked up in, the owner's when the request reaches it through a share
+func libraryOwner(ctx context.Context, resourceID string) (string, error) {
+	if grant, ok := common.GrantFromContext(ctx); ok && grant.Resource == resourceID {
+		return grant.Owner, nil
+	}
+	if identity, ok := common.IdentityFromContext(ctx); ok {
+		return identity.ID, nil
+	}
+	return "", common.ErrNoIdentity
+}
+
+// ownsResource checks a document or link belongs to the user before its tags are changed
+func (r *PostgresDatabase) ownsResource(ctx context.Context, run sqlRunner, resourceID string) (bool, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	for _, table := range []string{"documents", "links"} {
+		var count int
+		if err := ps.Select("count(id)").From(table).
+			Where(sq.Eq{"id": resourceID}).
+			Where(ownerPred(ctx, table+".owner_id")).
+			RunWith(run).QueryRow().Scan(&count); err != nil {
+			logrus.WithError(err).Error("unable to find resource")
+			return false, errors.New("unable to find resource")
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"alexandria/internal/common"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"time"
+)
+
+// sortColumns maps the sort keys accepted by an endpoint to the expression rows are ordered by
+type sortColumns map[string]string
+
+var (
+	documentSorts = sortColumns{
+		"name":    "documents.display_name",
+		"created": "documents.created",
+		"updated": "COALESCE(documents.updated, documents.created)",
+	}
+	linkSorts = sortColumns{
+		"created": "links.created",
+		"name":    "COALESCE(links.display_name, '')",
+		"updated": "links.updated",
+	}
+	tagSorts = sortColumns{
+		"name": "tags.display_name",
+	}
+	entrySorts = sortColumns{
+		"created": "journal_entry.created",
+	}
+)
+
+var (
+	defaultDocumentPage = common.PageRequest{Sort: "name", Order: common.OrderAsc}
+	defaultLinkPage     = common.PageRequest{Sort: "created", Order: common.OrderDesc}
+	defaultTagPage      = common.PageRequest{Sort: "name", Order: common.OrderAsc}
+	defaultEntryPage    = common.PageRequest{Sort: "created", Order: common.OrderDesc}
+)
+
+// paginate orders the query by the requested sort with the id breaking ties so every row has a fixed position,
+// skips past the cursor and fetches one row more than the limit to tell whether there is another page
+func paginate(q sq.SelectBuilder, page common.PageRequest, columns sortColumns, idColumn string) sq.SelectBuilder {
+	column, ok := columns[page.Sort]
+	if !ok {
+		return q.OrderBy(idColumn)
+	}
+
+	direction, comparison := "ASC", ">"
+	if page.Order == common.OrderDesc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if page.After != nil {
+		q = q.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", column, idColumn, comparison), page.After.Value, page.After.ID)
+	}
+	q = q.OrderBy(column+" "+direction, idColumn+" "+direction)
+	if page.Limit > 0 {
+		q = q.Limit(uint64(page.Limit + 1))
+	}
+	return q
+}
+
+// hasMore reports whether a page fetched by paginate ran past the limit
+func hasMore(page common.PageRequest, rows int) bool {
+	return page.Limit > 0 && rows > page.Limit
+}
+
+func cursorTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
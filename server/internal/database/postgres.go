@@ -1,7 +1,6 @@
 package database
 
 import (
-	"alexandria/internal/backup"
 	"alexandria/internal/common"
 	"alexandria/internal/documents"
 	"alexandria/internal/journal"
@@ -18,7 +17,7 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/google/uuid"
 	"github.com/iancoleman/strcase"
-	_ "github.com/lib/pq" // Used for specifying the type client we are creating
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/fx"
 	"strings"
@@ -29,6 +28,13 @@ type PostgresDatabase struct {
 	conn *sql.DB
 }
 
+// sqlRunner is satisfied by both *sql.DB and *sql.Tx so writes can take part in a transaction
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 func NewPostgresDatabase(lc fx.Lifecycle, config common.PostgresDatabaseConfig) *PostgresDatabase {
 	logrus.Info("connecting to postgres")
 	db, err := retryPostgres(3, 10*time.Second, func() (db *sql.DB, e error) {
@@ -89,72 +95,175 @@ func retryPostgres(attempts int, sleep time.Duration, callback func() (*sql.DB,
 	return nil, fmt.Errorf("after %d attempts, connection failed", attempts)
 }
 
-func (r *PostgresDatabase) FindAll(ctx context.Context, filter map[string]interface{}) (docs []*documents.Document, err error) {
+func (r *PostgresDatabase) FindAll(ctx context.Context, filter documents.Filter) (docs []*documents.Document, err error) {
+	docs, _, err = r.findDocuments(ctx, documentFilter(filter), defaultDocumentPage)
+	return docs, err
+}
+
+func (r *PostgresDatabase) FindPage(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error) {
+	return r.findDocuments(ctx, documentFilter(filter), page)
+}
+
+func (r *PostgresDatabase) findDocuments(ctx context.Context, pred interface{}, page common.PageRequest) (docs []*documents.Document, next string, err error) {
 	docs = []*documents.Document{}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	rows, err := ps.Select("documents.id", "description", "display_name", "name", "type", "path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created", "updated").
+	q := ps.Select(documentColumns...).
 		From("documents").
 		LeftJoin("tagged_resources ON documents.id=tagged_resources.resource_id").
-		Suffix("GROUP BY documents.id ORDER BY display_name ASC").
-		Where(filter).RunWith(r.conn).Query()
+		GroupBy("documents.id").
+		Where(pred).
+		Where(ownerPred(ctx, "documents.owner_id"))
+	rows, err := paginate(q, page, documentSorts, "documents.id").RunWith(r.conn).Query()
 
 	if err != nil {
 		logrus.WithError(err).Error("unable to fetch results")
-		return nil, errors.New("unable to fetch results")
+		return nil, "", errors.New("unable to fetch results")
 	}
 	for rows.Next() {
-		doc := &documents.Document{}
-		var tagList string
-		doc.Tags = []string{}
-		if err := rows.Scan(&doc.ID, &doc.Description, &doc.DisplayName, &doc.Name, &doc.Type, &doc.Path, &tagList, &doc.Created, &doc.Updated); err != nil {
+		doc, err := scanDocument(rows)
+		if err != nil {
 			logrus.WithError(err).Warn("unable to scan doc results")
 		}
-		if tagList != "" {
-			doc.Tags = append(doc.Tags, strings.Split(tagList, ",")...)
-		}
 		docs = append(docs, doc)
 	}
-	return docs, nil
+	if hasMore(page, len(docs)) {
+		docs = docs[:page.Limit]
+		last := docs[len(docs)-1]
+		next = page.NextCursor(documentSortValue(last, page.Sort), last.ID)
+	}
+	return docs, next, nil
+}
+
+func documentSortValue(doc *documents.Document, sort string) string {
+	switch sort {
+	case "created":
+		return cursorTime(doc.Created)
+	case "updated":
+		if doc.Updated != nil {
+			return cursorTime(*doc.Updated)
+		}
+		return cursorTime(doc.Created)
+	default:
+		return doc.DisplayName
+	}
 }
 
 func (r *PostgresDatabase) FindByID(ctx context.Context, id string) (*documents.Document, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	row := ps.Select("documents.id", "description", "display_name", "name", "type", "path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created", "updated").
+	row := ps.Select(documentColumns...).
 		From("documents").
 		LeftJoin("tagged_resources ON documents.id=tagged_resources.resource_id").
 		Suffix("GROUP BY documents.id").
-		Where(sq.Eq{"documents.id": id}).RunWith(r.conn).QueryRow()
+		Where(sq.Eq{"documents.id": id}).
+		Where(ownerPred(ctx, "documents.owner_id")).RunWith(r.conn).QueryRow()
+	doc, err := scanDocument(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, documents.ErrNotFound
+		}
+		logrus.WithError(err).Warn("unable to scan doc results")
+	}
+
+	return doc, nil
+}
+
+// FindByHash finds a document in the caller's library with the same content, it is nil when there is none
+func (r *PostgresDatabase) FindByHash(ctx context.Context, hash string) (*documents.Document, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	row := ps.Select(documentColumns...).
+		From("documents").
+		LeftJoin("tagged_resources ON documents.id=tagged_resources.resource_id").
+		Suffix("GROUP BY documents.id LIMIT 1").
+		Where(sq.Eq{"documents.sha256": hash}).
+		Where(ownerPred(ctx, "documents.owner_id")).RunWith(r.conn).QueryRow()
+	doc, err := scanDocument(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find doc by hash")
+		return nil, errors.New("unable to find doc by hash")
+	}
+	return doc, nil
+}
+
+var documentColumns = []string{"documents.id", "description", "display_name", "name", "type", "path",
+	"COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created", "updated", "owner_id",
+	"COALESCE(title, '')", "authors", "COALESCE(publisher, '')", "COALESCE(language, '')", "COALESCE(isbn, '')", "subjects",
+	"published", "COALESCE(doi, '')", "COALESCE(arxiv_id, '')", "COALESCE(citation_key, '')", "COALESCE(entry_type, '')",
+	"COALESCE(journal, '')", "COALESCE(volume, '')", "COALESCE(issue, '')", "COALESCE(pages, '')", "COALESCE(url, '')",
+	"COALESCE(cover_url, '')", "COALESCE(sha256, '')", "COALESCE(format, '')"}
+
+// metadataColumns are written from metadataValues whenever a document is stored
+var metadataColumns = []string{"title", "authors", "publisher", "language", "isbn", "subjects", "published", "doi", "arxiv_id",
+	"citation_key", "entry_type", "journal", "volume", "issue", "pages", "url", "cover_url"}
+
+func metadataValues(m documents.Metadata) []interface{} {
+	return []interface{}{m.Title, pq.Array(stringList(m.Authors)), m.Publisher, m.Language, m.ISBN, pq.Array(stringList(m.Subjects)),
+		m.Published, m.DOI, m.ArXivID, m.CitationKey, m.EntryType, m.Journal, m.Volume, m.Issue, m.Pages, m.URL, m.CoverURL}
+}
+
+// scanDocument reads a row selected with documentColumns, the tag ids are aggregated into a single column
+func scanDocument(row sq.RowScanner) (*documents.Document, error) {
 	doc := &documents.Document{}
 	var tagList string
 	doc.Tags = []string{}
-	if err := row.Scan(&doc.ID, &doc.Description, &doc.DisplayName, &doc.Name, &doc.Type, &doc.Path, &tagList, &doc.Created, &doc.Updated); err != nil {
-		logrus.WithError(err).Warn("unable to scan doc results")
-	}
+	doc.Authors = []string{}
+	doc.Subjects = []string{}
+	err := row.Scan(&doc.ID, &doc.Description, &doc.DisplayName, &doc.Name, &doc.Type, &doc.Path, &tagList, &doc.Created, &doc.Updated, &doc.Owner,
+		&doc.Title, pq.Array(&doc.Authors), &doc.Publisher, &doc.Language, &doc.ISBN, pq.Array(&doc.Subjects),
+		&doc.Published, &doc.DOI, &doc.ArXivID, &doc.CitationKey, &doc.EntryType, &doc.Journal, &doc.Volume, &doc.Issue, &doc.Pages, &doc.URL, &doc.CoverURL, &doc.SHA256, &doc.Format)
 	if tagList != "" {
 		doc.Tags = append(doc.Tags, strings.Split(tagList, ",")...)
 	}
+	return doc, err
+}
 
-	return doc, nil
+// stringList keeps array columns from being written as null
+func stringList(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
 }
 
-func (r *PostgresDatabase) UpdateDocument(_ context.Context, doc documents.Document) (result documents.Document, err error) {
+func (r *PostgresDatabase) updateDocument(ctx context.Context, run sqlRunner, doc documents.Document) (result documents.Document, err error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	_, err = ps.Update("documents").SetMap(
-		map[string]interface{}{
-			"description":  doc.Description,
-			"display_name": doc.DisplayName,
-			"type":         doc.Type,
-			"updated":      time.Now()}).
-		Where(sq.Eq{"id": doc.ID}).RunWith(r.conn).Exec()
+	fields := map[string]interface{}{
+		"description":  doc.Description,
+		"display_name": doc.DisplayName,
+		"type":         doc.Type,
+		"updated":      time.Now()}
+	for i, value := range metadataValues(doc.Metadata) {
+		fields[metadataColumns[i]] = value
+	}
+	res, err := ps.Update("documents").SetMap(fields).
+		Where(sq.Eq{"id": doc.ID}).
+		Where(ownerPred(ctx, "owner_id")).RunWith(run).Exec()
 
 	if err != nil {
 		logrus.WithError(err).Error("unable to update doc")
 		return result, errors.New("unable to update doc")
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return result, documents.ErrNotFound
+	}
 
 	return doc, nil
 }
 
+// moveDocumentFile points the document at another stored file, used when a merge keeps the file of a duplicate
+func (r *PostgresDatabase) moveDocumentFile(run sqlRunner, doc documents.Document) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("documents").
+		SetMap(map[string]interface{}{"path": doc.Path, "name": doc.Name, "sha256": doc.SHA256, "format": doc.Format}).
+		Where(sq.Eq{"id": doc.ID}).RunWith(run).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to move doc file")
+		return errors.New("unable to move doc file")
+	}
+	return nil
+}
+
 func (r *PostgresDatabase) existsByPath(ctx context.Context, path string) (bool, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	row := ps.Select("count(id)").
@@ -167,62 +276,117 @@ func (r *PostgresDatabase) existsByPath(ctx context.Context, path string) (bool,
 	return count > 0, nil
 }
 
-func (r *PostgresDatabase) Insert(ctx context.Context, doc *documents.Document) error {
+func (r *PostgresDatabase) userExists(ctx context.Context, id string) (bool, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	if _, err := ps.Insert("documents").Columns("id", "description", "display_name", "name", "type", "path").
-		Values(doc.ID, doc.Description, doc.DisplayName, doc.Name, doc.Type, doc.Path).
-		RunWith(r.conn).
-		Exec(); err != nil {
-		logrus.WithError(err).Warn("unable to insert doc")
-		return errors.New("unable to insert doc metadata")
+	var count int
+	if err := ps.Select("count(id)").From("users").Where(sq.Eq{"id": id}).
+		RunWith(r.conn).QueryRowContext(ctx).Scan(&count); err != nil {
+		logrus.WithError(err).Error("unable to find user")
+		return false, errors.New("unable to find user")
 	}
-	return nil
+	return count > 0, nil
 }
 
-func (r *PostgresDatabase) UpsertStream(ctx context.Context, input <-chan *documents.Document) error {
-	count := 0
-	for doc := range input {
-		bctx := context.Background()
-		if exists, _ := r.existsByPath(bctx, doc.Path); exists {
-			continue
-		}
-		if err := r.Insert(bctx, doc); err != nil {
-			logrus.WithError(err).Info("unable to upsert document")
-			return errors.New("unable to upsert document")
-		}
-		count++
+func (r *PostgresDatabase) insertDocument(ctx context.Context, run sqlRunner, doc *documents.Document) (err error) {
+	if doc.Owner, err = ownerID(ctx, doc.Owner); err != nil {
+		return err
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("documents").
+		Columns(append([]string{"id", "description", "display_name", "name", "type", "path", "owner_id", "sha256", "format"}, metadataColumns...)...).
+		Values(append([]interface{}{doc.ID, doc.Description, doc.DisplayName, doc.Name, doc.Type, doc.Path, doc.Owner, doc.SHA256, doc.Format}, metadataValues(doc.Metadata)...)...).
+		RunWith(run).
+		Exec(); err != nil {
+		logrus.WithError(err).Warn("unable to insert doc")
+		return errors.New("unable to insert doc metadata")
 	}
-	logrus.WithField("count", count).Info("documents added")
 	return nil
 }
 
-func (r *PostgresDatabase) Delete(ctx context.Context, id string) error {
+func (r *PostgresDatabase) deleteDocument(ctx context.Context, run sqlRunner, id string) error {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	if _, err := ps.Delete("documents").Where(sq.Eq{"id": id}).RunWith(r.conn).Exec(); err != nil {
+	res, err := ps.Delete("documents").Where(sq.Eq{"id": id}).Where(ownerPred(ctx, "owner_id")).RunWith(run).Exec()
+	if err != nil {
 		logrus.WithError(err).Warn("unable to scan doc results")
 		return errors.New("unable to delete")
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return documents.ErrNotFound
+	}
+	if _, err := ps.Delete("tagged_resources").Where(sq.Eq{"resource_id": id}).RunWith(run).Exec(); err != nil {
+		logrus.WithError(err).Warn("unable to delete doc tags")
+		return errors.New("unable to delete")
+	}
+	if _, err := ps.Delete("shares").Where(sq.Eq{"resource_id": id}).RunWith(run).Exec(); err != nil {
+		logrus.WithError(err).Warn("unable to delete doc shares")
+		return errors.New("unable to delete")
+	}
 
 	return nil
 }
 
+var userColumns = []string{"id", "username", "password", "role", "created", "COALESCE(external_id, '')"}
+
+func scanUser(row sq.RowScanner) (*user.User, error) {
+	var entity user.User
+	if err := row.Scan(&entity.ID, &entity.Username, &entity.Password, &entity.Role, &entity.Created, &entity.ExternalID); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
 func (r *PostgresDatabase) FindUserByUsername(ctx context.Context, username string) (*user.User, error) {
+	return r.findUser(sq.Eq{"username": username})
+}
+
+func (r *PostgresDatabase) FindUserByID(ctx context.Context, id string) (*user.User, error) {
+	return r.findUser(sq.Eq{"id": id})
+}
+
+func (r *PostgresDatabase) FindUserByExternalID(ctx context.Context, externalID string) (*user.User, error) {
+	return r.findUser(sq.Eq{"external_id": externalID})
+}
+
+func (r *PostgresDatabase) findUser(pred sq.Sqlizer) (*user.User, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	var entity user.User
-	if err := ps.Select("id", "username", "password").
+	entity, err := scanUser(ps.Select(userColumns...).
 		From("users").
-		Where(sq.Eq{"username": username}).
+		Where(pred).
 		RunWith(r.conn).
-		QueryRow().
-		Scan(&entity.ID, &entity.Username, &entity.Password); err != nil {
+		QueryRow())
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		logrus.WithError(err).Error("could not find user")
 		return nil, errors.New("could not find user")
 	}
-	return &entity, nil
+	return entity, nil
+}
+
+func (r *PostgresDatabase) FindUsers(ctx context.Context) ([]user.User, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select(userColumns...).
+		From("users").
+		OrderBy("created", "username").
+		RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find users")
+		return nil, errors.New("unable to find users")
+	}
+	defer rows.Close()
+
+	users := []user.User{}
+	for rows.Next() {
+		entity, err := scanUser(rows)
+		if err != nil {
+			logrus.WithError(err).Warn("unable to scan user")
+			continue
+		}
+		users = append(users, *entity)
+	}
+	return users, nil
 }
 
 func (r *PostgresDatabase) CreateUser(ctx context.Context, user *user.User) error {
@@ -230,9 +394,13 @@ func (r *PostgresDatabase) CreateUser(ctx context.Context, user *user.User) erro
 		user.ID = uuid.New().String()
 	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	var externalID interface{}
+	if user.ExternalID != "" {
+		externalID = user.ExternalID
+	}
 	if _, err := ps.Insert("users").
-		Columns("id", "username", "password").
-		Values(user.ID, user.Username, user.Password).
+		Columns("id", "username", "password", "role", "created", "external_id").
+		Values(user.ID, user.Username, user.Password, user.Role, user.Created, externalID).
 		RunWith(r.conn).Exec(); err != nil {
 
 		logrus.WithError(err).Error("unable to create user")
@@ -241,33 +409,114 @@ func (r *PostgresDatabase) CreateUser(ctx context.Context, user *user.User) erro
 	return nil
 }
 
-func (r *PostgresDatabase) FindAllEntries() ([]journal.Entry, error) {
+func (r *PostgresDatabase) UpdateUserRole(ctx context.Context, id, role string) error {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	rows, err := ps.Select("id", "content", "created").From("journal_entry").RunWith(r.conn).Query()
+	if _, err := ps.Update("users").
+		Set("role", role).
+		Where(sq.Eq{"id": id}).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to update user")
+		return errors.New("unable to update user")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) LinkExternalID(ctx context.Context, id, externalID string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("users").
+		Set("external_id", externalID).
+		Where(sq.Eq{"id": id}).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to link user")
+		return errors.New("unable to link user")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) UpdateUserPassword(ctx context.Context, id, password string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("users").
+		Set("password", password).
+		Where(sq.Eq{"id": id}).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to update password")
+		return errors.New("unable to update password")
+	}
+	return nil
+}
+
+// DeleteUser refuses to remove an account that still owns resources rather than orphaning its library
+func (r *PostgresDatabase) DeleteUser(ctx context.Context, id string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	for _, table := range []string{"documents", "links", "tags", "journal_entry"} {
+		var count int
+		if err := ps.Select("count(*)").From(table).
+			Where(sq.Eq{"owner_id": id}).
+			RunWith(r.conn).QueryRow().Scan(&count); err != nil {
+			logrus.WithError(err).WithField("table", table).Error("unable to count owned resources")
+			return errors.New("unable to delete user")
+		}
+		if count > 0 {
+			return user.ErrOwnsResources
+		}
+	}
+
+	if _, err := ps.Delete("users").Where(sq.Eq{"id": id}).RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to delete user")
+		return errors.New("unable to delete user")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FindAllEntries(ctx context.Context) ([]journal.Entry, error) {
+	entries, _, err := r.findEntries(ctx, nil, defaultEntryPage)
+	return entries, err
+}
+
+func (r *PostgresDatabase) FindEntriesPage(ctx context.Context, page common.PageRequest) ([]journal.Entry, string, error) {
+	return r.findEntries(ctx, nil, page)
+}
+
+func (r *PostgresDatabase) findEntries(ctx context.Context, pred interface{}, page common.PageRequest) ([]journal.Entry, string, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	q := ps.Select("id", "content", "created", "owner_id").From("journal_entry").Where(pred).Where(ownerPred(ctx, "journal_entry.owner_id"))
+	rows, err := paginate(q, page, entrySorts, "journal_entry.id").RunWith(r.conn).Query()
 	if err != nil {
 		logrus.WithError(err).Error("unable to find entries")
-		return nil, errors.New("unable to find entries")
+		return nil, "", errors.New("unable to find entries")
 	}
 	entries := []journal.Entry{}
 	for rows.Next() {
 		var entry journal.Entry
-		if err := rows.Scan(&entry.ID, &entry.Content, &entry.Created); err != nil {
+		if err := rows.Scan(&entry.ID, &entry.Content, &entry.Created, &entry.Owner); err != nil {
 			logrus.WithError(err).Warn("unable to scan entry")
 		}
 		entries = append(entries, entry)
 	}
 	rows.Close()
-	return entries, nil
+
+	next := ""
+	if hasMore(page, len(entries)) {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = page.NextCursor(cursorTime(last.Created), last.ID)
+	}
+	return entries, next, nil
 }
 
-func (r *PostgresDatabase) CreateEntry(entry journal.Entry) (journal.Entry, error) {
+func (r *PostgresDatabase) CreateEntry(ctx context.Context, entry journal.Entry) (journal.Entry, error) {
 	newEntry := journal.Entry{
 		Content: entry.Content,
 	}
+	owner, err := ownerID(ctx, entry.Owner)
+	if err != nil {
+		return newEntry, err
+	}
+	newEntry.Owner = owner
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	if err := ps.Insert("journal_entry").
-		Columns("content").
-		Values(entry.Content).
+		Columns("content", "owner_id").
+		Values(entry.Content, owner).
 		Suffix("RETURNING id, created").
 		RunWith(r.conn).
 		QueryRow().
@@ -279,21 +528,31 @@ func (r *PostgresDatabase) CreateEntry(entry journal.Entry) (journal.Entry, erro
 	return newEntry, nil
 }
 
-func (r *PostgresDatabase) FindAllLinks() ([]links.Link, error) {
+func (r *PostgresDatabase) FindAllLinks(ctx context.Context) ([]links.Link, error) {
+	entries, _, err := r.findLinks(ctx, nil, defaultLinkPage)
+	return entries, err
+}
+
+func (r *PostgresDatabase) FindLinksPage(ctx context.Context, page common.PageRequest) ([]links.Link, string, error) {
+	return r.findLinks(ctx, nil, page)
+}
+
+func (r *PostgresDatabase) findLinks(ctx context.Context, pred interface{}, page common.PageRequest) ([]links.Link, string, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	rows, err := ps.Select("links.id", "link", "display_name", "icon_path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created").
+	q := ps.Select("links.id", "link", "COALESCE(display_name, '')", "icon_path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created", "updated", "owner_id").
 		From("links").
-		LeftJoin("tagged_resources ON links.id=tagged_resources.resource_id").Suffix("GROUP BY links.id ORDER BY created DESC").RunWith(r.conn).Query()
+		LeftJoin("tagged_resources ON links.id=tagged_resources.resource_id").Where(pred).Where(ownerPred(ctx, "links.owner_id")).GroupBy("links.id")
+	rows, err := paginate(q, page, linkSorts, "links.id").RunWith(r.conn).Query()
 	if err != nil {
 		logrus.WithError(err).Error("unable to find links")
-		return nil, errors.New("unable to find links")
+		return nil, "", errors.New("unable to find links")
 	}
 	entries := []links.Link{}
 	for rows.Next() {
 		var entry links.Link
 		var tagList string
 		entry.Tags = []string{}
-		if err := rows.Scan(&entry.ID, &entry.Link, &entry.DisplayName, &entry.IconPath, &tagList, &entry.Created); err != nil {
+		if err := rows.Scan(&entry.ID, &entry.Link, &entry.DisplayName, &entry.IconPath, &tagList, &entry.Created, &entry.Updated, &entry.Owner); err != nil {
 			logrus.WithError(err).Warn("unable to scan link")
 		}
 		if tagList != "" {
@@ -303,15 +562,35 @@ func (r *PostgresDatabase) FindAllLinks() ([]links.Link, error) {
 		entries = append(entries, entry)
 	}
 	rows.Close()
-	return entries, nil
+
+	next := ""
+	if hasMore(page, len(entries)) {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = page.NextCursor(linkSortValue(last, page.Sort), last.ID)
+	}
+	return entries, next, nil
 }
 
-func (r *PostgresDatabase) FindLinkByID(id string) (entity links.Link, err error) {
+// linkSortValue is the cursor value of a link, links without a name sort as an empty one
+func linkSortValue(l links.Link, sort string) string {
+	switch sort {
+	case "name":
+		return l.DisplayName
+	case "updated":
+		return cursorTime(l.Updated)
+	default:
+		return cursorTime(l.Created)
+	}
+}
+
+func (r *PostgresDatabase) FindLinkByID(ctx context.Context, id string) (entity links.Link, err error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	rowscanner := ps.Select("links.id", "link", "display_name", "icon_path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created").
+	rowscanner := ps.Select("links.id", "link", "COALESCE(display_name, '')", "icon_path", "COALESCE(string_agg(tagged_resources.id::character varying, ','), '')", "created", "updated", "owner_id").
 		From("links").
 		LeftJoin("tagged_resources ON links.id=tagged_resources.resource_id").
 		Where(sq.Eq{"links.id": id}).
+		Where(ownerPred(ctx, "links.owner_id")).
 		Suffix("GROUP BY links.id ORDER BY created DESC").RunWith(r.conn).QueryRow()
 	if err != nil {
 		logrus.WithError(err).Error("unable to find links")
@@ -320,7 +599,10 @@ func (r *PostgresDatabase) FindLinkByID(id string) (entity links.Link, err error
 	var entry links.Link
 	var tagList string
 	entry.Tags = []string{}
-	if err := rowscanner.Scan(&entry.ID, &entry.Link, &entry.DisplayName, &entry.IconPath, &tagList, &entry.Created); err != nil {
+	if err := rowscanner.Scan(&entry.ID, &entry.Link, &entry.DisplayName, &entry.IconPath, &tagList, &entry.Created, &entry.Updated, &entry.Owner); err != nil {
+		if err == sql.ErrNoRows {
+			return entity, links.ErrNotFound
+		}
 		logrus.WithError(err).Warn("unable to scan link")
 	}
 	if tagList != "" {
@@ -330,21 +612,26 @@ func (r *PostgresDatabase) FindLinkByID(id string) (entity links.Link, err error
 	return entry, nil
 }
 
-func (r *PostgresDatabase) CreateLink(entry links.Link) (links.Link, error) {
+func (r *PostgresDatabase) createLink(ctx context.Context, run sqlRunner, entry links.Link) (links.Link, error) {
 	newEntry := links.Link{
 		Link:        entry.Link,
 		DisplayName: entry.DisplayName,
 		IconPath:    entry.IconPath,
 		Tags:        []string{},
 	}
+	owner, err := ownerID(ctx, entry.Owner)
+	if err != nil {
+		return newEntry, err
+	}
+	newEntry.Owner = owner
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	if err := ps.Insert("links").
-		Columns("link", "display_name", "icon_path").
-		Values(entry.Link, entry.DisplayName, entry.IconPath).
-		Suffix("RETURNING id, created").
-		RunWith(r.conn).
+		Columns("link", "display_name", "icon_path", "owner_id").
+		Values(entry.Link, entry.DisplayName, entry.IconPath, owner).
+		Suffix("RETURNING id, created, updated").
+		RunWith(run).
 		QueryRow().
-		Scan(&newEntry.ID, &newEntry.Created); err != nil {
+		Scan(&newEntry.ID, &newEntry.Created, &newEntry.Updated); err != nil {
 
 		logrus.WithError(err).Error("unable to insert link")
 		return newEntry, errors.New("unable to insert link")
@@ -352,41 +639,63 @@ func (r *PostgresDatabase) CreateLink(entry links.Link) (links.Link, error) {
 	return newEntry, nil
 }
 
-func (r *PostgresDatabase) FindAllTags() ([]tags.Tag, error) {
+func (r *PostgresDatabase) FindAllTags(ctx context.Context) ([]tags.Tag, error) {
+	entries, _, err := r.findTags(ctx, nil, defaultTagPage)
+	return entries, err
+}
+
+func (r *PostgresDatabase) FindTagsPage(ctx context.Context, page common.PageRequest) ([]tags.Tag, string, error) {
+	return r.findTags(ctx, nil, page)
+}
+
+func (r *PostgresDatabase) findTags(ctx context.Context, pred interface{}, page common.PageRequest) ([]tags.Tag, string, error) {
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	rows, err := ps.Select("id", "display_name", "color").From("tags").Suffix("ORDER BY display_name ASC").RunWith(r.conn).Query()
+	q := ps.Select("id", "display_name", "color", "owner_id").From("tags").Where(pred).Where(ownerPred(ctx, "tags.owner_id"))
+	rows, err := paginate(q, page, tagSorts, "tags.id").RunWith(r.conn).Query()
 	if err != nil {
 		logrus.WithError(err).Error("unable to find tags")
-		return nil, errors.New("unable to find tags")
+		return nil, "", errors.New("unable to find tags")
 	}
 	entries := []tags.Tag{}
 	for rows.Next() {
 		var entry tags.Tag
-		if err := rows.Scan(&entry.ID, &entry.DisplayName, &entry.TagColor); err != nil {
+		if err := rows.Scan(&entry.ID, &entry.DisplayName, &entry.TagColor, &entry.Owner); err != nil {
 			logrus.WithError(err).Warn("unable to scan tags")
 		}
 		entries = append(entries, entry)
 	}
 	rows.Close()
-	return entries, nil
+
+	next := ""
+	if hasMore(page, len(entries)) {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = page.NextCursor(last.DisplayName, last.ID)
+	}
+	return entries, next, nil
 }
 
-func (r *PostgresDatabase) CreateTag(entry tags.Tag) (tags.Tag, error) {
+func (r *PostgresDatabase) createTag(ctx context.Context, run sqlRunner, entry tags.Tag) (tags.Tag, error) {
 	color := tags.GetRandomColor()
 	newEntry := tags.Tag{
 		DisplayName: strcase.ToKebab(entry.DisplayName),
 		TagColor:    color,
 	}
+	owner, err := ownerID(ctx, entry.Owner)
+	if err != nil {
+		return newEntry, err
+	}
+	newEntry.Owner = owner
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 	if err := ps.Insert("tags").
-		Columns("display_name", "color").
-		Values(newEntry.DisplayName, color).
+		Columns("display_name", "color", "owner_id").
+		Values(newEntry.DisplayName, color, owner).
 		Suffix("ON CONFLICT DO NOTHING RETURNING id").
-		RunWith(r.conn).
+		RunWith(run).
 		QueryRow().
 		Scan(&newEntry.ID); err != nil {
 		if err == sql.ErrNoRows {
-			return newEntry, nil
+			return r.getTagByName(run, owner, newEntry.DisplayName)
 		}
 		logrus.WithError(err).Error("unable to insert tag")
 		return newEntry, errors.New("unable to insert tag")
@@ -394,15 +703,15 @@ func (r *PostgresDatabase) CreateTag(entry tags.Tag) (tags.Tag, error) {
 	return newEntry, nil
 }
 
-func (r *PostgresDatabase) GetTagByName(name string) (entity tags.Tag, err error) {
+func (r *PostgresDatabase) getTagByName(run sqlRunner, owner string, name string) (entity tags.Tag, err error) {
 	name = strcase.ToKebab(name)
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	if err := ps.Select("id", "display_name", "color").
+	if err := ps.Select("id", "display_name", "color", "owner_id").
 		From("tags").
-		Where(sq.Eq{"display_name": name}).
-		RunWith(r.conn).
+		Where(sq.Eq{"display_name": name, "owner_id": owner}).
+		RunWith(run).
 		QueryRow().
-		Scan(&entity.ID, &entity.DisplayName, &entity.TagColor); err != nil {
+		Scan(&entity.ID, &entity.DisplayName, &entity.TagColor, &entity.Owner); err != nil {
 		if err == sql.ErrNoRows {
 			return entity, nil
 		}
@@ -412,82 +721,91 @@ func (r *PostgresDatabase) GetTagByName(name string) (entity tags.Tag, err error
 	return entity, nil
 }
 
-func (r *PostgresDatabase) AddResourceTag(resourceID string, resourceType tags.ResourceType, tagName string) error {
+func (r *PostgresDatabase) getTagByID(run sqlRunner, id string) (entity tags.Tag, err error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if err := ps.Select("id", "display_name", "color", "owner_id").
+		From("tags").
+		Where(sq.Eq{"id": id}).
+		RunWith(run).
+		QueryRow().
+		Scan(&entity.ID, &entity.DisplayName, &entity.TagColor, &entity.Owner); err != nil {
+		logrus.WithError(err).Error("unable to find tag")
+		return entity, errors.New("unable to find tag")
+	}
+	return entity, nil
+}
+
+func (r *PostgresDatabase) addResourceTag(ctx context.Context, run sqlRunner, resourceID string, resourceType tags.ResourceType, tagName string) (tags.Tag, error) {
 	tagName = strcase.ToKebab(tagName)
-	if _, err := r.CreateTag(tags.Tag{DisplayName: tagName}); err != nil {
+	if err := r.requireResource(ctx, run, resourceID); err != nil {
+		return tags.Tag{}, err
+	}
+	t, err := r.createTag(ctx, run, tags.Tag{DisplayName: tagName})
+	if err != nil {
 		logrus.WithError(err).Error("unable to upsert tag")
-		return errors.New("unable to upsert tag")
+		return t, errors.New("unable to upsert tag")
 	}
-	if _, err := r.conn.Exec("INSERT INTO tagged_resources(id, resource_id, resource_type) SELECT id, $1, $2  FROM tags where display_name = $3", resourceID, resourceType, tagName); err != nil {
+	if _, err := run.Exec("INSERT INTO tagged_resources(id, resource_id, resource_type) VALUES ($1, $2, $3)", t.ID, resourceID, resourceType); err != nil {
 		logrus.WithError(err).Error("unable to add tag")
-		return errors.New("unable to add tag")
+		return t, errors.New("unable to add tag")
 	}
-	return nil
+	return t, r.touchResource(run, resourceID)
 }
 
-func (r *PostgresDatabase) RemoveResourceTag(resourceID string, tagName string) error {
+func (r *PostgresDatabase) removeResourceTag(ctx context.Context, run sqlRunner, resourceID string, tagName string) (tags.Tag, error) {
 	tagName = strcase.ToKebab(tagName)
-	if _, err := r.conn.Exec("DELETE FROM tagged_resources where resource_id = $1 and id = (SELECT id FROM tags where display_name = $2)", resourceID, tagName); err != nil {
-		logrus.WithError(err).Error("unable to remove tag")
-		return errors.New("unable to remove tag")
+	if err := r.requireResource(ctx, run, resourceID); err != nil {
+		return tags.Tag{}, err
 	}
-	return nil
-}
-
-func (r *PostgresDatabase) Restore(b backup.Backup) error {
-
-	tx, err := r.conn.BeginTx(context.Background(), nil)
+	owner, err := ownerID(ctx, "")
 	if err != nil {
-		logrus.WithError(err).Error("unable to create transaction")
-		return errors.New("unable to create transaction")
-	}
-
-	if err := r.bulkInsertTags(tx, b.Tags); err != nil {
-		logrus.WithError(err).Error("unable to insert tags")
-		tx.Rollback()
-		return errors.New("unable to insert tags")
-	}
-	if err := r.bulkInsertEntries(tx, b.Journal); err != nil {
-		logrus.WithError(err).Error("unable to insert entries")
-		tx.Rollback()
-		return errors.New("unable to insert entries")
+		return tags.Tag{}, err
 	}
-
-	docsTrs, err := r.bulkInsertDocuments(tx, b.Docs)
+	t, err := r.getTagByName(run, owner, tagName)
 	if err != nil {
-		logrus.WithError(err).Error("unable to insert tags")
-		tx.Rollback()
-		return errors.New("unable to insert tags")
+		return t, err
 	}
+	if _, err := run.Exec("DELETE FROM tagged_resources where resource_id = $1 and id = $2", resourceID, t.ID); err != nil {
+		logrus.WithError(err).Error("unable to remove tag")
+		return t, errors.New("unable to remove tag")
+	}
+	return t, r.touchResource(run, resourceID)
+}
 
-	linksTrs, err := r.bulkInsertLinks(tx, b.Links)
+// requireResource stops users tagging documents and links in another library
+func (r *PostgresDatabase) requireResource(ctx context.Context, run sqlRunner, resourceID string) error {
+	owned, err := r.ownsResource(ctx, run, resourceID)
 	if err != nil {
-		logrus.WithError(err).Error("unable to insert tags")
-		tx.Rollback()
-		return errors.New("unable to insert tags")
+		return err
 	}
-
-	trs := append(docsTrs, linksTrs...)
-	if err := r.bulkInsertTaggedResources(tx, trs); err != nil {
-		logrus.WithError(err).Error("unable to insert tags")
-		tx.Rollback()
-		return errors.New("unable to insert tags")
+	if !owned {
+		return tags.ErrResourceNotFound
 	}
+	return nil
+}
 
-	if err := tx.Commit(); err != nil {
-		logrus.WithError(err).Error("unable to commit transaction")
-		return errors.New("unable to commit transaction")
+// touchResource bumps the updated timestamp of a tagged document or link so incremental backups pick up the change
+func (r *PostgresDatabase) touchResource(run sqlRunner, resourceID string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	for _, table := range []string{"documents", "links"} {
+		if _, err := ps.Update(table).Set("updated", time.Now()).Where(sq.Eq{"id": resourceID}).RunWith(run).Exec(); err != nil {
+			logrus.WithError(err).Error("unable to update resource")
+			return errors.New("unable to update resource")
+		}
 	}
 	return nil
 }
 
 func (r *PostgresDatabase) bulkInsertTags(tx *sql.Tx, tags []tags.Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	s := ps.Insert("tags").Columns("id", "display_name", "color")
+	s := ps.Insert("tags").Columns("id", "display_name", "color", "owner_id")
 
 	for _, t := range tags {
-		s = s.Values(t.ID, t.DisplayName, t.TagColor)
+		s = s.Values(t.ID, t.DisplayName, t.TagColor, sq.Expr(restoredOwnerExpr("?"), t.Owner))
 	}
 
 	if _, err := s.RunWith(tx).Exec(); err != nil {
@@ -499,6 +817,9 @@ func (r *PostgresDatabase) bulkInsertTags(tx *sql.Tx, tags []tags.Tag) error {
 }
 
 func (r *PostgresDatabase) bulkInsertTaggedResources(tx *sql.Tx, tags []tags.TaggedResource) error {
+	if len(tags) == 0 {
+		return nil
+	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
 	s := ps.Insert("tagged_resources").Columns("id", "resource_id", "resource_type")
@@ -516,10 +837,16 @@ func (r *PostgresDatabase) bulkInsertTaggedResources(tx *sql.Tx, tags []tags.Tag
 }
 
 func (r *PostgresDatabase) bulkInsertDocuments(tx *sql.Tx, docs []*documents.Document) (tr []tags.TaggedResource, err error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	s := ps.Insert("documents").Columns("id", "description", "display_name", "name", "type", "path")
+	s := ps.Insert("documents").Columns(append([]string{"id", "description", "display_name", "name", "type", "path", "created", "updated", "owner_id", "sha256", "format"}, metadataColumns...)...)
 	for _, d := range docs {
+		if d.Format == "" {
+			d.Format = documents.FormatOf(d.Path)
+		}
 
 		for _, t := range d.Tags {
 			ty := tags.BookResource
@@ -533,7 +860,8 @@ func (r *PostgresDatabase) bulkInsertDocuments(tx *sql.Tx, docs []*documents.Doc
 			})
 		}
 
-		s = s.Values(d.ID, d.Description, d.DisplayName, d.Name, d.Type, d.Path)
+		s = s.Values(append([]interface{}{d.ID, d.Description, d.DisplayName, d.Name, d.Type, d.Path, created(d.Created), d.Updated,
+			sq.Expr(restoredOwnerExpr("?"), d.Owner), d.SHA256, d.Format}, metadataValues(d.Metadata)...)...)
 	}
 
 	if _, err := s.RunWith(tx).Exec(); err != nil {
@@ -545,12 +873,15 @@ func (r *PostgresDatabase) bulkInsertDocuments(tx *sql.Tx, docs []*documents.Doc
 }
 
 func (r *PostgresDatabase) bulkInsertEntries(tx *sql.Tx, entries []journal.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	s := ps.Insert("journal_entry").Columns("id", "content")
+	s := ps.Insert("journal_entry").Columns("id", "content", "created", "owner_id")
 
 	for _, e := range entries {
-		s = s.Values(e.ID, e.Content)
+		s = s.Values(e.ID, e.Content, created(e.Created), sq.Expr(restoredOwnerExpr("?"), e.Owner))
 	}
 
 	if _, err := s.RunWith(tx).Exec(); err != nil {
@@ -562,9 +893,12 @@ func (r *PostgresDatabase) bulkInsertEntries(tx *sql.Tx, entries []journal.Entry
 }
 
 func (r *PostgresDatabase) bulkInsertLinks(tx *sql.Tx, lks []links.Link) (tr []tags.TaggedResource, err error) {
+	if len(lks) == 0 {
+		return nil, nil
+	}
 	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	s := ps.Insert("links").Columns("id", "link", "icon_path", "display_name")
+	s := ps.Insert("links").Columns("id", "link", "icon_path", "display_name", "created", "updated", "owner_id")
 	for _, l := range lks {
 
 		for _, t := range l.Tags {
@@ -575,7 +909,7 @@ func (r *PostgresDatabase) bulkInsertLinks(tx *sql.Tx, lks []links.Link) (tr []t
 			})
 		}
 
-		s = s.Values(l.ID, l.Link, l.IconPath, l.DisplayName)
+		s = s.Values(l.ID, l.Link, l.IconPath, l.DisplayName, created(l.Created), linkUpdated(l), sq.Expr(restoredOwnerExpr("?"), l.Owner))
 	}
 
 	if _, err := s.RunWith(tx).Exec(); err != nil {
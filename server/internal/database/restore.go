@@ -0,0 +1,268 @@
+package database
+
+import (
+	"alexandria/internal/backup"
+	"alexandria/internal/documents"
+	"alexandria/internal/links"
+	"alexandria/internal/tags"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Restore loads a backup into postgres. Replace clears the tables before loading, merge upserts by id keeping
+// whichever copy was updated last and dry-run runs the merge in a transaction that is rolled back.
+func (r *PostgresDatabase) Restore(b backup.Backup, mode backup.RestoreMode) (map[string]*backup.RestoreCounts, error) {
+	report := map[string]*backup.RestoreCounts{
+		"documents":       {},
+		"journal_entries": {},
+		"links":           {},
+		"tags":            {},
+	}
+
+	tx, err := r.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create transaction")
+		return nil, errors.New("unable to create transaction")
+	}
+
+	if mode == backup.ModeReplace {
+		err = r.replace(tx, b, report)
+	} else {
+		err = r.merge(tx, b, report)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if mode == backup.ModeDryRun {
+		tx.Rollback()
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.WithError(err).Error("unable to commit transaction")
+		return nil, errors.New("unable to commit transaction")
+	}
+	return report, nil
+}
+
+func (r *PostgresDatabase) replace(tx *sql.Tx, b backup.Backup, report map[string]*backup.RestoreCounts) error {
+	// tagged resources go first as they reference tags, document pages are removed with their documents
+	tables := []struct {
+		name   string
+		report string
+	}{
+		{"tagged_resources", ""},
+		{"documents", "documents"},
+		{"links", "links"},
+		{"journal_entry", "journal_entries"},
+		{"tags", "tags"},
+	}
+	for _, t := range tables {
+		res, err := tx.Exec("DELETE FROM " + t.name)
+		if err != nil {
+			logrus.WithError(err).WithField("table", t.name).Error("unable to clear table")
+			return errors.New("unable to clear table")
+		}
+		if t.report != "" {
+			n, _ := res.RowsAffected()
+			report[t.report].Deleted = int(n)
+		}
+	}
+
+	if err := r.bulkInsertTags(tx, b.Tags); err != nil {
+		return err
+	}
+	if err := r.bulkInsertEntries(tx, b.Journal); err != nil {
+		return err
+	}
+	docsTrs, err := r.bulkInsertDocuments(tx, b.Docs)
+	if err != nil {
+		return err
+	}
+	linksTrs, err := r.bulkInsertLinks(tx, b.Links)
+	if err != nil {
+		return err
+	}
+	if err := r.bulkInsertTaggedResources(tx, append(docsTrs, linksTrs...)); err != nil {
+		return err
+	}
+
+	report["tags"].Inserted = len(b.Tags)
+	report["journal_entries"].Inserted = len(b.Journal)
+	report["documents"].Inserted = len(b.Docs)
+	report["links"].Inserted = len(b.Links)
+	return nil
+}
+
+func (r *PostgresDatabase) merge(tx *sql.Tx, b backup.Backup, report map[string]*backup.RestoreCounts) error {
+	// tags are unique by name per owner so a tag in the backup may already exist under another id
+	tagIDs := map[string]string{}
+	for _, t := range b.Tags {
+		id, inserted, err := r.mergeTag(tx, t)
+		if err != nil {
+			return err
+		}
+		tagIDs[t.ID] = id
+		count(report["tags"], inserted, false)
+	}
+
+	for _, e := range b.Journal {
+		var inserted bool
+		err := tx.QueryRow(`INSERT INTO journal_entry (id, content, created, owner_id) VALUES ($1, $2, $3, `+restoredOwnerExpr("$4")+`)
+			ON CONFLICT (id) DO NOTHING RETURNING true`, e.ID, e.Content, created(e.Created), e.Owner).Scan(&inserted)
+		if err != nil && err != sql.ErrNoRows {
+			logrus.WithError(err).Error("unable to merge entry")
+			return errors.New("unable to merge entry")
+		}
+		count(report["journal_entries"], inserted, false)
+	}
+
+	for _, d := range b.Docs {
+		inserted, updated, err := r.mergeDocument(tx, d)
+		if err != nil {
+			return err
+		}
+		count(report["documents"], inserted, updated)
+		if inserted || updated {
+			resourceType := tags.BookResource
+			if d.Type == "paper" {
+				resourceType = tags.PaperResource
+			}
+			if err := r.replaceResourceTags(tx, d.ID, resourceType, d.Tags, tagIDs); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, l := range b.Links {
+		inserted, updated, err := r.mergeLink(tx, l)
+		if err != nil {
+			return err
+		}
+		count(report["links"], inserted, updated)
+		if inserted || updated {
+			if err := r.replaceResourceTags(tx, l.ID, tags.LinksResource, l.Tags, tagIDs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) mergeTag(tx *sql.Tx, t tags.Tag) (id string, inserted bool, err error) {
+	err = tx.QueryRow(`INSERT INTO tags (id, display_name, color, owner_id) VALUES ($1, $2, $3, `+restoredOwnerExpr("$4")+`)
+		ON CONFLICT DO NOTHING RETURNING id`, t.ID, t.DisplayName, t.TagColor, t.Owner).Scan(&id)
+	if err == nil {
+		return id, true, nil
+	}
+	if err != sql.ErrNoRows {
+		logrus.WithError(err).Error("unable to merge tag")
+		return "", false, errors.New("unable to merge tag")
+	}
+	if err := tx.QueryRow(`SELECT id FROM tags WHERE id = $1 OR (display_name = $2 AND owner_id = `+restoredOwnerExpr("$3")+`)
+		ORDER BY id = $1 DESC LIMIT 1`, t.ID, t.DisplayName, t.Owner).Scan(&id); err != nil {
+		logrus.WithError(err).Error("unable to find existing tag")
+		return "", false, errors.New("unable to find existing tag")
+	}
+	return id, false, nil
+}
+
+// mergeDocument upserts a document unless the stored copy was updated more recently, xmax is zero for fresh rows
+func (r *PostgresDatabase) mergeDocument(tx *sql.Tx, d *documents.Document) (inserted, updated bool, err error) {
+	// backups taken before formats were stored only have the extension to go by
+	if d.Format == "" {
+		d.Format = documents.FormatOf(d.Path)
+	}
+	set := make([]string, len(metadataColumns))
+	for i, column := range metadataColumns {
+		set[i] = column + " = EXCLUDED." + column
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	err = ps.Insert("documents").
+		Columns(append([]string{"id", "description", "display_name", "name", "type", "path", "created", "updated", "owner_id", "sha256", "format"}, metadataColumns...)...).
+		Values(append([]interface{}{d.ID, d.Description, d.DisplayName, d.Name, d.Type, d.Path, created(d.Created), d.Updated,
+			sq.Expr(restoredOwnerExpr("?"), d.Owner), d.SHA256, d.Format}, metadataValues(d.Metadata)...)...).
+		Suffix(`ON CONFLICT (id) DO UPDATE SET description = EXCLUDED.description, display_name = EXCLUDED.display_name,
+			name = EXCLUDED.name, type = EXCLUDED.type, path = EXCLUDED.path, updated = EXCLUDED.updated, sha256 = EXCLUDED.sha256, format = EXCLUDED.format, ` + strings.Join(set, ", ") + `
+		WHERE COALESCE(documents.updated, documents.created) < COALESCE(EXCLUDED.updated, EXCLUDED.created)
+		RETURNING (xmax = 0)`).
+		RunWith(tx).QueryRow().Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to merge doc")
+		return false, false, errors.New("unable to merge doc")
+	}
+	return inserted, !inserted, nil
+}
+
+func (r *PostgresDatabase) mergeLink(tx *sql.Tx, l links.Link) (inserted, updated bool, err error) {
+	err = tx.QueryRow(`INSERT INTO links (id, link, icon_path, display_name, created, updated, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, `+restoredOwnerExpr("$7")+`)
+		ON CONFLICT (id) DO UPDATE SET link = EXCLUDED.link, icon_path = EXCLUDED.icon_path,
+			display_name = EXCLUDED.display_name, updated = EXCLUDED.updated
+		WHERE links.updated < EXCLUDED.updated
+		RETURNING (xmax = 0)`,
+		l.ID, l.Link, l.IconPath, l.DisplayName, created(l.Created), linkUpdated(l), l.Owner).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to merge link")
+		return false, false, errors.New("unable to merge link")
+	}
+	return inserted, !inserted, nil
+}
+
+// replaceResourceTags swaps the tags of a resource for the ones in the backup, tags that don't exist are skipped
+func (r *PostgresDatabase) replaceResourceTags(tx *sql.Tx, resourceID string, resourceType tags.ResourceType, tagIDs []string, mapping map[string]string) error {
+	if _, err := tx.Exec("DELETE FROM tagged_resources WHERE resource_id = $1", resourceID); err != nil {
+		logrus.WithError(err).Error("unable to remove tags")
+		return errors.New("unable to remove tags")
+	}
+	for _, id := range tagIDs {
+		if mapped, ok := mapping[id]; ok {
+			id = mapped
+		}
+		if _, err := tx.Exec(`INSERT INTO tagged_resources (id, resource_id, resource_type)
+			SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM tags WHERE id = $1)`, id, resourceID, resourceType); err != nil {
+			logrus.WithError(err).Error("unable to add tag")
+			return errors.New("unable to add tag")
+		}
+	}
+	return nil
+}
+
+func count(c *backup.RestoreCounts, inserted, updated bool) {
+	switch {
+	case inserted:
+		c.Inserted++
+	case updated:
+		c.Updated++
+	default:
+		c.Unchanged++
+	}
+}
+
+// created falls back to now for rows from backups that didn't record when they were created
+func created(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+func linkUpdated(l links.Link) time.Time {
+	if l.Updated.IsZero() {
+		return created(l.Created)
+	}
+	return l.Updated
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/search"
+	"context"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+)
+
+// The expressions below need to match the indexes created in the migrations for the search to use them
+const searchQuery = `
+WITH q AS (SELECT plainto_tsquery('english', $1) AS query)
+SELECT id, type, display_name, page, snippet, rank FROM (
+	SELECT documents.id, documents.type, documents.display_name, document_pages.page,
+		ts_headline('english', document_pages.content, q.query, 'MaxFragments=1, MaxWords=35, MinWords=15') AS snippet,
+		ts_rank(document_pages.tsv, q.query) AS rank
+	FROM document_pages JOIN documents ON documents.id = document_pages.document_id, q
+	WHERE document_pages.tsv @@ q.query AND documents.owner_id = $3
+	UNION ALL
+	SELECT documents.id, documents.type, documents.display_name, 0,
+		ts_headline('english', documents.display_name || ' ' || COALESCE(documents.description, ''), q.query),
+		ts_rank(to_tsvector('english', documents.display_name || ' ' || COALESCE(documents.description, '')), q.query)
+	FROM documents, q
+	WHERE to_tsvector('english', documents.display_name || ' ' || COALESCE(documents.description, '')) @@ q.query
+		AND documents.owner_id = $3
+	UNION ALL
+	SELECT links.id, 'link', COALESCE(links.display_name, links.link), 0,
+		ts_headline('english', COALESCE(links.display_name, '') || ' ' || links.link, q.query),
+		ts_rank(to_tsvector('english', COALESCE(links.display_name, '') || ' ' || links.link), q.query)
+	FROM links, q
+	WHERE to_tsvector('english', COALESCE(links.display_name, '') || ' ' || links.link) @@ q.query
+		AND links.owner_id = $3
+	UNION ALL
+	SELECT journal_entry.id, 'journal', to_char(journal_entry.created, 'YYYY-MM-DD'), 0,
+		ts_headline('english', journal_entry.content, q.query, 'MaxFragments=1, MaxWords=35, MinWords=15'),
+		ts_rank(to_tsvector('english', journal_entry.content), q.query)
+	FROM journal_entry, q
+	WHERE to_tsvector('english', journal_entry.content) @@ q.query AND journal_entry.owner_id = $3
+) results
+ORDER BY rank DESC
+LIMIT $2`
+
+func NewSearchRepository(database *PostgresDatabase) search.Repository {
+	return database
+}
+
+// Search only looks through the library of the user in the context
+func (r *PostgresDatabase) Search(ctx context.Context, query string, limit int) ([]search.Result, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	rows, err := r.conn.QueryContext(ctx, searchQuery, query, limit, identity.ID)
+	if err != nil {
+		logrus.WithError(err).Error("unable to search")
+		return nil, errors.New("unable to search")
+	}
+	defer rows.Close()
+
+	results := []search.Result{}
+	for rows.Next() {
+		var result search.Result
+		if err := rows.Scan(&result.ID, &result.Type, &result.DisplayName, &result.Page, &result.Snippet, &result.Rank); err != nil {
+			logrus.WithError(err).Warn("unable to scan search result")
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (r *PostgresDatabase) IndexPages(ctx context.Context, id string, pages []search.Page) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to create transaction")
+		return errors.New("unable to create transaction")
+	}
+
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Delete("document_pages").Where(sq.Eq{"document_id": id}).RunWith(tx).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to clear pages")
+		tx.Rollback()
+		return errors.New("unable to clear pages")
+	}
+
+	for _, p := range pages {
+		if _, err := ps.Insert("document_pages").
+			Columns("document_id", "page", "content", "tsv").
+			Values(id, p.Number, p.Content, sq.Expr("to_tsvector('english', ?)", p.Content)).
+			RunWith(tx).Exec(); err != nil {
+			logrus.WithError(err).WithField("page", p.Number).Error("unable to insert page")
+			tx.Rollback()
+			return errors.New("unable to insert page")
+		}
+	}
+
+	if _, err := ps.Update("documents").Set("indexed", sq.Expr("now()")).Where(sq.Eq{"id": id}).RunWith(tx).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to mark document indexed")
+		tx.Rollback()
+		return errors.New("unable to mark document indexed")
+	}
+
+	if err := tx.Commit(); err != nil {
+		logrus.WithError(err).Error("unable to commit transaction")
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) MarkIndexed(ctx context.Context, id string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("documents").Set("indexed", sq.Expr("now()")).Where(sq.Eq{"id": id}).RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to mark document indexed")
+		return errors.New("unable to mark document indexed")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FindUnindexed(ctx context.Context) ([]search.Source, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select("documents.id", "documents.path").
+		From("documents").
+		Where(sq.Eq{"documents.indexed": nil}).
+		// citations imported without a file have nothing to index
+		Where(sq.NotEq{"documents.path": ""}).
+		RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find unindexed documents")
+		return nil, errors.New("unable to find unindexed documents")
+	}
+	defer rows.Close()
+
+	sources := []search.Source{}
+	for rows.Next() {
+		var src search.Source
+		if err := rows.Scan(&src.ID, &src.Path); err != nil {
+			logrus.WithError(err).Warn("unable to scan document")
+			continue
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"alexandria/internal/sharing"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// a tag share covers whatever carries the tag at the time of the request
+const grantedRolesQuery = `SELECT role FROM shares
+	WHERE (expires IS NULL OR expires > $4)
+	AND (grantee_id = NULLIF($2, '')::uuid OR token = NULLIF($3, ''))
+	AND (resource_id = $1 OR (resource_type = 'tag' AND EXISTS (
+		SELECT 1 FROM tagged_resources tr WHERE tr.id = shares.resource_id AND tr.resource_id = $1)))`
+
+var shareColumns = []string{"id", "resource_id", "resource_type", "owner_id", "COALESCE(grantee_id::varchar, '')",
+	"COALESCE(token, '')", "role", "created", "expires"}
+
+func NewSharesRepository(database *PostgresDatabase) sharing.Repository {
+	return database
+}
+
+func (r *PostgresDatabase) FindResourceOwner(ctx context.Context, resourceID string) (string, string, error) {
+	if _, err := uuid.Parse(resourceID); err != nil {
+		return "", "", sharing.ErrNotFound
+	}
+	var resourceType, owner string
+	err := r.conn.QueryRowContext(ctx, `SELECT type, owner_id FROM documents WHERE id = $1
+		UNION ALL SELECT 'link', owner_id FROM links WHERE id = $1
+		UNION ALL SELECT 'tag', owner_id FROM tags WHERE id = $1
+		LIMIT 1`, resourceID).Scan(&resourceType, &owner)
+	if err == sql.ErrNoRows {
+		return "", "", sharing.ErrNotFound
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find resource owner")
+		return "", "", errors.New("unable to find resource owner")
+	}
+	return resourceType, owner, nil
+}
+
+func (r *PostgresDatabase) FindGrantedRoles(ctx context.Context, resourceID, userID, token string) ([]sharing.Role, error) {
+	rows, err := r.conn.QueryContext(ctx, grantedRolesQuery, resourceID, userID, token, time.Now().UTC())
+	if err != nil {
+		logrus.WithError(err).Error("unable to find shares")
+		return nil, errors.New("unable to find shares")
+	}
+	defer rows.Close()
+
+	roles := []sharing.Role{}
+	for rows.Next() {
+		var role sharing.Role
+		if err := rows.Scan(&role); err != nil {
+			logrus.WithError(err).Warn("unable to scan share")
+			continue
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (r *PostgresDatabase) CreateShare(ctx context.Context, share sharing.Share) (sharing.Share, error) {
+	share.ID = uuid.New().String()
+	share.Created = time.Now().UTC()
+	if share.Expires != nil {
+		expires := share.Expires.UTC()
+		share.Expires = &expires
+	}
+
+	var grantee, token interface{}
+	if share.Grantee != "" {
+		grantee = share.Grantee
+	}
+	if share.Token != "" {
+		token = share.Token
+	}
+
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("shares").
+		Columns("id", "resource_id", "resource_type", "owner_id", "grantee_id", "token", "role", "created", "expires").
+		Values(share.ID, share.ResourceID, share.ResourceType, share.Owner, grantee, token, share.Role, share.Created, share.Expires).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to create share")
+		return share, errors.New("unable to create share")
+	}
+	return share, nil
+}
+
+func (r *PostgresDatabase) FindShares(ctx context.Context, owner, resourceID string) ([]sharing.Share, error) {
+	pred := sq.And{sq.Eq{"owner_id": owner}}
+	if resourceID != "" {
+		if _, err := uuid.Parse(resourceID); err != nil {
+			return []sharing.Share{}, nil
+		}
+		pred = append(pred, sq.Eq{"resource_id": resourceID})
+	}
+	return r.findShares(ctx, pred)
+}
+
+func (r *PostgresDatabase) FindSharesWith(ctx context.Context, grantee string) ([]sharing.Share, error) {
+	return r.findShares(ctx, sq.And{
+		sq.Eq{"grantee_id": grantee},
+		sq.Or{sq.Eq{"expires": nil}, sq.Gt{"expires": time.Now().UTC()}},
+	})
+}
+
+func (r *PostgresDatabase) FindShareByToken(ctx context.Context, token string) (sharing.Share, error) {
+	shares, err := r.findShares(ctx, sq.And{
+		sq.Eq{"token": token},
+		sq.Or{sq.Eq{"expires": nil}, sq.Gt{"expires": time.Now().UTC()}},
+	})
+	if err != nil {
+		return sharing.Share{}, err
+	}
+	if len(shares) == 0 {
+		return sharing.Share{}, sharing.ErrNotFound
+	}
+	return shares[0], nil
+}
+
+func (r *PostgresDatabase) DeleteShare(ctx context.Context, owner, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return sharing.ErrNotFound
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	res, err := ps.Delete("shares").Where(sq.Eq{"id": id, "owner_id": owner}).RunWith(r.conn).Exec()
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete share")
+		return errors.New("unable to delete share")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sharing.ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) findShares(ctx context.Context, pred sq.Sqlizer) ([]sharing.Share, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select(shareColumns...).
+		From("shares").
+		Where(pred).
+		OrderBy("created DESC").
+		RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find shares")
+		return nil, errors.New("unable to find shares")
+	}
+	defer rows.Close()
+
+	shares := []sharing.Share{}
+	for rows.Next() {
+		var share sharing.Share
+		if err := rows.Scan(&share.ID, &share.ResourceID, &share.ResourceType, &share.Owner, &share.Grantee,
+			&share.Token, &share.Role, &share.Created, &share.Expires); err != nil {
+			logrus.WithError(err).Warn("unable to scan share")
+			continue
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"alexandria/internal/common"
 	"alexandria/internal/tags"
+	"context"
+	"database/sql"
 )
 
 type tagsRepo struct {
@@ -15,43 +18,45 @@ func NewTagsRepository(psql *PostgresDatabase, neo *Neo4jDatabase) tags.Reposito
 		neo:      neo,
 	}
 }
-func (r *tagsRepo) FindAllTags() ([]tags.Tag, error) {
-	return r.postgres.FindAllTags()
+func (r *tagsRepo) FindAllTags(ctx context.Context) ([]tags.Tag, error) {
+	return r.postgres.FindAllTags(ctx)
 }
 
-func (r *tagsRepo) CreateTag(tag tags.Tag) (tags.Tag, error) {
-	t, err := r.postgres.CreateTag(tag)
-	if err != nil {
-		return t, err
-	}
-	return r.neo.CreateTag(t)
+func (r *tagsRepo) FindTagsPage(ctx context.Context, page common.PageRequest) ([]tags.Tag, string, error) {
+	return r.postgres.FindTagsPage(ctx, page)
 }
 
-func (r *tagsRepo) AddResourceTag(resourceID string, resourceType tags.ResourceType, tagName string) error {
-	if err := r.postgres.AddResourceTag(resourceID, resourceType, tagName); err != nil {
-		return err
-	}
-	t, err := r.postgres.GetTagByName(tagName)
-	if err != nil {
-		return err
-	}
-	if _, err := r.neo.CreateTag(t); err != nil {
-		return err
-	}
-	return r.neo.AddResourceTag(resourceID, resourceType, t.DisplayName)
+func (r *tagsRepo) CreateTag(ctx context.Context, tag tags.Tag) (t tags.Tag, err error) {
+	err = r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		t, err = r.postgres.createTag(ctx, tx, tag)
+		if err != nil {
+			return err
+		}
+		return enqueue(tx, eventTagUpserted, t)
+	})
+	return t, err
 }
 
-func (r *tagsRepo) RemoveResourceTag(resourceID string, tagName string) error {
-	t, err := r.postgres.GetTagByName(tagName)
-	if err != nil {
-		return err
-	}
-	if err := r.postgres.RemoveResourceTag(resourceID, tagName); err != nil {
-		return err
-	}
-	return r.neo.RemoveResourceTag(resourceID, t.ID)
+func (r *tagsRepo) AddResourceTag(ctx context.Context, resourceID string, resourceType tags.ResourceType, tagName string) error {
+	return r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		t, err := r.postgres.addResourceTag(ctx, tx, resourceID, resourceType, tagName)
+		if err != nil {
+			return err
+		}
+		return enqueue(tx, eventResourceTagged, resourceTagEvent{ResourceID: resourceID, ResourceType: resourceType, Tag: t})
+	})
+}
+
+func (r *tagsRepo) RemoveResourceTag(ctx context.Context, resourceID string, tagName string) error {
+	return r.postgres.inTx(ctx, func(tx *sql.Tx) error {
+		t, err := r.postgres.removeResourceTag(ctx, tx, resourceID, tagName)
+		if err != nil {
+			return err
+		}
+		return enqueue(tx, eventResourceUntagged, resourceTagEvent{ResourceID: resourceID, Tag: t})
+	})
 }
 
-func (r *tagsRepo) GetTaggedResources(id string) ([]tags.TaggedResource, error) {
-	return r.neo.GetTaggedResources(id)
+func (r *tagsRepo) GetTaggedResources(ctx context.Context, id string) ([]tags.TaggedResource, error) {
+	return r.neo.GetTaggedResources(ctx, id)
 }
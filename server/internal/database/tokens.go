@@ -0,0 +1,106 @@
+package database
+
+import (
+	"alexandria/internal/user"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+func NewTokenRepository(database *PostgresDatabase) user.TokenRepository {
+	return database
+}
+
+func (r *PostgresDatabase) CreateRefreshToken(ctx context.Context, token user.RefreshToken) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("refresh_tokens").
+		Columns("id", "user_id", "family", "token_hash", "created", "expires").
+		Values(token.ID, token.UserID, token.Family, token.Hash, token.Created, token.Expires).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to create refresh token")
+		return errors.New("unable to create refresh token")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FindRefreshToken(ctx context.Context, hash string) (*user.RefreshToken, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	var token user.RefreshToken
+	if err := ps.Select("id", "user_id", "family", "token_hash", "created", "expires", "revoked", "COALESCE(replaced_by::varchar, '')").
+		From("refresh_tokens").
+		Where(sq.Eq{"token_hash": hash}).
+		RunWith(r.conn).QueryRow().
+		Scan(&token.ID, &token.UserID, &token.Family, &token.Hash, &token.Created, &token.Expires, &token.Revoked, &token.ReplacedBy); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		logrus.WithError(err).Error("unable to find refresh token")
+		return nil, errors.New("unable to find refresh token")
+	}
+	return &token, nil
+}
+
+func (r *PostgresDatabase) RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	res, err := ps.Update("refresh_tokens").
+		Set("revoked", time.Now().UTC()).
+		Set("replaced_by", replacedBy).
+		Where(sq.Eq{"id": id, "revoked": nil}).
+		RunWith(r.conn).Exec()
+	if err != nil {
+		logrus.WithError(err).Error("unable to revoke refresh token")
+		return false, errors.New("unable to revoke refresh token")
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *PostgresDatabase) RevokeRefreshFamily(ctx context.Context, family string) error {
+	return r.revokeRefreshTokens(sq.Eq{"family": family, "revoked": nil})
+}
+
+func (r *PostgresDatabase) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	return r.revokeRefreshTokens(sq.Eq{"user_id": userID, "revoked": nil})
+}
+
+func (r *PostgresDatabase) revokeRefreshTokens(pred sq.Eq) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Update("refresh_tokens").
+		Set("revoked", time.Now().UTC()).
+		Where(pred).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to revoke refresh tokens")
+		return errors.New("unable to revoke refresh tokens")
+	}
+	return nil
+}
+
+// RevokeAccessToken adds the token to the revocation list, entries are dropped once the token would have expired
+func (r *PostgresDatabase) RevokeAccessToken(ctx context.Context, tokenID string, expires time.Time) error {
+	if _, err := r.conn.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires < $1", time.Now().UTC()); err != nil {
+		logrus.WithError(err).Warn("unable to prune revoked tokens")
+	}
+	if _, err := r.conn.ExecContext(ctx, `INSERT INTO revoked_tokens (token_id, expires) VALUES ($1, $2)
+		ON CONFLICT (token_id) DO NOTHING`, tokenID, expires.UTC()); err != nil {
+		logrus.WithError(err).Error("unable to revoke token")
+		return errors.New("unable to revoke token")
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) IsAccessTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	var count int
+	if err := ps.Select("count(*)").
+		From("revoked_tokens").
+		Where(sq.Eq{"token_id": tokenID}).
+		RunWith(r.conn).QueryRow().Scan(&count); err != nil {
+		logrus.WithError(err).Error("unable to check revoked tokens")
+		return false, errors.New("unable to check revoked tokens")
+	}
+	return count > 0, nil
+}
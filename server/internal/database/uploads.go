@@ -0,0 +1,136 @@
+package database
+
+import (
+	"alexandria/internal/uploads"
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var uploadColumns = []string{"id", "name", "display_name", "type", "size", "received", "COALESCE(sha256, '')", "owner_id",
+	"created", "updated", "parts"}
+
+func NewUploadsRepository(database *PostgresDatabase) uploads.Repository {
+	return database
+}
+
+func (r *PostgresDatabase) CreateUpload(ctx context.Context, upload uploads.Upload) (uploads.Upload, error) {
+	owner, err := ownerID(ctx, upload.Owner)
+	if err != nil {
+		return upload, err
+	}
+	upload.Owner = owner
+	upload.Created = time.Now().UTC()
+	upload.Updated = upload.Created
+
+	var hash interface{}
+	if upload.SHA256 != "" {
+		hash = upload.SHA256
+	}
+
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	if _, err := ps.Insert("uploads").
+		Columns("id", "owner_id", "name", "display_name", "type", "size", "sha256", "created", "updated").
+		Values(upload.ID, upload.Owner, upload.Name, upload.DisplayName, upload.Type, upload.Size, hash, upload.Created, upload.Updated).
+		RunWith(r.conn).Exec(); err != nil {
+		logrus.WithError(err).Error("unable to create upload")
+		return upload, errors.New("unable to create upload")
+	}
+	return upload, nil
+}
+
+func (r *PostgresDatabase) FindUpload(ctx context.Context, id string) (uploads.Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return uploads.Upload{}, uploads.ErrNotFound
+	}
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	row := ps.Select(uploadColumns...).
+		From("uploads").
+		Where(sq.Eq{"id": id}).
+		Where(ownerPred(ctx, "owner_id")).
+		RunWith(r.conn).QueryRow()
+	upload, err := scanUpload(row)
+	if err == sql.ErrNoRows {
+		return upload, uploads.ErrNotFound
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find upload")
+		return upload, errors.New("unable to find upload")
+	}
+	return upload, nil
+}
+
+// AddPart only moves the offset on from where the chunk started, a second chunk sent for the same offset loses
+func (r *PostgresDatabase) AddPart(ctx context.Context, id string, offset, size int64, path string) (uploads.Upload, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	row := ps.Update("uploads").
+		Set("received", sq.Expr("received + ?", size)).
+		Set("parts", sq.Expr("array_append(parts, ?::text)", path)).
+		Set("updated", time.Now().UTC()).
+		Where(sq.Eq{"id": id, "received": offset}).
+		Where(ownerPred(ctx, "owner_id")).
+		Suffix("RETURNING " + strings.Join(uploadColumns, ", ")).
+		RunWith(r.conn).QueryRow()
+	upload, err := scanUpload(row)
+	if err == sql.ErrNoRows {
+		return upload, uploads.ErrOffsetMismatch
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to add upload part")
+		return upload, errors.New("unable to add upload part")
+	}
+	return upload, nil
+}
+
+func (r *PostgresDatabase) DeleteUpload(ctx context.Context, id string) error {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	res, err := ps.Delete("uploads").Where(sq.Eq{"id": id}).Where(ownerPred(ctx, "owner_id")).RunWith(r.conn).Exec()
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete upload")
+		return errors.New("unable to delete upload")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return uploads.ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresDatabase) FindExpiredUploads(ctx context.Context, before time.Time) ([]uploads.Upload, error) {
+	ps := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	rows, err := ps.Select(uploadColumns...).
+		From("uploads").
+		Where(sq.Lt{"updated": before}).
+		Where(ownerPred(ctx, "owner_id")).
+		RunWith(r.conn).Query()
+	if err != nil {
+		logrus.WithError(err).Error("unable to find expired uploads")
+		return nil, errors.New("unable to find expired uploads")
+	}
+	defer rows.Close()
+
+	expired := []uploads.Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			logrus.WithError(err).Warn("unable to scan upload")
+			continue
+		}
+		expired = append(expired, upload)
+	}
+	return expired, nil
+}
+
+func scanUpload(row sq.RowScanner) (uploads.Upload, error) {
+	upload := uploads.Upload{}
+	parts := pq.StringArray{}
+	err := row.Scan(&upload.ID, &upload.Name, &upload.DisplayName, &upload.Type, &upload.Size, &upload.Offset, &upload.SHA256,
+		&upload.Owner, &upload.Created, &upload.Updated, &parts)
+	upload.Parts = parts
+	return upload, err
+}
@@ -0,0 +1,303 @@
+package documents
+
+import (
+	"alexandria/internal/search"
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatText is only produced by conversion, plain text files aren't stored as documents
+const FormatText = "txt"
+
+const conversionTimeout = 5 * time.Minute
+
+var ErrConversionUnsupported = errors.New("conversion not supported")
+
+// Converter turns a document into another format. in is a local copy of the stored file and the result is written
+// to out, both are named with the extension of their format.
+type Converter interface {
+	Converts(from, to string) bool
+	Convert(ctx context.Context, doc *Document, to, in, out string) error
+}
+
+var contentTypes = map[string]string{
+	FormatPDF:  "application/pdf",
+	FormatEPUB: "application/epub+zip",
+	FormatMOBI: "application/x-mobipocket-ebook",
+	FormatAZW3: "application/vnd.amazon.ebook",
+	FormatText: "text/plain; charset=utf-8",
+}
+
+// ContentType is the media type served for a converted format, the bucket can't tell an epub from any other zip
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// ValidConversion reports whether format can be asked for, whether there is a converter for the document is only
+// known once it is looked up
+func ValidConversion(format string) bool {
+	return format == FormatText || validFormat(format)
+}
+
+// convertedPath keeps a converted copy alongside the original, the suffix keeps scans from taking it for a document
+func convertedPath(original, format string) string {
+	return original + "~" + format
+}
+
+// NewConverters lists the converters in the order they are tried, the pure Go ones come first and the external
+// tools are only added when they are installed
+func NewConverters() []Converter {
+	converters := []Converter{textConverter{}, comicConverter{}}
+	for _, c := range []*commandConverter{
+		{
+			tool: "ddjvu",
+			from: []string{FormatDJVU},
+			to:   []string{FormatPDF},
+			args: func(in, out string) []string { return []string{"-format=pdf", in, out} },
+		},
+		// calibre picks the input and output formats from the extensions
+		{
+			tool: "ebook-convert",
+			from: []string{FormatEPUB, FormatMOBI, FormatAZW3, FormatCBZ, FormatMarkdown, FormatHTML, FormatPDF},
+			to:   []string{FormatEPUB, FormatPDF, FormatMOBI, FormatAZW3},
+			args: func(in, out string) []string { return []string{in, out} },
+		},
+	} {
+		if _, err := exec.LookPath(c.tool); err != nil {
+			logrus.WithField("tool", c.tool).Info("converter not installed, its conversions are unavailable")
+			continue
+		}
+		converters = append(converters, c)
+	}
+	return converters
+}
+
+// textConverter writes the text search extracts, pages are separated by a blank line
+type textConverter struct{}
+
+func (textConverter) Converts(from, to string) bool {
+	return to == FormatText && from != FormatCBZ
+}
+
+func (textConverter) Convert(ctx context.Context, doc *Document, to, in, out string) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pages, err := search.Extract(ctx, filepath.Ext(in), f)
+	if err != nil {
+		return err
+	}
+
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	buf := bufio.NewWriter(w)
+	for _, page := range pages {
+		fmt.Fprintf(buf, "%s\n\n", page.Content)
+	}
+	return buf.Flush()
+}
+
+// comicConverter packs the pages of a comic into a fixed layout epub with a page for every image
+type comicConverter struct{}
+
+func (comicConverter) Converts(from, to string) bool {
+	return from == FormatCBZ && to == FormatEPUB
+}
+
+var comicMediaTypes = map[string]string{".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif",
+	".webp": "image/webp", ".bmp": "image/bmp"}
+
+func (comicConverter) Convert(ctx context.Context, doc *Document, to, in, out string) error {
+	comic, err := zip.OpenReader(in)
+	if err != nil {
+		return err
+	}
+	defer comic.Close()
+
+	images := []*zip.File{}
+	for _, f := range comic.File {
+		if !strings.HasPrefix(f.Name, "__MACOSX/") && comicMediaTypes[strings.ToLower(path.Ext(f.Name))] != "" {
+			images = append(images, f)
+		}
+	}
+	if len(images) == 0 {
+		return errors.New("comic has no pages")
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	epub := zip.NewWriter(w)
+
+	// the mimetype has to be the first entry and stored uncompressed
+	mimetype, err := epub.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+	if err := writeEntry(epub, "META-INF/container.xml", epubContainerXML); err != nil {
+		return err
+	}
+
+	title, language := doc.Title, doc.Language
+	if title == "" {
+		title = doc.DisplayName
+	}
+	if language == "" {
+		language = "en"
+	}
+	var manifest, spine, nav strings.Builder
+	for i, image := range images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ext := strings.ToLower(path.Ext(image.Name))
+		name := fmt.Sprintf("page%04d", i+1)
+		if err := copyEntry(epub, "OEBPS/images/"+name+ext, image); err != nil {
+			return err
+		}
+		page := fmt.Sprintf(epubPageXHTML, escapeXML(title), name+ext)
+		if err := writeEntry(epub, "OEBPS/"+name+".xhtml", page); err != nil {
+			return err
+		}
+		properties := ""
+		if i == 0 {
+			properties = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&manifest, `<item id="%s" href="%s.xhtml" media-type="application/xhtml+xml"/>`+"\n", name, name)
+		fmt.Fprintf(&manifest, `<item id="%s-image" href="images/%s%s" media-type="%s"%s/>`+"\n", name, name, ext, comicMediaTypes[ext], properties)
+		fmt.Fprintf(&spine, `<itemref idref="%s"/>`+"\n", name)
+		fmt.Fprintf(&nav, `<li><a href="%s.xhtml">%d</a></li>`+"\n", name, i+1)
+	}
+
+	opf := fmt.Sprintf(epubPackageXML, doc.ID, escapeXML(title), escapeXML(language), time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		manifest.String(), spine.String())
+	if err := writeEntry(epub, "OEBPS/content.opf", opf); err != nil {
+		return err
+	}
+	if err := writeEntry(epub, "OEBPS/nav.xhtml", fmt.Sprintf(epubNavXHTML, escapeXML(title), nav.String())); err != nil {
+		return err
+	}
+	return epub.Close()
+}
+
+const epubContainerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+const epubPackageXML = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:uuid:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>%s</dc:language>
+<meta property="dcterms:modified">%s</meta>
+<meta property="rendition:layout">pre-paginated</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+%s</manifest>
+<spine>
+%s</spine>
+</package>`
+
+const epubPageXHTML = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>%s</title><style>body{margin:0}img{width:100%%;height:100%%;object-fit:contain}</style></head>
+<body><img src="images/%s" alt=""/></body>
+</html>`
+
+const epubNavXHTML = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body><nav epub:type="toc"><ol>
+%s</ol></nav></body>
+</html>`
+
+func writeEntry(archive *zip.Writer, name, content string) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// copyEntry stores images as they are, they are compressed already
+func copyEntry(archive *zip.Writer, name string, f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func escapeXML(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// commandConverter adapts a command line tool that reads the input file and writes the output file
+type commandConverter struct {
+	tool string
+	from []string
+	to   []string
+	args func(in, out string) []string
+}
+
+func (c *commandConverter) Converts(from, to string) bool {
+	return contains(c.from, from) && contains(c.to, to) && from != to
+}
+
+func (c *commandConverter) Convert(ctx context.Context, doc *Document, to, in, out string) error {
+	ctx, cancel := context.WithTimeout(ctx, conversionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.tool, c.args(in, out)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logrus.WithError(err).WithField("output", string(output)).Errorf("unable to run %s", c.tool)
+		return fmt.Errorf("unable to run %s", c.tool)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package documents
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrNothingToMerge = errors.New("no other documents to merge")
+	ErrNotOwner       = errors.New("only the owner can merge documents")
+)
+
+// DuplicateError is returned when the same bytes were already uploaded, ID is the document holding them
+type DuplicateError struct {
+	ID string
+}
+
+func (e *DuplicateError) Error() string {
+	return "document already exists"
+}
+
+const (
+	DuplicateHash  = "hash"
+	DuplicateTitle = "title"
+)
+
+// DuplicateGroup is a set of documents that look like the same work, either by identical content or by title
+type DuplicateGroup struct {
+	Reason    string      `json:"reason"`
+	Key       string      `json:"key"`
+	Documents []*Document `json:"documents"`
+}
+
+// hashFile reads the whole upload for its SHA-256 and rewinds it for storage
+func hashFile(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var (
+	titleWords    = regexp.MustCompile(`[\pL\pN]+`)
+	leadingTitles = map[string]bool{"a": true, "an": true, "the": true}
+)
+
+// normalizeTitle reduces a title to its words so case, punctuation, a leading article and file name noise don't
+// keep copies of the same work apart
+func normalizeTitle(title string) string {
+	words := titleWords.FindAllString(strings.ToLower(title), -1)
+	if len(words) > 1 && leadingTitles[words[0]] {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// hashMissing hashes the stored files of documents that came from a scan or predate hashing, so they show up as
+// duplicates of uploads
+func (s *documentService) hashMissing(ctx context.Context) {
+	docs, err := s.repo.FindAll(ctx, Filter{})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch documents to hash")
+		return
+	}
+	count := 0
+	for _, doc := range docs {
+		if doc.SHA256 != "" || doc.Path == "" {
+			continue
+		}
+		content, err := s.storage.Stream(ctx, doc.Path)
+		if err != nil {
+			logrus.WithError(err).WithField("id", doc.ID).Warn("unable to open document to hash")
+			continue
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, content)
+		content.Close()
+		if err != nil {
+			logrus.WithError(err).WithField("id", doc.ID).Warn("unable to hash document")
+			continue
+		}
+		if err := s.repo.SetHash(ctx, doc.ID, hex.EncodeToString(h.Sum(nil))); err != nil {
+			logrus.WithError(err).WithField("id", doc.ID).Warn("unable to save document hash")
+			continue
+		}
+		count++
+	}
+	logrus.WithField("count", count).Info("documents hashed")
+}
+
+func (s *documentService) FindDuplicates(ctx context.Context) ([]DuplicateGroup, error) {
+	docs, err := s.repo.FindAll(ctx, Filter{})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch documents from repository")
+		return nil, errors.New("unable to fetch from repository")
+	}
+
+	byHash := map[string][]*Document{}
+	byTitle := map[string][]*Document{}
+	for _, doc := range docs {
+		if doc.SHA256 != "" {
+			byHash[doc.SHA256] = append(byHash[doc.SHA256], doc)
+		}
+		title := doc.Title
+		if title == "" {
+			title = doc.DisplayName
+		}
+		if key := normalizeTitle(title); key != "" {
+			byTitle[key] = append(byTitle[key], doc)
+		}
+	}
+
+	groups := []DuplicateGroup{}
+	// a title group holding exactly the copies of a hash group adds nothing
+	seen := map[string]bool{}
+	for key, docs := range byHash {
+		if len(docs) > 1 {
+			groups = append(groups, DuplicateGroup{Reason: DuplicateHash, Key: key, Documents: docs})
+			seen[groupIDs(docs)] = true
+		}
+	}
+	for key, docs := range byTitle {
+		if len(docs) > 1 && !seen[groupIDs(docs)] {
+			groups = append(groups, DuplicateGroup{Reason: DuplicateTitle, Key: key, Documents: docs})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reason != groups[j].Reason {
+			return groups[i].Reason == DuplicateHash
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+func groupIDs(docs []*Document) string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// Merge folds the duplicates into the document and deletes them. Tags are combined, descriptions are appended and
+// metadata the document is missing is taken from the duplicates, as is the file when the document has none. Every
+// document has to belong to the caller, merging deletes the duplicates.
+func (s *documentService) Merge(ctx context.Context, id string, duplicateIDs []string) (*Document, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	entity, err := s.repo.FindByID(ctx, id)
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to fetch doc from repository")
+		return nil, errors.New("unable to fetch from repository")
+	}
+	if entity.Owner != identity.ID {
+		return nil, ErrNotOwner
+	}
+
+	duplicates := []*Document{}
+	seen := map[string]bool{id: true}
+	for _, duplicateID := range duplicateIDs {
+		if seen[duplicateID] {
+			continue
+		}
+		seen[duplicateID] = true
+		duplicate, err := s.repo.FindByID(ctx, duplicateID)
+		if err == ErrNotFound {
+			return nil, err
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("id", duplicateID).Error("unable to fetch doc from repository")
+			return nil, errors.New("unable to fetch from repository")
+		}
+		if duplicate.Owner != identity.ID {
+			return nil, ErrNotOwner
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+	if len(duplicates) == 0 {
+		return nil, ErrNothingToMerge
+	}
+
+	for _, duplicate := range duplicates {
+		fold(entity, duplicate)
+	}
+	merged, err := s.repo.Merge(ctx, *entity, duplicates)
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to merge documents")
+		return nil, errors.New("unable to merge documents")
+	}
+	for _, duplicate := range duplicates {
+		if duplicate.Path != merged.Path {
+			s.removeFiles(ctx, duplicate.Path)
+		}
+	}
+	if merged.Path != "" {
+		go s.indexer.Index(context.Background(), merged.ID, merged.Path)
+	}
+	return &merged, nil
+}
+
+// fold copies what the duplicate knows and the document doesn't into the document
+func fold(doc *Document, duplicate *Document) {
+	for _, tag := range duplicate.Tags {
+		doc.Tags = appendUnique(doc.Tags, tag)
+	}
+	if description := strings.TrimSpace(duplicate.Description); description != "" && !strings.Contains(doc.Description, description) {
+		if doc.Description != "" {
+			description = doc.Description + "\n\n" + description
+		}
+		doc.Description = description
+	}
+	if doc.Path == "" && duplicate.Path != "" {
+		doc.Path, doc.Name, doc.SHA256, doc.Format = duplicate.Path, duplicate.Name, duplicate.SHA256, duplicate.Format
+	}
+
+	m, d := &doc.Metadata, duplicate.Metadata
+	for field, value := range map[*string]string{
+		&m.Title: d.Title, &m.Publisher: d.Publisher, &m.Language: d.Language, &m.ISBN: d.ISBN, &m.DOI: d.DOI,
+		&m.ArXivID: d.ArXivID, &m.CitationKey: d.CitationKey, &m.EntryType: d.EntryType, &m.Journal: d.Journal,
+		&m.Volume: d.Volume, &m.Issue: d.Issue, &m.Pages: d.Pages, &m.URL: d.URL, &m.CoverURL: d.CoverURL,
+	} {
+		if *field == "" {
+			*field = value
+		}
+	}
+	if len(m.Authors) == 0 {
+		m.Authors = d.Authors
+	}
+	for _, subject := range d.Subjects {
+		m.Subjects = appendUnique(m.Subjects, subject)
+	}
+	if m.Published == nil {
+		m.Published = d.Published
+	}
+}
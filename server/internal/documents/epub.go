@@ -0,0 +1,228 @@
+package documents
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const maxPackageSize = 4 << 20
+
+var (
+	ErrInvalidEPUB = errors.New("invalid epub")
+	markup         = regexp.MustCompile(`<[^>]*>`)
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// opfPackage is the part of the OPF package document holding the Dublin Core metadata, elements are matched by
+// local name so both EPUB 2 and 3 files are read
+type opfPackage struct {
+	Metadata struct {
+		Titles       []string        `xml:"title"`
+		Creators     []opfCreator    `xml:"creator"`
+		Publishers   []string        `xml:"publisher"`
+		Languages    []string        `xml:"language"`
+		Identifiers  []opfIdentifier `xml:"identifier"`
+		Descriptions []string        `xml:"description"`
+		Subjects     []string        `xml:"subject"`
+		Meta         []opfMeta       `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+type opfCreator struct {
+	ID   string `xml:"id,attr"`
+	Role string `xml:"role,attr"`
+	Name string `xml:",chardata"`
+}
+
+type opfIdentifier struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// opfMeta carries EPUB 3 refinements, such as the role of a creator
+type opfMeta struct {
+	Refines  string `xml:"refines,attr"`
+	Property string `xml:"property,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// fileInfo is what could be read from an uploaded file
+type fileInfo struct {
+	Metadata
+	Description string
+}
+
+// readEPUBMetadata finds the package document through META-INF/container.xml and reads its metadata
+func readEPUBMetadata(r io.ReaderAt, size int64) (*fileInfo, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidEPUB, err.Error())
+	}
+
+	var container epubContainer
+	if err := decodeEntry(archive, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return nil, errors.Wrap(ErrInvalidEPUB, "no package document")
+	}
+
+	var pkg opfPackage
+	if err := decodeEntry(archive, container.Rootfiles[0].FullPath, &pkg); err != nil {
+		return nil, err
+	}
+	return pkg.metadata(), nil
+}
+
+func decodeEntry(archive *zip.Reader, name string, v interface{}) error {
+	name = path.Clean(name)
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return errors.Wrap(ErrInvalidEPUB, err.Error())
+		}
+		defer rc.Close()
+
+		decoder := xml.NewDecoder(io.LimitReader(rc, maxPackageSize))
+		decoder.Strict = false
+		decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			// everything but utf-8 is rare enough that reading it as is beats rejecting the file
+			return input, nil
+		}
+		if err := decoder.Decode(v); err != nil {
+			return errors.Wrapf(ErrInvalidEPUB, "unable to parse %s: %s", name, err)
+		}
+		return nil
+	}
+	return errors.Wrapf(ErrInvalidEPUB, "missing %s", name)
+}
+
+func (p opfPackage) metadata() *fileInfo {
+	m := p.Metadata
+	roles := map[string]string{}
+	for _, meta := range m.Meta {
+		if meta.Property == "role" && strings.HasPrefix(meta.Refines, "#") {
+			roles[meta.Refines[1:]] = strings.TrimSpace(meta.Value)
+		}
+	}
+
+	meta := &fileInfo{Metadata: Metadata{
+		Title:     first(m.Titles),
+		Authors:   []string{},
+		Publisher: first(m.Publishers),
+		Language:  first(m.Languages),
+		Subjects:  []string{},
+	}}
+	for _, c := range m.Creators {
+		role := c.Role
+		if role == "" {
+			role = roles[c.ID]
+		}
+		name := clean(c.Name)
+		if name != "" && (role == "" || role == "aut") {
+			meta.Authors = appendUnique(meta.Authors, name)
+		}
+	}
+	for _, id := range m.Identifiers {
+		value := strings.TrimSpace(id.Value)
+		if strings.EqualFold(id.Scheme, "isbn") || strings.HasPrefix(strings.ToLower(value), "urn:isbn:") {
+			value = value[strings.LastIndex(value, ":")+1:]
+		}
+		if isbn := NormalizeISBN(value); isbn != "" {
+			meta.ISBN = isbn
+			break
+		}
+	}
+	for _, s := range m.Subjects {
+		if s = clean(s); s != "" {
+			meta.Subjects = appendUnique(meta.Subjects, s)
+		}
+	}
+	if d := first(m.Descriptions); d != "" {
+		meta.Description = clean(markup.ReplaceAllString(html.UnescapeString(d), " "))
+	}
+	return meta
+}
+
+// NormalizeISBN strips separators from an ISBN-10 or ISBN-13, it is empty when the check digit doesn't match
+func NormalizeISBN(value string) string {
+	isbn := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == 'X' || r == 'x' {
+			return r
+		}
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return '?'
+	}, value)
+	isbn = strings.ToUpper(isbn)
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			d := int(r - '0')
+			if r == 'X' && i == 9 {
+				d = 10
+			} else if r < '0' || r > '9' {
+				return ""
+			}
+			sum += d * (10 - i)
+		}
+		if sum%11 == 0 {
+			return isbn
+		}
+	case 13:
+		sum := 0
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return ""
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		if sum%10 == 0 {
+			return isbn
+		}
+	}
+	return ""
+}
+
+func first(values []string) string {
+	for _, v := range values {
+		if v = clean(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func clean(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,117 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iancoleman/strcase"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter narrows a document listing, empty fields don't filter
+type Filter struct {
+	Type               string
+	Format             string
+	Tags               []string
+	ExcludeTags        []string
+	CreatedAfter       *time.Time
+	CreatedBefore      *time.Time
+	UpdatedAfter       *time.Time
+	UpdatedBefore      *time.Time
+	NamePrefix         string
+	NameContains       string
+	MissingDescription bool
+}
+
+var documentTypes = []string{"book", "paper"}
+
+// ParseFilter reads a filter from the query string. Tags are matched by their kebab case name and can be repeated, a
+// leading dash excludes the tag (tag=go&tag=-draft). Dates are RFC3339 or YYYY-MM-DD, a date on its own in a before bound
+// includes the whole day.
+func ParseFilter(r *http.Request) (f Filter, err error) {
+	v := r.URL.Query()
+
+	if t := v.Get("type"); t != "" {
+		if !validType(t) {
+			return f, fmt.Errorf("%w: unknown type %q", ErrInvalidFilter, t)
+		}
+		f.Type = t
+	}
+
+	if format := v.Get("format"); format != "" {
+		if !validFormat(format) {
+			return f, fmt.Errorf("%w: unknown format %q", ErrInvalidFilter, format)
+		}
+		f.Format = format
+	}
+
+	for _, tag := range v["tag"] {
+		// tags are stored in kebab case, the filter matches them however they are written
+		tag = strings.TrimSpace(tag)
+		exclude := strings.HasPrefix(tag, "-")
+		name := strcase.ToKebab(strings.TrimPrefix(tag, "-"))
+		switch {
+		case name == "":
+			return f, fmt.Errorf("%w: empty tag", ErrInvalidFilter)
+		case exclude:
+			f.ExcludeTags = append(f.ExcludeTags, name)
+		default:
+			f.Tags = append(f.Tags, name)
+		}
+	}
+
+	if f.CreatedAfter, err = parseBound(v, "created_after", false); err != nil {
+		return f, err
+	}
+	if f.CreatedBefore, err = parseBound(v, "created_before", true); err != nil {
+		return f, err
+	}
+	if f.UpdatedAfter, err = parseBound(v, "updated_after", false); err != nil {
+		return f, err
+	}
+	if f.UpdatedBefore, err = parseBound(v, "updated_before", true); err != nil {
+		return f, err
+	}
+
+	f.NamePrefix = v.Get("name_prefix")
+	f.NameContains = v.Get("name")
+
+	if missing := v.Get("missing_description"); missing != "" {
+		if f.MissingDescription, err = strconv.ParseBool(missing); err != nil {
+			return f, fmt.Errorf("%w: missing_description must be true or false", ErrInvalidFilter)
+		}
+	}
+	return f, nil
+}
+
+func parseBound(v url.Values, key string, before bool) (*time.Time, error) {
+	s := v.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be RFC3339 or YYYY-MM-DD", ErrInvalidFilter, key)
+	}
+	if before {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func validType(t string) bool {
+	for _, dt := range documentTypes {
+		if dt == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,332 @@
+package documents
+
+import (
+	"alexandria/internal/mobi"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/matchers"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime/multipart"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// file formats a document can be stored in, independent of whether it is a book or a paper
+const (
+	FormatPDF      = "pdf"
+	FormatEPUB     = "epub"
+	FormatMOBI     = "mobi"
+	FormatAZW3     = "azw3"
+	FormatDJVU     = "djvu"
+	FormatCBZ      = "cbz"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+var Formats = []string{FormatPDF, FormatEPUB, FormatMOBI, FormatAZW3, FormatDJVU, FormatCBZ, FormatMarkdown, FormatHTML}
+
+var formatExtensions = map[string]string{
+	".pdf":      FormatPDF,
+	".epub":     FormatEPUB,
+	".mobi":     FormatMOBI,
+	".prc":      FormatMOBI,
+	".azw":      FormatAZW3,
+	".azw3":     FormatAZW3,
+	".djvu":     FormatDJVU,
+	".djv":      FormatDJVU,
+	".cbz":      FormatCBZ,
+	".md":       FormatMarkdown,
+	".markdown": FormatMarkdown,
+	".html":     FormatHTML,
+	".htm":      FormatHTML,
+}
+
+// Extension is the file extension stored files of a format get
+func Extension(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return ".md"
+	case "":
+		return ""
+	}
+	return "." + format
+}
+
+// FormatOf names the format of a file by its extension, it is empty for files that aren't documents
+func FormatOf(name string) string {
+	return formatExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+func validFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCover reports whether a cover can be made for the format, notes are text only
+func HasCover(format string) bool {
+	return format != FormatMarkdown && format != FormatHTML
+}
+
+var comicImages = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
+
+// detectFormat sniffs the format of an upload. Binary formats are recognised by their content, the text formats have
+// no magic number so they are taken from the name as long as the content is text.
+func detectFormat(file multipart.File, name string) (string, bool) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !(err == io.ErrUnexpectedEOF && n > 0) {
+		logrus.WithField("bytesRead", n).WithError(err).Error("couldn't read file header")
+		return "", false
+	}
+	head = head[:n]
+	defer file.Seek(0, io.SeekStart)
+
+	switch {
+	case n >= 68 && string(head[60:68]) == "BOOKMOBI":
+		if FormatOf(name) == FormatAZW3 {
+			return FormatAZW3, true
+		}
+		return FormatMOBI, true
+	case n >= 16 && string(head[:8]) == "AT&TFORM" && string(head[12:15]) == "DJV":
+		return FormatDJVU, true
+	}
+
+	kind, _ := filetype.Match(head)
+	switch kind {
+	case matchers.TypePdf:
+		return FormatPDF, true
+	case matchers.TypeEpub:
+		return FormatEPUB, true
+	case matchers.TypeZip:
+		if isComic(file) {
+			return FormatCBZ, true
+		}
+	}
+
+	if !isText(head) {
+		logrus.WithFields(logrus.Fields{"mime": kind.MIME.Value, "name": name}).Error("file type not supported")
+		return "", false
+	}
+	lower := strings.ToLower(strings.TrimSpace(string(head)))
+	switch format := FormatOf(name); {
+	case format == FormatMarkdown || format == FormatHTML:
+		return format, true
+	case strings.HasPrefix(lower, "<!doctype html") || strings.HasPrefix(lower, "<html"):
+		return FormatHTML, true
+	}
+	logrus.WithField("name", name).Error("file type not supported")
+	return "", false
+}
+
+// isComic accepts an archive of images, a ComicInfo.xml and the odd metadata file packers add are allowed too
+func isComic(file multipart.File) bool {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false
+	}
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return false
+	}
+	images := 0
+	for _, f := range archive.File {
+		base := path.Base(f.Name)
+		switch {
+		case f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, "."):
+		case comicImages[strings.ToLower(path.Ext(base))]:
+			images++
+		case strings.EqualFold(base, "ComicInfo.xml") || strings.EqualFold(base, "Thumbs.db"):
+		default:
+			return false
+		}
+	}
+	return images > 0
+}
+
+// isText checks the head is utf-8, a rune cut off at the end of the head doesn't count against it
+func isText(head []byte) bool {
+	if len(head) == 0 || bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	return utf8.Valid(head)
+}
+
+// metadataReader returns how metadata is read for a format, formats without metadata return nil
+func metadataReader(ctx context.Context, format string) func(io.ReaderAt, int64) (*fileInfo, error) {
+	switch format {
+	case FormatEPUB:
+		return readEPUBMetadata
+	case FormatPDF:
+		return func(r io.ReaderAt, size int64) (*fileInfo, error) {
+			return readPDFMetadata(ctx, r, size)
+		}
+	case FormatMOBI, FormatAZW3:
+		return readMOBIMetadata
+	case FormatCBZ:
+		return readComicMetadata
+	case FormatMarkdown:
+		return readMarkdownMetadata
+	case FormatHTML:
+		return readHTMLMetadata
+	}
+	return nil
+}
+
+// readMOBIMetadata reads the EXTH records, the full name in the header is used when there is no title record
+func readMOBIMetadata(r io.ReaderAt, size int64) (*fileInfo, error) {
+	book, err := mobi.Open(r, size)
+	if err != nil {
+		return nil, err
+	}
+	meta := &fileInfo{Metadata: Metadata{
+		Title:     clean(book.Title()),
+		Authors:   []string{},
+		Publisher: clean(book.Value(mobi.Publisher)),
+		Language:  clean(book.Value(mobi.Language)),
+		ISBN:      NormalizeISBN(book.Value(mobi.ISBN)),
+		Subjects:  []string{},
+		Published: parseDate(book.Value(mobi.Published)),
+	}}
+	for _, author := range book.Values(mobi.Author) {
+		for _, name := range splitAuthors(author) {
+			meta.Authors = appendUnique(meta.Authors, name)
+		}
+	}
+	for _, subject := range book.Values(mobi.Subject) {
+		if subject = clean(subject); subject != "" {
+			meta.Subjects = appendUnique(meta.Subjects, subject)
+		}
+	}
+	if d := book.Value(mobi.Description); d != "" {
+		meta.Description = clean(markup.ReplaceAllString(d, " "))
+	}
+	return meta, nil
+}
+
+// comicInfo is the ComicInfo.xml written by comic taggers, see https://github.com/anansi-project/comicinfo
+type comicInfo struct {
+	Title       string `xml:"Title"`
+	Series      string `xml:"Series"`
+	Number      string `xml:"Number"`
+	Summary     string `xml:"Summary"`
+	Year        int    `xml:"Year"`
+	Month       int    `xml:"Month"`
+	Writer      string `xml:"Writer"`
+	Publisher   string `xml:"Publisher"`
+	Genre       string `xml:"Genre"`
+	LanguageISO string `xml:"LanguageISO"`
+}
+
+func readComicMetadata(r io.ReaderAt, size int64) (*fileInfo, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var info comicInfo
+	for _, f := range archive.File {
+		if !strings.EqualFold(path.Base(f.Name), "ComicInfo.xml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = xml.NewDecoder(io.LimitReader(rc, maxPackageSize)).Decode(&info)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	title := info.Title
+	if info.Series != "" {
+		title = info.Series
+		if info.Number != "" {
+			title += " #" + info.Number
+		}
+		if info.Title != "" {
+			title += ": " + info.Title
+		}
+	}
+	meta := &fileInfo{Metadata: Metadata{
+		Title:     clean(title),
+		Authors:   splitKeywords(info.Writer),
+		Publisher: clean(info.Publisher),
+		Language:  clean(info.LanguageISO),
+		Subjects:  splitKeywords(info.Genre),
+	}, Description: clean(info.Summary)}
+	if info.Year > 0 {
+		date := strconv.Itoa(info.Year)
+		if info.Month >= 1 && info.Month <= 12 {
+			date = fmt.Sprintf("%d-%02d", info.Year, info.Month)
+		}
+		meta.Published = parseDate(date)
+	}
+	return meta, nil
+}
+
+// readMarkdownMetadata takes the title from the first heading, or the title key of a front matter block
+func readMarkdownMetadata(r io.ReaderAt, size int64) (*fileInfo, error) {
+	meta := &fileInfo{Metadata: Metadata{Authors: []string{}, Subjects: []string{}}}
+	scanner := bufio.NewScanner(io.NewSectionReader(r, 0, size))
+	frontMatter := false
+	for line := 0; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == 0 && text == "---":
+			frontMatter = true
+		case frontMatter && text == "---":
+			frontMatter = false
+		case frontMatter && strings.HasPrefix(text, "title:"):
+			meta.Title = clean(strings.Trim(strings.TrimPrefix(text, "title:"), ` "'`))
+		case !frontMatter && strings.HasPrefix(text, "# "):
+			if meta.Title == "" {
+				meta.Title = clean(strings.TrimPrefix(text, "# "))
+			}
+			return meta, nil
+		}
+	}
+	return meta, scanner.Err()
+}
+
+// readHTMLMetadata reads the title and the author, description and keywords meta tags
+func readHTMLMetadata(r io.ReaderAt, size int64) (*fileInfo, error) {
+	doc, err := goquery.NewDocumentFromReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	metaContent := func(name string) string {
+		content, _ := doc.Find(`meta[name="` + name + `"]`).Attr("content")
+		return content
+	}
+	lang, _ := doc.Find("html").Attr("lang")
+
+	meta := &fileInfo{Metadata: Metadata{
+		Title:    clean(doc.Find("head title").First().Text()),
+		Authors:  splitAuthors(metaContent("author")),
+		Language: clean(lang),
+		Subjects: splitKeywords(metaContent("keywords")),
+	}, Description: clean(metaContent("description"))}
+	if meta.Title == "" {
+		meta.Title = clean(doc.Find("h1").First().Text())
+	}
+	return meta, nil
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"strings"
 )
 
 func MakeDocumentHandler(mr *mux.Router, service DocumentService) http.Handler {
@@ -16,10 +19,14 @@ func MakeDocumentHandler(mr *mux.Router, service DocumentService) http.Handler {
 		service: service,
 	}
 
+	r.HandleFunc("/duplicates", h.FindDuplicates).Methods("GET")
 	r.HandleFunc("/{id}", h.FindByID).Methods("GET")
+	r.HandleFunc("/{id}/content", h.Content).Methods("GET", "HEAD")
 	r.HandleFunc("/{id}", h.UpdateFields).Methods("PATCH")
 	r.HandleFunc("/{id}", h.Delete).Methods("DELETE")
-	r.HandleFunc("/scan", h.Scan).Methods("PUT")
+	r.HandleFunc("/{id}/merge", h.Merge).Methods("POST")
+	// the bucket is shared so only admins can pull untracked files into their library
+	r.Handle("/scan", common.RequireAdmin(http.HandlerFunc(h.Scan))).Methods("PUT")
 	r.HandleFunc("/", h.FindAll).Methods("GET")
 
 	return r
@@ -42,6 +49,10 @@ func (h *documentHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 
 	entity, err := h.service.FindByID(ctx, id)
 
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "findbyid")
+		return
+	}
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "findbyid")
 		return
@@ -50,6 +61,142 @@ func (h *documentHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 	common.EncodeResponse(r.Context(), w, entity)
 }
 
+// Content streams the document through the server, http.ServeContent takes care of range and conditional requests.
+// Passing download=true will ask the browser to save the file rather than display it, format=epub|pdf|txt converts
+// it first.
+func (h *documentHandler) Content(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+
+	if !ok {
+		common.MakeError(w, http.StatusBadRequest, "document", "Missing Id", "content")
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format != "" && !ValidConversion(format) {
+		common.MakeError(w, http.StatusBadRequest, "document", "unknown format", "content")
+		return
+	}
+
+	var entity *Document
+	var content *common.Content
+	var err error
+	if format == "" {
+		entity, content, err = h.service.Content(ctx, id)
+	} else {
+		entity, content, err = h.service.Convert(ctx, id, format)
+	}
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "content")
+		return
+	}
+	if err == ErrNoFile {
+		common.MakeError(w, http.StatusNotFound, "document", err.Error(), "content")
+		return
+	}
+	if err == ErrConversionUnsupported {
+		common.MakeError(w, http.StatusUnprocessableEntity, "document", err.Error(), "content")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "content")
+		return
+	}
+	defer content.Close()
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "true" {
+		disposition = "attachment"
+	}
+	fileName := downloadName(entity)
+	if format != "" && format != entity.Format {
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + Extension(format)
+		if contentType := ContentType(format); contentType != "" {
+			content.ContentType = contentType
+		}
+	}
+	// html and markdown are shown in a sandbox so a script in a document can't act as the reader
+	served := entity.Format
+	if format != "" {
+		served = format
+	}
+	if served == FormatHTML || served == FormatMarkdown {
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	w.Header().Set("ETag", content.ETag)
+	w.Header().Set("Cache-Control", "private")
+	if content.ContentType != "" {
+		w.Header().Set("Content-Type", content.ContentType)
+	}
+
+	http.ServeContent(w, r, fileName, content.ModTime, content)
+}
+
+func (h *documentHandler) FindDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	groups, err := h.service.FindDuplicates(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "findDuplicates")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, groups)
+}
+
+type mergeRequest struct {
+	Duplicates []string `json:"duplicates"`
+}
+
+// Merge keeps the document in the path and folds the duplicates listed in the body into it
+func (h *documentHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := mergeRequest{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal merge request")
+		common.MakeError(w, http.StatusBadRequest, "document", "Bad Request", "merge")
+		return
+	}
+
+	entity, err := h.service.Merge(ctx, mux.Vars(r)["id"], req.Duplicates)
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "merge")
+		return
+	}
+	if err == ErrNothingToMerge {
+		common.MakeError(w, http.StatusBadRequest, "document", err.Error(), "merge")
+		return
+	}
+	if err == ErrNotOwner {
+		common.MakeError(w, http.StatusForbidden, "document", err.Error(), "merge")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "merge")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, entity)
+}
+
+// WriteDuplicate answers an upload of a file that is already in the library with the id of the document holding it
+func WriteDuplicate(w http.ResponseWriter, r *http.Request, err *DuplicateError) {
+	logrus.WithField("id", err.ID).Info("duplicate upload")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/documents/"+err.ID)
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error(), "id": err.ID})
+}
+
 func (h *documentHandler) UpdateFields(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -74,6 +221,14 @@ func (h *documentHandler) UpdateFields(w http.ResponseWriter, r *http.Request) {
 
 	entity, err := h.service.UpdateFields(ctx, id, req)
 
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "updateFields")
+		return
+	}
+	if err == ErrInvalidISBN || err == ErrInvalidDOI || err == ErrInvalidArXivID {
+		common.MakeError(w, http.StatusBadRequest, "document", err.Error(), "updateFields")
+		return
+	}
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "updateFields")
 		return
@@ -94,6 +249,10 @@ func (h *documentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.Delete(ctx, id); err != nil {
+		if err == ErrNotFound {
+			common.MakeError(w, http.StatusNotFound, "document", "Not Found", "delete")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "delete")
 		return
 	}
@@ -104,13 +263,26 @@ func (h *documentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 func (h *documentHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entity, err := h.service.FindAll(ctx, nil)
+	page, err := common.ParsePageRequest(r, SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "document", err.Error(), "findall")
+		return
+	}
+
+	filter, err := ParseFilter(r)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "document", err.Error(), "findall")
+		return
+	}
+
+	entity, next, err := h.service.FindPage(ctx, filter, page)
 
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(r.Context(), w, entity)
 }
 
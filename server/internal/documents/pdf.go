@@ -0,0 +1,301 @@
+package documents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const pdfTimeout = 30 * time.Second
+
+var (
+	ErrInvalidDOI = errors.New("invalid doi")
+	doiPattern    = regexp.MustCompile(`\b10\.\d{4,9}/[^\s"'<>]+`)
+	arxivPattern  = regexp.MustCompile(`(?i)\barxiv[:.\s]\s*(\d{4}\.\d{4,5}|[a-z-]+(?:\.[a-z]{2})?/\d{7})(?:v\d+)?`)
+	isbnPattern   = regexp.MustCompile(`ISBN(?:-1[03])?:?\s*([0-9][0-9\- ]{8,15}[0-9Xx])`)
+	dateLayouts   = []string{time.RFC3339, "2006-01-02T15:04:05Z07", "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006"}
+)
+
+// readPDFMetadata reads the Info dictionary and XMP packet with pdfinfo and looks through the text of the first
+// pages for identifiers, both tools come with poppler like pdftotext used for indexing. XMP is preferred as the
+// Info dictionary often only names the program that wrote the file.
+func readPDFMetadata(ctx context.Context, r io.ReaderAt, size int64) (*fileInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, pdfTimeout)
+	defer cancel()
+
+	file, err := ioutil.TempFile("", "metadata-*.pdf")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temp file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := io.Copy(file, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, errors.Wrap(err, "unable to write temp file")
+	}
+
+	out, err := exec.CommandContext(ctx, "pdfinfo", "-enc", "UTF-8", "-isodates", file.Name()).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to run pdfinfo")
+	}
+	info := parsePDFInfo(out)
+
+	if out, err := exec.CommandContext(ctx, "pdfinfo", "-meta", file.Name()).Output(); err != nil {
+		logrus.WithError(err).Warn("unable to read xmp metadata")
+	} else {
+		info = parseXMP(out).merge(info)
+	}
+
+	text, err := exec.CommandContext(ctx, "pdftotext", "-f", "1", "-l", "2", "-enc", "UTF-8", file.Name(), "-").Output()
+	if err != nil {
+		logrus.WithError(err).Warn("unable to read first pages")
+	}
+	return info.fileInfo(string(text)), nil
+}
+
+// pdfMetadata holds the raw values found in either the Info dictionary or XMP
+type pdfMetadata struct {
+	Title    string
+	Authors  []string
+	Subject  string
+	Keywords []string
+	Date     *time.Time
+	DOI      string
+}
+
+// parsePDFInfo reads the "Key: value" lines written by pdfinfo
+func parsePDFInfo(out []byte) pdfMetadata {
+	var m pdfMetadata
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := clean(parts[1])
+		switch parts[0] {
+		case "Title":
+			if !looksLikeFileName(value) {
+				m.Title = value
+			}
+		case "Author":
+			m.Authors = splitAuthors(value)
+		case "Subject":
+			m.Subject = value
+		case "Keywords":
+			m.Keywords = splitKeywords(value)
+		case "CreationDate":
+			m.Date = parseDate(value)
+		}
+	}
+	return m
+}
+
+// parseXMP walks the packet by local name, properties can be elements holding rdf lists or attributes of
+// rdf:Description
+func parseXMP(out []byte) pdfMetadata {
+	values := map[string][]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(out))
+	decoder.Strict = false
+	current := ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "title", "creator", "subject", "Keywords", "date", "publicationDate", "coverDate", "CreateDate", "doi", "identifier":
+				current = t.Name.Local
+			case "Description":
+				for _, attr := range t.Attr {
+					values[attr.Name.Local] = append(values[attr.Name.Local], attr.Value)
+				}
+			}
+		case xml.CharData:
+			if value := clean(string(t)); current != "" && value != "" {
+				values[current] = append(values[current], value)
+			}
+		case xml.EndElement:
+			if t.Name.Local == current {
+				current = ""
+			}
+		}
+	}
+
+	var m pdfMetadata
+	if title := first(values["title"]); !looksLikeFileName(title) {
+		m.Title = title
+	}
+	for _, creator := range values["creator"] {
+		m.Authors = appendUnique(m.Authors, creator)
+	}
+	m.Keywords = values["subject"]
+	if len(m.Keywords) == 0 {
+		m.Keywords = splitKeywords(first(values["Keywords"]))
+	}
+	for _, key := range []string{"publicationDate", "coverDate", "date", "CreateDate"} {
+		if m.Date = parseDate(first(values[key])); m.Date != nil {
+			break
+		}
+	}
+	for _, id := range append(values["doi"], values["identifier"]...) {
+		if m.DOI = NormalizeDOI(id); m.DOI != "" {
+			break
+		}
+	}
+	return m
+}
+
+// merge fills in whatever is missing from the other source
+func (m pdfMetadata) merge(other pdfMetadata) pdfMetadata {
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if len(m.Authors) == 0 {
+		m.Authors = other.Authors
+	}
+	if m.Subject == "" {
+		m.Subject = other.Subject
+	}
+	if len(m.Keywords) == 0 {
+		m.Keywords = other.Keywords
+	}
+	if m.Date == nil {
+		m.Date = other.Date
+	}
+	if m.DOI == "" {
+		m.DOI = other.DOI
+	}
+	return m
+}
+
+// fileInfo looks for identifiers in the metadata before the text of the first pages, which also cites other work
+func (m pdfMetadata) fileInfo(text string) *fileInfo {
+	info := &fileInfo{Metadata: Metadata{
+		Title:     m.Title,
+		Authors:   []string{},
+		Subjects:  []string{},
+		Published: m.Date,
+		DOI:       m.DOI,
+	}}
+	for _, author := range m.Authors {
+		info.Authors = appendUnique(info.Authors, author)
+	}
+	for _, keyword := range m.Keywords {
+		info.Subjects = appendUnique(info.Subjects, keyword)
+	}
+
+	sources := []string{m.Subject, strings.Join(m.Keywords, " "), text}
+	for _, source := range sources {
+		if info.DOI == "" {
+			info.DOI = NormalizeDOI(doiPattern.FindString(source))
+		}
+		if info.ArXivID == "" {
+			info.ArXivID = FindArXivID(source)
+		}
+		if info.ISBN == "" {
+			if match := isbnPattern.FindStringSubmatch(source); match != nil {
+				info.ISBN = NormalizeISBN(match[1])
+			}
+		}
+	}
+	// papers on arXiv are registered under its own DOI prefix
+	if info.ArXivID == "" && strings.HasPrefix(strings.ToLower(info.DOI), "10.48550/arxiv.") {
+		info.ArXivID = info.DOI[len("10.48550/arxiv."):]
+	}
+	return info
+}
+
+// NormalizeDOI strips resolver prefixes and trailing punctuation from a DOI, it is empty when there is none
+func NormalizeDOI(value string) string {
+	value = strings.TrimSpace(value)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		if strings.HasPrefix(strings.ToLower(value), prefix) {
+			value = strings.TrimSpace(value[len(prefix):])
+		}
+	}
+	doi := doiPattern.FindString(value)
+	if doi == "" || !strings.HasPrefix(value, doi) {
+		return ""
+	}
+	// a closing bracket is part of the DOI when it closes one opened inside it, as in 10.1016/0370-2693(92)90123-x
+	for doi != "" {
+		last := doi[len(doi)-1:]
+		if i := strings.Index(")]}", last); i >= 0 {
+			if strings.Count(doi, "([{"[i:i+1]) >= strings.Count(doi, last) {
+				return doi
+			}
+		} else if !strings.Contains(".,;:", last) {
+			return doi
+		}
+		doi = doi[:len(doi)-1]
+	}
+	return ""
+}
+
+// FindArXivID returns the first arXiv identifier in the text, without its version
+func FindArXivID(text string) string {
+	match := arxivPattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func parseDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return nil
+}
+
+// splitAuthors separates the author field, commas are left alone as they often split last and first names
+func splitAuthors(value string) []string {
+	authors := []string{}
+	for _, part := range strings.Split(value, ";") {
+		for _, name := range strings.Split(part, " and ") {
+			if name = clean(strings.Trim(name, ", ")); name != "" {
+				authors = appendUnique(authors, name)
+			}
+		}
+	}
+	return authors
+}
+
+func splitKeywords(value string) []string {
+	keywords := []string{}
+	for _, keyword := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		if keyword = clean(keyword); keyword != "" {
+			keywords = appendUnique(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// looksLikeFileName catches titles written by the program that made the pdf, like "Microsoft Word - draft.docx"
+func looksLikeFileName(title string) bool {
+	switch strings.ToLower(filepath.Ext(title)) {
+	case ".doc", ".docx", ".dvi", ".pdf", ".tex", ".odt", ".rtf", ".ps", ".indd":
+		return true
+	}
+	return strings.EqualFold(title, "untitled")
+}
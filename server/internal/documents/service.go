@@ -2,17 +2,20 @@ package documents
 
 import (
 	"alexandria/internal/common"
+	"alexandria/internal/search"
+	"alexandria/internal/tags"
 	"context"
 	"crypto/tls"
 	"github.com/go-resty/resty/v2"
 	"github.com/google/uuid"
-	"github.com/h2non/filetype"
-	"github.com/h2non/filetype/matchers"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"gocloud.dev/gcerrors"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,6 +23,11 @@ import (
 
 var (
 	ErrInvalidFileType = errors.New("invalid file type")
+	ErrNotFound        = errors.New("document not found")
+	ErrInvalidISBN     = errors.New("invalid isbn")
+	ErrInvalidArXivID  = errors.New("invalid arxiv id")
+	ErrNoFile          = errors.New("document has no file")
+	subjectTags        = os.Getenv("METADATA_SUBJECT_TAGS") == "true"
 )
 
 type Document struct {
@@ -28,43 +36,93 @@ type Document struct {
 	Name        string     `json:"name"`
 	Path        string     `json:"path"`
 	Type        string     `json:"type"`
+	Format      string     `json:"format"`
 	Description string     `json:"description"`
 	Tags        []string   `json:"tag_ids"`
 	Created     time.Time  `json:"created"`
 	Updated     *time.Time `json:"updated"`
+	Owner       string     `json:"owner_id"`
+	SHA256      string     `json:"sha256,omitempty"`
+	Metadata
 }
 
+// Metadata describes the work itself, it is read from the file when it is uploaded and can be corrected afterwards
+type Metadata struct {
+	Title     string     `json:"title"`
+	Authors   []string   `json:"authors"`
+	Publisher string     `json:"publisher"`
+	Language  string     `json:"language"`
+	ISBN      string     `json:"isbn"`
+	Subjects  []string   `json:"subjects"`
+	Published *time.Time `json:"published"`
+	DOI       string     `json:"doi"`
+	ArXivID   string     `json:"arxiv_id"`
+	// citation fields, mostly filled in by importing a bibliography
+	CitationKey string `json:"citation_key"`
+	EntryType   string `json:"entry_type"`
+	Journal     string `json:"journal"`
+	Volume      string `json:"volume"`
+	Issue       string `json:"issue"`
+	Pages       string `json:"pages"`
+	URL         string `json:"url"`
+	// CoverURL points at a cover found by enrichment, generated covers are kept in the site bucket instead
+	CoverURL string `json:"cover_url"`
+}
+
+// SortOptions are the orderings accepted when listing documents, books and papers
+var SortOptions = common.SortOptions{Keys: []string{"name", "created", "updated"}, Order: common.OrderAsc}
+
 type DocumentService interface {
-	FindAll(ctx context.Context, filter map[string]interface{}) ([]*Document, error)
+	FindAll(ctx context.Context, filter Filter) ([]*Document, error)
+	FindPage(ctx context.Context, filter Filter, page common.PageRequest) ([]*Document, string, error)
 	FindByID(ctx context.Context, id string) (*Document, error)
+	Content(ctx context.Context, id string) (*Document, *common.Content, error)
+	// Convert returns the document in another format, converting it the first time it is asked for
+	Convert(ctx context.Context, id, format string) (*Document, *common.Content, error)
 	Add(ctx context.Context, file multipart.File, document *Document) error
+	// AddRecord stores a document that only has metadata, like a citation imported without its pdf
+	AddRecord(ctx context.Context, document *Document) error
 	Delete(ctx context.Context, id string) error
 	Scan(ctx context.Context) error
 	UpdateFields(ctx context.Context, id string, docs Document) (Document, error)
+	FindDuplicates(ctx context.Context) ([]DuplicateGroup, error)
+	Merge(ctx context.Context, id string, duplicateIDs []string) (*Document, error)
 }
 
 type DocumentRepository interface {
-	FindAll(ctx context.Context, filter map[string]interface{}) ([]*Document, error)
+	FindAll(ctx context.Context, filter Filter) ([]*Document, error)
+	FindPage(ctx context.Context, filter Filter, page common.PageRequest) ([]*Document, string, error)
 	FindByID(ctx context.Context, id string) (*Document, error)
 	Insert(ctx context.Context, document *Document) error
 	Delete(ctx context.Context, id string) error
 	UpdateDocument(ctx context.Context, document Document) (Document, error)
+	// UpsertStream adds the documents with a new path to the library of their owner, owners that don't exist are skipped
 	UpsertStream(ctx context.Context, input <-chan *Document) error
+	FindByHash(ctx context.Context, hash string) (*Document, error)
+	SetHash(ctx context.Context, id, hash string) error
+	// Merge saves the document and deletes the duplicates, their tags are moved to the document
+	Merge(ctx context.Context, document Document, duplicates []*Document) (Document, error)
 }
 
 type documentService struct {
-	storage common.DocumentStorage
-	repo    DocumentRepository
+	storage    common.DocumentStorage
+	repo       DocumentRepository
+	indexer    search.Indexer
+	tagsRepo   tags.Repository
+	converters []Converter
 }
 
-func NewDocumentService(storage common.DocumentStorage, repo DocumentRepository) DocumentService {
+func NewDocumentService(storage common.DocumentStorage, repo DocumentRepository, indexer search.Indexer, tagsRepo tags.Repository) DocumentService {
 	return &documentService{
-		storage: storage,
-		repo:    repo,
+		storage:    storage,
+		repo:       repo,
+		indexer:    indexer,
+		tagsRepo:   tagsRepo,
+		converters: NewConverters(),
 	}
 }
 
-func (s *documentService) FindAll(ctx context.Context, filter map[string]interface{}) ([]*Document, error) {
+func (s *documentService) FindAll(ctx context.Context, filter Filter) ([]*Document, error) {
 	entities, err := s.repo.FindAll(ctx, filter)
 	if err != nil {
 		logrus.WithError(err).Error("unable to fetch documents from repository")
@@ -73,12 +131,27 @@ func (s *documentService) FindAll(ctx context.Context, filter map[string]interfa
 	return entities, nil
 }
 
+func (s *documentService) FindPage(ctx context.Context, filter Filter, page common.PageRequest) ([]*Document, string, error) {
+	entities, next, err := s.repo.FindPage(ctx, filter, page)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch documents from repository")
+		return nil, "", errors.Wrap(err, "unable to fetch from repository")
+	}
+	return entities, next, nil
+}
+
 func (s *documentService) FindByID(ctx context.Context, id string) (*Document, error) {
 	entity, err := s.repo.FindByID(ctx, id)
+	if err == ErrNotFound {
+		return nil, err
+	}
 	if err != nil {
 		logrus.WithError(err).WithField("id", id).Error("unable to fetch doc from repository")
 		return nil, errors.Wrap(err, "unable to fetch from repository")
 	}
+	if entity.Path == "" {
+		return entity, nil
+	}
 
 	filePath, err := s.storage.Get(ctx, entity.Path)
 	if err != nil {
@@ -89,17 +162,154 @@ func (s *documentService) FindByID(ctx context.Context, id string) (*Document, e
 	return entity, nil
 }
 
+func (s *documentService) Content(ctx context.Context, id string) (*Document, *common.Content, error) {
+	entity, err := s.findWithFile(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Stream(ctx, entity.Path)
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to open document from storage")
+		return nil, nil, errors.Wrap(err, "unable to open document from storage")
+	}
+	return entity, content, nil
+}
+
+func (s *documentService) findWithFile(ctx context.Context, id string) (*Document, error) {
+	entity, err := s.repo.FindByID(ctx, id)
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to fetch doc from repository")
+		return nil, errors.Wrap(err, "unable to fetch from repository")
+	}
+	if entity == nil || entity.ID == "" {
+		return nil, ErrNotFound
+	}
+	if entity.Path == "" {
+		return nil, ErrNoFile
+	}
+	if entity.Format == "" {
+		entity.Format = FormatOf(entity.Path)
+	}
+	return entity, nil
+}
+
+func (s *documentService) Convert(ctx context.Context, id, format string) (*Document, *common.Content, error) {
+	entity, err := s.findWithFile(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == entity.Format {
+		return s.Content(ctx, id)
+	}
+
+	path := convertedPath(entity.Path, format)
+	if content, err := s.storage.Stream(ctx, path); err == nil {
+		return entity, content, nil
+	}
+
+	var converter Converter
+	for _, c := range s.converters {
+		if c.Converts(entity.Format, format) {
+			converter = c
+			break
+		}
+	}
+	if converter == nil {
+		return nil, nil, ErrConversionUnsupported
+	}
+
+	if err := s.convert(ctx, converter, entity, format, path); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"id": id, "format": format}).Error("unable to convert document")
+		return nil, nil, errors.Wrap(err, "unable to convert document")
+	}
+	content, err := s.storage.Stream(ctx, path)
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to open converted document from storage")
+		return nil, nil, errors.Wrap(err, "unable to open converted document from storage")
+	}
+	return entity, content, nil
+}
+
+// convert runs the converter on a local copy of the document and stores the result at path
+func (s *documentService) convert(ctx context.Context, converter Converter, doc *Document, format, path string) error {
+	dir, err := ioutil.TempDir("", "convert-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	original, err := s.storage.Reader(ctx, doc.Path)
+	if err != nil {
+		return err
+	}
+	in := filepath.Join(dir, "original"+Extension(doc.Format))
+	f, err := os.Create(in)
+	if err == nil {
+		_, err = io.Copy(f, original)
+		f.Close()
+	}
+	original.Close()
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(dir, "converted"+Extension(format))
+	if err := converter.Convert(ctx, doc, format, in, out); err != nil {
+		return err
+	}
+	converted, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer converted.Close()
+	_, err = s.storage.Save(ctx, path, converted)
+	return err
+}
+
 func (s *documentService) Add(ctx context.Context, file multipart.File, doc *Document) error {
-	if !isSupported(file) {
+	format, ok := detectFormat(file, doc.Name)
+	if !ok {
 		return ErrInvalidFileType
 	}
-	path, err := s.storage.Save(ctx, doc.Name, file)
+	hash, err := hashFile(file)
 	if err != nil {
-		logrus.WithError(err).Error("unable to write to storage")
-		return errors.Wrap(err, "failed to write to storage")
+		logrus.WithError(err).Error("unable to hash file")
+		return errors.Wrap(err, "failed to read file")
+	}
+	existing, err := s.repo.FindByHash(ctx, hash)
+	if err != nil {
+		logrus.WithError(err).Error("unable to look up file hash")
+		return errors.Wrap(err, "unable to fetch from repository")
+	}
+	if existing != nil {
+		return &DuplicateError{ID: existing.ID}
+	}
+	doc.SHA256 = hash
+	doc.Format = format
+	if read := metadataReader(ctx, format); read != nil {
+		readMetadata(file, doc, read)
+	}
+	if doc.DisplayName == "" {
+		doc.DisplayName = strings.TrimSuffix(doc.Name, filepath.Ext(doc.Name))
+	}
+	owner := doc.Owner
+	if identity, ok := common.IdentityFromContext(ctx); ok {
+		owner = identity.ID
+	}
+	if owner == "" {
+		return common.ErrNoIdentity
 	}
 
 	doc.ID = uuid.New().String()
+	path, err := s.storage.Save(ctx, storagePath(owner, doc.ID, format), file)
+	if err != nil {
+		logrus.WithError(err).Error("unable to write to storage")
+		return errors.Wrap(err, "failed to write to storage")
+	}
 	doc.Path = path
 	t := time.Now()
 	doc.Created = t
@@ -109,12 +319,104 @@ func (s *documentService) Add(ctx context.Context, file multipart.File, doc *Doc
 		logrus.WithError(err).Error("unable to save to repo")
 		return errors.Wrap(err, "failed to store data in repo")
 	}
+	if subjectTags {
+		s.tagSubjects(ctx, doc)
+	}
 
-	go s.CreateCover(doc.ID, doc.Path)
+	if HasCover(doc.Format) {
+		go s.CreateCover(doc.ID, doc.Path, doc.Format)
+	}
+	go s.indexer.Index(context.Background(), doc.ID, doc.Path)
 	return nil
 }
 
-func (s *documentService) CreateCover(id, path string) {
+// storagePath keys a file by its owner and document, the name sent by the client never picks the object. Text
+// extraction goes by the extension of the stored file.
+func storagePath(owner, id, format string) string {
+	return owner + "/" + id + Extension(format)
+}
+
+// removeFiles deletes a stored file together with the converted copies kept alongside it
+func (s *documentService) removeFiles(ctx context.Context, path string) {
+	if path == "" {
+		return
+	}
+	paths := []string{path, convertedPath(path, FormatText)}
+	for _, format := range Formats {
+		paths = append(paths, convertedPath(path, format))
+	}
+	for _, p := range paths {
+		if err := s.storage.Delete(ctx, p); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			logrus.WithError(err).WithField("path", p).Error("unable to delete file")
+		}
+	}
+}
+
+// downloadName is the file name a document is served as, the name it was uploaded with in its current format
+func downloadName(doc *Document) string {
+	name := filepath.Base(doc.Name)
+	if name == "." || name == "/" {
+		name = doc.ID
+	}
+	if FormatOf(name) != doc.Format {
+		name += Extension(doc.Format)
+	}
+	return name
+}
+
+func (s *documentService) AddRecord(ctx context.Context, doc *Document) error {
+	doc.ID = uuid.New().String()
+	t := time.Now()
+	doc.Created = t
+	doc.Updated = &t
+
+	if err := s.repo.Insert(ctx, doc); err != nil {
+		logrus.WithError(err).Error("unable to save to repo")
+		return errors.Wrap(err, "failed to store data in repo")
+	}
+	if subjectTags {
+		s.tagSubjects(ctx, doc)
+	}
+	return nil
+}
+
+// readMetadata fills in the document from the file, a file that can't be read is still stored as it was uploaded
+func readMetadata(file multipart.File, doc *Document, read func(io.ReaderAt, int64) (*fileInfo, error)) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to determine file size")
+		return
+	}
+	defer file.Seek(0, io.SeekStart)
+
+	info, err := read(file, size)
+	if err != nil {
+		logrus.WithError(err).WithField("name", doc.Name).Warn("unable to read metadata")
+		return
+	}
+	doc.Metadata = info.Metadata
+	if doc.Description == "" {
+		doc.Description = info.Description
+	}
+	if doc.DisplayName == "" {
+		doc.DisplayName = info.Title
+	}
+}
+
+// tagSubjects adds the subjects of a new document as tags, failing to tag doesn't fail the upload
+func (s *documentService) tagSubjects(ctx context.Context, doc *Document) {
+	resourceType := tags.BookResource
+	if doc.Type == "paper" {
+		resourceType = tags.PaperResource
+	}
+	for _, subject := range doc.Subjects {
+		if err := s.tagsRepo.AddResourceTag(ctx, doc.ID, resourceType, subject); err != nil {
+			logrus.WithError(err).WithField("subject", subject).Warn("unable to tag subject")
+		}
+	}
+}
+
+func (s *documentService) CreateCover(id, path, format string) {
 	url := common.GetEnv("COVER_ENDPOINT", "")
 	if url == "" {
 		logrus.Panic("cover endpoint not set")
@@ -130,7 +432,7 @@ func (s *documentService) CreateCover(id, path string) {
 	logrus.Infof("calling %s", url)
 	resp, err := client.
 		R().
-		SetBody(coverRequest{ID: id, Path: path}).
+		SetBody(coverRequest{ID: id, Path: path, Format: format}).
 		Post(url)
 
 	if err != nil {
@@ -151,14 +453,29 @@ func (s *documentService) CreateCover(id, path string) {
 }
 
 type coverRequest struct {
-	ID   string `json:"id"`
-	Path string `json:"path"`
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	Format string `json:"format"`
 }
 
 func (s *documentService) Delete(ctx context.Context, id string) error {
-	return s.repo.Delete(ctx, id)
+	entity, err := s.repo.FindByID(ctx, id)
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to fetch doc from repository")
+		return errors.New("unable to fetch from repository")
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.removeFiles(ctx, entity.Path)
+	return nil
 }
 
+// Scan adds the files in the bucket that aren't documents yet. Files are stored as owner/id.ext, the owner is taken
+// from the path and files outside a library are left alone.
 func (s *documentService) Scan(ctx context.Context) error {
 	fileNameStream := s.storage.List(ctx)
 	docStream := make(chan *Document)
@@ -166,7 +483,13 @@ func (s *documentService) Scan(ctx context.Context) error {
 		defer close(docStream)
 		for path := range fileNameStream {
 			ext := filepath.Ext(path)
-			if ext != ".pdf" {
+			format := FormatOf(path)
+			if format == "" {
+				continue
+			}
+			owner := strings.SplitN(path, "/", 2)[0]
+			if _, err := uuid.Parse(owner); err != nil || owner == path {
+				logrus.WithField("path", path).Warn("skipping file outside a library")
 				continue
 			}
 			name := strings.ReplaceAll(path, ext, "")
@@ -180,16 +503,32 @@ func (s *documentService) Scan(ctx context.Context) error {
 				Name:        name,
 				Path:        path,
 				Type:        "book",
+				Format:      format,
+				Owner:       owner,
 				Created:     time.Now(),
 			}
 			docStream <- doc
 		}
 	}()
-	return s.repo.UpsertStream(ctx, docStream)
+	if err := s.repo.UpsertStream(ctx, docStream); err != nil {
+		// the listing goroutine blocks on the stream until it is read to the end
+		go func() {
+			for range docStream {
+			}
+		}()
+		return err
+	}
+
+	go s.indexer.IndexMissing(context.Background())
+	go s.hashMissing(common.WithSystem(context.Background()))
+	return nil
 }
 
 func (s *documentService) UpdateFields(ctx context.Context, id string, updatedDoc Document) (doc Document, err error) {
 	entity, err := s.repo.FindByID(ctx, id)
+	if err == ErrNotFound {
+		return doc, err
+	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to find entity")
 		return doc, errors.New("unable to find entity")
@@ -205,6 +544,53 @@ func (s *documentService) UpdateFields(ctx context.Context, id string, updatedDo
 	if updatedDoc.DisplayName != "" {
 		entity.DisplayName = updatedDoc.DisplayName
 	}
+	if updatedDoc.Title != "" {
+		entity.Title = updatedDoc.Title
+	}
+	if updatedDoc.Authors != nil {
+		entity.Authors = updatedDoc.Authors
+	}
+	if updatedDoc.Publisher != "" {
+		entity.Publisher = updatedDoc.Publisher
+	}
+	if updatedDoc.Language != "" {
+		entity.Language = updatedDoc.Language
+	}
+	if updatedDoc.ISBN != "" {
+		if entity.ISBN = NormalizeISBN(updatedDoc.ISBN); entity.ISBN == "" {
+			return doc, ErrInvalidISBN
+		}
+	}
+	if updatedDoc.Subjects != nil {
+		entity.Subjects = updatedDoc.Subjects
+	}
+	if updatedDoc.Published != nil {
+		entity.Published = updatedDoc.Published
+	}
+	if updatedDoc.DOI != "" {
+		if entity.DOI = NormalizeDOI(updatedDoc.DOI); entity.DOI == "" {
+			return doc, ErrInvalidDOI
+		}
+	}
+	if updatedDoc.ArXivID != "" {
+		if entity.ArXivID = FindArXivID("arXiv:" + updatedDoc.ArXivID); entity.ArXivID == "" {
+			return doc, ErrInvalidArXivID
+		}
+	}
+	for field, value := range map[*string]string{
+		&entity.CitationKey: updatedDoc.CitationKey,
+		&entity.EntryType:   strings.ToLower(updatedDoc.EntryType),
+		&entity.Journal:     updatedDoc.Journal,
+		&entity.Volume:      updatedDoc.Volume,
+		&entity.Issue:       updatedDoc.Issue,
+		&entity.Pages:       updatedDoc.Pages,
+		&entity.URL:         updatedDoc.URL,
+		&entity.CoverURL:    updatedDoc.CoverURL,
+	} {
+		if value != "" {
+			*field = value
+		}
+	}
 	if updatedDoc.Type != "" {
 		if updatedDoc.Type == "book" || updatedDoc.Type == "paper" {
 			entity.Type = updatedDoc.Type
@@ -216,27 +602,3 @@ func (s *documentService) UpdateFields(ctx context.Context, id string, updatedDo
 	return s.repo.UpdateDocument(ctx, *entity)
 
 }
-
-func isSupported(file multipart.File) bool {
-	head := make([]byte, 261)
-	if bytesRead, err := io.ReadFull(file, head); err == io.EOF {
-		logrus.WithField("bytesRead", bytesRead).WithError(err).Error("couldn't read file header: unexpected EOF")
-		return false
-	} else if err != nil {
-		logrus.WithField("bytesRead", bytesRead).WithError(err).Error("couldn't read file header")
-		return false
-	}
-
-	file.Seek(0, io.SeekStart)
-
-	if kind, err := filetype.Match(head); err != nil {
-		logrus.WithError(err).Error("unable to determine file type")
-		return false
-	} else {
-		if kind != matchers.TypeEpub && kind != matchers.TypePdf {
-			logrus.WithFields(logrus.Fields{"mime": kind.MIME.Value, "ext": kind.Extension}).WithError(err).Error("file type not supported")
-			return false
-		}
-	} // TODO mobi check
-	return true
-}
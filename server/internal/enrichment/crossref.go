@@ -0,0 +1,193 @@
+package enrichment
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"context"
+	"github.com/go-resty/resty/v2"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// crossref looks papers up by doi, or by title when there is none, see https://api.crossref.org/swagger-ui/index.html
+type crossref struct {
+	client *resty.Client
+	mailto string
+}
+
+func NewCrossref(config common.EnrichmentConfig) Source {
+	return &crossref{
+		client: newClient(config, config.CrossrefURL),
+		mailto: config.Mailto,
+	}
+}
+
+func (c *crossref) Name() string {
+	return "crossref"
+}
+
+type crossrefWork struct {
+	DOI            string   `json:"DOI"`
+	URL            string   `json:"URL"`
+	Type           string   `json:"type"`
+	Title          []string `json:"title"`
+	ContainerTitle []string `json:"container-title"`
+	Publisher      string   `json:"publisher"`
+	Volume         string   `json:"volume"`
+	Issue          string   `json:"issue"`
+	Page           string   `json:"page"`
+	Language       string   `json:"language"`
+	Subject        []string `json:"subject"`
+	ISBN           []string `json:"ISBN"`
+	Abstract       string   `json:"abstract"`
+	Author         []struct {
+		Given  string `json:"given"`
+		Family string `json:"family"`
+		Name   string `json:"name"`
+	} `json:"author"`
+	Issued          crossrefDate `json:"issued"`
+	PublishedPrint  crossrefDate `json:"published-print"`
+	PublishedOnline crossrefDate `json:"published-online"`
+}
+
+type crossrefDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// crossrefTypes maps the work types to the BibTeX entry types used for citations
+var crossrefTypes = map[string]string{
+	"journal-article":     "article",
+	"proceedings-article": "inproceedings",
+	"book":                "book",
+	"monograph":           "book",
+	"book-chapter":        "incollection",
+	"report":              "techreport",
+	"dissertation":        "phdthesis",
+	"posted-content":      "misc",
+}
+
+func (c *crossref) Lookup(ctx context.Context, doc *documents.Document) (*Match, error) {
+	if doc.DOI != "" {
+		return c.byDOI(ctx, doc.DOI)
+	}
+	if doc.Type != "paper" || searchTitle(doc) == "" {
+		return nil, nil
+	}
+	return c.search(ctx, doc)
+}
+
+func (c *crossref) byDOI(ctx context.Context, doi string) (*Match, error) {
+	segments := strings.Split(doi, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	result := struct {
+		Message crossrefWork `json:"message"`
+	}{}
+	resp, err := c.request(ctx).SetResult(&result).Get("/works/" + strings.Join(segments, "/"))
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+	if len(result.Message.Title) == 0 {
+		return nil, nil
+	}
+	return result.Message.match(), nil
+}
+
+func (c *crossref) search(ctx context.Context, doc *documents.Document) (*Match, error) {
+	title := searchTitle(doc)
+	req := c.request(ctx).SetQueryParams(map[string]string{"query.bibliographic": title, "rows": "5"})
+	if len(doc.Authors) > 0 {
+		req.SetQueryParam("query.author", doc.Authors[0])
+	}
+
+	result := struct {
+		Message struct {
+			Items []crossrefWork `json:"items"`
+		} `json:"message"`
+	}{}
+	resp, err := req.SetResult(&result).Get("/works")
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+
+	for _, work := range result.Message.Items {
+		if len(work.Title) > 0 && sameTitle(title, work.Title[0]) {
+			return work.match(), nil
+		}
+	}
+	return nil, nil
+}
+
+// request adds the contact address, Crossref serves identified clients from a more reliable pool
+func (c *crossref) request(ctx context.Context) *resty.Request {
+	req := c.client.R().SetContext(ctx)
+	if c.mailto != "" {
+		req.SetQueryParam("mailto", c.mailto)
+	}
+	return req
+}
+
+var jatsTags = regexp.MustCompile(`<[^>]+>`)
+
+func (w crossrefWork) match() *Match {
+	m := &Match{Metadata: documents.Metadata{
+		Title:     strings.Join(strings.Fields(first(w.Title)), " "),
+		Journal:   first(w.ContainerTitle),
+		Publisher: w.Publisher,
+		Volume:    w.Volume,
+		Issue:     w.Issue,
+		Pages:     strings.ReplaceAll(w.Page, "–", "-"),
+		Language:  w.Language,
+		DOI:       documents.NormalizeDOI(w.DOI),
+		ArXivID:   documents.FindArXivID(w.DOI),
+		URL:       w.URL,
+		EntryType: crossrefTypes[w.Type],
+		Subjects:  limit(w.Subject, 10),
+		Authors:   []string{},
+	}}
+	for _, author := range w.Author {
+		name := strings.TrimSpace(author.Given + " " + author.Family)
+		if name == "" {
+			name = author.Name
+		}
+		if name != "" {
+			m.Authors = append(m.Authors, name)
+		}
+	}
+	for _, isbn := range w.ISBN {
+		if m.ISBN = documents.NormalizeISBN(isbn); m.ISBN != "" {
+			break
+		}
+	}
+	// abstracts are JATS xml, only the text is kept
+	if w.Abstract != "" {
+		m.Description = strings.Join(strings.Fields(html.UnescapeString(jatsTags.ReplaceAllString(w.Abstract, " "))), " ")
+	}
+	for _, d := range []crossrefDate{w.Issued, w.PublishedPrint, w.PublishedOnline} {
+		if m.Published = d.time(); m.Published != nil {
+			break
+		}
+	}
+	return m
+}
+
+func (d crossrefDate) time() *time.Time {
+	if len(d.DateParts) == 0 || len(d.DateParts[0]) == 0 || d.DateParts[0][0] <= 0 {
+		return nil
+	}
+	parts := append(append([]int{}, d.DateParts[0]...), 1, 1)
+	month, day := parts[1], parts[2]
+	if month < 1 || month > 12 {
+		month = 1
+	}
+	if day < 1 {
+		day = 1
+	}
+	t := time.Date(parts[0], time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
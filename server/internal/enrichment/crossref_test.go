@@ -0,0 +1,106 @@
+package enrichment
+
+import (
+	"alexandria/internal/documents"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const crossrefArticle = `{
+	"DOI": "10.1234/abc.5",
+	"URL": "https://doi.org/10.1234/abc.5",
+	"type": "journal-article",
+	"title": ["Attention   Is All\nYou Need"],
+	"container-title": ["Journal of Examples"],
+	"publisher": "Example Press",
+	"volume": "30",
+	"issue": "2",
+	"page": "5998–6008",
+	"author": [{"given": "Ashish", "family": "Vaswani"}, {"name": "Brain Team"}],
+	"abstract": "<jats:p>Sequence &amp; transduction</jats:p>",
+	"issued": {"date-parts": [[2017, 12]]}
+}`
+
+func TestCrossrefDOI(t *testing.T) {
+	server, last := stubSource(t, map[string]string{"/works/10.1234/abc.5": `{"message": ` + crossrefArticle + `}`})
+
+	doc := &documents.Document{Type: "paper", Metadata: documents.Metadata{DOI: "10.1234/abc.5"}}
+	m, err := NewCrossref(testConfig(server.URL)).Lookup(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.URL.Query().Get("mailto") != "library@example.com" {
+		t.Errorf("contact address wasn't sent: %s", last.URL.RawQuery)
+	}
+	if m == nil {
+		t.Fatal("expected a match")
+	}
+	if m.Title != "Attention Is All You Need" || m.Journal != "Journal of Examples" || m.EntryType != "article" {
+		t.Errorf("unexpected match %+v", m.Metadata)
+	}
+	if m.Volume != "30" || m.Issue != "2" || m.Pages != "5998-6008" || m.DOI != "10.1234/abc.5" {
+		t.Errorf("unexpected citation fields %+v", m.Metadata)
+	}
+	if !reflect.DeepEqual(m.Authors, []string{"Ashish Vaswani", "Brain Team"}) {
+		t.Errorf("unexpected authors %v", m.Authors)
+	}
+	if m.Description != "Sequence & transduction" {
+		t.Errorf("unexpected description %q", m.Description)
+	}
+	if m.Published == nil || m.Published.Year() != 2017 || m.Published.Month() != 12 {
+		t.Errorf("unexpected published date %v", m.Published)
+	}
+}
+
+func TestCrossrefTitleSearch(t *testing.T) {
+	server, last := stubSource(t, map[string]string{"/works": `{"message": {"items": [
+		{"title": ["Attention Economics"]},
+		` + crossrefArticle + `
+	]}}`})
+
+	doc := &documents.Document{Type: "paper", Metadata: documents.Metadata{Title: "Attention is all you need", Authors: []string{"Vaswani"}}}
+	m, err := NewCrossref(testConfig(server.URL)).Lookup(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := last.URL.Query(); q.Get("query.bibliographic") != "Attention is all you need" || q.Get("query.author") != "Vaswani" {
+		t.Errorf("unexpected query %s", last.URL.RawQuery)
+	}
+	if m == nil || m.DOI != "10.1234/abc.5" {
+		t.Errorf("expected the matching title, got %+v", m)
+	}
+}
+
+func TestCrossrefNoMatch(t *testing.T) {
+	server, _ := stubSource(t, map[string]string{
+		"/works": `{"message": {"items": [{"title": ["Something Else"]}, {"title": []}]}}`,
+	})
+	source := NewCrossref(testConfig(server.URL))
+
+	for name, doc := range map[string]*documents.Document{
+		"doi":      {Type: "paper", Metadata: documents.Metadata{DOI: "10.1234/missing"}},
+		"title":    {Type: "paper", Metadata: documents.Metadata{Title: "Attention is all you need"}},
+		"book":     {Type: "book", Metadata: documents.Metadata{Title: "Attention is all you need"}},
+		"untitled": {Type: "paper"},
+	} {
+		if m, err := source.Lookup(context.Background(), doc); m != nil || err != nil {
+			t.Errorf("%s: expected no match, got %+v and %v", name, m, err)
+		}
+	}
+}
+
+func TestCrossrefUnavailable(t *testing.T) {
+	server, _ := stubSource(t, map[string]string{"/works": ""})
+
+	doc := &documents.Document{Type: "paper", Metadata: documents.Metadata{Title: "Attention is all you need"}}
+	if _, err := NewCrossref(testConfig(server.URL)).Lookup(context.Background(), doc); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected %v, got %v", ErrUnavailable, err)
+	}
+
+	server.Close()
+	if _, err := NewCrossref(testConfig(server.URL)).Lookup(context.Background(), doc); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("unreachable source: expected %v, got %v", ErrUnavailable, err)
+	}
+}
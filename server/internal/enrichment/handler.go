@@ -0,0 +1,81 @@
+package enrichment
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type enrichmentHandler struct {
+	service Service
+}
+
+func MakeEnrichmentHandler(r *mux.Router, service Service) http.Handler {
+	h := &enrichmentHandler{
+		service: service,
+	}
+
+	r.HandleFunc("/documents/{id}/enrich", h.Enrich).Methods("POST")
+	// the job runs over every library so only admins can start it
+	r.Handle("/admin/enrichment", common.RequireAdmin(http.HandlerFunc(h.Job))).Methods("GET")
+	r.Handle("/admin/enrichment", common.RequireAdmin(http.HandlerFunc(h.StartJob))).Methods("POST")
+	r.Handle("/admin/enrichment", common.RequireAdmin(http.HandlerFunc(h.CancelJob))).Methods("DELETE")
+
+	return r
+}
+
+func (h *enrichmentHandler) Enrich(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	result, err := h.service.Enrich(ctx, id, r.URL.Query().Get("overwrite") == "true")
+	switch {
+	case err == documents.ErrNotFound:
+		common.MakeError(w, http.StatusNotFound, "enrichment", "Not Found", "enrich")
+		return
+	case err == ErrNoMatch:
+		common.MakeError(w, http.StatusNotFound, "enrichment", err.Error(), "enrich")
+		return
+	case errors.Is(err, ErrUnavailable):
+		common.MakeError(w, http.StatusBadGateway, "enrichment", err.Error(), "enrich")
+		return
+	case err != nil:
+		common.MakeError(w, http.StatusInternalServerError, "enrichment", "Server Error", "enrich")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, result)
+}
+
+func (h *enrichmentHandler) Job(w http.ResponseWriter, r *http.Request) {
+	common.EncodeResponse(r.Context(), w, h.service.Job())
+}
+
+func (h *enrichmentHandler) StartJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	filter, err := documents.ParseFilter(r)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "enrichment", err.Error(), "start")
+		return
+	}
+
+	job, err := h.service.StartJob(ctx, filter, r.URL.Query().Get("overwrite") == "true")
+	if err == ErrJobRunning {
+		common.MakeError(w, http.StatusConflict, "enrichment", err.Error(), "start")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "enrichment", "Server Error", "start")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	common.EncodeResponse(ctx, w, job)
+}
+
+func (h *enrichmentHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	common.EncodeResponse(r.Context(), w, h.service.CancelJob())
+}
@@ -0,0 +1,194 @@
+package enrichment
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"context"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"strconv"
+)
+
+// openLibrary looks books up by isbn, or by title when there is none, see https://openlibrary.org/developers/api
+type openLibrary struct {
+	client    *resty.Client
+	coversURL string
+}
+
+func NewOpenLibrary(config common.EnrichmentConfig) Source {
+	return &openLibrary{
+		client:    newClient(config, config.OpenLibraryURL),
+		coversURL: config.CoversURL,
+	}
+}
+
+func (o *openLibrary) Name() string {
+	return "openlibrary"
+}
+
+type openLibraryName struct {
+	Name string `json:"name"`
+}
+
+// openLibraryBook is an entry of the books api with jscmd=data
+type openLibraryBook struct {
+	Title       string            `json:"title"`
+	Subtitle    string            `json:"subtitle"`
+	Authors     []openLibraryName `json:"authors"`
+	Publishers  []openLibraryName `json:"publishers"`
+	PublishDate string            `json:"publish_date"`
+	Subjects    []openLibraryName `json:"subjects"`
+	URL         string            `json:"url"`
+	Cover       struct {
+		Large  string `json:"large"`
+		Medium string `json:"medium"`
+	} `json:"cover"`
+	Identifiers struct {
+		ISBN13 []string `json:"isbn_13"`
+		ISBN10 []string `json:"isbn_10"`
+	} `json:"identifiers"`
+}
+
+type openLibrarySearch struct {
+	Docs []struct {
+		Title            string   `json:"title"`
+		AuthorName       []string `json:"author_name"`
+		FirstPublishYear int      `json:"first_publish_year"`
+		Publisher        []string `json:"publisher"`
+		Subject          []string `json:"subject"`
+		ISBN             []string `json:"isbn"`
+		CoverID          int      `json:"cover_i"`
+		Key              string   `json:"key"`
+	} `json:"docs"`
+}
+
+func (o *openLibrary) Lookup(ctx context.Context, doc *documents.Document) (*Match, error) {
+	if doc.ISBN != "" {
+		return o.byISBN(ctx, doc.ISBN)
+	}
+	if doc.Type != "book" || searchTitle(doc) == "" {
+		return nil, nil
+	}
+	return o.search(ctx, doc)
+}
+
+func (o *openLibrary) byISBN(ctx context.Context, isbn string) (*Match, error) {
+	key := "ISBN:" + isbn
+	books := map[string]openLibraryBook{}
+	resp, err := o.client.R().SetContext(ctx).
+		SetQueryParams(map[string]string{"bibkeys": key, "format": "json", "jscmd": "data"}).
+		SetResult(&books).
+		Get("/api/books")
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+
+	book, ok := books[key]
+	if !ok || book.Title == "" {
+		return nil, nil
+	}
+
+	m := &Match{Metadata: documents.Metadata{
+		Title:     book.Title,
+		Publisher: first(names(book.Publishers)),
+		Published: year(book.PublishDate),
+		URL:       book.URL,
+		CoverURL:  book.Cover.Large,
+		Authors:   names(book.Authors),
+		Subjects:  limit(names(book.Subjects), 10),
+		ISBN:      isbn,
+	}}
+	if book.Subtitle != "" {
+		m.Title += ": " + book.Subtitle
+	}
+	if m.CoverURL == "" {
+		m.CoverURL = book.Cover.Medium
+	}
+	return m, nil
+}
+
+func (o *openLibrary) search(ctx context.Context, doc *documents.Document) (*Match, error) {
+	title := searchTitle(doc)
+	params := map[string]string{"title": title, "limit": "5"}
+	if len(doc.Authors) > 0 {
+		params["author"] = doc.Authors[0]
+	}
+
+	results := openLibrarySearch{}
+	resp, err := o.client.R().SetContext(ctx).SetQueryParams(params).SetResult(&results).Get("/search.json")
+	if err := checkResponse(resp, err); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results.Docs {
+		if !sameTitle(title, result.Title) {
+			continue
+		}
+		m := &Match{Metadata: documents.Metadata{
+			Title:     result.Title,
+			Authors:   result.AuthorName,
+			Publisher: first(result.Publisher),
+			Subjects:  limit(result.Subject, 10),
+		}}
+		if result.FirstPublishYear > 0 {
+			m.Published = year(strconv.Itoa(result.FirstPublishYear))
+		}
+		for _, isbn := range result.ISBN {
+			if m.ISBN = documents.NormalizeISBN(isbn); m.ISBN != "" {
+				break
+			}
+		}
+		if result.CoverID > 0 {
+			m.CoverURL = fmt.Sprintf("%s/b/id/%d-L.jpg", o.coversURL, result.CoverID)
+		}
+		if result.Key != "" {
+			m.URL = o.client.HostURL + result.Key
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+func names(values []openLibraryName) []string {
+	list := []string{}
+	for _, value := range values {
+		if value.Name != "" {
+			list = append(list, value.Name)
+		}
+	}
+	return list
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func newClient(config common.EnrichmentConfig, host string) *resty.Client {
+	agent := "alexandria"
+	if config.Mailto != "" {
+		agent += " (mailto:" + config.Mailto + ")"
+	}
+	return resty.New().
+		SetHostURL(host).
+		SetTimeout(config.Timeout).
+		SetHeader("Accept", "application/json").
+		SetHeader("User-Agent", agent)
+}
+
+// checkResponse treats not found as an empty result, anything else that isn't a success means the source is down
+func checkResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%w: %s", ErrUnavailable, resp.Status())
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package enrichment
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubSource serves canned responses by path and records the query of the last request
+func stubSource(t *testing.T, responses map[string]string) (*httptest.Server, *http.Request) {
+	last := &http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = *r
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if body == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, last
+}
+
+func testConfig(url string) common.EnrichmentConfig {
+	return common.EnrichmentConfig{
+		OpenLibraryURL: url,
+		CoversURL:      "https://covers.example.com",
+		CrossrefURL:    url,
+		Mailto:         "library@example.com",
+		Timeout:        time.Second,
+	}
+}
+
+func TestOpenLibraryISBN(t *testing.T) {
+	server, last := stubSource(t, map[string]string{"/api/books": `{"ISBN:9780441013593": {
+		"title": "Dune",
+		"subtitle": "Deluxe Edition",
+		"authors": [{"name": "Frank Herbert"}],
+		"publishers": [{"name": "Ace"}],
+		"publish_date": "August 2005",
+		"subjects": [{"name": "Science fiction"}, {"name": ""}],
+		"url": "https://openlibrary.org/books/OL1M/Dune",
+		"cover": {"medium": "https://covers.example.com/b/id/1-M.jpg"}
+	}}`})
+
+	doc := &documents.Document{Type: "book", Metadata: documents.Metadata{ISBN: "9780441013593"}}
+	m, err := NewOpenLibrary(testConfig(server.URL)).Lookup(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := last.URL.Query(); q.Get("bibkeys") != "ISBN:9780441013593" || q.Get("jscmd") != "data" {
+		t.Errorf("unexpected query %s", last.URL.RawQuery)
+	}
+	if m == nil {
+		t.Fatal("expected a match")
+	}
+	if m.Title != "Dune: Deluxe Edition" || m.Publisher != "Ace" || m.ISBN != "9780441013593" {
+		t.Errorf("unexpected match %+v", m.Metadata)
+	}
+	if !reflect.DeepEqual(m.Authors, []string{"Frank Herbert"}) || !reflect.DeepEqual(m.Subjects, []string{"Science fiction"}) {
+		t.Errorf("unexpected authors %v or subjects %v", m.Authors, m.Subjects)
+	}
+	if m.Published == nil || m.Published.Year() != 2005 {
+		t.Errorf("unexpected published date %v", m.Published)
+	}
+	if m.CoverURL != "https://covers.example.com/b/id/1-M.jpg" {
+		t.Errorf("medium cover wasn't used when there is no large one: %s", m.CoverURL)
+	}
+}
+
+func TestOpenLibraryTitleSearch(t *testing.T) {
+	server, last := stubSource(t, map[string]string{"/search.json": `{"docs": [
+		{"title": "Children of Dune", "key": "/works/OL2W"},
+		{"title": "Dune", "author_name": ["Frank Herbert"], "first_publish_year": 1965, "publisher": ["Chilton"],
+			"isbn": ["not-an-isbn", "0441013597"], "cover_i": 42, "key": "/works/OL1W"}
+	]}`})
+
+	doc := &documents.Document{Type: "book", DisplayName: "dune", Metadata: documents.Metadata{Authors: []string{"Frank Herbert"}}}
+	m, err := NewOpenLibrary(testConfig(server.URL)).Lookup(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := last.URL.Query(); q.Get("title") != "dune" || q.Get("author") != "Frank Herbert" {
+		t.Errorf("unexpected query %s", last.URL.RawQuery)
+	}
+	if m == nil {
+		t.Fatal("expected a match")
+	}
+	if m.Title != "Dune" || m.Publisher != "Chilton" || m.ISBN == "" {
+		t.Errorf("unexpected match %+v", m.Metadata)
+	}
+	if m.Published == nil || m.Published.Year() != 1965 {
+		t.Errorf("unexpected published date %v", m.Published)
+	}
+	if m.CoverURL != "https://covers.example.com/b/id/42-L.jpg" || m.URL != server.URL+"/works/OL1W" {
+		t.Errorf("unexpected cover %s or url %s", m.CoverURL, m.URL)
+	}
+}
+
+func TestOpenLibraryNoMatch(t *testing.T) {
+	server, _ := stubSource(t, map[string]string{
+		"/api/books":   `{}`,
+		"/search.json": `{"docs": [{"title": "Something Else"}]}`,
+	})
+	source := NewOpenLibrary(testConfig(server.URL))
+
+	for name, doc := range map[string]*documents.Document{
+		"isbn":     {Type: "book", Metadata: documents.Metadata{ISBN: "9780000000002"}},
+		"title":    {Type: "book", Metadata: documents.Metadata{Title: "Dune"}},
+		"paper":    {Type: "paper", Metadata: documents.Metadata{Title: "Dune"}},
+		"untitled": {Type: "book"},
+	} {
+		if m, err := source.Lookup(context.Background(), doc); m != nil || err != nil {
+			t.Errorf("%s: expected no match, got %+v and %v", name, m, err)
+		}
+	}
+}
+
+func TestOpenLibraryUnavailable(t *testing.T) {
+	server, _ := stubSource(t, map[string]string{"/api/books": ""})
+
+	doc := &documents.Document{Type: "book", Metadata: documents.Metadata{ISBN: "9780441013593"}}
+	if _, err := NewOpenLibrary(testConfig(server.URL)).Lookup(context.Background(), doc); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected %v, got %v", ErrUnavailable, err)
+	}
+}
@@ -0,0 +1,299 @@
+package enrichment
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoMatch     = errors.New("no match found")
+	ErrUnavailable = errors.New("metadata source unavailable")
+	ErrJobRunning  = errors.New("enrichment job already running")
+)
+
+// Source looks a document up in an external catalogue, a nil match without an error means it has nothing to offer
+type Source interface {
+	Name() string
+	Lookup(ctx context.Context, doc *documents.Document) (*Match, error)
+}
+
+// Match is what a source found for a document
+type Match struct {
+	documents.Metadata
+	Description string
+}
+
+type Service interface {
+	Enrich(ctx context.Context, id string, overwrite bool) (*Result, error)
+	StartJob(ctx context.Context, filter documents.Filter, overwrite bool) (Job, error)
+	CancelJob() Job
+	Job() Job
+}
+
+// Result lists the fields an enrichment wrote to the document
+type Result struct {
+	Document *documents.Document `json:"document"`
+	Source   string              `json:"source"`
+	Updated  []string            `json:"updated"`
+}
+
+// Job is the progress of the bulk enrichment, only one runs at a time and the last one is kept until the next starts
+type Job struct {
+	Running   bool       `json:"running"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Overwrite bool       `json:"overwrite"`
+	Total     int        `json:"total"`
+	Processed int        `json:"processed"`
+	Enriched  int        `json:"enriched"`
+	NoMatch   int        `json:"no_match"`
+	Failed    int        `json:"failed"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type service struct {
+	docs   documents.DocumentService
+	books  []Source
+	papers []Source
+	delay  time.Duration
+	mu     sync.Mutex
+	job    Job
+	cancel context.CancelFunc
+}
+
+func NewService(config common.EnrichmentConfig, docs documents.DocumentService) Service {
+	openLibrary := NewOpenLibrary(config)
+	crossref := NewCrossref(config)
+	return &service{
+		docs:   docs,
+		books:  []Source{openLibrary, crossref},
+		papers: []Source{crossref, openLibrary},
+		delay:  config.Delay,
+	}
+}
+
+func (s *service) Enrich(ctx context.Context, id string, overwrite bool) (*Result, error) {
+	doc, err := s.docs.FindByID(ctx, id)
+	if err == documents.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to fetch document")
+		return nil, errors.New("unable to fetch document")
+	}
+
+	sources := s.books
+	if doc.Type == "paper" {
+		sources = s.papers
+	}
+	// a source that is down doesn't stop the others being asked, it is only an error when none of them answered
+	failed := 0
+	for _, source := range sources {
+		found, err := source.Lookup(ctx, doc)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"id": id, "source": source.Name()}).Warn("metadata lookup failed")
+			failed++
+			continue
+		}
+		if found == nil {
+			continue
+		}
+
+		update, fields := merge(doc, *found, overwrite)
+		result := &Result{Document: doc, Source: source.Name(), Updated: fields}
+		if len(fields) == 0 {
+			return result, nil
+		}
+		updated, err := s.docs.UpdateFields(ctx, id, update)
+		if err != nil {
+			logrus.WithError(err).WithField("id", id).Error("unable to save enriched document")
+			return nil, errors.New("unable to save document")
+		}
+		result.Document = &updated
+		return result, nil
+	}
+	if failed == len(sources) {
+		return nil, ErrUnavailable
+	}
+	return nil, ErrNoMatch
+}
+
+// StartJob enriches every document matching the filter in the background, the lookups are spaced out by the
+// configured delay
+func (s *service) StartJob(ctx context.Context, filter documents.Filter, overwrite bool) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.job.Running {
+		return s.job, ErrJobRunning
+	}
+
+	ctx, cancel := context.WithCancel(common.WithSystem(context.Background()))
+	docs, err := s.docs.FindAll(ctx, filter)
+	if err != nil {
+		cancel()
+		logrus.WithError(err).Error("unable to fetch documents to enrich")
+		return s.job, errors.New("unable to fetch documents")
+	}
+
+	started := time.Now()
+	s.job = Job{Running: true, Started: &started, Overwrite: overwrite, Total: len(docs)}
+	s.cancel = cancel
+	go s.run(ctx, docs, overwrite)
+	return s.job, nil
+}
+
+func (s *service) run(ctx context.Context, docs []*documents.Document, overwrite bool) {
+	defer s.finish(ctx)
+	for i, doc := range docs {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.delay):
+			}
+		}
+
+		result, err := s.Enrich(ctx, doc.ID, overwrite)
+		s.mu.Lock()
+		s.job.Processed++
+		switch {
+		case err == ErrNoMatch:
+			s.job.NoMatch++
+		case err != nil:
+			s.job.Failed++
+		case len(result.Updated) > 0:
+			s.job.Enriched++
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *service) finish(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	s.job.Running = false
+	s.job.Finished = &finished
+	if ctx.Err() != nil {
+		s.job.Error = "cancelled"
+	}
+	s.cancel()
+	logrus.WithFields(logrus.Fields{"processed": s.job.Processed, "enriched": s.job.Enriched, "failed": s.job.Failed}).
+		Info("enrichment job finished")
+}
+
+func (s *service) CancelJob() Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.job.Running {
+		s.cancel()
+	}
+	return s.job
+}
+
+func (s *service) Job() Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.job
+}
+
+// merge picks the found fields to write to the document, without overwrite only the empty ones are filled
+func merge(doc *documents.Document, found Match, overwrite bool) (documents.Document, []string) {
+	update := documents.Document{}
+	fields := []string{}
+
+	for _, f := range []struct {
+		name           string
+		current, value string
+		field          *string
+	}{
+		{"title", doc.Title, found.Title, &update.Title},
+		{"publisher", doc.Publisher, found.Publisher, &update.Publisher},
+		{"language", doc.Language, found.Language, &update.Language},
+		{"isbn", doc.ISBN, found.ISBN, &update.ISBN},
+		{"doi", doc.DOI, found.DOI, &update.DOI},
+		{"arxiv_id", doc.ArXivID, found.ArXivID, &update.ArXivID},
+		{"entry_type", doc.EntryType, found.EntryType, &update.EntryType},
+		{"journal", doc.Journal, found.Journal, &update.Journal},
+		{"volume", doc.Volume, found.Volume, &update.Volume},
+		{"issue", doc.Issue, found.Issue, &update.Issue},
+		{"pages", doc.Pages, found.Pages, &update.Pages},
+		{"url", doc.URL, found.URL, &update.URL},
+		{"cover_url", doc.CoverURL, found.CoverURL, &update.CoverURL},
+		{"description", doc.Description, found.Description, &update.Description},
+	} {
+		if f.value != "" && f.value != f.current && (overwrite || f.current == "") {
+			*f.field = f.value
+			fields = append(fields, f.name)
+		}
+	}
+
+	if len(found.Authors) > 0 && (overwrite || len(doc.Authors) == 0) && !equal(doc.Authors, found.Authors) {
+		update.Authors = found.Authors
+		fields = append(fields, "authors")
+	}
+	if len(found.Subjects) > 0 && (overwrite || len(doc.Subjects) == 0) && !equal(doc.Subjects, found.Subjects) {
+		update.Subjects = found.Subjects
+		fields = append(fields, "subjects")
+	}
+	if found.Published != nil && (doc.Published == nil || overwrite && !doc.Published.Equal(*found.Published)) {
+		update.Published = found.Published
+		fields = append(fields, "published")
+	}
+
+	return update, fields
+}
+
+func equal(a, b []string) bool {
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
+
+var titleWords = regexp.MustCompile(`[\pL\pN]+`)
+
+// sameTitle compares titles by their words, a search result may add or drop a subtitle
+func sameTitle(a, b string) bool {
+	x := strings.ToLower(strings.Join(titleWords.FindAllString(a, -1), " "))
+	y := strings.ToLower(strings.Join(titleWords.FindAllString(b, -1), " "))
+	if x == "" || y == "" {
+		return false
+	}
+	return x == y || strings.HasPrefix(x, y+" ") || strings.HasPrefix(y, x+" ")
+}
+
+// searchTitle is what a document is searched for by when it has no identifier
+func searchTitle(doc *documents.Document) string {
+	if doc.Title != "" {
+		return doc.Title
+	}
+	return doc.DisplayName
+}
+
+var yearPattern = regexp.MustCompile(`\b(1[5-9]|20)\d{2}\b`)
+
+// year finds the year in a free text date such as "March 1984"
+func year(text string) *time.Time {
+	match := yearPattern.FindString(text)
+	if match == "" {
+		return nil
+	}
+	t, err := time.Parse("2006", match)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// limit keeps catalogues with dozens of subjects from flooding the document
+func limit(values []string, n int) []string {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
@@ -0,0 +1,61 @@
+package enrichment
+
+import (
+	"alexandria/internal/documents"
+	"context"
+	"testing"
+)
+
+type fixedSource struct {
+	name  string
+	match *Match
+	err   error
+}
+
+func (f fixedSource) Name() string {
+	return f.name
+}
+
+func (f fixedSource) Lookup(ctx context.Context, doc *documents.Document) (*Match, error) {
+	return f.match, f.err
+}
+
+type memoryDocuments struct {
+	documents.DocumentService
+	doc *documents.Document
+}
+
+func (m *memoryDocuments) FindByID(ctx context.Context, id string) (*documents.Document, error) {
+	return m.doc, nil
+}
+
+func (m *memoryDocuments) UpdateFields(ctx context.Context, id string, update documents.Document) (documents.Document, error) {
+	m.doc.Title = update.Title
+	return *m.doc, nil
+}
+
+func TestEnrichSourceFallback(t *testing.T) {
+	down := fixedSource{name: "down", err: ErrUnavailable}
+	empty := fixedSource{name: "empty"}
+	found := fixedSource{name: "found", match: &Match{Metadata: documents.Metadata{Title: "Dune"}}}
+
+	for name, tc := range map[string]struct {
+		sources []Source
+		source  string
+		err     error
+	}{
+		"next source after an error": {sources: []Source{down, found}, source: "found"},
+		"no match after an error":    {sources: []Source{down, empty}, err: ErrNoMatch},
+		"every source down":          {sources: []Source{down, down}, err: ErrUnavailable},
+	} {
+		s := &service{docs: &memoryDocuments{doc: &documents.Document{ID: "doc", Type: "book"}}, books: tc.sources}
+		result, err := s.Enrich(context.Background(), "doc", false)
+		if err != tc.err {
+			t.Errorf("%s: expected %v, got %v", name, tc.err, err)
+			continue
+		}
+		if tc.err == nil && (result.Source != tc.source || result.Document.Title != "Dune") {
+			t.Errorf("%s: unexpected result %+v", name, result)
+		}
+	}
+}
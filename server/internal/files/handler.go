@@ -0,0 +1,81 @@
+package files
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/sharing"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sandboxed are the suffixes of files a browser would run scripts in, converted copies end in ~format
+var sandboxed = []string{".html", ".htm", ".md", ".markdown", "~html", "~markdown"}
+
+type fileHandler struct {
+	storage common.DocumentStorage
+	signer  *common.URLSigner
+	shares  sharing.Service
+}
+
+// MakeFileHandler streams files out of buckets that are unable to sign urls. Requests either carry a signature
+// created by common.URLSigner or come from an admin, the bucket holds every user's library. Urls signed for a share
+// stop working once the share is revoked or expires.
+func MakeFileHandler(mr *mux.Router, storage common.DocumentStorage, signer *common.URLSigner, shares sharing.Service) http.Handler {
+	r := mr.PathPrefix("/files").Subrouter()
+	h := &fileHandler{
+		storage: storage,
+		signer:  signer,
+		shares:  shares,
+	}
+	r.HandleFunc("/{path:.+}", h.Get).Methods("GET", "HEAD")
+
+	return r
+}
+
+func (h *fileHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p := mux.Vars(r)["path"]
+
+	q := r.URL.Query()
+	if q.Get("signature") != "" {
+		if err := h.signer.Verify(p, q); err != nil {
+			common.MakeError(w, http.StatusForbidden, "files", err.Error(), "get")
+			return
+		}
+		if resource := q.Get("resource"); resource != "" {
+			shareCtx := common.WithIdentity(ctx, common.Identity{ID: q.Get("user")})
+			if _, err := h.shares.Authorize(shareCtx, resource, q.Get("share")); err == sharing.ErrNotFound {
+				common.MakeError(w, http.StatusForbidden, "files", "Forbidden", "get")
+				return
+			} else if err != nil {
+				logrus.WithError(err).WithField("resource", resource).Error("unable to check share")
+				common.MakeError(w, http.StatusInternalServerError, "files", "Server Error", "get")
+				return
+			}
+		}
+	} else if identity, ok := common.IdentityFromContext(ctx); !ok || !identity.IsAdmin() {
+		common.MakeError(w, http.StatusForbidden, "files", "Forbidden", "get")
+		return
+	}
+
+	content, err := h.storage.Stream(ctx, p)
+	if err != nil {
+		common.MakeError(w, http.StatusNotFound, "files", "Not Found", "get")
+		return
+	}
+	defer content.Close()
+
+	for _, suffix := range sandboxed {
+		if strings.HasSuffix(strings.ToLower(p), suffix) {
+			w.Header().Set("Content-Security-Policy", "sandbox")
+		}
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", content.ETag)
+	if content.ContentType != "" {
+		w.Header().Set("Content-Type", content.ContentType)
+	}
+	http.ServeContent(w, r, path.Base(p), content.ModTime, content)
+}
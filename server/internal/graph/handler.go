@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"alexandria/internal/common"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type graphHandler struct {
+	service Service
+}
+
+func MakeGraphHandler(mr *mux.Router, service Service) http.Handler {
+	r := mr.PathPrefix("/admin/graph").Subrouter()
+	r.Use(common.RequireAdmin)
+	h := &graphHandler{
+		service: service,
+	}
+	r.HandleFunc("/drift", h.Drift).Methods("GET")
+	r.HandleFunc("/repair", h.Repair).Methods("POST")
+	r.HandleFunc("/rebuild", h.Rebuild).Methods("POST")
+
+	return r
+}
+
+func (h *graphHandler) Drift(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	report, err := h.service.Drift(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "graph", "Server error", "drift")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, report)
+}
+
+func (h *graphHandler) Repair(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	result, err := h.service.Repair(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "graph", "Server error", "repair")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, result)
+}
+
+func (h *graphHandler) Rebuild(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	wipe := r.URL.Query().Get("wipe") == "true"
+	result, err := h.service.Rebuild(ctx, wipe)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "graph", "Server error", "rebuild")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, result)
+}
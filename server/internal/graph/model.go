@@ -0,0 +1,51 @@
+package graph
+
+import "time"
+
+type Node struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type Edge struct {
+	ResourceID   string `json:"resource_id"`
+	TagID        string `json:"tag_id"`
+	ResourceType string `json:"resource_type,omitempty"`
+}
+
+// OutboxStatus counts the events waiting for the relay. Failed events ran out of attempts, the relay stops at the
+// first one until they are retried.
+type OutboxStatus struct {
+	Pending       int        `json:"pending"`
+	Failed        int        `json:"failed"`
+	OldestPending *time.Time `json:"oldest_pending"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// DriftReport compares postgres, the source of truth, against the graph. Missing entries exist in postgres but not
+// in the graph (or have the wrong label or owner), extra entries only exist in the graph.
+type DriftReport struct {
+	Outbox       OutboxStatus `json:"outbox"`
+	MissingNodes []Node       `json:"missing_nodes"`
+	ExtraNodes   []Node       `json:"extra_nodes"`
+	MissingEdges []Edge       `json:"missing_edges"`
+	ExtraEdges   []Edge       `json:"extra_edges"`
+	InSync       bool         `json:"in_sync"`
+}
+
+type RepairResult struct {
+	Drift   DriftReport `json:"drift"`
+	Queued  int         `json:"queued"`
+	Retried int         `json:"retried"`
+}
+
+// RebuildResult reports what was written to the graph, Before holds the drift found prior to the rebuild
+type RebuildResult struct {
+	Before       DriftReport    `json:"before"`
+	Wiped        bool           `json:"wiped"`
+	Nodes        map[string]int `json:"nodes"`
+	Edges        int            `json:"edges"`
+	RemovedNodes int            `json:"removed_nodes"`
+	RemovedEdges int            `json:"removed_edges"`
+}
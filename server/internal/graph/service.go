@@ -0,0 +1,150 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Service interface {
+	Drift(ctx context.Context) (DriftReport, error)
+	Repair(ctx context.Context) (RepairResult, error)
+	Rebuild(ctx context.Context, wipe bool) (RebuildResult, error)
+}
+
+type Repository interface {
+	OutboxStatus(ctx context.Context) (OutboxStatus, error)
+	SourceGraph(ctx context.Context) ([]Node, []Edge, error)
+	CurrentGraph(ctx context.Context) ([]Node, []Edge, error)
+	EnqueueRepair(ctx context.Context, drift DriftReport) (int, error)
+	RetryFailed(ctx context.Context) (int, error)
+	Rebuild(ctx context.Context, drift DriftReport, wipe bool) (RebuildResult, error)
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return &service{
+		repo: repo,
+	}
+}
+
+func (s *service) Drift(ctx context.Context) (report DriftReport, err error) {
+	report.Outbox, err = s.repo.OutboxStatus(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch outbox status")
+		return report, errors.New("unable to fetch outbox status")
+	}
+
+	sourceNodes, sourceEdges, err := s.repo.SourceGraph(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch source graph")
+		return report, errors.New("unable to fetch source graph")
+	}
+
+	currentNodes, currentEdges, err := s.repo.CurrentGraph(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch current graph")
+		return report, errors.New("unable to fetch current graph")
+	}
+
+	report.MissingNodes, report.ExtraNodes = diffNodes(sourceNodes, currentNodes)
+	report.MissingEdges, report.ExtraEdges = diffEdges(sourceEdges, currentEdges)
+	report.InSync = len(report.MissingNodes) == 0 && len(report.ExtraNodes) == 0 &&
+		len(report.MissingEdges) == 0 && len(report.ExtraEdges) == 0
+	return report, nil
+}
+
+// Repair queues the events needed to bring the graph back in line with postgres and gives failed events another
+// round of attempts.
+func (s *service) Repair(ctx context.Context) (result RepairResult, err error) {
+	result.Drift, err = s.Drift(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.Retried, err = s.repo.RetryFailed(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to retry failed events")
+		return result, errors.New("unable to retry failed events")
+	}
+
+	if result.Drift.InSync {
+		return result, nil
+	}
+
+	result.Queued, err = s.repo.EnqueueRepair(ctx, result.Drift)
+	if err != nil {
+		logrus.WithError(err).Error("unable to queue repair")
+		return result, errors.New("unable to queue repair")
+	}
+
+	logrus.WithFields(logrus.Fields{"queued": result.Queued, "retried": result.Retried}).Info("graph repair queued")
+	return result, nil
+}
+
+// Rebuild writes every node and edge from postgres straight to the graph. Without wipe only the nodes and edges
+// that no longer exist in postgres are removed before everything is merged, so it is safe to run repeatedly.
+func (s *service) Rebuild(ctx context.Context, wipe bool) (RebuildResult, error) {
+	drift, err := s.Drift(ctx)
+	if err != nil {
+		return RebuildResult{}, err
+	}
+
+	result, err := s.repo.Rebuild(ctx, drift, wipe)
+	if err != nil {
+		logrus.WithError(err).Error("unable to rebuild graph")
+		return result, errors.New("unable to rebuild graph")
+	}
+	result.Before = drift
+	result.Wiped = wipe
+
+	logrus.WithFields(logrus.Fields{"nodes": result.Nodes, "edges": result.Edges, "wipe": wipe}).Info("graph rebuilt")
+	return result, nil
+}
+
+func diffNodes(source, current []Node) (missing []Node, extra []Node) {
+	missing, extra = []Node{}, []Node{}
+	currentNodes := make(map[string]Node)
+	for _, n := range current {
+		currentNodes[n.ID] = n
+	}
+	sourceIDs := make(map[string]bool)
+	for _, n := range source {
+		sourceIDs[n.ID] = true
+		// a node in the wrong library is rewritten, tagged resources are looked up by owner
+		if c, ok := currentNodes[n.ID]; !ok || c.Label != n.Label || c.Owner != n.Owner {
+			missing = append(missing, n)
+		}
+	}
+	for _, n := range current {
+		if !sourceIDs[n.ID] {
+			extra = append(extra, n)
+		}
+	}
+	return missing, extra
+}
+
+func diffEdges(source, current []Edge) (missing []Edge, extra []Edge) {
+	missing, extra = []Edge{}, []Edge{}
+	key := func(e Edge) string { return e.ResourceID + ":" + e.TagID }
+	currentKeys := make(map[string]bool)
+	for _, e := range current {
+		currentKeys[key(e)] = true
+	}
+	sourceKeys := make(map[string]bool)
+	for _, e := range source {
+		sourceKeys[key(e)] = true
+		if !currentKeys[key(e)] {
+			missing = append(missing, e)
+		}
+	}
+	for _, e := range current {
+		if !sourceKeys[key(e)] {
+			extra = append(extra, e)
+		}
+	}
+	return missing, extra
+}
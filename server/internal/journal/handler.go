@@ -27,12 +27,19 @@ func MakeJournalHandler(mr *mux.Router, repo Repository) http.Handler {
 func (h *journalHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entities, err := h.repo.FindAllEntries()
+	page, err := common.ParsePageRequest(r, SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "journal", err.Error(), "findall")
+		return
+	}
+
+	entities, next, err := h.repo.FindEntriesPage(ctx, page)
 	if err != nil {
 		common.MakeError(w, http.StatusBadRequest, "journal", "Server error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(ctx, w, entities)
 }
 
@@ -49,7 +56,7 @@ func (h *journalHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entity, err := h.repo.CreateEntry(entity)
+	entity, err := h.repo.CreateEntry(ctx, entity)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "journal", "Server error", "create")
 		return
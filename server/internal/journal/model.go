@@ -6,4 +6,5 @@ type Entry struct {
 	ID      string    `json:"id"`
 	Content string    `json:"content"`
 	Created time.Time `json:"created"`
+	Owner   string    `json:"owner_id"`
 }
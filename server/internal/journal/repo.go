@@ -1,6 +1,15 @@
 package journal
 
+import (
+	"alexandria/internal/common"
+	"context"
+)
+
+// SortOptions are the orderings accepted when listing journal entries
+var SortOptions = common.SortOptions{Keys: []string{"created"}, Order: common.OrderDesc}
+
 type Repository interface {
-	FindAllEntries() ([]Entry, error)
-	CreateEntry(Entry) (Entry, error)
+	FindAllEntries(ctx context.Context) ([]Entry, error)
+	FindEntriesPage(ctx context.Context, page common.PageRequest) ([]Entry, string, error)
+	CreateEntry(ctx context.Context, entry Entry) (Entry, error)
 }
@@ -2,6 +2,7 @@ package links
 
 import (
 	"alexandria/internal/common"
+	"alexandria/internal/tags"
 	"encoding/json"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -30,12 +31,19 @@ func MakeLinksHandler(mr *mux.Router, service Service) http.Handler {
 func (h *linkHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entities, err := h.service.FindAll()
+	page, err := common.ParsePageRequest(r, SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "links", err.Error(), "findall")
+		return
+	}
+
+	entities, next, err := h.service.FindAll(ctx, page)
 	if err != nil {
 		common.MakeError(w, http.StatusBadRequest, "links", "Server error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(ctx, w, entities)
 }
 
@@ -45,7 +53,11 @@ func (h *linkHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	entity, err := h.service.FindByID(id)
+	entity, err := h.service.FindByID(ctx, id)
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "links", "Not Found", "find")
+		return
+	}
 	if err != nil {
 		common.MakeError(w, http.StatusBadRequest, "links", "Server error", "find")
 		return
@@ -67,7 +79,7 @@ func (h *linkHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entity, err := h.service.Create(entity)
+	entity, err := h.service.Create(ctx, entity)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "create")
 		return
@@ -92,12 +104,16 @@ func (h *linkHandler) AddTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.AddTag(id, req.Tag); err != nil {
+	if err := h.service.AddTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "addTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "addTag")
 		return
 	}
 
-	entity, err := h.service.FindByID(id)
+	entity, err := h.service.FindByID(ctx, id)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "addTag")
 		return
@@ -122,12 +138,16 @@ func (h *linkHandler) RemoveTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.RemoveTag(id, req.Tag); err != nil {
+	if err := h.service.RemoveTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "removeTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "removeTag")
 		return
 	}
 
-	entity, err := h.service.FindByID(id)
+	entity, err := h.service.FindByID(ctx, id)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "removeTag")
 		return
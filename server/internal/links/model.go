@@ -11,4 +11,6 @@ type Link struct {
 	IconPath    string    `json:"icon_path"`
 	Tags        []string  `json:"tag_ids"`
 	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Owner       string    `json:"owner_id"`
 }
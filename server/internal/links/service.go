@@ -1,7 +1,9 @@
 package links
 
 import (
+	"alexandria/internal/common"
 	"alexandria/internal/tags"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
@@ -12,18 +14,24 @@ import (
 	"strings"
 )
 
+var ErrNotFound = errors.New("link not found")
+
+// SortOptions are the orderings accepted when listing links
+var SortOptions = common.SortOptions{Keys: []string{"created", "name", "updated"}, Order: common.OrderDesc}
+
 type Repository interface {
-	FindAllLinks() ([]Link, error)
-	FindLinkByID(id string) (Link, error)
-	CreateLink(Link) (Link, error)
+	FindAllLinks(ctx context.Context) ([]Link, error)
+	FindLinksPage(ctx context.Context, page common.PageRequest) ([]Link, string, error)
+	FindLinkByID(ctx context.Context, id string) (Link, error)
+	CreateLink(ctx context.Context, link Link) (Link, error)
 }
 
 type Service interface {
-	FindAll() ([]Link, error)
-	FindByID(id string) (Link, error)
-	Create(Link) (Link, error)
-	AddTag(id string, tag string) error
-	RemoveTag(id string, tag string) error
+	FindAll(ctx context.Context, page common.PageRequest) ([]Link, string, error)
+	FindByID(ctx context.Context, id string) (Link, error)
+	Create(ctx context.Context, link Link) (Link, error)
+	AddTag(ctx context.Context, id string, tag string) error
+	RemoveTag(ctx context.Context, id string, tag string) error
 }
 
 type service struct {
@@ -38,11 +46,11 @@ func NewService(repo Repository, tagsRepo tags.Repository) Service {
 	}
 }
 
-func (s *service) FindAll() ([]Link, error) {
-	return s.repo.FindAllLinks()
+func (s *service) FindAll(ctx context.Context, page common.PageRequest) ([]Link, string, error) {
+	return s.repo.FindLinksPage(ctx, page)
 }
 
-func (s *service) Create(entity Link) (linkEntity Link, err error) {
+func (s *service) Create(ctx context.Context, entity Link) (linkEntity Link, err error) {
 
 	link := entity.Link
 	if link == "" {
@@ -88,17 +96,17 @@ func (s *service) Create(entity Link) (linkEntity Link, err error) {
 		IconPath:    parseIcon(link, icon),
 	}
 
-	return s.repo.CreateLink(linkEntity)
+	return s.repo.CreateLink(ctx, linkEntity)
 }
-func (s *service) FindByID(id string) (Link, error) {
-	return s.repo.FindLinkByID(id)
+func (s *service) FindByID(ctx context.Context, id string) (Link, error) {
+	return s.repo.FindLinkByID(ctx, id)
 }
-func (s *service) AddTag(id string, tag string) error {
-	return s.tagsRepo.AddResourceTag(id, tags.LinksResource, tag)
+func (s *service) AddTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.AddResourceTag(ctx, id, tags.LinksResource, tag)
 }
 
-func (s *service) RemoveTag(id string, tag string) error {
-	return s.tagsRepo.RemoveResourceTag(id, tag)
+func (s *service) RemoveTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.RemoveResourceTag(ctx, id, tag)
 }
 
 func parseIcon(website, path string) string {
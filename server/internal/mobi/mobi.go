@@ -0,0 +1,269 @@
+// Package mobi reads the metadata and text of MOBI and AZW3 (KF8) ebooks. Both are PalmDB files holding a
+// MOBI header and EXTH metadata in the first record, followed by the compressed text and then the images.
+// See https://wiki.mobileread.com/wiki/MOBI
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalid     = errors.New("not a mobi file")
+	ErrEncrypted   = errors.New("mobi file is drm protected")
+	ErrCompression = errors.New("mobi compression not supported")
+)
+
+// EXTH record types
+const (
+	Author      = 100
+	Publisher   = 101
+	Description = 103
+	ISBN        = 104
+	Subject     = 105
+	Published   = 106
+	Title       = 503
+	Language    = 524
+)
+
+const (
+	compressionNone    = 1
+	compressionPalmDOC = 2
+	utf8Encoding       = 65001
+)
+
+type Book struct {
+	r          io.ReaderAt
+	offsets    []int64
+	size       int64
+	record0    []byte
+	encoding   uint32
+	textCount  int
+	extraFlags uint16
+	exth       map[uint32][][]byte
+}
+
+// Open reads the record list and first record, the text is only read when asked for
+func Open(r io.ReaderAt, size int64) (*Book, error) {
+	header := make([]byte, 78)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[60:68]) != "BOOKMOBI" {
+		return nil, ErrInvalid
+	}
+	count := int(binary.BigEndian.Uint16(header[76:]))
+	list := make([]byte, count*8)
+	if _, err := r.ReadAt(list, 78); err != nil || count == 0 {
+		return nil, ErrInvalid
+	}
+
+	b := &Book{r: r, size: size, exth: map[uint32][][]byte{}}
+	for i := 0; i < count; i++ {
+		offset := int64(binary.BigEndian.Uint32(list[i*8:]))
+		if offset > size {
+			return nil, ErrInvalid
+		}
+		b.offsets = append(b.offsets, offset)
+	}
+
+	rec, err := b.record(0)
+	if err != nil || len(rec) < 132 || string(rec[16:20]) != "MOBI" {
+		return nil, ErrInvalid
+	}
+	b.record0 = rec
+	if binary.BigEndian.Uint16(rec[12:]) != 0 {
+		return nil, ErrEncrypted
+	}
+	headerLength := binary.BigEndian.Uint32(rec[20:])
+	b.encoding = binary.BigEndian.Uint32(rec[28:])
+	b.textCount = int(binary.BigEndian.Uint16(rec[8:]))
+	if headerLength >= 0xE4 && len(rec) >= 0xF4 {
+		b.extraFlags = binary.BigEndian.Uint16(rec[0xF2:])
+	}
+	if binary.BigEndian.Uint32(rec[128:])&0x40 != 0 {
+		b.readEXTH(rec[min(16+int(headerLength), len(rec)):])
+	}
+	return b, nil
+}
+
+func (b *Book) readEXTH(data []byte) {
+	if len(data) < 12 || string(data[:4]) != "EXTH" {
+		return
+	}
+	count := binary.BigEndian.Uint32(data[8:])
+	data = data[12:]
+	for i := uint32(0); i < count && len(data) >= 8; i++ {
+		kind := binary.BigEndian.Uint32(data)
+		length := int(binary.BigEndian.Uint32(data[4:]))
+		if length < 8 || length > len(data) {
+			return
+		}
+		b.exth[kind] = append(b.exth[kind], data[8:length])
+		data = data[length:]
+	}
+}
+
+func (b *Book) record(i int) ([]byte, error) {
+	if i < 0 || i >= len(b.offsets) {
+		return nil, ErrInvalid
+	}
+	end := b.size
+	if i+1 < len(b.offsets) {
+		end = b.offsets[i+1]
+	}
+	if end < b.offsets[i] {
+		return nil, ErrInvalid
+	}
+	data := make([]byte, end-b.offsets[i])
+	if _, err := b.r.ReadAt(data, b.offsets[i]); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Values returns every EXTH record of a type as text
+func (b *Book) Values(kind uint32) []string {
+	values := []string{}
+	for _, value := range b.exth[kind] {
+		if text := strings.TrimSpace(b.decode(value)); text != "" {
+			values = append(values, text)
+		}
+	}
+	return values
+}
+
+func (b *Book) Value(kind uint32) string {
+	if values := b.Values(kind); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Title prefers the EXTH title over the full name kept in the header
+func (b *Book) Title() string {
+	if title := b.Value(Title); title != "" {
+		return title
+	}
+	offset := int(binary.BigEndian.Uint32(b.record0[84:]))
+	length := int(binary.BigEndian.Uint32(b.record0[88:]))
+	if offset+length > len(b.record0) {
+		return ""
+	}
+	return strings.TrimSpace(b.decode(b.record0[offset : offset+length]))
+}
+
+// Text decompresses the text records, the result is the html-like markup of the book
+func (b *Book) Text() (string, error) {
+	compression := binary.BigEndian.Uint16(b.record0)
+	if compression != compressionNone && compression != compressionPalmDOC {
+		return "", ErrCompression
+	}
+	var text bytes.Buffer
+	for i := 1; i <= b.textCount; i++ {
+		rec, err := b.record(i)
+		if err != nil {
+			return "", err
+		}
+		rec = rec[:len(rec)-trailingSize(rec, b.extraFlags)]
+		if compression == compressionPalmDOC {
+			rec = decompress(rec)
+		}
+		text.Write(rec)
+	}
+	return b.decode(text.Bytes()), nil
+}
+
+// trailingSize is the size of the entries appended to a text record, they are described by the extra flags and
+// read backwards from the end of the record
+func trailingSize(data []byte, flags uint16) int {
+	size := 0
+	for f := flags >> 1; f != 0; f >>= 1 {
+		if f&1 == 0 {
+			continue
+		}
+		value, shift := 0, uint(0)
+		for pos := len(data) - size - 1; pos >= 0; pos-- {
+			c := data[pos]
+			value |= int(c&0x7F) << shift
+			shift += 7
+			if c&0x80 != 0 || shift >= 28 {
+				break
+			}
+		}
+		size += value
+	}
+	if flags&1 != 0 && len(data) > size {
+		size += int(data[len(data)-size-1]&0x3) + 1
+	}
+	if size > len(data) {
+		return len(data)
+	}
+	return size
+}
+
+// decompress undoes PalmDOC compression, a byte oriented LZ77 with space folding
+func decompress(in []byte) []byte {
+	out := make([]byte, 0, 4096)
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		switch {
+		case c >= 1 && c <= 8:
+			end := i + 1 + int(c)
+			if end > len(in) {
+				end = len(in)
+			}
+			out = append(out, in[i+1:end]...)
+			i = end - 1
+		case c < 0x80:
+			out = append(out, c)
+		case c >= 0xC0:
+			out = append(out, ' ', c^0x80)
+		default:
+			if i+1 >= len(in) {
+				return out
+			}
+			pair := int(c)<<8 | int(in[i+1])
+			i++
+			distance := (pair >> 3) & 0x7FF
+			length := pair&7 + 3
+			if distance == 0 || distance > len(out) {
+				continue
+			}
+			for j := 0; j < length; j++ {
+				out = append(out, out[len(out)-distance])
+			}
+		}
+	}
+	return out
+}
+
+// cp1252 holds the characters windows-1252 puts where latin-1 has control codes
+var cp1252 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š',
+	0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+	0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+func (b *Book) decode(data []byte) string {
+	if b.encoding == utf8Encoding {
+		return strings.ToValidUTF8(string(data), "")
+	}
+	var s strings.Builder
+	for _, c := range data {
+		if r, ok := cp1252[c]; ok {
+			s.WriteRune(r)
+		} else if c < utf8.RuneSelf || c >= 0xA0 {
+			s.WriteRune(rune(c))
+		}
+	}
+	return s.String()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -24,7 +24,7 @@ func MakeNetworkHandler(mr *mux.Router, service Service) http.Handler {
 func (h *networkHandler) GetNetwork(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entity, err := h.service.GetNetwork()
+	entity, err := h.service.GetNetwork(ctx)
 	if err != nil {
 		common.MakeError(w, http.StatusBadRequest, "network", "Server error", "get")
 		return
@@ -2,6 +2,7 @@ package network
 
 import (
 	"alexandria/internal/backup"
+	"context"
 	"errors"
 	"github.com/sirupsen/logrus"
 )
@@ -11,7 +12,7 @@ type service struct {
 }
 
 type Service interface {
-	GetNetwork() (Network, error)
+	GetNetwork(ctx context.Context) (Network, error)
 }
 
 func NewService(aggService backup.SystemAggregator) Service {
@@ -20,8 +21,8 @@ func NewService(aggService backup.SystemAggregator) Service {
 	}
 }
 
-func (s *service) GetNetwork() (n Network, err error) {
-	b, err := s.aggService.AggregateAllData()
+func (s *service) GetNetwork(ctx context.Context) (n Network, err error) {
+	b, err := s.aggService.AggregateAllData(ctx)
 
 	if err != nil {
 		logrus.WithError(err).Error("unable to get aggregations")
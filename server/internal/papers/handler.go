@@ -1,13 +1,17 @@
 package papers
 
 import (
+	"alexandria/internal/citations"
 	"alexandria/internal/common"
 	"alexandria/internal/documents"
+	"alexandria/internal/tags"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 func MakePaperHandler(mr *mux.Router, service PaperService) http.Handler {
@@ -18,6 +22,8 @@ func MakePaperHandler(mr *mux.Router, service PaperService) http.Handler {
 	}
 
 	r.HandleFunc("/", h.FindAll).Methods("GET")
+	r.HandleFunc("/export", h.Export).Methods("GET")
+	r.HandleFunc("/import", h.Import).Methods("POST")
 	r.HandleFunc("/{id}", h.FindByID).Methods("GET")
 	r.HandleFunc("/", h.Create).Methods("POST")
 	r.HandleFunc("/{id}/tags/", h.AddTag).Methods("POST")
@@ -44,10 +50,10 @@ func (h *paperHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	displayName, ok := r.MultipartForm.Value["name"]
-	if !ok {
-		common.MakeError(w, http.StatusBadRequest, "paper", "Name missing from form", "create")
-		return
+	// without a name the title read from the file is used, or else the file name
+	displayName := r.MultipartForm.Value["name"]
+	if len(displayName) == 0 {
+		displayName = []string{""}
 	}
 
 	book := &documents.Document{
@@ -57,6 +63,11 @@ func (h *paperHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.Add(ctx, file, book); err != nil {
+		duplicate := &documents.DuplicateError{}
+		if errors.As(err, &duplicate) {
+			documents.WriteDuplicate(w, r, duplicate)
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "book", err.Error(), "add")
 		return
 	}
@@ -68,16 +79,90 @@ func (h *paperHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *paperHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entity, err := h.service.FindAll(ctx)
+	page, err := common.ParsePageRequest(r, documents.SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "paper", err.Error(), "findall")
+		return
+	}
+
+	filter, err := documents.ParseFilter(r)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "paper", err.Error(), "findall")
+		return
+	}
+
+	entity, next, err := h.service.FindAll(ctx, filter, page)
 
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "paper", "Server Error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(r.Context(), w, entity)
 }
 
+func (h *paperHandler) Export(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = citations.FormatBibTeX
+	}
+	if !citations.Supported(format) {
+		common.MakeError(w, http.StatusBadRequest, "paper", citations.ErrUnsupportedFormat.Error(), "export")
+		return
+	}
+
+	// format names the citation format here, not the file format the document filter reads
+	query := r.URL.Query()
+	query.Del("format")
+	fr := r.Clone(ctx)
+	fr.URL.RawQuery = query.Encode()
+	filter, err := documents.ParseFilter(fr)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "paper", err.Error(), "export")
+		return
+	}
+
+	w.Header().Set("Content-Type", citations.ContentType(format))
+	w.Header().Set("Content-Disposition", "attachment; filename=papers"+citations.Extension(format))
+	if err := h.service.Export(ctx, w, format, filter); err != nil {
+		logrus.WithError(err).Error("unable to export papers")
+		common.MakeError(w, http.StatusInternalServerError, "paper", "Server Error", "export")
+		return
+	}
+}
+
+func (h *paperHandler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "paper", "Unable to parse form", "import")
+		return
+	}
+	defer file.Close()
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = citations.FormatFromName(fileHeader.Filename)
+	}
+
+	result, err := h.service.Import(ctx, file, format)
+	if errors.Is(err, citations.ErrUnsupportedFormat) || errors.Is(err, citations.ErrInvalidFile) {
+		common.MakeError(w, http.StatusBadRequest, "paper", err.Error(), "import")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "paper", "Server Error", "import")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(ctx, w, result)
+}
+
 func (h *paperHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -91,6 +176,10 @@ func (h *paperHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 
 	entity, err := h.service.FindByID(ctx, id)
 
+	if err == documents.ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "document", "Not Found", "findbyid")
+		return
+	}
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "document", "Server Error", "findbyid")
 		return
@@ -115,7 +204,11 @@ func (h *paperHandler) AddTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.AddTag(id, req.Tag); err != nil {
+	if err := h.service.AddTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "addTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "addTag")
 		return
 	}
@@ -145,7 +238,11 @@ func (h *paperHandler) RemoveTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.RemoveTag(id, req.Tag); err != nil {
+	if err := h.service.RemoveTag(ctx, id, req.Tag); err != nil {
+		if err == tags.ErrResourceNotFound {
+			common.MakeError(w, http.StatusNotFound, "links", "Not Found", "removeTag")
+			return
+		}
 		common.MakeError(w, http.StatusInternalServerError, "links", "Server error", "removeTag")
 		return
 	}
@@ -1,20 +1,41 @@
 package papers
 
 import (
+	"alexandria/internal/citations"
+	"alexandria/internal/common"
 	"alexandria/internal/documents"
 	"alexandria/internal/tags"
 	"context"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"io"
 	"mime/multipart"
+	"strings"
 )
 
 type PaperService interface {
-	FindAll(ctx context.Context) ([]*documents.Document, error)
+	FindAll(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error)
 	FindByID(ctx context.Context, id string) (*documents.Document, error)
 	Add(ctx context.Context, file multipart.File, paper *documents.Document) error
-	AddTag(id string, tag string) error
-	RemoveTag(id string, tag string) error
+	AddTag(ctx context.Context, id string, tag string) error
+	RemoveTag(ctx context.Context, id string, tag string) error
+	Export(ctx context.Context, w io.Writer, format string, filter documents.Filter) error
+	Import(ctx context.Context, r io.Reader, format string) (*ImportResult, error)
+}
+
+// ImportResult lists the papers created by an import, entries already in the library are skipped and entries that
+// couldn't be saved are listed in Failed so they can be imported again
+type ImportResult struct {
+	Created []*documents.Document `json:"created"`
+	Skipped int                   `json:"skipped"`
+	Failed  []ImportFailure       `json:"failed"`
+}
+
+// ImportFailure is an entry of the bibliography that wasn't saved
+type ImportFailure struct {
+	CitationKey string `json:"citation_key,omitempty"`
+	Title       string `json:"title"`
+	Error       string `json:"error"`
 }
 
 type service struct {
@@ -29,18 +50,21 @@ func NewPaperService(docService documents.DocumentService, tagsRepo tags.Reposit
 	}
 }
 
-func (s *service) FindAll(ctx context.Context) ([]*documents.Document, error) {
-	m := map[string]interface{}{"type": "paper"}
-	entities, err := s.docService.FindAll(ctx, m)
+func (s *service) FindAll(ctx context.Context, filter documents.Filter, page common.PageRequest) ([]*documents.Document, string, error) {
+	filter.Type = "paper"
+	entities, next, err := s.docService.FindPage(ctx, filter, page)
 	if err != nil {
 		logrus.WithError(err).Error("unable to fetch papers from repository")
-		return nil, errors.Wrap(err, "unable to fetch from repository")
+		return nil, "", errors.Wrap(err, "unable to fetch from repository")
 	}
-	return entities, nil
+	return entities, next, nil
 }
 
 func (s *service) FindByID(ctx context.Context, id string) (*documents.Document, error) {
 	entity, err := s.docService.FindByID(ctx, id)
+	if err == documents.ErrNotFound {
+		return nil, err
+	}
 	if err != nil {
 		logrus.WithError(err).WithField("id", id).Error("unable to fetch paper from repository")
 		return nil, errors.Wrap(err, "unable to fetch from repository")
@@ -59,10 +83,87 @@ func (s *service) Add(ctx context.Context, file multipart.File, paper *documents
 	return nil
 }
 
-func (s *service) AddTag(id string, tag string) error {
-	return s.tagsRepo.AddResourceTag(id, tags.PaperResource, tag)
+func (s *service) AddTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.AddResourceTag(ctx, id, tags.PaperResource, tag)
+}
+
+func (s *service) RemoveTag(ctx context.Context, id string, tag string) error {
+	return s.tagsRepo.RemoveResourceTag(ctx, id, tag)
 }
 
-func (s *service) RemoveTag(id string, tag string) error {
-	return s.tagsRepo.RemoveResourceTag(id, tag)
+func (s *service) Export(ctx context.Context, w io.Writer, format string, filter documents.Filter) error {
+	filter.Type = "paper"
+	entities, err := s.docService.FindAll(ctx, filter)
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch papers from repository")
+		return errors.Wrap(err, "unable to fetch from repository")
+	}
+	return citations.Encode(w, format, entities)
+}
+
+func (s *service) Import(ctx context.Context, r io.Reader, format string) (*ImportResult, error) {
+	entries, err := citations.Decode(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.docService.FindAll(ctx, documents.Filter{Type: "paper"})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch papers from repository")
+		return nil, errors.Wrap(err, "unable to fetch from repository")
+	}
+	seen := map[string]bool{}
+	for _, paper := range existing {
+		for _, key := range paperKeys(paper) {
+			seen[key] = true
+		}
+	}
+
+	result := &ImportResult{Created: []*documents.Document{}, Failed: []ImportFailure{}}
+	for _, entry := range entries {
+		keys := paperKeys(entry)
+		duplicate := len(keys) == 0
+		for _, key := range keys {
+			duplicate = duplicate || seen[key]
+		}
+		if duplicate {
+			result.Skipped++
+			continue
+		}
+
+		if err := s.docService.AddRecord(ctx, entry); err != nil {
+			logrus.WithError(err).WithField("title", entry.Title).Error("unable to save imported paper")
+			result.Failed = append(result.Failed, ImportFailure{
+				CitationKey: entry.CitationKey,
+				Title:       entry.Title,
+				Error:       "unable to save paper",
+			})
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		result.Created = append(result.Created, entry)
+	}
+
+	// nothing saved at all means the library itself is failing rather than the entries
+	if len(result.Created) == 0 && len(result.Failed) > 0 {
+		return nil, errors.New("failed to store data in repo")
+	}
+	return result, nil
+}
+
+// paperKeys identify a paper when matching an import against the library, entries without any are skipped
+func paperKeys(paper *documents.Document) []string {
+	keys := []string{}
+	if paper.DOI != "" {
+		keys = append(keys, "doi:"+strings.ToLower(paper.DOI))
+	}
+	if paper.ArXivID != "" {
+		keys = append(keys, "arxiv:"+paper.ArXivID)
+	}
+	if title := strings.ToLower(strings.Join(strings.Fields(paper.Title), " ")); title != "" {
+		keys = append(keys, "title:"+title)
+	}
+	return keys
 }
@@ -0,0 +1,241 @@
+package search
+
+import (
+	"alexandria/internal/mobi"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format for extraction")
+	ErrUnreadable        = errors.New("document is unreadable")
+)
+
+// unreadable marks a failure caused by the file itself, as opposed to a missing tool or storage, extracting it
+// again won't get any further
+func unreadable(message string) error {
+	return fmt.Errorf("%s: %w", message, ErrUnreadable)
+}
+
+// Extract pulls the text out of a document, split by page. Pdfs and djvus are split on the form feeds written by
+// pdftotext and djvutxt, epubs use each item of the spine as a page, mobis their page breaks and markdown notes
+// their headings. Comics have no text so they have no pages.
+func Extract(ctx context.Context, ext string, r io.Reader) ([]Page, error) {
+	switch strings.ToLower(ext) {
+	case ".pdf":
+		// pdftotext -enc UTF-8 input.pdf - writes every page to stdout separated by a form feed
+		return extractPages(ctx, r, "pdftotext", "-enc", "UTF-8")
+	case ".djvu", ".djv":
+		return extractPages(ctx, r, "djvutxt")
+	case ".epub":
+		return extractEPUB(r)
+	case ".mobi", ".prc", ".azw", ".azw3":
+		return extractMOBI(r)
+	case ".cbz":
+		return nil, nil
+	case ".md", ".markdown":
+		return extractMarkdown(r)
+	case ".html", ".htm":
+		return extractHTML(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// extractPages runs a tool that takes the file followed by - and writes the text of every page to stdout
+func extractPages(ctx context.Context, r io.Reader, tool string, args ...string) ([]Page, error) {
+	file, err := ioutil.TempFile("", "extract-*")
+	if err != nil {
+		logrus.WithError(err).Error("unable to create temp file")
+		return nil, errors.New("unable to create temp file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		logrus.WithError(err).Error("unable to write temp file")
+		return nil, errors.New("unable to write temp file")
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, append(args, file.Name(), "-")...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		logrus.WithError(err).Errorf("unable to run %s", tool)
+		return nil, fmt.Errorf("unable to run %s", tool)
+	}
+
+	var pages []Page
+	for i, content := range strings.Split(out.String(), "\f") {
+		pages = appendPage(pages, i+1, content)
+	}
+	return pages, nil
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+func extractEPUB(r io.Reader) ([]Page, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		logrus.WithError(err).Error("unable to read epub")
+		return nil, errors.New("unable to read epub")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		logrus.WithError(err).Error("unable to open epub archive")
+		return nil, unreadable("unable to open epub archive")
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	container := epubContainer{}
+	if err := decodeZipXML(files["META-INF/container.xml"], &container); err != nil || len(container.Rootfiles) == 0 {
+		logrus.WithError(err).Error("unable to read epub container")
+		return nil, unreadable("unable to read epub container")
+	}
+	rootPath := container.Rootfiles[0].FullPath
+
+	pkg := epubPackage{}
+	if err := decodeZipXML(files[rootPath], &pkg); err != nil {
+		logrus.WithError(err).Error("unable to read epub package")
+		return nil, unreadable("unable to read epub package")
+	}
+
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = path.Join(path.Dir(rootPath), item.Href)
+	}
+
+	var pages []Page
+	for i, ref := range pkg.Spine {
+		f, ok := files[hrefs[ref.IDRef]]
+		if !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			logrus.WithError(err).WithField("file", f.Name).Warn("unable to open epub chapter")
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(rc)
+		rc.Close()
+		if err != nil {
+			logrus.WithError(err).WithField("file", f.Name).Warn("unable to parse epub chapter")
+			continue
+		}
+		pages = appendPage(pages, i+1, doc.Find("body").Text())
+	}
+	return pages, nil
+}
+
+// mobiPages splits the markup of a mobi on its page breaks, and of a KF8 book on the start of each of its files
+var mobiPages = regexp.MustCompile(`(?i)<mbp:pagebreak[^>]*>|<\?xml[^>]*>`)
+
+func extractMOBI(r io.Reader) ([]Page, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		logrus.WithError(err).Error("unable to read mobi")
+		return nil, errors.New("unable to read mobi")
+	}
+	book, err := mobi.Open(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		logrus.WithError(err).Error("unable to open mobi")
+		return nil, unreadable("unable to open mobi")
+	}
+	text, err := book.Text()
+	if err != nil {
+		logrus.WithError(err).Error("unable to read mobi text")
+		return nil, unreadable("unable to read mobi text")
+	}
+
+	var pages []Page
+	for _, part := range mobiPages.Split(text, -1) {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(part))
+		if err != nil {
+			logrus.WithError(err).Warn("unable to parse mobi page")
+			continue
+		}
+		pages = appendPage(pages, len(pages)+1, doc.Text())
+	}
+	return pages, nil
+}
+
+var markdownHeading = regexp.MustCompile(`(?m)^#{1,6} `)
+
+// extractMarkdown makes a page of every section, the text before the first heading is a page of its own
+func extractMarkdown(r io.Reader) ([]Page, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		logrus.WithError(err).Error("unable to read markdown")
+		return nil, errors.New("unable to read markdown")
+	}
+	text := string(b)
+
+	var pages []Page
+	start := 0
+	for _, loc := range markdownHeading.FindAllStringIndex(text, -1) {
+		pages = appendPage(pages, len(pages)+1, text[start:loc[0]])
+		start = loc[0]
+	}
+	return appendPage(pages, len(pages)+1, text[start:]), nil
+}
+
+func extractHTML(r io.Reader) ([]Page, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		logrus.WithError(err).Error("unable to parse html")
+		return nil, errors.New("unable to parse html")
+	}
+	doc.Find("script, style").Remove()
+	return appendPage(nil, 1, doc.Find("body").Text()), nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("file missing from archive")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+func appendPage(pages []Page, number int, content string) []Page {
+	content = strings.ReplaceAll(content, "\x00", "")
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return pages
+	}
+	return append(pages, Page{Number: number, Content: content})
+}
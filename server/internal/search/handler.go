@@ -0,0 +1,38 @@
+package search
+
+import (
+	"alexandria/internal/common"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type searchHandler struct {
+	service Service
+}
+
+func MakeSearchHandler(mr *mux.Router, service Service) http.Handler {
+	r := mr.PathPrefix("/search").Subrouter()
+	h := &searchHandler{
+		service: service,
+	}
+	r.HandleFunc("", h.Search).Methods("GET")
+	r.HandleFunc("/", h.Search).Methods("GET")
+
+	return r
+}
+
+func (h *searchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	results, err := h.service.Search(ctx, r.URL.Query().Get("q"))
+	if err == ErrEmptyQuery {
+		common.MakeError(w, http.StatusBadRequest, "search", "Missing Query", "search")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "search", "Server error", "search")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, results)
+}
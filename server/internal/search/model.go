@@ -0,0 +1,22 @@
+package search
+
+type Result struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	DisplayName string  `json:"display_name"`
+	Page        int     `json:"page"`
+	Snippet     string  `json:"snippet"`
+	Rank        float64 `json:"rank"`
+}
+
+// Page is the extracted text of a single page (or chapter for epubs) of a document
+type Page struct {
+	Number  int
+	Content string
+}
+
+// Source is a stored document that has not had its contents indexed yet
+type Source struct {
+	ID   string
+	Path string
+}
@@ -0,0 +1,110 @@
+package search
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrEmptyQuery = errors.New("query cannot be empty")
+)
+
+const maxResults = 50
+
+type Service interface {
+	Search(ctx context.Context, query string) ([]Result, error)
+}
+
+type Indexer interface {
+	Index(ctx context.Context, id, path string) error
+	IndexMissing(ctx context.Context) error
+}
+
+type Repository interface {
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+	// IndexPages replaces the pages of a document and marks it indexed
+	IndexPages(ctx context.Context, id string, pages []Page) error
+	// MarkIndexed records an extraction that failed on the file itself, it won't get better by trying again
+	MarkIndexed(ctx context.Context, id string) error
+	// FindUnindexed lists the documents with a file that hasn't been through extraction
+	FindUnindexed(ctx context.Context) ([]Source, error)
+}
+
+type service struct {
+	repo    Repository
+	storage common.DocumentStorage
+}
+
+func NewService(repo Repository, storage common.DocumentStorage) Service {
+	return &service{
+		repo:    repo,
+		storage: storage,
+	}
+}
+
+func NewIndexer(repo Repository, storage common.DocumentStorage) Indexer {
+	return &service{
+		repo:    repo,
+		storage: storage,
+	}
+}
+
+func (s *service) Search(ctx context.Context, query string) ([]Result, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrEmptyQuery
+	}
+	results, err := s.repo.Search(ctx, query, maxResults)
+	if err != nil {
+		logrus.WithError(err).Error("unable to search repository")
+		return nil, errors.New("unable to search repository")
+	}
+	return results, nil
+}
+
+func (s *service) Index(ctx context.Context, id, path string) error {
+	r, err := s.storage.Reader(ctx, path)
+	if err != nil {
+		logrus.WithError(err).WithField("path", path).Error("unable to open document")
+		return errors.New("unable to open document")
+	}
+	defer r.Close()
+
+	pages, err := Extract(ctx, filepath.Ext(path), r)
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to extract text")
+		// only the file itself is given up on, a missing tool or a failed run is tried again on the next start
+		if errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrUnreadable) {
+			if err := s.repo.MarkIndexed(ctx, id); err != nil {
+				logrus.WithError(err).WithField("id", id).Warn("unable to record failed extraction")
+			}
+		}
+		return errors.New("unable to extract text")
+	}
+
+	if err := s.repo.IndexPages(ctx, id, pages); err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to index pages")
+		return errors.New("unable to index pages")
+	}
+
+	logrus.WithFields(logrus.Fields{"id": id, "pages": len(pages)}).Info("document indexed")
+	return nil
+}
+
+func (s *service) IndexMissing(ctx context.Context) error {
+	sources, err := s.repo.FindUnindexed(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find unindexed documents")
+		return errors.New("unable to find unindexed documents")
+	}
+	for _, src := range sources {
+		if err := s.Index(ctx, src.ID, src.Path); err != nil {
+			logrus.WithError(err).WithField("id", src.ID).Warn("skipping document")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package sharing
+
+import (
+	"alexandria/internal/common"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+)
+
+type shareHandler struct {
+	service Service
+}
+
+func MakeShareHandler(mr *mux.Router, service Service) http.Handler {
+	r := mr.PathPrefix("/shares").Subrouter()
+	h := &shareHandler{
+		service: service,
+	}
+	r.HandleFunc("/", h.FindAll).Methods("GET")
+	r.HandleFunc("/", h.Grant).Methods("POST")
+	r.HandleFunc("/with-me", h.FindSharedWithMe).Methods("GET")
+	r.HandleFunc("/public/{token}", h.FindPublic).Methods("GET")
+	r.HandleFunc("/{id}", h.Revoke).Methods("DELETE")
+
+	return r
+}
+
+// FindAll lists the shares the caller has made, resource_id narrows them to one resource
+func (h *shareHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	entities, err := h.service.FindByResource(ctx, r.URL.Query().Get("resource_id"))
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "shares", "Server Error", "findall")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, entities)
+}
+
+func (h *shareHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := GrantRequest{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal share")
+		common.MakeError(w, http.StatusBadRequest, "shares", "Bad Request", "grant")
+		return
+	}
+
+	entity, err := h.service.Grant(ctx, req)
+	switch err {
+	case nil:
+	case ErrInvalidShare, ErrExpired:
+		common.MakeError(w, http.StatusBadRequest, "shares", err.Error(), "grant")
+		return
+	case ErrNotFound:
+		common.MakeError(w, http.StatusNotFound, "shares", "Not Found", "grant")
+		return
+	case ErrNotOwner:
+		common.MakeError(w, http.StatusForbidden, "shares", err.Error(), "grant")
+		return
+	default:
+		common.MakeError(w, http.StatusInternalServerError, "shares", "Server Error", "grant")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(ctx, w, entity)
+}
+
+func (h *shareHandler) FindSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	entities, err := h.service.FindSharedWithMe(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "shares", "Server Error", "withme")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, entities)
+}
+
+// FindPublic tells the holder of a public link what it points to, the resource is then fetched from its own route
+// passing the token as the share query parameter
+func (h *shareHandler) FindPublic(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := mux.Vars(r)["token"]
+
+	entity, err := h.service.FindPublic(ctx, token)
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "shares", "Not Found", "public")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "shares", "Server Error", "public")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, entity)
+}
+
+func (h *shareHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if err := h.service.Revoke(ctx, id); err != nil {
+		if err == ErrNotFound {
+			common.MakeError(w, http.StatusNotFound, "shares", "Not Found", "revoke")
+			return
+		}
+		common.MakeError(w, http.StatusInternalServerError, "shares", "Server Error", "revoke")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, map[string]string{"status": "success"})
+}
@@ -0,0 +1,46 @@
+package sharing
+
+import (
+	"time"
+)
+
+type Role = string
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// TagResource shares every resource carrying the tag
+const TagResource = "tag"
+
+var roleRank = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Share grants a role on a resource to another account, or to anyone holding the token when Grantee is empty
+type Share struct {
+	ID           string     `json:"id"`
+	ResourceID   string     `json:"resource_id"`
+	ResourceType string     `json:"resource_type"`
+	Owner        string     `json:"owner_id"`
+	Grantee      string     `json:"grantee_id,omitempty"`
+	Token        string     `json:"token,omitempty"`
+	Role         Role       `json:"role"`
+	Created      time.Time  `json:"created"`
+	Expires      *time.Time `json:"expires,omitempty"`
+}
+
+// Access is what a caller may do with a resource and whose library it lives in
+type Access struct {
+	Owner string
+	Role  Role
+}
+
+// Allows reports whether the access is at least the required role
+func (a Access) Allows(required Role) bool {
+	return roleRank[a.Role] >= roleRank[required]
+}
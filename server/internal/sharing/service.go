@@ -0,0 +1,183 @@
+package sharing
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("resource not found")
+	ErrInvalidShare = errors.New("shares need an editor or viewer role and either a grantee or public set")
+	ErrNotOwner     = errors.New("only the owner can share a resource")
+	ErrExpired      = errors.New("shares can't expire in the past")
+)
+
+type Repository interface {
+	// FindResourceOwner looks a document, link or tag up in every library
+	FindResourceOwner(ctx context.Context, resourceID string) (resourceType string, owner string, err error)
+	// FindGrantedRoles lists the roles given to the user or token on the resource, directly or through its tags
+	FindGrantedRoles(ctx context.Context, resourceID, userID, token string) ([]Role, error)
+	CreateShare(ctx context.Context, share Share) (Share, error)
+	FindShares(ctx context.Context, owner, resourceID string) ([]Share, error)
+	FindSharesWith(ctx context.Context, grantee string) ([]Share, error)
+	FindShareByToken(ctx context.Context, token string) (Share, error)
+	DeleteShare(ctx context.Context, owner, id string) error
+}
+
+type Service interface {
+	Grant(ctx context.Context, req GrantRequest) (Share, error)
+	Revoke(ctx context.Context, id string) error
+	FindByResource(ctx context.Context, resourceID string) ([]Share, error)
+	FindSharedWithMe(ctx context.Context) ([]Share, error)
+	FindPublic(ctx context.Context, token string) (Share, error)
+	// Authorize works out what the user in the context, or the holder of the token, may do with a resource
+	Authorize(ctx context.Context, resourceID, token string) (Access, error)
+}
+
+// GrantRequest shares a resource with an account, or creates a public link when Public is set
+type GrantRequest struct {
+	ResourceID string     `json:"resource_id"`
+	Grantee    string     `json:"grantee_id"`
+	Public     bool       `json:"public"`
+	Role       Role       `json:"role"`
+	Expires    *time.Time `json:"expires"`
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return &service{
+		repo: repo,
+	}
+}
+
+func (s *service) Grant(ctx context.Context, req GrantRequest) (Share, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return Share{}, common.ErrNoIdentity
+	}
+	if req.Role != RoleEditor && req.Role != RoleViewer {
+		return Share{}, ErrInvalidShare
+	}
+	if req.Public == (req.Grantee != "") || req.Grantee == identity.ID {
+		return Share{}, ErrInvalidShare
+	}
+	if _, err := uuid.Parse(req.Grantee); req.Grantee != "" && err != nil {
+		return Share{}, ErrInvalidShare
+	}
+	// expiries are stored in utc, the zone the reads compare them in
+	if req.Expires != nil {
+		expires := req.Expires.UTC()
+		if !expires.After(time.Now().UTC()) {
+			return Share{}, ErrExpired
+		}
+		req.Expires = &expires
+	}
+
+	resourceType, owner, err := s.repo.FindResourceOwner(ctx, req.ResourceID)
+	if err != nil {
+		return Share{}, err
+	}
+	if owner != identity.ID {
+		return Share{}, ErrNotOwner
+	}
+
+	share := Share{
+		ResourceID:   req.ResourceID,
+		ResourceType: resourceType,
+		Owner:        identity.ID,
+		Grantee:      req.Grantee,
+		Role:         req.Role,
+		Expires:      req.Expires,
+	}
+	if req.Public {
+		if share.Token, err = newToken(); err != nil {
+			return Share{}, err
+		}
+	}
+	return s.repo.CreateShare(ctx, share)
+}
+
+func (s *service) Revoke(ctx context.Context, id string) error {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return common.ErrNoIdentity
+	}
+	return s.repo.DeleteShare(ctx, identity.ID, id)
+}
+
+func (s *service) FindByResource(ctx context.Context, resourceID string) ([]Share, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	return s.repo.FindShares(ctx, identity.ID, resourceID)
+}
+
+func (s *service) FindSharedWithMe(ctx context.Context) ([]Share, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	return s.repo.FindSharesWith(ctx, identity.ID)
+}
+
+// FindPublic resolves a public link, the token itself isn't handed back
+func (s *service) FindPublic(ctx context.Context, token string) (Share, error) {
+	share, err := s.repo.FindShareByToken(ctx, token)
+	if err != nil {
+		return share, err
+	}
+	share.Owner = ""
+	share.Token = ""
+	return share, nil
+}
+
+func (s *service) Authorize(ctx context.Context, resourceID, token string) (Access, error) {
+	_, owner, err := s.repo.FindResourceOwner(ctx, resourceID)
+	if err != nil {
+		return Access{}, err
+	}
+
+	access := Access{Owner: owner}
+	identity, ok := common.IdentityFromContext(ctx)
+	if ok && identity.ID == owner {
+		access.Role = RoleOwner
+		return access, nil
+	}
+	if !ok && token == "" {
+		return access, ErrNotFound
+	}
+
+	roles, err := s.repo.FindGrantedRoles(ctx, resourceID, identity.ID, token)
+	if err != nil {
+		return access, err
+	}
+	for _, role := range roles {
+		if roleRank[role] > roleRank[access.Role] {
+			access.Role = role
+		}
+	}
+	if access.Role == "" {
+		// resources that aren't shared with the caller look the same as ones that don't exist
+		return access, ErrNotFound
+	}
+	return access, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logrus.WithError(err).Error("unable to generate share token")
+		return "", errors.New("unable to generate share token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
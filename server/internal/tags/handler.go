@@ -28,12 +28,19 @@ func MakeLinksHandler(mr *mux.Router, repo Repository) http.Handler {
 func (h *tagHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	entities, err := h.repo.FindAllTags()
+	page, err := common.ParsePageRequest(r, SortOptions)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "tags", err.Error(), "findall")
+		return
+	}
+
+	entities, next, err := h.repo.FindTagsPage(ctx, page)
 	if err != nil {
 		common.MakeError(w, http.StatusBadRequest, "tags", "Server error", "findall")
 		return
 	}
 
+	common.SetNextPage(w, r, next)
 	common.EncodeResponse(ctx, w, entities)
 }
 
@@ -50,7 +57,7 @@ func (h *tagHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entity, err := h.repo.CreateTag(entity)
+	entity, err := h.repo.CreateTag(ctx, entity)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "tags", "Server error", "create")
 		return
@@ -66,7 +73,7 @@ func (h *tagHandler) GetTaggedResource(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := vars["id"]
 
-	entity, err := h.repo.GetTaggedResources(id)
+	entity, err := h.repo.GetTaggedResources(ctx, id)
 	if err != nil {
 		common.MakeError(w, http.StatusInternalServerError, "tags", "Server error", "create")
 		return
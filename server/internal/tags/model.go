@@ -4,6 +4,7 @@ type Tag struct {
 	ID          string `json:"id"`
 	DisplayName string `json:"display_name"`
 	TagColor    Color  `json:"color"`
+	Owner       string `json:"owner_id"`
 }
 
 type TaggedResource struct {
@@ -1,9 +1,22 @@
 package tags
 
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+)
+
+// ErrResourceNotFound is returned when tagging a document or link that isn't in the user's library
+var ErrResourceNotFound = errors.New("resource not found")
+
+// SortOptions are the orderings accepted when listing tags
+var SortOptions = common.SortOptions{Keys: []string{"name"}, Order: common.OrderAsc}
+
 type Repository interface {
-	FindAllTags() ([]Tag, error)
-	CreateTag(Tag) (Tag, error)
-	AddResourceTag(resourceID string, resourceType ResourceType, tagName string) error
-	RemoveResourceTag(resourceID string, tagName string) error
-	GetTaggedResources(id string) ([]TaggedResource, error)
+	FindAllTags(ctx context.Context) ([]Tag, error)
+	FindTagsPage(ctx context.Context, page common.PageRequest) ([]Tag, string, error)
+	CreateTag(ctx context.Context, tag Tag) (Tag, error)
+	AddResourceTag(ctx context.Context, resourceID string, resourceType ResourceType, tagName string) error
+	RemoveResourceTag(ctx context.Context, resourceID string, tagName string) error
+	GetTaggedResources(ctx context.Context, id string) ([]TaggedResource, error)
 }
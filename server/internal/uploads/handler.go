@@ -0,0 +1,179 @@
+package uploads
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// offsetHeader carries the number of bytes received, a chunk is sent with the offset it starts at
+const offsetHeader = "Upload-Offset"
+
+type uploadHandler struct {
+	service Service
+}
+
+func MakeUploadHandler(mr *mux.Router, service Service) http.Handler {
+	r := mr.PathPrefix("/uploads").Subrouter()
+
+	h := &uploadHandler{
+		service: service,
+	}
+
+	r.HandleFunc("", h.Create).Methods("POST")
+	r.HandleFunc("/", h.Create).Methods("POST")
+	r.HandleFunc("/{id}", h.Find).Methods("GET", "HEAD")
+	r.HandleFunc("/{id}", h.Write).Methods("PATCH")
+	r.HandleFunc("/{id}", h.Delete).Methods("DELETE")
+	r.HandleFunc("/{id}/complete", h.Complete).Methods("POST")
+
+	return r
+}
+
+func (h *uploadHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := Upload{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal upload")
+		common.MakeError(w, http.StatusBadRequest, "upload", "Bad Request", "create")
+		return
+	}
+
+	entity, err := h.service.Create(ctx, req)
+	if err == ErrInvalidUpload {
+		common.MakeError(w, http.StatusBadRequest, "upload", err.Error(), "create")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "upload", "Server Error", "create")
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/"+entity.ID)
+	setOffset(w, entity)
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(ctx, w, entity)
+}
+
+func (h *uploadHandler) Find(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	entity, err := h.service.Find(ctx, mux.Vars(r)["id"])
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "upload", "Not Found", "find")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "upload", "Server Error", "find")
+		return
+	}
+
+	setOffset(w, entity)
+	common.EncodeResponse(ctx, w, entity)
+}
+
+func (h *uploadHandler) Write(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	defer r.Body.Close()
+
+	offset, err := strconv.ParseInt(r.Header.Get(offsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		common.MakeError(w, http.StatusBadRequest, "upload", "Upload-Offset header missing or invalid", "write")
+		return
+	}
+
+	entity, err := h.service.Write(ctx, id, offset, r.Body)
+	switch {
+	case err == ErrNotFound:
+		common.MakeError(w, http.StatusNotFound, "upload", "Not Found", "write")
+		return
+	case err == ErrOffsetMismatch:
+		// the client resumes from the offset sent back
+		if entity, err := h.service.Find(ctx, id); err == nil {
+			setOffset(w, entity)
+		}
+		common.MakeError(w, http.StatusConflict, "upload", err.Error(), "write")
+		return
+	case err == ErrTooLarge:
+		common.MakeError(w, http.StatusRequestEntityTooLarge, "upload", err.Error(), "write")
+		return
+	case err != nil:
+		common.MakeError(w, http.StatusInternalServerError, "upload", "Server Error", "write")
+		return
+	}
+
+	setOffset(w, entity)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *uploadHandler) Complete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// the checksum can be left out when it was given on create
+	req := struct {
+		SHA256 string `json:"sha256"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logrus.WithError(err).Error("unable to unmarshal upload")
+			common.MakeError(w, http.StatusBadRequest, "upload", "Bad Request", "complete")
+			return
+		}
+	}
+
+	doc, err := h.service.Complete(ctx, mux.Vars(r)["id"], req.SHA256)
+	duplicate := &documents.DuplicateError{}
+	switch {
+	case err == ErrNotFound:
+		common.MakeError(w, http.StatusNotFound, "upload", "Not Found", "complete")
+		return
+	case err == ErrIncomplete:
+		common.MakeError(w, http.StatusConflict, "upload", err.Error(), "complete")
+		return
+	case err == ErrChecksumRequired:
+		common.MakeError(w, http.StatusBadRequest, "upload", err.Error(), "complete")
+		return
+	case err == ErrChecksumMismatch:
+		common.MakeError(w, http.StatusUnprocessableEntity, "upload", err.Error(), "complete")
+		return
+	case err == documents.ErrInvalidFileType:
+		common.MakeError(w, http.StatusUnsupportedMediaType, "upload", err.Error(), "complete")
+		return
+	case errors.As(err, &duplicate):
+		documents.WriteDuplicate(w, r, duplicate)
+		return
+	case err != nil:
+		common.MakeError(w, http.StatusInternalServerError, "upload", "Server Error", "complete")
+		return
+	}
+
+	w.Header().Set("Location", "/documents/"+doc.ID)
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(ctx, w, doc)
+}
+
+func (h *uploadHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := h.service.Delete(r.Context(), mux.Vars(r)["id"])
+	if err == ErrNotFound {
+		common.MakeError(w, http.StatusNotFound, "upload", "Not Found", "delete")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "upload", "Server Error", "delete")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setOffset(w http.ResponseWriter, upload Upload) {
+	w.Header().Set(offsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+}
@@ -0,0 +1,270 @@
+package uploads
+
+import (
+	"alexandria/internal/common"
+	"alexandria/internal/documents"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("upload not found")
+	ErrInvalidUpload    = errors.New("uploads need a name, a size and a type of book or paper")
+	ErrOffsetMismatch   = errors.New("offset does not match the bytes received")
+	ErrTooLarge         = errors.New("chunk goes past the size of the upload")
+	ErrIncomplete       = errors.New("upload is missing bytes")
+	ErrChecksumRequired = errors.New("sha256 of the file is required")
+	ErrChecksumMismatch = errors.New("sha256 does not match the uploaded bytes")
+)
+
+// uploads that haven't received anything for this long are deleted along with their chunks
+const uploadExpiry = 7 * 24 * time.Hour
+
+// Upload is a file sent in chunks, each chunk is stored in the bucket as it arrives and the document is created from
+// them once every byte has been received
+type Upload struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name"`
+	Type        string    `json:"type"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Owner       string    `json:"owner_id"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Parts       []string  `json:"-"`
+}
+
+type Repository interface {
+	CreateUpload(ctx context.Context, upload Upload) (Upload, error)
+	FindUpload(ctx context.Context, id string) (Upload, error)
+	// AddPart records the chunk stored under path for offset, it fails with ErrOffsetMismatch when another chunk got
+	// there first
+	AddPart(ctx context.Context, id string, offset, size int64, path string) (Upload, error)
+	DeleteUpload(ctx context.Context, id string) error
+	// FindExpiredUploads lists the uploads of every library last written to before the time
+	FindExpiredUploads(ctx context.Context, before time.Time) ([]Upload, error)
+}
+
+// Storage keeps the chunks, it is the bucket documents are stored in
+type Storage interface {
+	common.DocumentSave
+	common.DocumentReader
+	common.BackupDelete
+}
+
+type Service interface {
+	Create(ctx context.Context, upload Upload) (Upload, error)
+	Find(ctx context.Context, id string) (Upload, error)
+	// Write stores the chunk read from r, offset has to be the number of bytes received so far
+	Write(ctx context.Context, id string, offset int64, r io.Reader) (Upload, error)
+	// Complete checks the file against the checksum given here or when the upload was created and adds the document
+	Complete(ctx context.Context, id, checksum string) (*documents.Document, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type service struct {
+	repo    Repository
+	storage Storage
+	docs    documents.DocumentService
+}
+
+func NewService(repo Repository, storage *common.BucketStorage, docs documents.DocumentService) Service {
+	return &service{
+		repo:    repo,
+		storage: storage,
+		docs:    docs,
+	}
+}
+
+// partPath is unique for every chunk, two chunks sent for the same offset at once mustn't overwrite each other
+func partPath(id string, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%020d-%s", id, offset, uuid.New().String())
+}
+
+func (s *service) Create(ctx context.Context, upload Upload) (Upload, error) {
+	if upload.Type == "" {
+		upload.Type = "book"
+	}
+	upload.Name = strings.TrimSpace(upload.Name)
+	upload.SHA256 = strings.ToLower(upload.SHA256)
+	if upload.Name == "" || upload.Size <= 0 || (upload.Type != "book" && upload.Type != "paper") {
+		return Upload{}, ErrInvalidUpload
+	}
+	if _, err := hex.DecodeString(upload.SHA256); err != nil || (upload.SHA256 != "" && len(upload.SHA256) != 64) {
+		return Upload{}, ErrInvalidUpload
+	}
+
+	upload.ID = uuid.New().String()
+	upload.Offset = 0
+	upload.Parts = []string{}
+	entity, err := s.repo.CreateUpload(ctx, upload)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	go s.expire(common.WithSystem(context.Background()))
+	return entity, nil
+}
+
+func (s *service) Find(ctx context.Context, id string) (Upload, error) {
+	return s.repo.FindUpload(ctx, id)
+}
+
+func (s *service) Write(ctx context.Context, id string, offset int64, r io.Reader) (Upload, error) {
+	upload, err := s.repo.FindUpload(ctx, id)
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	// reading one byte more than is left tells a chunk that is too large from one that fills the upload
+	remaining := upload.Size - offset
+	counter := &countingReader{r: io.LimitReader(r, remaining+1)}
+	path := partPath(id, offset)
+	if _, err := s.storage.Save(ctx, path, counter); err != nil {
+		logrus.WithError(err).WithField("id", id).Error("unable to store chunk")
+		s.removePart(path)
+		return upload, errors.New("unable to store chunk")
+	}
+	if counter.n > remaining {
+		s.removePart(path)
+		return upload, ErrTooLarge
+	}
+	if counter.n == 0 {
+		s.removePart(path)
+		return upload, nil
+	}
+
+	entity, err := s.repo.AddPart(ctx, id, offset, counter.n, path)
+	if err != nil {
+		// the chunk that got there first is the one kept
+		s.removePart(path)
+	}
+	return entity, err
+}
+
+func (s *service) Complete(ctx context.Context, id, checksum string) (*documents.Document, error) {
+	upload, err := s.repo.FindUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Offset != upload.Size {
+		return nil, ErrIncomplete
+	}
+	if checksum = strings.ToLower(checksum); checksum == "" {
+		checksum = upload.SHA256
+	}
+	if checksum == "" {
+		return nil, ErrChecksumRequired
+	}
+
+	file, err := ioutil.TempFile("", "upload-*")
+	if err != nil {
+		logrus.WithError(err).Error("unable to create temp file")
+		return nil, errors.New("unable to create temp file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	for _, path := range upload.Parts {
+		if err := s.copyPart(ctx, io.MultiWriter(file, hash), path); err != nil {
+			logrus.WithError(err).WithField("id", id).Error("unable to read chunk")
+			return nil, errors.New("unable to read chunk")
+		}
+	}
+	// a bad file can't be fixed by sending more chunks so the upload has to start over
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		s.remove(ctx, upload)
+		return nil, ErrChecksumMismatch
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	doc := &documents.Document{
+		DisplayName: upload.DisplayName,
+		Name:        upload.Name,
+		Type:        upload.Type,
+	}
+	err = s.docs.Add(ctx, file, doc)
+	duplicate := &documents.DuplicateError{}
+	if err == nil || err == documents.ErrInvalidFileType || errors.As(err, &duplicate) {
+		// completing again would end the same way
+		s.remove(ctx, upload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (s *service) copyPart(ctx context.Context, w io.Writer, path string) error {
+	r, err := s.storage.Reader(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (s *service) Delete(ctx context.Context, id string) error {
+	upload, err := s.repo.FindUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, upload)
+}
+
+// remove deletes the chunks before the upload, a chunk left behind is only wasted space
+func (s *service) remove(ctx context.Context, upload Upload) error {
+	for _, path := range upload.Parts {
+		s.removePart(path)
+	}
+	return s.repo.DeleteUpload(ctx, upload.ID)
+}
+
+func (s *service) removePart(path string) {
+	if err := s.storage.Delete(context.Background(), path); err != nil {
+		logrus.WithError(err).WithField("path", path).Warn("unable to delete chunk")
+	}
+}
+
+func (s *service) expire(ctx context.Context) {
+	expired, err := s.repo.FindExpiredUploads(ctx, time.Now().Add(-uploadExpiry))
+	if err != nil {
+		logrus.WithError(err).Error("unable to find expired uploads")
+		return
+	}
+	for _, upload := range expired {
+		if err := s.remove(ctx, upload); err != nil {
+			logrus.WithError(err).WithField("id", upload.ID).Warn("unable to delete expired upload")
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
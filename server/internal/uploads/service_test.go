@@ -0,0 +1,271 @@
+package uploads
+
+import (
+	"alexandria/internal/documents"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memRepo keeps uploads in memory, AddPart fails like the database does when the offset has moved on
+type memRepo struct {
+	mu      sync.Mutex
+	uploads map[string]Upload
+	// beforeAdd runs as a part is added, standing in for a request that got there first
+	beforeAdd func()
+}
+
+func (r *memRepo) CreateUpload(_ context.Context, upload Upload) (Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploads[upload.ID] = upload
+	return upload, nil
+}
+
+func (r *memRepo) FindUpload(_ context.Context, id string) (Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return upload, nil
+}
+
+func (r *memRepo) AddPart(_ context.Context, id string, offset, size int64, path string) (Upload, error) {
+	if r.beforeAdd != nil {
+		r.beforeAdd()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	if upload.Offset != offset {
+		return upload, ErrOffsetMismatch
+	}
+	upload.Parts = append(append([]string{}, upload.Parts...), path)
+	upload.Offset += size
+	r.uploads[id] = upload
+	return upload, nil
+}
+
+func (r *memRepo) DeleteUpload(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.uploads, id)
+	return nil
+}
+
+func (r *memRepo) FindExpiredUploads(context.Context, time.Time) ([]Upload, error) {
+	return nil, nil
+}
+
+type memBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *memBucket) Save(_ context.Context, fileName string, reader io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[fileName] = data
+	return fileName, nil
+}
+
+func (b *memBucket) Reader(_ context.Context, path string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ioutil.NopCloser(bytes.NewReader(b.objects[path])), nil
+}
+
+func (b *memBucket) Delete(_ context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, path)
+	return nil
+}
+
+func (b *memBucket) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.objects)
+}
+
+// stubDocs records the file of the document that was added
+type stubDocs struct {
+	documents.DocumentService
+	added []byte
+}
+
+func (d *stubDocs) Add(_ context.Context, file multipart.File, doc *documents.Document) error {
+	var err error
+	d.added, err = ioutil.ReadAll(file)
+	doc.ID = "doc"
+	return err
+}
+
+func newTestService(t *testing.T, size int64) (*service, *memRepo, *memBucket, *stubDocs, Upload) {
+	repo := &memRepo{uploads: map[string]Upload{}}
+	bucket := &memBucket{objects: map[string][]byte{}}
+	docs := &stubDocs{}
+	s := &service{repo: repo, storage: bucket, docs: docs}
+	upload, err := s.Create(context.Background(), Upload{Name: "book.epub", Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, repo, bucket, docs, upload
+}
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestWriteChunks(t *testing.T) {
+	s, _, bucket, _, upload := newTestService(t, 10)
+	ctx := context.Background()
+
+	u, err := s.Write(ctx, upload.ID, 0, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Offset != 5 {
+		t.Errorf("expected offset 5, got %d", u.Offset)
+	}
+	if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("HELLO")); err != ErrOffsetMismatch {
+		t.Errorf("expected %v for a chunk at an old offset, got %v", ErrOffsetMismatch, err)
+	}
+	if _, err := s.Write(ctx, upload.ID, 5, strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.count() != 2 {
+		t.Errorf("expected 2 chunks, got %d", bucket.count())
+	}
+}
+
+func TestWriteDuplicateChunk(t *testing.T) {
+	s, repo, bucket, _, upload := newTestService(t, 10)
+	ctx := context.Background()
+
+	// both requests pass the offset check, the other one records its chunk first
+	repo.beforeAdd = func() {
+		repo.beforeAdd = nil
+		if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("first")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("later")); err != ErrOffsetMismatch {
+		t.Errorf("expected %v, got %v", ErrOffsetMismatch, err)
+	}
+
+	u, _ := repo.FindUpload(ctx, upload.ID)
+	if u.Offset != 5 || len(u.Parts) != 1 {
+		t.Fatalf("expected one chunk of 5 bytes, got %d parts and offset %d", len(u.Parts), u.Offset)
+	}
+	if bucket.count() != 1 || string(bucket.objects[u.Parts[0]]) != "first" {
+		t.Errorf("the chunk that lost wasn't removed or the one that won was, bucket has %d chunks", bucket.count())
+	}
+}
+
+func TestWriteOversizedChunk(t *testing.T) {
+	s, repo, bucket, _, upload := newTestService(t, 4)
+	ctx := context.Background()
+
+	if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("hello")); err != ErrTooLarge {
+		t.Errorf("expected %v, got %v", ErrTooLarge, err)
+	}
+	if u, _ := repo.FindUpload(ctx, upload.ID); u.Offset != 0 || len(u.Parts) != 0 {
+		t.Errorf("oversized chunk was recorded, offset %d", u.Offset)
+	}
+	if bucket.count() != 0 {
+		t.Errorf("oversized chunk was kept in the bucket")
+	}
+
+	// a chunk that fills the upload exactly is fine
+	if u, err := s.Write(ctx, upload.ID, 0, strings.NewReader("hell")); err != nil || u.Offset != 4 {
+		t.Errorf("expected offset 4, got %d and %v", u.Offset, err)
+	}
+}
+
+func TestWriteEmptyChunk(t *testing.T) {
+	s, repo, bucket, _, upload := newTestService(t, 4)
+	ctx := context.Background()
+
+	u, err := s.Write(ctx, upload.ID, 0, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Offset != 0 {
+		t.Errorf("expected offset 0, got %d", u.Offset)
+	}
+	if u, _ := repo.FindUpload(ctx, upload.ID); len(u.Parts) != 0 {
+		t.Errorf("empty chunk was recorded")
+	}
+	if bucket.count() != 0 {
+		t.Errorf("empty chunk was kept in the bucket")
+	}
+}
+
+func TestCompleteWrongChecksum(t *testing.T) {
+	s, repo, bucket, docs, upload := newTestService(t, 5)
+	ctx := context.Background()
+
+	if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Complete(ctx, upload.ID, checksum("world")); err != ErrChecksumMismatch {
+		t.Errorf("expected %v, got %v", ErrChecksumMismatch, err)
+	}
+	if docs.added != nil {
+		t.Error("document was added from a file that doesn't match its checksum")
+	}
+	if _, err := repo.FindUpload(ctx, upload.ID); err != ErrNotFound {
+		t.Errorf("upload wasn't removed, got %v", err)
+	}
+	if bucket.count() != 0 {
+		t.Errorf("chunks of the upload weren't removed")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s, repo, bucket, docs, upload := newTestService(t, 10)
+	ctx := context.Background()
+
+	if _, err := s.Complete(ctx, upload.ID, checksum("helloworld")); err != ErrIncomplete {
+		t.Errorf("expected %v, got %v", ErrIncomplete, err)
+	}
+	if _, err := s.Write(ctx, upload.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(ctx, upload.ID, 5, strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := s.Complete(ctx, upload.ID, strings.ToUpper(checksum("helloworld")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "doc" || string(docs.added) != "helloworld" {
+		t.Errorf("unexpected document %q from %q", doc.ID, docs.added)
+	}
+	if _, err := repo.FindUpload(ctx, upload.ID); err != ErrNotFound {
+		t.Errorf("upload wasn't removed, got %v", err)
+	}
+	if bucket.count() != 0 {
+		t.Errorf("chunks of the upload weren't removed")
+	}
+}
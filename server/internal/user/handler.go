@@ -2,9 +2,18 @@ package user
 
 import (
 	"alexandria/internal/common"
+	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type loginHandler struct {
@@ -16,6 +25,13 @@ func MakeLoginHandler(mr *mux.Router, service Service) http.Handler {
 		service: service,
 	}
 	mr.HandleFunc("/auth/", h.Login).Methods("GET")
+	mr.HandleFunc("/auth/register", h.Register).Methods("POST")
+	mr.HandleFunc("/auth/refresh", h.Refresh).Methods("POST")
+	mr.HandleFunc("/auth/logout", h.Logout).Methods("POST")
+	mr.HandleFunc("/auth/password", h.ChangePassword).Methods("PUT")
+	mr.HandleFunc("/auth/keys", h.FindKeys).Methods("GET")
+	mr.HandleFunc("/auth/keys", h.CreateKey).Methods("POST")
+	mr.HandleFunc("/auth/keys/{id}", h.RevokeKey).Methods("DELETE")
 
 	return mr
 }
@@ -31,8 +47,15 @@ func (h *loginHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Authenticate(ctx, username, password)
+	token, err := h.service.Authenticate(ctx, username, password, clientIP(r))
 	if err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			logrus.WithField("username", username).Warn("login locked")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			common.MakeError(w, http.StatusTooManyRequests, "login", locked.Error(), "login")
+			return
+		}
 		logrus.WithError(err).Error("failed to login")
 		common.MakeError(w, http.StatusUnauthorized, "login", "invalid login", "login")
 		return
@@ -41,3 +64,549 @@ func (h *loginHandler) Login(w http.ResponseWriter, r *http.Request) {
 	common.EncodeResponse(r.Context(), w, token)
 }
 
+// ChangePassword sets a new password for the caller, other logins are signed out
+func (h *loginHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal password")
+		common.MakeError(w, http.StatusBadRequest, "login", "Bad Request", "changepassword")
+		return
+	}
+
+	err := h.service.ChangePassword(ctx, req.CurrentPassword, req.NewPassword)
+	switch err {
+	case nil:
+	case common.ErrNoIdentity:
+		common.MakeError(w, http.StatusUnauthorized, "login", "Unauthorized", "changepassword")
+		return
+	case ErrWrongPassword, ErrKeyForbidden:
+		common.MakeError(w, http.StatusForbidden, "login", err.Error(), "changepassword")
+		return
+	default:
+		writeUserError(w, err, "changepassword")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *loginHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := refreshRequest{}
+	if err := json.Unmarshal(b, &req); err != nil || req.RefreshToken == "" {
+		common.MakeError(w, http.StatusBadRequest, "login", "Bad Request", "refresh")
+		return
+	}
+
+	token, err := h.service.Refresh(ctx, req.RefreshToken)
+	if err == ErrInvalidRefresh {
+		common.MakeError(w, http.StatusUnauthorized, "login", "invalid refresh token", "refresh")
+		return
+	}
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "refresh")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, token)
+}
+
+// Logout revokes the access token used for the request, sending the refresh token also ends the login everywhere
+// it was refreshed
+func (h *loginHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		common.MakeError(w, http.StatusUnauthorized, "login", "Unauthorized", "logout")
+		return
+	}
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := refreshRequest{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &req); err != nil {
+			common.MakeError(w, http.StatusBadRequest, "login", "Bad Request", "logout")
+			return
+		}
+	}
+
+	if err := h.service.Logout(ctx, identity.TokenID, identity.Expires, req.RefreshToken); err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "logout")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+}
+
+func (h *loginHandler) FindKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := h.service.FindKeys(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "findkeys")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, keys)
+}
+
+// CreateKey returns the new key, it can't be shown again afterwards
+func (h *loginHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal key")
+		common.MakeError(w, http.StatusBadRequest, "login", "Bad Request", "createkey")
+		return
+	}
+
+	key, err := h.service.CreateKey(ctx, req.Name, req.Scope)
+	switch err {
+	case nil:
+	case ErrInvalidKey, ErrInvalidScope:
+		common.MakeError(w, http.StatusBadRequest, "login", err.Error(), "createkey")
+		return
+	case ErrKeyExists:
+		common.MakeError(w, http.StatusConflict, "login", err.Error(), "createkey")
+		return
+	case ErrKeyForbidden:
+		common.MakeError(w, http.StatusForbidden, "login", err.Error(), "createkey")
+		return
+	default:
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "createkey")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(r.Context(), w, key)
+}
+
+func (h *loginHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if err := h.service.RevokeKey(ctx, id); err != nil {
+		if err == ErrKeyNotFound {
+			common.MakeError(w, http.StatusNotFound, "login", "Not Found", "revokekey")
+			return
+		}
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "revokekey")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+}
+
+type registerRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// Register lets people sign themselves up when ALLOW_REGISTRATION is set, otherwise an admin has to create accounts
+func (h *loginHandler) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !allowRegistration {
+		common.MakeError(w, http.StatusForbidden, "login", "registration is disabled", "register")
+		return
+	}
+
+	req, ok := decodeRegisterRequest(w, r, "register")
+	if !ok {
+		return
+	}
+
+	entity, err := h.service.Register(ctx, req.Username, req.Password)
+	if err != nil {
+		writeUserError(w, err, "register")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(r.Context(), w, entity)
+}
+
+type userHandler struct {
+	service Service
+}
+
+// MakeUserHandler serves the account of the caller and, for admins, the management of every account
+func MakeUserHandler(mr *mux.Router, service Service) http.Handler {
+	r := mr.PathPrefix("/users").Subrouter()
+
+	h := &userHandler{
+		service: service,
+	}
+
+	r.HandleFunc("/me", h.Me).Methods("GET")
+	r.Handle("/", common.RequireAdmin(http.HandlerFunc(h.FindAll))).Methods("GET")
+	r.Handle("/", common.RequireAdmin(http.HandlerFunc(h.Create))).Methods("POST")
+	r.Handle("/logins", common.RequireAdmin(http.HandlerFunc(h.FindLoginAttempts))).Methods("GET")
+	r.Handle("/{id}", common.RequireAdmin(http.HandlerFunc(h.FindByID))).Methods("GET")
+	r.Handle("/{id}/role", common.RequireAdmin(http.HandlerFunc(h.UpdateRole))).Methods("PUT")
+	r.Handle("/{id}/password", common.RequireAdmin(http.HandlerFunc(h.ResetPassword))).Methods("PUT")
+	r.Handle("/{id}", common.RequireAdmin(http.HandlerFunc(h.Delete))).Methods("DELETE")
+
+	return r
+}
+
+func (h *userHandler) Me(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		common.MakeError(w, http.StatusUnauthorized, "user", "Unauthorized", "me")
+		return
+	}
+
+	entity, err := h.service.FindByID(ctx, identity.ID)
+	if err != nil {
+		writeUserError(w, err, "me")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, entity)
+}
+
+func (h *userHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	entities, err := h.service.FindAll(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "user", "Server Error", "findall")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, entities)
+}
+
+func (h *userHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := decodeRegisterRequest(w, r, "create")
+	if !ok {
+		return
+	}
+	if req.Role == "" {
+		req.Role = common.RoleUser
+	}
+
+	entity, err := h.service.Create(ctx, req.Username, req.Password, req.Role)
+	if err != nil {
+		writeUserError(w, err, "create")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.EncodeResponse(r.Context(), w, entity)
+}
+
+func (h *userHandler) FindByID(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	entity, err := h.service.FindByID(ctx, id)
+	if err != nil {
+		writeUserError(w, err, "findbyid")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, entity)
+}
+
+func (h *userHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := struct {
+		Role string `json:"role"`
+	}{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal role")
+		common.MakeError(w, http.StatusBadRequest, "user", "Bad Request", "updaterole")
+		return
+	}
+
+	entity, err := h.service.UpdateRole(ctx, id, req.Role)
+	if err != nil {
+		writeUserError(w, err, "updaterole")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, entity)
+}
+
+// ResetPassword sets the password of an account, a random one is generated and returned when the body has none
+func (h *userHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := struct {
+		Password string `json:"password"`
+	}{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &req); err != nil {
+			logrus.WithError(err).Error("unable to unmarshal password")
+			common.MakeError(w, http.StatusBadRequest, "user", "Bad Request", "resetpassword")
+			return
+		}
+	}
+
+	password, err := h.service.ResetPassword(ctx, id, req.Password)
+	if err != nil {
+		writeUserError(w, err, "resetpassword")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, map[string]string{"password": password})
+}
+
+// FindLoginAttempts lists the most recent logins, optionally for a single username
+func (h *userHandler) FindLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	attempts, err := h.service.FindLoginAttempts(ctx, q.Get("username"), limit)
+	if err != nil {
+		common.MakeError(w, http.StatusInternalServerError, "user", "Server Error", "findlogins")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, attempts)
+}
+
+func (h *userHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if identity, ok := common.IdentityFromContext(ctx); ok && identity.ID == id {
+		common.MakeError(w, http.StatusConflict, "user", "you can't delete your own account", "delete")
+		return
+	}
+
+	if err := h.service.Delete(ctx, id); err != nil {
+		writeUserError(w, err, "delete")
+		return
+	}
+
+	common.EncodeResponse(r.Context(), w, map[string]string{"status": "success"})
+}
+
+func decodeRegisterRequest(w http.ResponseWriter, r *http.Request, op string) (registerRequest, bool) {
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := registerRequest{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		logrus.WithError(err).Error("unable to unmarshal user")
+		common.MakeError(w, http.StatusBadRequest, "user", "Bad Request", op)
+		return req, false
+	}
+	return req, true
+}
+
+// clientIP is the address logins are rate limited by, X-Forwarded-For is only trusted behind a proxy that sets it.
+// The proxy appends the address it saw, anything before that came from the client and can be made up.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeUserError(w http.ResponseWriter, err error, op string) {
+	switch err {
+	case ErrNotFound:
+		common.MakeError(w, http.StatusNotFound, "user", "Not Found", op)
+	case ErrInvalidUser, ErrInvalidRole:
+		common.MakeError(w, http.StatusBadRequest, "user", err.Error(), op)
+	case ErrUserExists, ErrOwnsResources, ErrLinked:
+		common.MakeError(w, http.StatusConflict, "user", err.Error(), op)
+	default:
+		common.MakeError(w, http.StatusInternalServerError, "user", "Server Error", op)
+	}
+}
+
+const oidcCookie = "oidc_state"
+
+type oidcHandler struct {
+	service  Service
+	provider *OIDCProvider
+}
+
+// MakeOIDCHandler adds login through the configured identity provider, the routes answer 404 while it isn't set up
+func MakeOIDCHandler(mr *mux.Router, service Service, provider *OIDCProvider) http.Handler {
+	h := &oidcHandler{
+		service:  service,
+		provider: provider,
+	}
+	mr.HandleFunc("/auth/oidc/login", h.Login).Methods("GET")
+	mr.HandleFunc("/auth/oidc/callback", h.Callback).Methods("GET")
+	mr.Handle("/users/{id}/oidc", common.RequireAdmin(http.HandlerFunc(h.Link))).Methods("PUT")
+
+	return mr
+}
+
+func (h *oidcHandler) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !h.provider.Enabled() {
+		common.MakeError(w, http.StatusNotFound, "login", "Not Found", "oidclogin")
+		return
+	}
+
+	redirect, state, err := h.provider.AuthCodeURL(ctx)
+	if err != nil {
+		common.MakeError(w, http.StatusBadGateway, "login", "identity provider unavailable", "oidclogin")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.provider.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Callback finishes the login. The tokens are returned as json, or handed to OIDC_POST_LOGIN_URL in the fragment
+// so they don't end up in server logs.
+func (h *oidcHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !h.provider.Enabled() {
+		common.MakeError(w, http.StatusNotFound, "login", "Not Found", "oidccallback")
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		logrus.WithField("error", e).Warn("identity provider refused login")
+		common.MakeError(w, http.StatusUnauthorized, "login", "invalid login", "oidccallback")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		common.MakeError(w, http.StatusBadRequest, "login", ErrInvalidState.Error(), "oidccallback")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/auth/oidc/", MaxAge: -1})
+
+	login, err := h.provider.Exchange(ctx, cookie.Value, q.Get("state"), q.Get("code"))
+	switch err {
+	case nil:
+	case ErrInvalidState:
+		common.MakeError(w, http.StatusBadRequest, "login", err.Error(), "oidccallback")
+		return
+	case ErrInvalidLogin:
+		common.MakeError(w, http.StatusUnauthorized, "login", "invalid login", "oidccallback")
+		return
+	default:
+		common.MakeError(w, http.StatusBadGateway, "login", "identity provider unavailable", "oidccallback")
+		return
+	}
+
+	token, err := h.service.LoginExternal(ctx, login)
+	switch err {
+	case nil:
+	case ErrNoAccount, ErrUserExists:
+		common.MakeError(w, http.StatusForbidden, "login", err.Error(), "oidccallback")
+		return
+	default:
+		common.MakeError(w, http.StatusInternalServerError, "login", "Server Error", "oidccallback")
+		return
+	}
+
+	if h.provider.config.PostLoginURL != "" {
+		fragment := url.Values{}
+		fragment.Set("token", token.AccessToken)
+		fragment.Set("expires", token.Expires.Format(time.RFC3339))
+		fragment.Set("refresh_token", token.RefreshToken)
+		fragment.Set("refresh_expires", token.RefreshExpires.Format(time.RFC3339))
+		http.Redirect(w, r, h.provider.config.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+	common.EncodeResponse(r.Context(), w, token)
+}
+
+// Link ties an existing account to the identity provider subject it signs in as, accounts are never matched by name
+func (h *oidcHandler) Link(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if !h.provider.Enabled() {
+		common.MakeError(w, http.StatusNotFound, "user", "Not Found", "linkoidc")
+		return
+	}
+
+	req := struct {
+		Subject string `json:"subject"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Subject == "" {
+		common.MakeError(w, http.StatusBadRequest, "user", "subject is required", "linkoidc")
+		return
+	}
+
+	externalID, err := h.provider.ExternalID(ctx, req.Subject)
+	if err != nil {
+		common.MakeError(w, http.StatusBadGateway, "user", "identity provider unavailable", "linkoidc")
+		return
+	}
+	entity, err := h.service.LinkExternal(ctx, id, externalID)
+	if err != nil {
+		writeUserError(w, err, "linkoidc")
+		return
+	}
+
+	common.EncodeResponse(ctx, w, entity)
+}
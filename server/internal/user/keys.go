@@ -0,0 +1,121 @@
+package user
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+const keyPrefix = "alx_"
+
+var (
+	ErrInvalidKey   = errors.New("invalid api key")
+	ErrInvalidScope = errors.New("scope must be read, upload or admin")
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrKeyExists    = errors.New("an api key with that name already exists")
+	// ErrKeyForbidden stops a key from minting keys or setting passwords, either would outlast its scope
+	ErrKeyForbidden = errors.New("api keys can't manage keys or passwords, sign in instead")
+)
+
+// APIKey lets scripts call the api as a user without logging in. Key is only filled in when the key is created,
+// afterwards the prefix is enough to tell keys apart.
+type APIKey struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"-"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Scope    string     `json:"scope"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+	Key      string     `json:"key,omitempty"`
+}
+
+type KeyRepository interface {
+	CreateAPIKey(ctx context.Context, key APIKey, hash string) error
+	FindAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	// TouchAPIKey records when a key was used, it is only written once a minute per key
+	TouchAPIKey(ctx context.Context, id string, used time.Time) error
+}
+
+func (s *userService) CreateKey(ctx context.Context, name, scope string) (*APIKey, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	if identity.Scope != "" {
+		return nil, ErrKeyForbidden
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidKey
+	}
+	if scope != common.ScopeRead && scope != common.ScopeUpload && scope != common.ScopeAdmin {
+		return nil, ErrInvalidScope
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := APIKey{
+		ID:      uuid.New().String(),
+		UserID:  identity.ID,
+		Name:    name,
+		Prefix:  secret[:8],
+		Scope:   scope,
+		Created: time.Now().UTC(),
+	}
+	if err := s.keys.CreateAPIKey(ctx, key, hashToken(keyPrefix+secret)); err != nil {
+		return nil, err
+	}
+	key.Key = keyPrefix + secret
+	return &key, nil
+}
+
+func (s *userService) FindKeys(ctx context.Context) ([]APIKey, error) {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return nil, common.ErrNoIdentity
+	}
+	return s.keys.FindAPIKeys(ctx, identity.ID)
+}
+
+func (s *userService) RevokeKey(ctx context.Context, id string) error {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return common.ErrNoIdentity
+	}
+	return s.keys.DeleteAPIKey(ctx, identity.ID, id)
+}
+
+// AuthenticateKey finds the account behind an api key, the identity carries the key's scope
+func (s *userService) AuthenticateKey(ctx context.Context, key string) (common.Identity, error) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return common.Identity{}, ErrInvalidKey
+	}
+	record, err := s.keys.FindAPIKeyByHash(ctx, hashToken(key))
+	if err != nil {
+		return common.Identity{}, err
+	}
+	if record == nil {
+		return common.Identity{}, ErrInvalidKey
+	}
+
+	user, err := s.repo.FindUserByID(ctx, record.UserID)
+	if err != nil {
+		return common.Identity{}, err
+	}
+	if user == nil {
+		return common.Identity{}, ErrInvalidKey
+	}
+
+	if err := s.keys.TouchAPIKey(ctx, record.ID, time.Now().UTC()); err != nil {
+		return common.Identity{}, err
+	}
+	return common.Identity{ID: user.ID, Role: user.Role, Scope: record.Scope}, nil
+}
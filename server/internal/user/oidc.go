@@ -0,0 +1,399 @@
+package user
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOIDCDisabled = errors.New("oidc login is not configured")
+	ErrInvalidState = errors.New("invalid oidc state")
+	ErrNoAccount    = errors.New("no account for this login")
+	ErrLinked       = errors.New("login is already linked to an account")
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// jwksRefetchInterval bounds how often tokens signed with an unknown key make the key set be fetched again
+const jwksRefetchInterval = time.Minute
+
+// OIDCProvider runs the authorization code flow against the configured issuer. Discovery and keys are fetched on
+// first use so the server starts while the provider is down.
+type OIDCProvider struct {
+	config common.OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcState travels through the provider in a signed cookie, the verifier is the PKCE secret for the code exchange
+type oidcState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"expires"`
+}
+
+// ExternalLogin is a user the identity provider vouched for, ID combines the issuer and subject. Logins only sign in
+// to accounts linked to them, the username claim is never trusted to pick an existing account.
+type ExternalLogin struct {
+	ID            string
+	Username      string
+	AutoProvision bool
+}
+
+func NewOIDCProvider(config common.OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) Enabled() bool {
+	return p.config.Issuer != "" && p.config.ClientID != ""
+}
+
+// ExternalID is what a login for subject is linked by, admins use it to link an existing account before its first login
+func (p *OIDCProvider) ExternalID(ctx context.Context, subject string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	return d.Issuer + "|" + subject, nil
+}
+
+// AuthCodeURL creates the redirect to the provider along with the state cookie value
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context) (string, string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := oidcState{Expires: time.Now().Add(oidcStateTTL)}
+	for _, v := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *v, err = randomToken(); err != nil {
+			return "", "", err
+		}
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state.State)
+	q.Set("nonce", state.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	cookie, err := p.signState(state)
+	if err != nil {
+		return "", "", err
+	}
+	return d.AuthorizationEndpoint + "?" + q.Encode(), cookie, nil
+}
+
+// Exchange checks the callback against the state cookie, swaps the code for tokens and verifies the id token
+func (p *OIDCProvider) Exchange(ctx context.Context, cookie, state, code string) (ExternalLogin, error) {
+	saved, err := p.verifyState(cookie)
+	if err != nil || !hmac.Equal([]byte(saved.State), []byte(state)) || code == "" {
+		return ExternalLogin{}, ErrInvalidState
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return ExternalLogin{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", saved.Verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return ExternalLogin{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logrus.WithError(err).Error("unable to exchange oidc code")
+		return ExternalLogin{}, errors.New("unable to exchange oidc code")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logrus.WithField("status", resp.StatusCode).Error("oidc provider rejected code")
+		return ExternalLogin{}, ErrInvalidState
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		logrus.WithError(err).Error("oidc token response has no id token")
+		return ExternalLogin{}, errors.New("oidc token response has no id token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokens.IDToken, saved.Nonce)
+	if err != nil {
+		return ExternalLogin{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	username, _ := claims[p.config.UsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if sub == "" || username == "" {
+		logrus.WithField("claim", p.config.UsernameClaim).Error("id token is missing subject or username")
+		return ExternalLogin{}, ErrInvalidLogin
+	}
+
+	return ExternalLogin{
+		ID:            d.Issuer + "|" + sub,
+		Username:      username,
+		AutoProvision: p.config.AutoProvision,
+	}, nil
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		logrus.WithError(err).Error("invalid id token")
+		return nil, ErrInvalidLogin
+	}
+
+	d, _ := p.getDiscovery(ctx)
+	if !claims.VerifyIssuer(d.Issuer, true) || !hasAudience(claims["aud"], p.config.ClientID) {
+		logrus.Error("id token was issued for someone else")
+		return nil, ErrInvalidLogin
+	}
+	if n, _ := claims["nonce"].(string); !hmac.Equal([]byte(n), []byte(nonce)) {
+		logrus.Error("id token nonce does not match")
+		return nil, ErrInvalidLogin
+	}
+	return claims, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	if !p.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		logrus.WithField("issuer", d.Issuer).Error("oidc discovery is for another issuer")
+		return nil, errors.New("oidc discovery is for another issuer")
+	}
+	p.discovery = d
+	return d, nil
+}
+
+// getKey finds the signing key by id, the key set is fetched again when the provider has rotated its keys
+func (p *OIDCProvider) getKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	recent := time.Since(p.keysFetched) < jwksRefetchInterval
+	if !ok && !recent {
+		p.keysFetched = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if recent {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logrus.WithError(err).WithField("url", endpoint).Error("unable to reach oidc provider")
+		return errors.New("unable to reach oidc provider")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logrus.WithField("status", resp.StatusCode).WithField("url", endpoint).Error("oidc provider returned an error")
+		return errors.New("oidc provider returned an error")
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) signState(state oidcState) (string, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + p.mac(payload), nil
+}
+
+func (p *OIDCProvider) verifyState(cookie string) (oidcState, error) {
+	state := oidcState{}
+	parts := strings.SplitN(cookie, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(p.mac(parts[0]))) {
+		return state, ErrInvalidState
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return state, ErrInvalidState
+	}
+	if err := json.Unmarshal(b, &state); err != nil || time.Now().After(state.Expires) {
+		return state, ErrInvalidState
+	}
+	return state, nil
+}
+
+func (p *OIDCProvider) mac(payload string) string {
+	m := hmac.New(sha256.New, []byte(p.config.StateKey))
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,368 @@
+package user
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubIssuer is an identity provider that signs id tokens for whatever the last authorization request asked for
+type stubIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	signer *rsa.PrivateKey
+
+	mu          sync.Mutex
+	issuer      string
+	kid         string
+	publishKid  string
+	jwksFetches int
+	challenge   string
+	nonce       string
+	subject     string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	signer, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{t: t, signer: signer, kid: "key-1", publishKid: "key-1", subject: "subject-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		issuer := s.issuer
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: s.server.URL + "/authorize",
+			TokenEndpoint:         s.server.URL + "/token",
+			JWKSURI:               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jwksFetches++
+		pub := s.signer.PublicKey
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kid: s.publishKid,
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", s.token)
+
+	s.server = httptest.NewServer(mux)
+	s.issuer = s.server.URL
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// token checks the pkce verifier against the challenge of the authorization request before issuing an id token
+func (s *stubIssuer) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                s.issuer,
+		"aud":                "client",
+		"sub":                s.subject,
+		"nonce":              s.nonce,
+		"preferred_username": "Reader",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = s.kid
+	raw, err := token.SignedString(s.signer)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+}
+
+func (s *stubIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(common.OIDCConfig{
+		Issuer:        s.server.URL,
+		ClientID:      "client",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost/auth/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		StateKey:      "state-key",
+	})
+}
+
+// authorize starts a login and remembers what the provider would have been asked for, it returns the state and cookie
+func (s *stubIssuer) authorize(p *OIDCProvider) (string, string) {
+	redirect, cookie, err := p.AuthCodeURL(context.Background())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(redirect, s.server.URL+"/authorize?") || q.Get("code_challenge_method") != "S256" {
+		s.t.Fatalf("unexpected authorization url %s", redirect)
+	}
+
+	s.mu.Lock()
+	s.challenge, s.nonce = q.Get("code_challenge"), q.Get("nonce")
+	s.mu.Unlock()
+	return q.Get("state"), cookie
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := issuer.provider()
+
+	state, cookie := issuer.authorize(p)
+	login, err := p.Exchange(context.Background(), cookie, state, "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.ID != issuer.server.URL+"|subject-1" || login.Username != "Reader" {
+		t.Errorf("unexpected login %+v", login)
+	}
+
+	id, err := p.ExternalID(context.Background(), "subject-1")
+	if err != nil || id != login.ID {
+		t.Errorf("external id %q doesn't match login %q: %v", id, login.ID, err)
+	}
+}
+
+func TestOIDCDiscoveryForAnotherIssuer(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.issuer = "https://elsewhere.example.com"
+
+	if _, _, err := issuer.provider().AuthCodeURL(context.Background()); err == nil {
+		t.Error("discovery for another issuer was accepted")
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	p := NewOIDCProvider(common.OIDCConfig{})
+	if _, _, err := p.AuthCodeURL(context.Background()); err != ErrOIDCDisabled {
+		t.Errorf("expected %v, got %v", ErrOIDCDisabled, err)
+	}
+}
+
+func TestOIDCSigningKeys(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := issuer.provider()
+
+	// a kid the key set doesn't have is refused even after fetching the keys again
+	issuer.kid = "unknown"
+	state, cookie := issuer.authorize(p)
+	if _, err := p.Exchange(context.Background(), cookie, state, "code"); err != ErrInvalidLogin {
+		t.Errorf("expected %v for an unknown key, got %v", ErrInvalidLogin, err)
+	}
+
+	// unknown keys don't fetch the key set again until the interval has passed
+	fetches := issuer.jwksFetches
+	state, cookie = issuer.authorize(p)
+	if _, err := p.Exchange(context.Background(), cookie, state, "code"); err != ErrInvalidLogin {
+		t.Errorf("expected %v for an unknown key, got %v", ErrInvalidLogin, err)
+	}
+	if issuer.jwksFetches != fetches {
+		t.Errorf("key set fetched again within the interval")
+	}
+
+	// a rotated key is picked up by fetching the key set again
+	p.keysFetched = time.Now().Add(-jwksRefetchInterval)
+	issuer.kid, issuer.publishKid = "key-2", "key-2"
+	state, cookie = issuer.authorize(p)
+	if _, err := p.Exchange(context.Background(), cookie, state, "code"); err != nil {
+		t.Errorf("rotated key was refused: %v", err)
+	}
+
+	// known keys are cached
+	fetches = issuer.jwksFetches
+	state, cookie = issuer.authorize(p)
+	if _, err := p.Exchange(context.Background(), cookie, state, "code"); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.jwksFetches != fetches {
+		t.Errorf("key set fetched again for a known key")
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := issuer.provider()
+	ctx := context.Background()
+
+	state, cookie := issuer.authorize(p)
+	if _, err := p.Exchange(ctx, cookie, "other-state", "code"); err != ErrInvalidState {
+		t.Errorf("mismatched state: expected %v, got %v", ErrInvalidState, err)
+	}
+	if _, err := p.Exchange(ctx, cookie+"x", state, "code"); err != ErrInvalidState {
+		t.Errorf("tampered cookie: expected %v, got %v", ErrInvalidState, err)
+	}
+	if _, err := p.Exchange(ctx, cookie, state, ""); err != ErrInvalidState {
+		t.Errorf("missing code: expected %v, got %v", ErrInvalidState, err)
+	}
+
+	other := issuer.provider()
+	other.config.StateKey = "another-key"
+	if _, err := other.Exchange(ctx, cookie, state, "code"); err != ErrInvalidState {
+		t.Errorf("cookie signed with another key: expected %v, got %v", ErrInvalidState, err)
+	}
+
+	expired, err := p.signState(oidcState{State: state, Expires: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, expired, state, "code"); err != ErrInvalidState {
+		t.Errorf("expired cookie: expected %v, got %v", ErrInvalidState, err)
+	}
+
+	// the verifier from a previous login doesn't match the challenge the provider was given
+	issuer.authorize(p)
+	if _, err := p.Exchange(ctx, cookie, state, "code"); err != ErrInvalidState {
+		t.Errorf("stale verifier: expected %v, got %v", ErrInvalidState, err)
+	}
+}
+
+func TestOIDCNonce(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := issuer.provider()
+
+	state, cookie := issuer.authorize(p)
+	issuer.nonce = "replayed"
+	if _, err := p.Exchange(context.Background(), cookie, state, "code"); err != ErrInvalidLogin {
+		t.Errorf("expected %v, got %v", ErrInvalidLogin, err)
+	}
+}
+
+type memoryUsers struct {
+	Repository
+	users []*User
+}
+
+func (m *memoryUsers) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	for _, u := range m.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryUsers) FindUserByID(ctx context.Context, id string) (*User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryUsers) FindUserByExternalID(ctx context.Context, externalID string) (*User, error) {
+	for _, u := range m.users {
+		if u.ExternalID == externalID {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryUsers) LinkExternalID(ctx context.Context, id, externalID string) error {
+	u, _ := m.FindUserByID(ctx, id)
+	u.ExternalID = externalID
+	return nil
+}
+
+func (m *memoryUsers) CreateUser(ctx context.Context, user *User) error {
+	m.users = append(m.users, user)
+	return nil
+}
+
+type memoryTokens struct {
+	TokenRepository
+}
+
+func (memoryTokens) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	return nil
+}
+
+func TestLoginExternal(t *testing.T) {
+	ctx := context.Background()
+	admin := &User{ID: "admin-id", Username: "admin", Role: common.RoleAdmin}
+	linked := &User{ID: "linked-id", Username: "linked", Role: common.RoleUser, ExternalID: "issuer|linked"}
+	repo := &memoryUsers{users: []*User{admin, linked}}
+	s := &userService{repo: repo, tokens: memoryTokens{}}
+
+	// the username claim never signs in to an account that isn't linked
+	if _, err := s.LoginExternal(ctx, ExternalLogin{ID: "issuer|attacker", Username: "Admin", AutoProvision: true}); err != ErrUserExists {
+		t.Errorf("expected %v for an unlinked account, got %v", ErrUserExists, err)
+	}
+	if admin.ExternalID != "" {
+		t.Errorf("login was linked to %s", admin.Username)
+	}
+
+	// the subject signs in to the linked account whatever the username claim says
+	if _, err := s.LoginExternal(ctx, ExternalLogin{ID: "issuer|linked", Username: "renamed"}); err != nil {
+		t.Errorf("linked login was refused: %v", err)
+	}
+
+	if _, err := s.LoginExternal(ctx, ExternalLogin{ID: "issuer|new", Username: "newcomer"}); err != ErrNoAccount {
+		t.Errorf("expected %v without auto provisioning, got %v", ErrNoAccount, err)
+	}
+
+	if _, err := s.LoginExternal(ctx, ExternalLogin{ID: "issuer|new", Username: "Newcomer", AutoProvision: true}); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := repo.FindUserByExternalID(ctx, "issuer|new")
+	if created == nil || created.Username != "newcomer" || created.Role != common.RoleUser {
+		t.Errorf("unexpected provisioned account %+v", created)
+	}
+}
+
+func TestLinkExternal(t *testing.T) {
+	ctx := context.Background()
+	admin := &User{ID: "admin-id", Username: "admin", Role: common.RoleAdmin}
+	linked := &User{ID: "linked-id", Username: "linked", Role: common.RoleUser, ExternalID: "issuer|linked"}
+	repo := &memoryUsers{users: []*User{admin, linked}}
+	s := &userService{repo: repo, tokens: memoryTokens{}}
+
+	if _, err := s.LinkExternal(ctx, admin.ID, "issuer|linked"); err != ErrLinked {
+		t.Errorf("expected %v for a login linked to another account, got %v", ErrLinked, err)
+	}
+	if _, err := s.LinkExternal(ctx, "missing", "issuer|admin"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+
+	if _, err := s.LinkExternal(ctx, admin.ID, "issuer|admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoginExternal(ctx, ExternalLogin{ID: "issuer|admin", Username: "someone"}); err != nil {
+		t.Errorf("login linked by an admin was refused: %v", err)
+	}
+}
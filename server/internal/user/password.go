@@ -0,0 +1,159 @@
+package user
+
+import (
+	"alexandria/internal/common"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrWrongPassword = errors.New("current password is incorrect")
+	maxUserFailures  = intFromEnv("LOGIN_MAX_FAILURES", 5)
+	maxIPFailures    = intFromEnv("LOGIN_MAX_IP_FAILURES", 20)
+	lockoutWindow    = durationFromEnv("LOGIN_LOCKOUT", 15*time.Minute)
+)
+
+// Reasons recorded with failed login attempts
+const (
+	ReasonUnknownUser   = "unknown_user"
+	ReasonWrongPassword = "wrong_password"
+	ReasonLockedOut     = "locked_out"
+	ReasonRateLimited   = "rate_limited"
+	ReasonReset         = "password_reset"
+)
+
+// LoginAttempt is the audit record of a password login
+type LoginAttempt struct {
+	ID       int64     `json:"id"`
+	Username string    `json:"username"`
+	UserID   string    `json:"user_id,omitempty"`
+	IP       string    `json:"ip"`
+	Success  bool      `json:"success"`
+	Reason   string    `json:"reason,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// LockedError is returned while a username or address has failed to log in too often
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+type AuditRepository interface {
+	RecordLoginAttempt(ctx context.Context, attempt LoginAttempt) error
+	// FailedLogins counts failures since the given time, failures for a username before its last success and attempts
+	// refused by a lockout are ignored
+	FailedLogins(ctx context.Context, username, ip string, since time.Time) (byUser int, byIP int, last time.Time, err error)
+	FindLoginAttempts(ctx context.Context, username string, limit int) ([]LoginAttempt, error)
+}
+
+// checkLockout stops password guessing, each username and address gets a number of failures inside the window
+func (s *userService) checkLockout(ctx context.Context, username, ip string) error {
+	now := time.Now().UTC()
+	byUser, byIP, last, err := s.audit.FailedLogins(ctx, username, ip, now.Add(-lockoutWindow))
+	if err != nil {
+		return err
+	}
+	if byUser < maxUserFailures && byIP < maxIPFailures {
+		return nil
+	}
+
+	reason := ReasonLockedOut
+	if byIP >= maxIPFailures {
+		reason = ReasonRateLimited
+	}
+	s.recordAttempt(ctx, LoginAttempt{Username: username, IP: ip, Reason: reason})
+	return &LockedError{RetryAfter: last.Add(lockoutWindow).Sub(now)}
+}
+
+func (s *userService) recordAttempt(ctx context.Context, attempt LoginAttempt) {
+	attempt.Created = time.Now().UTC()
+	if err := s.audit.RecordLoginAttempt(ctx, attempt); err != nil {
+		logrus.WithError(err).Warn("unable to record login attempt")
+	}
+}
+
+func (s *userService) FindLoginAttempts(ctx context.Context, username string, limit int) ([]LoginAttempt, error) {
+	return s.audit.FindLoginAttempts(ctx, username, limit)
+}
+
+// ChangePassword replaces the caller's password, accounts created through an identity provider have none to check.
+// Every refresh token is revoked so other logins have to sign in again. Only a login can change it, a key would let
+// its holder take the account over.
+func (s *userService) ChangePassword(ctx context.Context, current, updated string) error {
+	identity, ok := common.IdentityFromContext(ctx)
+	if !ok {
+		return common.ErrNoIdentity
+	}
+	if identity.Scope != "" {
+		return ErrKeyForbidden
+	}
+	user, err := s.FindByID(ctx, identity.ID)
+	if err != nil {
+		return err
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+			return ErrWrongPassword
+		}
+	}
+	return s.setPassword(ctx, user.ID, updated)
+}
+
+// ResetPassword lets an admin set a new password, one is generated when none is given and returned so it can be
+// passed on. It also lifts a lockout on the account.
+func (s *userService) ResetPassword(ctx context.Context, id, password string) (string, error) {
+	user, err := s.FindByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		generated, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		password = generated[:16]
+	}
+	if err := s.setPassword(ctx, id, password); err != nil {
+		return "", err
+	}
+	s.recordAttempt(ctx, LoginAttempt{Username: user.Username, UserID: user.ID, IP: "admin", Success: true, Reason: ReasonReset})
+	return password, nil
+}
+
+func (s *userService) setPassword(ctx context.Context, id, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrInvalidUser
+	}
+	ePwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		logrus.WithError(err).Error("unable to encrypt password")
+		return errors.New("unable to encrypt password")
+	}
+	if err := s.repo.UpdateUserPassword(ctx, id, string(ePwd)); err != nil {
+		return err
+	}
+	return s.tokens.RevokeUserRefreshTokens(ctx, id)
+}
+
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		logrus.WithField("value", value).Warnf("invalid %s, using %d", name, fallback)
+		return fallback
+	}
+	return i
+}
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"alexandria/internal/common"
 	"context"
 	"errors"
 	"github.com/dgrijalva/jwt-go"
@@ -13,44 +14,96 @@ import (
 )
 
 var (
-	key             = os.Getenv("JWT_SECRET")
-	ErrInvalidLogin = errors.New("invalid login")
-	defaultuser     = os.Getenv("DEFAULT_USER")
-	defaultpassword = os.Getenv("DEFAULT_PASSWORD")
+	key               = os.Getenv("JWT_SECRET")
+	ErrInvalidLogin   = errors.New("invalid login")
+	ErrNotFound       = errors.New("user not found")
+	ErrUserExists     = errors.New("username is taken")
+	ErrInvalidUser    = errors.New("username is required and passwords need at least 8 characters")
+	ErrInvalidRole    = errors.New("role must be admin or user")
+	ErrOwnsResources  = errors.New("user still owns resources")
+	defaultuser       = os.Getenv("DEFAULT_USER")
+	defaultpassword   = os.Getenv("DEFAULT_PASSWORD")
+	allowRegistration = os.Getenv("ALLOW_REGISTRATION") == "true"
+	trustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
 )
 
+const minPasswordLength = 8
+
 type User struct {
-	ID       string `firestore:"id"`
-	Username string `firestore:"username"`
-	Password string `firestore:"password"`
+	ID       string    `firestore:"id" json:"id"`
+	Username string    `firestore:"username" json:"username"`
+	Password string    `firestore:"password" json:"-"`
+	Role     string    `firestore:"role" json:"role"`
+	Created  time.Time `firestore:"created" json:"created"`
+	// ExternalID links the account to an identity provider login
+	ExternalID string `firestore:"external_id" json:"-"`
 }
 
 type Token struct {
-	Type        string    `json:"type"`
-	AccessToken string    `json:"token"`
-	Expires     time.Time `json:"expires"`
+	Type           string    `json:"type"`
+	AccessToken    string    `json:"token"`
+	Expires        time.Time `json:"expires"`
+	RefreshToken   string    `json:"refresh_token"`
+	RefreshExpires time.Time `json:"refresh_expires"`
+	refreshID      string
 }
 
 type Service interface {
-	Authenticate(ctx context.Context, username, password string) (*Token, error)
+	Authenticate(ctx context.Context, username, password, ip string) (*Token, error)
+	ChangePassword(ctx context.Context, current, updated string) error
+	ResetPassword(ctx context.Context, id, password string) (string, error)
+	FindLoginAttempts(ctx context.Context, username string, limit int) ([]LoginAttempt, error)
+	Refresh(ctx context.Context, refreshToken string) (*Token, error)
+	Logout(ctx context.Context, tokenID string, expires time.Time, refreshToken string) error
+	// IsRevoked is true once the access token has been logged out
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+	CreateKey(ctx context.Context, name, scope string) (*APIKey, error)
+	FindKeys(ctx context.Context) ([]APIKey, error)
+	RevokeKey(ctx context.Context, id string) error
+	AuthenticateKey(ctx context.Context, key string) (common.Identity, error)
+	// LoginExternal signs in the account linked to an identity provider login, creating it when allowed
+	LoginExternal(ctx context.Context, login ExternalLogin) (*Token, error)
+	// LinkExternal ties an account to an identity provider login, replacing the login it was linked to
+	LinkExternal(ctx context.Context, id, externalID string) (*User, error)
+	// Register creates a regular account for someone signing themselves up
+	Register(ctx context.Context, username, password string) (*User, error)
+	Create(ctx context.Context, username, password, role string) (*User, error)
+	FindAll(ctx context.Context) ([]User, error)
+	FindByID(ctx context.Context, id string) (*User, error)
+	UpdateRole(ctx context.Context, id, role string) (*User, error)
+	// Delete removes an account, accounts that still own resources can't be deleted
+	Delete(ctx context.Context, id string) error
 }
 
 type Repository interface {
 	FindUserByUsername(ctx context.Context, username string) (*User, error)
+	FindUserByID(ctx context.Context, id string) (*User, error)
+	FindUserByExternalID(ctx context.Context, externalID string) (*User, error)
+	LinkExternalID(ctx context.Context, id, externalID string) error
+	UpdateUserPassword(ctx context.Context, id, password string) error
+	FindUsers(ctx context.Context) ([]User, error)
 	CreateUser(ctx context.Context, user *User) error
+	UpdateUserRole(ctx context.Context, id, role string) error
+	DeleteUser(ctx context.Context, id string) error
 }
 
 type userService struct {
-	repo Repository
+	repo   Repository
+	tokens TokenRepository
+	keys   KeyRepository
+	audit  AuditRepository
 }
 
-func NewUserService(repo Repository) Service {
+func NewUserService(repo Repository, tokens TokenRepository, keys KeyRepository, audit AuditRepository) Service {
 	if key == "" {
 		logrus.Fatal("jwt not set")
 	}
 
 	s := &userService{
-		repo: repo,
+		repo:   repo,
+		tokens: tokens,
+		keys:   keys,
+		audit:  audit,
 	}
 
 	logrus.Info("checking it see if user exists")
@@ -67,7 +120,7 @@ func NewUserService(repo Repository) Service {
 		if defaultpassword == "" {
 			logrus.Fatal("default username not set")
 		}
-		if err := s.createUser(ctx, defaultuser, defaultpassword); err != nil {
+		if _, err := s.createUser(ctx, defaultuser, defaultpassword, common.RoleAdmin); err != nil {
 			logrus.WithError(err).Fatal("unable to create default user")
 		}
 		logrus.Info("default user created")
@@ -76,59 +129,174 @@ func NewUserService(repo Repository) Service {
 	return s
 }
 
-func (s *userService) createUser(ctx context.Context, username, password string) error {
+func (s *userService) createUser(ctx context.Context, username, password, role string) (*User, error) {
 	username = strings.ToLower(username)
 	ePwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		logrus.WithError(err).Error("unable to encrypt password")
-		return errors.New("unable to encrypt password")
+		return nil, errors.New("unable to encrypt password")
 	}
 
 	user := &User{
 		ID:       uuid.New().String(),
 		Username: username,
 		Password: string(ePwd),
+		Role:     role,
+		Created:  time.Now().UTC(),
 	}
 
 	if err := s.repo.CreateUser(ctx, user); err != nil {
 		logrus.WithError(err).Error("unable to create user")
-		return errors.New("unable to create user")
+		return nil, errors.New("unable to create user")
+	}
+
+	return user, nil
+}
+
+func (s *userService) Register(ctx context.Context, username, password string) (*User, error) {
+	return s.Create(ctx, username, password, common.RoleUser)
+}
+
+func (s *userService) Create(ctx context.Context, username, password, role string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" || len(password) < minPasswordLength {
+		return nil, ErrInvalidUser
+	}
+	if !validRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	existing, err := s.repo.FindUserByUsername(ctx, strings.ToLower(username))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrUserExists
+	}
+
+	return s.createUser(ctx, username, password, role)
+}
+
+func (s *userService) FindAll(ctx context.Context) ([]User, error) {
+	return s.repo.FindUsers(ctx)
+}
+
+func (s *userService) FindByID(ctx context.Context, id string) (*User, error) {
+	user, err := s.repo.FindUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *userService) UpdateRole(ctx context.Context, id, role string) (*User, error) {
+	if !validRole(role) {
+		return nil, ErrInvalidRole
+	}
+	if _, err := s.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateUserRole(ctx, id, role); err != nil {
+		return nil, err
 	}
+	return s.FindByID(ctx, id)
+}
 
-	return nil
+func (s *userService) Delete(ctx context.Context, id string) error {
+	if _, err := s.FindByID(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteUser(ctx, id)
 }
 
-func (s *userService) Authenticate(ctx context.Context, username, password string) (*Token, error) {
+func (s *userService) LoginExternal(ctx context.Context, login ExternalLogin) (*Token, error) {
+	user, err := s.repo.FindUserByExternalID(ctx, login.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return s.issueTokens(ctx, user, "")
+	}
+
+	username := strings.ToLower(login.Username)
+	existing, err := s.repo.FindUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case existing != nil:
+		// the name belongs to a local account, an admin has to link it to the login first
+		return nil, ErrUserExists
+	case !login.AutoProvision:
+		return nil, ErrNoAccount
+	}
+
+	user = &User{
+		ID:         uuid.New().String(),
+		Username:   username,
+		Role:       common.RoleUser,
+		Created:    time.Now().UTC(),
+		ExternalID: login.ID,
+	}
+	if err := s.repo.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	logrus.WithField("username", username).Info("created account for identity provider login")
+	return s.issueTokens(ctx, user, "")
+}
+
+// LinkExternal lets an existing account sign in through the identity provider, a login can only belong to one account
+func (s *userService) LinkExternal(ctx context.Context, id, externalID string) (*User, error) {
+	user, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	linked, err := s.repo.FindUserByExternalID(ctx, externalID)
+	if err != nil {
+		return nil, err
+	}
+	if linked != nil && linked.ID != user.ID {
+		return nil, ErrLinked
+	}
+	if err := s.repo.LinkExternalID(ctx, user.ID, externalID); err != nil {
+		return nil, err
+	}
+	logrus.WithField("username", user.Username).Info("linked account to identity provider")
+	return s.FindByID(ctx, id)
+}
+
+func validRole(role string) bool {
+	return role == common.RoleAdmin || role == common.RoleUser
+}
+
+// Authenticate checks a password login, every attempt is recorded and repeated failures lock the username and address
+func (s *userService) Authenticate(ctx context.Context, username, password, ip string) (*Token, error) {
 	username = strings.ToLower(username)
+	if err := s.checkLockout(ctx, username, ip); err != nil {
+		return nil, err
+	}
+
 	user, err := s.repo.FindUserByUsername(ctx, username)
 	if err != nil {
 		logrus.WithError(err).Error("unable to find user")
 		return nil, errors.New("unable to find user")
 	}
 	if user == nil {
+		s.recordAttempt(ctx, LoginAttempt{Username: username, IP: ip, Reason: ReasonUnknownUser})
 		return nil, ErrInvalidLogin
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		logrus.WithField("username", username).WithError(err).Error("invalid login")
+		s.recordAttempt(ctx, LoginAttempt{Username: username, UserID: user.ID, IP: ip, Reason: ReasonWrongPassword})
 		return nil, ErrInvalidLogin
 	}
 
-	tokenExpiration := time.Now().Add(time.Hour * 72)
-	tokenString, err := s.getToken(user, tokenExpiration)
-
-	if err != nil {
-		logrus.WithField("username", username).WithError(err).Error("unable to generate token")
-		return nil, err
-	}
-
-	token := &Token{
-		AccessToken: tokenString,
-		Expires:     tokenExpiration,
-		Type:        "bearer",
-	}
-
-	return token, nil
+	s.recordAttempt(ctx, LoginAttempt{Username: username, UserID: user.ID, IP: ip, Success: true})
+	return s.issueTokens(ctx, user, "")
 }
 
 // getToken is an internal method used to generate JWT Token
@@ -142,6 +310,8 @@ func (s *userService) getToken(user *User, expiration time.Time) (string, error)
 
 	// Set token claims
 	claims["sub"] = user.ID
+	claims["jti"] = uuid.New().String()
+	claims["role"] = user.Role
 	claims["iss"] = "http://think.jholmestech.com"
 	claims["exp"] = expiration.Unix()
 
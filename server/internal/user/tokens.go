@@ -0,0 +1,177 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvalidRefresh = errors.New("invalid refresh token")
+	accessTokenTTL    = durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL   = durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+)
+
+// RefreshToken is the server side record of a refresh token, only a hash of the token itself is kept
+type RefreshToken struct {
+	ID         string
+	UserID     string
+	Family     string
+	Hash       string
+	Created    time.Time
+	Expires    time.Time
+	Revoked    *time.Time
+	ReplacedBy string
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	FindRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	// RevokeRefreshToken marks a token as used, it is false when the token had already been revoked
+	RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error)
+	RevokeRefreshFamily(ctx context.Context, family string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string) error
+	RevokeAccessToken(ctx context.Context, tokenID string, expires time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// issueTokens creates an access token and a refresh token, family is empty for a fresh login
+func (s *userService) issueTokens(ctx context.Context, user *User, family string) (*Token, error) {
+	now := time.Now().UTC()
+	accessExpiration := now.Add(accessTokenTTL)
+	accessToken, err := s.getToken(user, accessExpiration)
+	if err != nil {
+		logrus.WithField("username", user.Username).WithError(err).Error("unable to generate token")
+		return nil, err
+	}
+
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if family == "" {
+		family = uuid.New().String()
+	}
+	record := RefreshToken{
+		ID:      uuid.New().String(),
+		UserID:  user.ID,
+		Family:  family,
+		Hash:    hashToken(refresh),
+		Created: now,
+		Expires: now.Add(refreshTokenTTL),
+	}
+	if err := s.tokens.CreateRefreshToken(ctx, record); err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		AccessToken:    accessToken,
+		Expires:        accessExpiration,
+		Type:           "bearer",
+		RefreshToken:   refresh,
+		RefreshExpires: record.Expires,
+		refreshID:      record.ID,
+	}, nil
+}
+
+// Refresh swaps a refresh token for a new pair. Tokens can only be used once, presenting one that was already swapped
+// means it has leaked so every token from the same login is revoked.
+func (s *userService) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	record, err := s.tokens.FindRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || time.Now().After(record.Expires) {
+		return nil, ErrInvalidRefresh
+	}
+	if record.Revoked != nil {
+		logrus.WithField("family", record.Family).Warn("refresh token reused, revoking family")
+		if err := s.tokens.RevokeRefreshFamily(ctx, record.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefresh
+	}
+
+	user, err := s.repo.FindUserByID(ctx, record.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidRefresh
+	}
+
+	token, err := s.issueTokens(ctx, user, record.Family)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.tokens.RevokeRefreshToken(ctx, record.ID, token.refreshID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// another request swapped the same token first
+		if err := s.tokens.RevokeRefreshFamily(ctx, record.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefresh
+	}
+	return token, nil
+}
+
+// Logout revokes the access token the request was made with and, when given, every refresh token of the login
+func (s *userService) Logout(ctx context.Context, tokenID string, expires time.Time, refreshToken string) error {
+	if tokenID != "" {
+		if err := s.tokens.RevokeAccessToken(ctx, tokenID, expires); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	record, err := s.tokens.FindRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return nil
+	}
+	return s.tokens.RevokeRefreshFamily(ctx, record.Family)
+}
+
+func (s *userService) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return s.tokens.IsAccessTokenRevoked(ctx, tokenID)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logrus.WithError(err).Error("unable to generate refresh token")
+		return "", errors.New("unable to generate refresh token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		logrus.WithField("value", value).Warnf("invalid %s, using %s", name, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+  id uuid PRIMARY KEY,
+  user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  -- every token handed out from the same login shares a family so reuse can revoke all of them
+  family uuid NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires TIMESTAMP NOT NULL,
+  revoked TIMESTAMP NULL DEFAULT NULL,
+  replaced_by uuid NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens(
+  token_id VARCHAR(64) PRIMARY KEY,
+  expires TIMESTAMP NOT NULL
+);
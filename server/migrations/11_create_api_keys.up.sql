@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys(
+  id uuid PRIMARY KEY,
+  user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  name VARCHAR(128) NOT NULL,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash VARCHAR(64) NOT NULL UNIQUE,
+  scope VARCHAR(16) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used TIMESTAMP NULL DEFAULT NULL,
+  UNIQUE (user_id, name)
+);
@@ -0,0 +1,3 @@
+-- accounts signed in through an identity provider are found by issuer and subject
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(512) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_external_id_idx ON users (external_id);
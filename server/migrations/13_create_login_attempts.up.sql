@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS login_attempts(
+  id BIGSERIAL PRIMARY KEY,
+  username VARCHAR(128) NOT NULL,
+  user_id uuid NULL REFERENCES users (id) ON DELETE SET NULL,
+  ip VARCHAR(64) NOT NULL,
+  success BOOLEAN NOT NULL,
+  reason VARCHAR(32),
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON login_attempts (username, created DESC);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created DESC);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS documents_isbn_idx;
+ALTER TABLE documents DROP COLUMN IF EXISTS title;
+ALTER TABLE documents DROP COLUMN IF EXISTS authors;
+ALTER TABLE documents DROP COLUMN IF EXISTS publisher;
+ALTER TABLE documents DROP COLUMN IF EXISTS language;
+ALTER TABLE documents DROP COLUMN IF EXISTS isbn;
+ALTER TABLE documents DROP COLUMN IF EXISTS subjects;
@@ -0,0 +1,8 @@
+-- metadata read from uploaded files, subjects can also become tags
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS title VARCHAR NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS authors TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS publisher VARCHAR NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS language VARCHAR(35) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS isbn VARCHAR(13) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS subjects TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS documents_isbn_idx ON documents (isbn);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS documents_arxiv_id_idx;
+DROP INDEX IF EXISTS documents_doi_idx;
+ALTER TABLE documents DROP COLUMN IF EXISTS published;
+ALTER TABLE documents DROP COLUMN IF EXISTS doi;
+ALTER TABLE documents DROP COLUMN IF EXISTS arxiv_id;
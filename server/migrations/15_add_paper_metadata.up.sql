@@ -0,0 +1,6 @@
+-- publication date and identifiers read from pdfs
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS published TIMESTAMP NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS doi VARCHAR NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS arxiv_id VARCHAR(32) NULL;
+CREATE INDEX IF NOT EXISTS documents_doi_idx ON documents (lower(doi));
+CREATE INDEX IF NOT EXISTS documents_arxiv_id_idx ON documents (arxiv_id);
@@ -0,0 +1,7 @@
+ALTER TABLE documents DROP COLUMN IF EXISTS citation_key;
+ALTER TABLE documents DROP COLUMN IF EXISTS entry_type;
+ALTER TABLE documents DROP COLUMN IF EXISTS journal;
+ALTER TABLE documents DROP COLUMN IF EXISTS volume;
+ALTER TABLE documents DROP COLUMN IF EXISTS issue;
+ALTER TABLE documents DROP COLUMN IF EXISTS pages;
+ALTER TABLE documents DROP COLUMN IF EXISTS url;
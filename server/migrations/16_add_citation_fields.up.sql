@@ -0,0 +1,8 @@
+-- citation fields for bibliography export and import, imported records can have no file
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS citation_key VARCHAR(255) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS entry_type VARCHAR(32) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS journal VARCHAR NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS volume VARCHAR(32) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS issue VARCHAR(32) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS pages VARCHAR(32) NULL;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS url VARCHAR NULL;
@@ -0,0 +1 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS cover_url VARCHAR NULL;
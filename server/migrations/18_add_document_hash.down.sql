@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS documents_sha256_idx;
+ALTER TABLE documents DROP COLUMN IF EXISTS sha256;
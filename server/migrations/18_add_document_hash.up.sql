@@ -0,0 +1,3 @@
+-- content hash of uploaded files, used to refuse uploading the same file twice
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS sha256 VARCHAR(64) NULL;
+CREATE INDEX IF NOT EXISTS documents_sha256_idx ON documents (owner_id, sha256);
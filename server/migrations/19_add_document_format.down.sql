@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS documents_format_idx;
+ALTER TABLE documents DROP COLUMN IF EXISTS format;
@@ -0,0 +1,5 @@
+-- file format of a document, the type only says whether it is a book or a paper
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS format VARCHAR(16) NULL;
+UPDATE documents SET format = 'pdf' WHERE format IS NULL AND lower(path) LIKE '%.pdf';
+UPDATE documents SET format = 'epub' WHERE format IS NULL AND lower(path) LIKE '%.epub';
+CREATE INDEX IF NOT EXISTS documents_format_idx ON documents (owner_id, format);
@@ -0,0 +1,17 @@
+-- chunked uploads in progress, the chunks are kept in the bucket until the upload is completed
+CREATE TABLE IF NOT EXISTS uploads(
+  id uuid PRIMARY KEY,
+  owner_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  name VARCHAR NOT NULL,
+  display_name VARCHAR NOT NULL DEFAULT '',
+  type VARCHAR(16) NOT NULL,
+  size BIGINT NOT NULL,
+  received BIGINT NOT NULL DEFAULT 0,
+  -- the bucket key of each stored chunk, in the order they were received
+  parts TEXT[] NOT NULL DEFAULT '{}',
+  sha256 VARCHAR(64) NULL,
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS uploads_owner_idx ON uploads (owner_id);
@@ -0,0 +1,5 @@
+-- when the text of a document was last extracted, set even when nothing could be extracted so it isn't tried again
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS indexed TIMESTAMPTZ NULL;
+UPDATE documents SET indexed = now()
+  WHERE indexed IS NULL AND EXISTS (SELECT 1 FROM document_pages WHERE document_pages.document_id = documents.id);
+CREATE INDEX IF NOT EXISTS documents_unindexed_idx ON documents (id) WHERE indexed IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS journal_entry_search_idx;
+DROP INDEX IF EXISTS links_search_idx;
+DROP INDEX IF EXISTS documents_search_idx;
+DROP TABLE IF EXISTS document_pages;
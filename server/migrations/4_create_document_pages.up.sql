@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS document_pages(
+  document_id uuid NOT NULL,
+  page INTEGER NOT NULL,
+  content TEXT NOT NULL,
+  tsv tsvector NOT NULL,
+  PRIMARY KEY (document_id, page),
+  FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS document_pages_tsv_idx ON document_pages USING GIN (tsv);
+CREATE INDEX IF NOT EXISTS documents_search_idx ON documents USING GIN (to_tsvector('english', display_name || ' ' || COALESCE(description, '')));
+CREATE INDEX IF NOT EXISTS links_search_idx ON links USING GIN (to_tsvector('english', COALESCE(display_name, '') || ' ' || link));
+CREATE INDEX IF NOT EXISTS journal_entry_search_idx ON journal_entry USING GIN (to_tsvector('english', content));
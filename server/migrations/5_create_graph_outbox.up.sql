@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS graph_outbox(
+  id BIGSERIAL PRIMARY KEY,
+  event_type VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT,
+  next_attempt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  processed TIMESTAMP NULL DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS graph_outbox_pending_idx ON graph_outbox (id) WHERE processed IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE links DROP COLUMN IF EXISTS updated;
+ALTER TABLE tags DROP COLUMN IF EXISTS updated;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS backup_runs(
+  id BIGSERIAL PRIMARY KEY,
+  trigger VARCHAR(32) NOT NULL,
+  started TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  finished TIMESTAMP NULL DEFAULT NULL,
+  backup_id VARCHAR(32),
+  kind VARCHAR(32),
+  size BIGINT NOT NULL DEFAULT 0,
+  outcome VARCHAR(32) NOT NULL,
+  error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS backup_runs_started_idx ON backup_runs (started DESC);
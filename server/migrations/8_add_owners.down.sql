@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS journal_entry_owner_idx;
+DROP INDEX IF EXISTS links_owner_idx;
+DROP INDEX IF EXISTS documents_owner_idx;
+DROP INDEX IF EXISTS tags_owner_display_name_idx;
+ALTER TABLE tags ADD CONSTRAINT tags_display_name_key UNIQUE (display_name);
+
+ALTER TABLE journal_entry DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE tags DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE links DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE documents DROP COLUMN IF EXISTS owner_id;
+
+DROP INDEX IF EXISTS users_username_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS created;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
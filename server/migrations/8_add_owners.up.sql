@@ -0,0 +1,29 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users (username);
+
+-- accounts that existed before multiple users were supported owned the whole library
+UPDATE users SET role = 'admin';
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS owner_id uuid REFERENCES users (id);
+ALTER TABLE links ADD COLUMN IF NOT EXISTS owner_id uuid REFERENCES users (id);
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS owner_id uuid REFERENCES users (id);
+ALTER TABLE journal_entry ADD COLUMN IF NOT EXISTS owner_id uuid REFERENCES users (id);
+
+UPDATE documents SET owner_id = (SELECT id FROM users ORDER BY created, username LIMIT 1) WHERE owner_id IS NULL;
+UPDATE links SET owner_id = (SELECT id FROM users ORDER BY created, username LIMIT 1) WHERE owner_id IS NULL;
+UPDATE tags SET owner_id = (SELECT id FROM users ORDER BY created, username LIMIT 1) WHERE owner_id IS NULL;
+UPDATE journal_entry SET owner_id = (SELECT id FROM users ORDER BY created, username LIMIT 1) WHERE owner_id IS NULL;
+
+ALTER TABLE documents ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE links ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE tags ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE journal_entry ALTER COLUMN owner_id SET NOT NULL;
+
+-- tag names are only unique within a library
+ALTER TABLE tags DROP CONSTRAINT IF EXISTS tags_display_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS tags_owner_display_name_idx ON tags (owner_id, display_name);
+
+CREATE INDEX IF NOT EXISTS documents_owner_idx ON documents (owner_id);
+CREATE INDEX IF NOT EXISTS links_owner_idx ON links (owner_id);
+CREATE INDEX IF NOT EXISTS journal_entry_owner_idx ON journal_entry (owner_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS shares(
+  id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+  resource_id uuid NOT NULL,
+  resource_type VARCHAR(32) NOT NULL,
+  owner_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  grantee_id uuid NULL REFERENCES users (id) ON DELETE CASCADE,
+  token VARCHAR(64) NULL UNIQUE,
+  role VARCHAR(16) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires TIMESTAMP NULL DEFAULT NULL,
+  -- a share is either for another account or for anyone holding the token
+  CHECK ((grantee_id IS NULL) <> (token IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS shares_resource_idx ON shares (resource_id);
+CREATE INDEX IF NOT EXISTS shares_grantee_idx ON shares (grantee_id);